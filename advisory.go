@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// maxLockName is the longest name GET_LOCK accepts.
+const maxLockName = 64
+
+// lockTimeoutError is returned when lock_name is held by another session
+// for longer than lock_timeout.
+type lockTimeoutError struct {
+	name   string
+	waited time.Duration
+}
+
+func (e *lockTimeoutError) Error() string {
+	return fmt.Sprintf("advisory lock %q is held by another session: gave up after %s", e.name, e.waited.Round(time.Millisecond))
+}
+
+// acquireAdvisoryLock takes lock_name on conn with GET_LOCK, waiting up to
+// lock_timeout seconds. The lock belongs to the connection: the returned
+// function releases it and must run before the connection goes back to the
+// pool.
+func acquireAdvisoryLock(ctx context.Context, conn *sql.Conn, cfg settings) (func(), error) {
+	started := time.Now()
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", cfg.lockName, cfg.lockTimeout).Scan(&got); err != nil {
+		return nil, fmt.Errorf("failed to acquire advisory lock %q: %v", cfg.lockName, err)
+	}
+	switch {
+	case !got.Valid:
+		// NULL: the server failed, e.g. the session was killed while waiting.
+		return nil, fmt.Errorf("failed to acquire advisory lock %q", cfg.lockName)
+	case got.Int64 == 0:
+		return nil, &lockTimeoutError{cfg.lockName, time.Since(started)}
+	}
+	return func() {
+		// The invocation's context may have expired; the release must not.
+		conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", cfg.lockName)
+	}, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// summaryAggregates are the aggregates summarize_columns computes. sum and
+// avg need numbers; min, max and count take any column.
+var summaryAggregates = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// avgExtraScale is the digits avg adds to the scale of its column, like
+// MySQL's default div_precision_increment.
+const avgExtraScale = 4
+
+// parseSummarizeColumns parses summarize_columns, an object of column
+// names and the aggregates to compute for each.
+func parseSummarizeColumns(raw string) (map[string][]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var spec map[string][]string
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return nil, fmt.Errorf("summarize_columns must be an object of columns and aggregates like {\"amount\": [\"sum\", \"avg\"]}: %v", err)
+	}
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("summarize_columns must name at least one column")
+	}
+	for col, aggs := range spec {
+		if len(aggs) == 0 {
+			return nil, fmt.Errorf("summarize_columns: %s names no aggregate", col)
+		}
+		for i, agg := range aggs {
+			if aggs[i] = strings.ToLower(agg); !summaryAggregates[aggs[i]] {
+				return nil, fmt.Errorf("summarize_columns: %s: unknown aggregate %q, expected sum, avg, min, max or count", col, agg)
+			}
+		}
+	}
+	return spec, nil
+}
+
+// resultSummary is the summary output field. Partial is set when
+// client_limit cut the result: the summary covers the returned rows only.
+type resultSummary struct {
+	Rows    int                               `json:"rows"`
+	Partial bool                              `json:"partial,omitempty"`
+	Columns map[string]map[string]interface{} `json:"columns"`
+}
+
+// columnTotal adds up the values of a column exactly.
+type columnTotal struct {
+	sum     *big.Rat
+	values  int  // non-NULL values
+	count   int  // numbers among them
+	scale   int  // most digits after the point among the values
+	text    bool // some value was text, such as DECIMAL by default
+	invalid bool // some value was not a number
+}
+
+func totalColumn(rows []map[string]interface{}, col string) columnTotal {
+	t := columnTotal{sum: new(big.Rat)}
+	for _, row := range rows {
+		v := row[col]
+		if v == nil {
+			continue
+		}
+		t.values++
+		var s string
+		switch v := v.(type) {
+		case string:
+			s, t.text = v, true
+		case json.Number:
+			s = string(v)
+		case int64, uint64:
+			s = fmt.Sprint(v)
+		case float32:
+			s = strconv.FormatFloat(float64(v), 'f', -1, 32)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			t.invalid = true
+			continue
+		}
+		n, ok := parseExact(s)
+		if !ok {
+			t.invalid = true
+			continue
+		}
+		if _, frac, ok := strings.Cut(s, "."); ok && len(frac) > t.scale && !strings.ContainsAny(frac, "eE") {
+			t.scale = len(frac)
+		}
+		t.sum.Add(t.sum, n)
+		t.count++
+	}
+	return t
+}
+
+// number returns n with scale digits after the point, as text when the
+// column held text and as a JSON number otherwise.
+func (t columnTotal) number(n *big.Rat, scale int) interface{} {
+	s := n.FloatString(scale)
+	if t.text {
+		return s
+	}
+	return json.Number(s)
+}
+
+// summarizeColumns computes summarize_columns over the returned rows. Sums
+// and averages are exact, never rounded through float64, with the scale of
+// the values and avg four digits more; they are text for columns returned
+// as text, such as DECIMAL by default. A column that is missing, or holds
+// values that are not numbers for sum or avg, gets a warning and null
+// aggregates instead of failing the result. Like SQL, the sum and average
+// of no values are null.
+func summarizeColumns(cfg settings, rows []map[string]interface{}, out *Output) *resultSummary {
+	spec, _ := parseSummarizeColumns(cfg.summarizeColumns) // validated by parseSettings
+	summary := &resultSummary{Rows: len(rows), Columns: make(map[string]map[string]interface{}, len(spec))}
+	if out.ClientSort != nil && out.ClientSort.Returned < out.ClientSort.Rows {
+		summary.Partial = true
+	}
+	for _, col := range sortedKeys(spec) {
+		if len(rows) > 0 {
+			if _, ok := rows[0][col]; !ok {
+				out.Warnings = append(out.Warnings, fmt.Sprintf("summarize_columns: column %s is not in the result", col))
+			}
+		}
+		t := totalColumn(rows, col)
+		values := make(map[string]interface{}, len(spec[col]))
+		warned := false
+		for _, agg := range spec[col] {
+			switch agg {
+			case "count":
+				values[agg] = t.values
+			case "min", "max":
+				values[agg] = extremeValue(rows, col, agg == "max", cfg.clientSortIgnoreCase)
+			case "sum", "avg":
+				switch {
+				case t.invalid:
+					values[agg] = nil
+					if !warned {
+						out.Warnings = append(out.Warnings, fmt.Sprintf("summarize_columns: %s holds values that are not numbers, so its sum and avg are null", col))
+						warned = true
+					}
+				case t.count == 0:
+					values[agg] = nil
+				case agg == "sum":
+					values[agg] = t.number(t.sum, t.scale)
+				default:
+					avg := new(big.Rat).Quo(t.sum, new(big.Rat).SetInt64(int64(t.count)))
+					values[agg] = t.number(avg, t.scale+avgExtraScale)
+				}
+			}
+		}
+		summary.Columns[col] = values
+	}
+	return summary
+}
+
+// extremeValue returns the least or greatest non-NULL value of col,
+// compared like client_sort does.
+func extremeValue(rows []map[string]interface{}, col string, greatest, ignoreCase bool) interface{} {
+	numeric := numericText(rows, col)
+	var best interface{}
+	for _, row := range rows {
+		v := row[col]
+		if v == nil {
+			continue
+		}
+		if best == nil {
+			best = v
+			continue
+		}
+		if c := compareValues(v, best, numeric, ignoreCase); (c > 0) == greatest && c != 0 {
+			best = v
+		}
+	}
+	return best
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// anonymizeTable is one entry of anonymize_spec:
+//
+//	{"table": "customers", "filter": {"id": 42}, "set": {"name": "REDACTED", "email": null, "phone": "hash"}}
+//
+// filter is the filter DSL and may not be empty. A set value is written as
+// is, except the strings "hash", which becomes the hex SHA-256 of the
+// column's current value (NULL stays NULL), and "random_token", a random
+// hex string per row.
+type anonymizeTable struct {
+	Table  string                     `json:"table"`
+	Filter json.RawMessage            `json:"filter"`
+	Set    map[string]json.RawMessage `json:"set"`
+
+	columns []anonymizeColumn
+}
+
+type anonymizeColumn struct {
+	name   string
+	quoted ident
+	token  string // hash, random_token, or empty for a literal
+	value  interface{}
+}
+
+// perRow reports whether the table's new values depend on the row, so its
+// rows have to be read and updated one by one.
+func (t anonymizeTable) perRow() bool {
+	for _, c := range t.columns {
+		if c.token != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAnonymizeSpec(raw string, cfg settings) ([]anonymizeTable, error) {
+	var spec []anonymizeTable
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf(`anonymize_spec must be a JSON array of {"table", "filter", "set"}: %v`, err)
+	}
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("anonymize_spec names no table")
+	}
+	for i := range spec {
+		t := &spec[i]
+		if _, err := quoteQualifiedIdent(t.Table); err != nil {
+			return nil, fmt.Errorf("anonymize_spec[%d] table: %v", i, err)
+		}
+		// As for update, an empty filter would scrub every row.
+		f, err := parseFilter(string(t.Filter))
+		if err != nil {
+			return nil, fmt.Errorf("anonymize_spec[%d] filter: %v", i, err)
+		}
+		if f.SQL == "" {
+			return nil, fmt.Errorf("anonymize_spec[%d] filter is required", i)
+		}
+		if len(t.Set) == 0 {
+			return nil, fmt.Errorf("anonymize_spec[%d] set is required", i)
+		}
+		for _, name := range sortedKeys(t.Set) {
+			col := anonymizeColumn{name: name}
+			if col.quoted, err = columnIdent(name); err != nil {
+				return nil, fmt.Errorf("anonymize_spec[%d] set: %v", i, err)
+			}
+			if cfg.tenantColumn != "" && strings.EqualFold(name, cfg.tenantColumn) {
+				return nil, fmt.Errorf("anonymize_spec[%d] may not set tenant column %s", i, name)
+			}
+			if err := json.Unmarshal(t.Set[name], &col.value); err != nil {
+				return nil, fmt.Errorf("anonymize_spec[%d] set %s: %v", i, name, err)
+			}
+			switch v := col.value.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("anonymize_spec[%d] set %s must be a literal, null, \"hash\" or \"random_token\"", i, name)
+			case string:
+				if v == "hash" || v == "random_token" {
+					col.token, col.value = v, nil
+				}
+			}
+			t.columns = append(t.columns, col)
+		}
+	}
+	return spec, nil
+}
+
+// tableAnonymization is the outcome for one entry of anonymize_spec. In a
+// dry run Preview holds up to sample_size rows as they would be written,
+// keyed by primary key; the current values are not shown.
+type tableAnonymization struct {
+	Table        string                   `json:"table"`
+	MatchingRows int64                    `json:"matching_rows"`
+	RowsAffected int64                    `json:"rows_affected"`
+	Preview      []map[string]interface{} `json:"preview,omitempty"`
+}
+
+// anonymize implements data_type=anonymize for erasure requests: every
+// entry of anonymize_spec runs in one transaction, so a failure leaves all
+// tables as they were. Each filter is scoped to the tenant like an update's,
+// dry_run (on unless set to false) previews without writing, and the
+// preview goes through the masking policy like any other result.
+func anonymize(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.readOnly && !cfg.dryRun {
+		return nil, &readOnlyError{"data_type=anonymize without dry_run"}
+	}
+	spec, err := parseAnonymizeSpec(cfg.anonymizeSpec, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.preflightPrivileges && !cfg.dryRun {
+		for _, t := range spec {
+			schema, object := splitTarget(t.Table, cfg.dbname)
+			report, err := evaluatePrivileges(ctx, db, []string{"SELECT", "UPDATE"}, schema, object)
+			if err != nil {
+				out.Warnings = append(out.Warnings, fmt.Sprintf("preflight_privileges: %v", err))
+				break
+			}
+			if !report.Allowed {
+				return nil, &privilegeError{report}
+			}
+		}
+	}
+	samples := cfg.sampleSize
+	if samples == 0 {
+		samples = 10
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	results := make([]tableAnonymization, len(spec))
+	for i, t := range spec {
+		if results[i], err = anonymizeOne(ctx, tx, cfg, t, samples); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Table, err)
+		}
+	}
+	if !cfg.dryRun {
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit failed: %v", err)
+		}
+	}
+	return map[string]interface{}{"dry_run": cfg.dryRun, "tables": results}, nil
+}
+
+func anonymizeOne(ctx context.Context, tx *sql.Tx, cfg settings, t anonymizeTable, samples int) (tableAnonymization, error) {
+	result := tableAnonymization{Table: t.Table}
+	table, _ := tableIdent(t.Table)
+	f, _ := parseFilter(string(t.Filter))
+	if cond, args := tenantCondition(cfg); cond != "" {
+		f = f.and(cond, args...)
+	}
+
+	if !t.perRow() && !cfg.dryRun {
+		b := new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ")
+		for i, c := range t.columns {
+			if i > 0 {
+				b.text(", ")
+			}
+			b.ident(c.quoted).text(" = ?").arg(c.value)
+		}
+		query, args := b.where(f).build()
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return result, fmt.Errorf("execution error: %w", err)
+		}
+		result.RowsAffected, _ = res.RowsAffected()
+		result.MatchingRows = result.RowsAffected
+		return result, nil
+	}
+
+	// The hash of a value is taken from the row as this transaction locked
+	// it, so the update cannot race a concurrent change of the column.
+	keys, err := primaryKeyColumns(ctx, tx, t.Table)
+	if err != nil {
+		return result, fmt.Errorf("failed to read the primary key: %v", err)
+	}
+	if len(keys) == 0 && t.perRow() {
+		return result, fmt.Errorf("hash and random_token need a primary key to update the rows one by one")
+	}
+	keyCols, _ := columnIdents(keys)
+	selected := append([]ident(nil), keyCols...)
+	var hashed []anonymizeColumn
+	for _, c := range t.columns {
+		if c.token == "hash" {
+			hashed = append(hashed, c)
+			selected = append(selected, c.quoted)
+		}
+	}
+	b := new(stmtBuilder).text("SELECT ")
+	if len(selected) == 0 {
+		// A dry run on a table without a primary key only counts.
+		b.text("1")
+	}
+	b.idents(selected).text(" FROM ").ident(table).where(f)
+	if !cfg.dryRun {
+		b.text(" FOR UPDATE")
+	}
+	query, args := b.build()
+	width := len(selected)
+	if width == 0 {
+		width = 1
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to read the rows: %v", err)
+	}
+	var current [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, width)
+		targets := make([]interface{}, width)
+		for i := range row {
+			targets[i] = &row[i]
+		}
+		if err := rows.Scan(targets...); err != nil {
+			rows.Close()
+			return result, err
+		}
+		current = append(current, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	result.MatchingRows = int64(len(current))
+
+	// One UPDATE by key per row; the arguments are bound row by row.
+	ub := new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ")
+	for i, c := range t.columns {
+		if i > 0 {
+			ub.text(", ")
+		}
+		ub.ident(c.quoted).text(" = ?")
+	}
+	ub.text(" WHERE ")
+	for i, k := range keyCols {
+		if i > 0 {
+			ub.text(" AND ")
+		}
+		ub.ident(k).text(" = ?")
+	}
+	update, _ := ub.build()
+	for _, row := range current {
+		values, err := anonymizedValues(t.columns, hashed, row[len(keys):])
+		if err != nil {
+			return result, err
+		}
+		if cfg.dryRun {
+			if len(result.Preview) < samples {
+				result.Preview = append(result.Preview, anonymizePreview(keys, row, t.columns, values, cfg.scanning().masking))
+			}
+			continue
+		}
+		res, err := tx.ExecContext(ctx, update, append(values, row[:len(keys)]...)...)
+		if err != nil {
+			return result, fmt.Errorf("execution error: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		result.RowsAffected += n
+	}
+	return result, nil
+}
+
+// anonymizedValues computes the new value of every column for one row;
+// original holds the current values of the hashed columns, in order.
+func anonymizedValues(columns, hashed []anonymizeColumn, original []interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		switch c.token {
+		case "hash":
+			for j, h := range hashed {
+				if h.name != c.name || original[j] == nil {
+					continue
+				}
+				var b []byte
+				if raw, ok := original[j].([]byte); ok {
+					b = raw
+				} else {
+					b = []byte(fmt.Sprint(original[j]))
+				}
+				sum := sha256.Sum256(b)
+				values[i] = hex.EncodeToString(sum[:])
+			}
+		case "random_token":
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return nil, fmt.Errorf("random_token: %v", err)
+			}
+			values[i] = hex.EncodeToString(b)
+		default:
+			values[i] = c.value
+		}
+	}
+	return values, nil
+}
+
+// anonymizePreview renders one row of a dry run as it would be written,
+// masked as results are.
+func anonymizePreview(keys []string, row []interface{}, columns []anonymizeColumn, values []interface{}, masking *columnMasking) map[string]interface{} {
+	preview := make(map[string]interface{}, len(keys)+len(columns))
+	for i, k := range keys {
+		v := row[i]
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		preview[k] = v
+	}
+	for i, c := range columns {
+		preview[c.name] = values[i]
+	}
+	for col := range preview {
+		if masking.dropped(col) {
+			delete(preview, col)
+		} else {
+			preview[col] = masking.value(col, preview[col])
+		}
+	}
+	return preview
+}
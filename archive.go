@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// archiveResult reports an archive run. Each batch commits on its own, so
+// LastKey, the primary key of the last row moved, is where a stopped run
+// left off; a rerun with the same filter carries on from there because the
+// moved rows no longer match.
+type archiveResult struct {
+	Batches      []int64                `json:"batches"` // rows moved per batch
+	RowsMoved    int64                  `json:"rows_moved"`
+	LastKey      map[string]interface{} `json:"last_key,omitempty"`
+	StoppedEarly bool                   `json:"stopped_early"`
+	StopReason   string                 `json:"stop_reason,omitempty"` // max_batches, max_runtime or deadline
+}
+
+type columnInfo struct {
+	Type     string
+	Nullable bool
+	Default  bool // has a default or is generated by the server
+}
+
+// readColumnInfo reads the columns of table from information_schema, in
+// ordinal order.
+func readColumnInfo(ctx context.Context, q execer, table string) ([]string, map[string]columnInfo, error) {
+	parts := splitQualified(table)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	rows, err := q.QueryContext(ctx,
+		"SELECT column_name, column_type, is_nullable, column_default IS NOT NULL OR extra <> '' FROM information_schema.columns WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? ORDER BY ordinal_position",
+		schema, parts[len(parts)-1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+	var names []string
+	info := map[string]columnInfo{}
+	for rows.Next() {
+		var name, nullable string
+		var c columnInfo
+		if err := rows.Scan(&name, &c.Type, &nullable, &c.Default); err != nil {
+			return nil, nil, err
+		}
+		c.Nullable = nullable == "YES"
+		names = append(names, name)
+		info[strings.ToLower(name)] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("table %s does not exist or has no columns", table)
+	}
+	return names, info, nil
+}
+
+// checkArchiveColumns requires archive_table to have every column of
+// object_name, and any column of its own, such as an archived_at, to be
+// nullable or defaulted so the INSERT ... SELECT can leave it out. A column
+// type that differs is only a warning: a wider type in the archive is common
+// and the server reports a value that does not fit.
+func checkArchiveColumns(ctx context.Context, q execer, cfg settings) ([]string, []string, error) {
+	source, sourceInfo, err := readColumnInfo(ctx, q, cfg.objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive, archiveInfo, err := readColumnInfo(ctx, q, cfg.archiveTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	var missing, warnings []string
+	for _, name := range source {
+		a, ok := archiveInfo[strings.ToLower(name)]
+		if !ok {
+			missing = append(missing, name)
+		} else if s := sourceInfo[strings.ToLower(name)]; !strings.EqualFold(a.Type, s.Type) {
+			warnings = append(warnings, fmt.Sprintf("column %s is %s in %s but %s in %s", name, s.Type, cfg.objectName, a.Type, cfg.archiveTable))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("archive_table %s lacks columns of %s: %s", cfg.archiveTable, cfg.objectName, strings.Join(missing, ", "))
+	}
+	for _, name := range archive {
+		if _, ok := sourceInfo[strings.ToLower(name)]; ok {
+			continue
+		}
+		if a := archiveInfo[strings.ToLower(name)]; !a.Nullable && !a.Default {
+			return nil, nil, fmt.Errorf("archive_table column %s is not in %s and has no default", name, cfg.objectName)
+		}
+	}
+	return source, warnings, nil
+}
+
+// archive implements data_type=archive: the rows of object_name matching
+// filter are copied into archive_table and deleted, batch_size rows at a
+// time in primary key order. Each batch is one transaction that locks the
+// keys of its rows, inserts those rows and deletes them, and only commits
+// when the three counts agree. max_batches, sleep_between_batches_ms and
+// max_runtime_seconds apply as for purge. dry_run, on unless set to false,
+// checks the columns and counts the matching rows.
+func archive(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.readOnly && !cfg.dryRun {
+		return nil, &readOnlyError{"data_type=archive without dry_run"}
+	}
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	target, err := tableIdent(cfg.archiveTable)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive_table: %v", err)
+	}
+	columns, warnings, err := checkArchiveColumns(ctx, db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	out.Warnings = append(out.Warnings, warnings...)
+	keys, err := primaryKeyColumns(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the primary key of %s: %v", cfg.objectName, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("data_type=archive needs a primary key on %s to delete exactly the rows it archived", cfg.objectName)
+	}
+	f, err := writeFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.dryRun {
+		var n int64
+		query, args := new(stmtBuilder).text("SELECT COUNT(*) FROM ").ident(table).where(f).build()
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+			return nil, fmt.Errorf("execution error: %w", err)
+		}
+		return map[string]interface{}{"dry_run": true, "matching_rows": n}, nil
+	}
+
+	quotedKeys, _ := columnIdents(keys)
+	quotedCols, _ := columnIdents(columns)
+	batchSize := cfg.batchSize
+	if batchSize == 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+	batch := archiveBatch{
+		table:   table,
+		target:  target,
+		columns: quotedCols,
+		keys:    quotedKeys,
+		filter:  f,
+		limit:   int64(batchSize),
+		strict:  cfg.strictWarnings,
+	}
+
+	started := time.Now()
+	result := &archiveResult{Batches: []int64{}}
+	var lastBatch time.Duration
+	for {
+		if result.StopReason = batchStopReason(ctx, cfg, started, len(result.Batches), lastBatch); result.StopReason != "" {
+			result.StoppedEarly = true
+			return result, nil
+		}
+		batchStarted := time.Now()
+		moved, err := batch.run(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: %w (%d rows moved by the earlier batches)", len(result.Batches)+1, err, result.RowsMoved)
+		}
+		lastBatch = time.Since(batchStarted)
+		n := int64(len(moved))
+		if n > 0 {
+			result.Batches = append(result.Batches, n)
+			result.RowsMoved += n
+			last := moved[len(moved)-1]
+			result.LastKey = make(map[string]interface{}, len(keys))
+			for i, k := range keys {
+				result.LastKey[k] = last[i]
+			}
+		}
+		if n < int64(batchSize) {
+			return result, nil
+		}
+		if !pauseBetweenBatches(ctx, cfg) {
+			result.StoppedEarly, result.StopReason = true, "deadline"
+			return result, nil
+		}
+	}
+}
+
+// archiveBatch describes the statements of one archive batch: a SELECT
+// locking the keys of up to limit rows matching filter, and an INSERT and
+// DELETE with a WHERE (key) IN (...) of the keys it locked.
+type archiveBatch struct {
+	table, target ident
+	columns, keys []ident
+	filter        filter
+	limit         int64
+	strict        bool // fail the batch on warnings
+}
+
+// run moves one batch and returns the keys of the rows it moved. A count
+// that differs rolls the batch back: a row inserted but not deleted would
+// be archived twice by the next run, one deleted but not inserted lost.
+func (b archiveBatch) run(ctx context.Context, db *database) ([][]interface{}, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	selectKeys := new(stmtBuilder).text("SELECT ").idents(b.keys).text(" FROM ").ident(b.table).where(b.filter).
+		text(" ORDER BY ").idents(b.keys).text(" LIMIT ").number(b.limit).text(" FOR UPDATE")
+	keys, err := lockedKeys(ctx, tx, selectKeys, len(b.keys))
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	insert, args := new(stmtBuilder).text("INSERT INTO ").ident(b.target).text(" (").idents(b.columns).text(") SELECT ").idents(b.columns).
+		text(" FROM ").ident(b.table).text(" WHERE ").keyIn(b.keys, keys).build()
+	res, err := tx.ExecContext(ctx, insert, args...)
+	if err != nil {
+		return nil, fmt.Errorf("insert into archive_table: %w", err)
+	}
+	if b.strict {
+		if err := checkStrict(ctx, tx); err != nil {
+			return nil, fmt.Errorf("insert into archive_table: %w", err)
+		}
+	}
+	inserted, _ := res.RowsAffected()
+	del, args := new(stmtBuilder).text("DELETE FROM ").ident(b.table).text(" WHERE ").keyIn(b.keys, keys).build()
+	if res, err = tx.ExecContext(ctx, del, args...); err != nil {
+		return nil, fmt.Errorf("delete: %w", err)
+	}
+	if b.strict {
+		if err := checkStrict(ctx, tx); err != nil {
+			return nil, fmt.Errorf("delete: %w", err)
+		}
+	}
+	deleted, _ := res.RowsAffected()
+	if inserted != int64(len(keys)) || deleted != int64(len(keys)) {
+		return nil, fmt.Errorf("row counts differ: %d locked, %d archived, %d deleted; the batch was rolled back", len(keys), inserted, deleted)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit: %v", err)
+	}
+	return keys, nil
+}
+
+// keyIn writes the condition matching the rows of keys, with columns the
+// key columns: (a, b) IN ((?, ?), (?, ?)).
+func (b *stmtBuilder) keyIn(columns []ident, keys [][]interface{}) *stmtBuilder {
+	b.text("(").idents(columns).text(") IN (")
+	for i, key := range keys {
+		if i > 0 {
+			b.text(", ")
+		}
+		b.text("(").values(key).text(")")
+	}
+	return b.text(")")
+}
+
+// lockedKeys runs the key SELECT ... FOR UPDATE of a batch. Byte values are
+// returned as strings so the last key reads back in the result.
+func lockedKeys(ctx context.Context, tx *sql.Tx, selectKeys *stmtBuilder, width int) ([][]interface{}, error) {
+	query, args := selectKeys.build()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the rows: %v", err)
+	}
+	defer rows.Close()
+	var keys [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, width)
+		targets := make([]interface{}, width)
+		for i := range row {
+			targets[i] = &row[i]
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		keys = append(keys, row)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assertionExitCode is the exit status when a required check fails, apart
+// from 1 for output that could not be written.
+const assertionExitCode = 2
+
+// assertion is one entry of checks:
+//
+//	{"name": "orders have customers", "query": "SELECT COUNT(*) FROM orders o LEFT JOIN customers c ON c.id = o.customer_id WHERE c.id IS NULL", "expect": "is_zero"}
+//	{"name": "orders today", "query": "SELECT COUNT(*) FROM orders WHERE created_at >= CURDATE()", "expect": "greater_than", "value": 0, "severity": "warn"}
+//
+// expect is equals, not_equals, greater_than (value), between (min and max,
+// inclusive) or is_zero. A check with severity warn is reported but does
+// not fail the request. timeout_seconds overrides check_timeout_seconds.
+type assertion struct {
+	Name     string      `json:"name"`
+	Query    string      `json:"query"`
+	Expect   string      `json:"expect"`
+	Value    interface{} `json:"value"`
+	Min      *float64    `json:"min"`
+	Max      *float64    `json:"max"`
+	Severity string      `json:"severity"`
+	Timeout  int         `json:"timeout_seconds"`
+}
+
+func parseAssertions(raw string) ([]assertion, error) {
+	var checks []assertion
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&checks); err != nil {
+		return nil, fmt.Errorf(`checks must be a JSON array of {"name", "query", "expect", ...}: %v`, err)
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("checks is empty")
+	}
+	for i := range checks {
+		c := &checks[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("check %d", i+1)
+		}
+		if !isSelectQuery(c.Query) {
+			return nil, fmt.Errorf("%s: query must be a SELECT returning one value", c.Name)
+		}
+		switch c.Expect {
+		case "is_zero":
+		case "equals", "not_equals":
+			if c.Value == nil {
+				return nil, fmt.Errorf("%s: expect=%s requires value", c.Name, c.Expect)
+			}
+		case "greater_than":
+			if _, ok := c.Value.(float64); !ok {
+				return nil, fmt.Errorf("%s: expect=greater_than requires a numeric value", c.Name)
+			}
+		case "between":
+			if c.Min == nil || c.Max == nil || *c.Min > *c.Max {
+				return nil, fmt.Errorf("%s: expect=between requires min and max, min not above max", c.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: expect must be equals, not_equals, greater_than, between or is_zero, got %q", c.Name, c.Expect)
+		}
+		switch c.Severity {
+		case "":
+			c.Severity = "error"
+		case "error", "warn":
+		default:
+			return nil, fmt.Errorf("%s: severity must be error or warn, got %q", c.Name, c.Severity)
+		}
+		if c.Timeout < 0 {
+			return nil, fmt.Errorf("%s: timeout_seconds must not be negative", c.Name)
+		}
+	}
+	return checks, nil
+}
+
+// assertionResult is the outcome of one check: pass, fail, or error when
+// the query itself failed or timed out.
+type assertionResult struct {
+	Name       string      `json:"name"`
+	Status     string      `json:"status"`
+	Severity   string      `json:"severity"`
+	Expect     string      `json:"expect"`
+	Actual     interface{} `json:"actual"`
+	Error      string      `json:"error,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+}
+
+// assertChecks implements data_type=assert: every check runs, in order,
+// with its own timeout. A failing required check sets error and the exit
+// status, but the result still reports every check.
+func assertChecks(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	checks, err := parseAssertions(cfg.checks)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]assertionResult, len(checks))
+	var failed, warned []string
+	for i, c := range checks {
+		results[i] = runAssertion(ctx, db, cfg, c)
+		if results[i].Status == "pass" {
+			continue
+		}
+		if c.Severity == "warn" {
+			warned = append(warned, c.Name)
+			out.Warnings = append(out.Warnings, fmt.Sprintf("check %q: %s", c.Name, assertionMessage(c, results[i])))
+		} else {
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) > 0 {
+		out.Error = fmt.Sprintf("%d of %d required checks failed: %s", len(failed), len(checks), strings.Join(failed, ", "))
+		out.ErrorClass = "assertion_failed"
+		out.exitCode = assertionExitCode
+	}
+	return map[string]interface{}{
+		"passed": len(checks) - len(failed) - len(warned), "failed": len(failed), "warned": len(warned), "checks": results,
+	}, nil
+}
+
+func assertionMessage(c assertion, r assertionResult) string {
+	if r.Error != "" {
+		return r.Error
+	}
+	want := "zero"
+	switch c.Expect {
+	case "equals":
+		want = fmt.Sprint(c.Value)
+	case "not_equals":
+		want = fmt.Sprintf("not %v", c.Value)
+	case "greater_than":
+		want = fmt.Sprintf("more than %v", c.Value)
+	case "between":
+		want = fmt.Sprintf("between %v and %v", *c.Min, *c.Max)
+	}
+	return fmt.Sprintf("expected %s, got %v", want, r.Actual)
+}
+
+func runAssertion(ctx context.Context, db *database, cfg settings, c assertion) (result assertionResult) {
+	result = assertionResult{Name: c.Name, Severity: c.Severity, Expect: c.Expect}
+	timeout := time.Duration(cfg.checkTimeout) * time.Second
+	if c.Timeout > 0 {
+		timeout = time.Duration(c.Timeout) * time.Second
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	defer func() { result.DurationMS = time.Since(started).Milliseconds() }()
+
+	rows, err := db.QueryContext(ctx, c.Query)
+	var values []map[string]interface{}
+	if err == nil {
+		values, err = scanRows(rows, cfg)
+		rows.Close()
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Status, result.Error = "error", fmt.Sprintf("no result after %s", timeout)
+		return result
+	case err != nil:
+		result.Status, result.Error = "error", err.Error()
+		return result
+	case len(values) != 1 || len(values[0]) != 1:
+		result.Status = "error"
+		result.Error = fmt.Sprintf("query must return one row of one column, got %d rows", len(values))
+		if len(values) == 1 {
+			result.Error = fmt.Sprintf("query must return one row of one column, got %d columns", len(values[0]))
+		}
+		return result
+	}
+	for _, v := range values[0] {
+		result.Actual = v
+	}
+	result.Status = "fail"
+	if assertionHolds(c, result.Actual) {
+		result.Status = "pass"
+	}
+	return result
+}
+
+// assertionHolds compares numerically where both sides are numbers, so
+// "3.0" from a DECIMAL equals 3, and as text otherwise.
+func assertionHolds(c assertion, actual interface{}) bool {
+	n, numErr := strconv.ParseFloat(fmt.Sprint(actual), 64)
+	isNum := actual != nil && numErr == nil
+	switch c.Expect {
+	case "is_zero":
+		return isNum && n == 0
+	case "greater_than":
+		return isNum && n > c.Value.(float64)
+	case "between":
+		return isNum && n >= *c.Min && n <= *c.Max
+	}
+	equal := false
+	if want, ok := c.Value.(float64); ok && isNum {
+		equal = n == want
+	} else if actual != nil {
+		equal = fmt.Sprint(actual) == fmt.Sprint(c.Value)
+	}
+	if c.Expect == "not_equals" {
+		return !equal
+	}
+	return equal
+}
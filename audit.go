@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// parseAuditContext checks that audit_context is a JSON object and returns it
+// in compact form for storage.
+func parseAuditContext(raw string) (string, error) {
+	if raw == "" {
+		return "{}", nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		return "", fmt.Errorf("audit_context must be a JSON object: %v", err)
+	}
+	compact, _ := json.Marshal(obj)
+	return string(compact), nil
+}
+
+// writeAudit records a successful write in the audit table. The table is
+// expected to have the columns fingerprint, statement, object_name,
+// rows_affected, context and executed_at.
+func writeAudit(ctx context.Context, q execer, table string, stmt statement, objectName string, result interface{}, auditContext string) error {
+	quoted, err := tableIdent(table)
+	if err != nil {
+		return fmt.Errorf("invalid audit_table: %v", err)
+	}
+
+	var affected int64
+	switch m := result.(type) {
+	case map[string]int64:
+		affected = m["rows_affected"]
+	case map[string]interface{}:
+		affected, _ = m["rows_affected"].(int64)
+	}
+
+	fp := newFingerprint(stmt.SQL)
+	query, args := new(stmtBuilder).text("INSERT INTO ").ident(quoted).
+		text(" (fingerprint, statement, object_name, rows_affected, context, executed_at) VALUES (?, ?, ?, ?, ?, NOW())").
+		arg(fp.Hash, fp.Normalized, objectName, affected, auditContext).build()
+	_, err = q.ExecContext(ctx, query, args...)
+	return err
+}
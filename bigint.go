@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxSafeInteger is 2^53, beyond which a float64 reader, such as
+// JavaScript, no longer holds every integer.
+const maxSafeInteger = 1 << 53
+
+// bigIntModes are the big_int_mode values for BIGINT columns:
+//
+//	number  exact JSON numbers, every digit kept (the default)
+//	safe    JSON numbers up to 2^53 in magnitude, strings beyond
+//	string  always strings
+var bigIntModes = map[string]bool{"number": true, "safe": true, "string": true}
+
+// bigIntColumn is how a result column holds BIGINT values.
+type bigIntColumn int
+
+const (
+	notBigInt bigIntColumn = iota
+	signedBigInt
+	unsignedBigInt
+)
+
+// bigIntColumns finds the BIGINT result columns, indexed like them, or
+// returns nil when there are none. UNSIGNED BIGINT is always found: the
+// driver returns values above math.MaxInt64 as text from prepared
+// statements and as numbers otherwise, so they are read into uint64 alike.
+func bigIntColumns(rows *sql.Rows, columns []string, mode string) ([]bigIntColumn, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("columns error: %v", err)
+	}
+	var kinds []bigIntColumn
+	for i, t := range types {
+		kind := notBigInt
+		switch t.DatabaseTypeName() {
+		case "UNSIGNED BIGINT":
+			kind = unsignedBigInt
+		case "BIGINT":
+			if mode != "" && mode != "number" {
+				kind = signedBigInt
+			}
+		}
+		if kind != notBigInt {
+			if kinds == nil {
+				kinds = make([]bigIntColumn, len(columns))
+			}
+			kinds[i] = kind
+		}
+	}
+	return kinds, nil
+}
+
+// convert returns a scanned BIGINT value as uint64 or int64, or under
+// big_int_mode (mode) safe and string as its decimal text.
+func (k bigIntColumn) convert(mode, col string, v interface{}) (interface{}, error) {
+	var text string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		text = string(v)
+	default:
+		text = fmt.Sprint(v)
+	}
+	var n interface{}
+	safe := false
+	if k == unsignedBigInt {
+		u, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %s holds %q, which is not an unsigned BIGINT", col, text)
+		}
+		n, safe = u, u <= maxSafeInteger
+	} else {
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %s holds %q, which is not a BIGINT", col, text)
+		}
+		n, safe = i, i <= maxSafeInteger && i >= -maxSafeInteger
+	}
+	if mode == "string" || (mode == "safe" && !safe) {
+		return text, nil
+	}
+	return n, nil
+}
+
+// exactInteger returns the integer in a JSON number beyond 2^53 as int64 or
+// uint64, which json.Unmarshal would round through float64.
+func exactInteger(raw json.RawMessage) (interface{}, bool) {
+	text := strings.TrimSpace(string(raw))
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, i > maxSafeInteger || i < -maxSafeInteger
+	}
+	if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+		return u, true
+	}
+	return nil, false
+}
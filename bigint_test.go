@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+)
+
+var idColumns = []stubColumn{{"id", "UNSIGNED BIGINT"}, {"delta", "BIGINT"}}
+
+func TestUnsignedBigIntRoundTrip(t *testing.T) {
+	max := strconv.FormatUint(math.MaxUint64, 10)
+	tests := []struct {
+		mode       string
+		parameters string
+		want       string
+	}{
+		{"", `[18446744073709551615, -9223372036854775808]`, `[{"delta":-9223372036854775808,"id":18446744073709551615}]`},
+		{"", `["18446744073709551615", 1]`, `[{"delta":1,"id":18446744073709551615}]`},
+		{"", `[9007199254740993, 9007199254740993]`, `[{"delta":9007199254740993,"id":9007199254740993}]`},
+		{"", `[9223372036854775808, 0]`, `[{"delta":0,"id":9223372036854775808}]`},
+		{"safe", `[9007199254740992, -9007199254740993]`, `[{"delta":"-9007199254740993","id":9007199254740992}]`},
+		{"safe", `[` + max + `, 9007199254740993]`, `[{"delta":"9007199254740993","id":"` + max + `"}]`},
+		{"string", `[42, -42]`, `[{"delta":"-42","id":"42"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.parameters, func(t *testing.T) {
+			if got := roundTrip(t, settings{bigIntMode: tt.mode}, idColumns, tt.parameters); got != tt.want {
+				t.Errorf("came back as %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsignedBigIntParameters(t *testing.T) {
+	args, err := parseArgs(`[18446744073709551615, 9223372036854775808, 9007199254740993, -9007199254740993, 9007199254740992]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{uint64(math.MaxUint64), uint64(1 << 63), int64(1<<53 + 1), int64(-(1<<53 + 1)), float64(1 << 53)}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("argument %d = %#v, want %#v", i, args[i], want[i])
+		}
+	}
+}
+
+func TestUnsignedBigIntFromDriverNumbers(t *testing.T) {
+	// Without prepared statements the driver returns UNSIGNED BIGINT as a
+	// number, and as text beyond math.MaxInt64 from prepared ones.
+	for _, v := range []interface{}{int64(42), uint64(math.MaxUint64), []byte("18446744073709551615")} {
+		got, err := unsignedBigInt.convert("", "id", v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(got)
+		if want := strconv.FormatUint(toUint(t, v), 10); string(b) != want {
+			t.Errorf("convert(%v) encodes as %s, want %s", v, b, want)
+		}
+	}
+	if _, err := unsignedBigInt.convert("", "id", []byte("18446744073709551616")); err == nil {
+		t.Error("a value beyond math.MaxUint64 was accepted")
+	}
+}
+
+func toUint(t *testing.T, v interface{}) uint64 {
+	t.Helper()
+	switch v := v.(type) {
+	case int64:
+		return uint64(v)
+	case uint64:
+		return v
+	case []byte:
+		u, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	t.Fatalf("unexpected %T", v)
+	return 0
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// binlogPosition is a set of binary log coordinates, as returned by
+// data_type=binlog_position and capture_binlog_position.
+type binlogPosition struct {
+	File         string `json:"file"`
+	Position     int64  `json:"position"`
+	GTIDExecuted string `json:"gtid_executed,omitempty"`
+
+	// Consistent is set when the coordinates are exactly those of the
+	// snapshot the rows were read from; Caveat explains when they are not.
+	Consistent bool   `json:"consistent"`
+	Caveat     string `json:"caveat,omitempty"`
+}
+
+// binaryLogStatus is the statement reporting the current coordinates:
+// MySQL 8.2 renamed SHOW MASTER STATUS.
+func (v serverVersion) binaryLogStatus() string {
+	if !v.MariaDB && v.atLeast(8, 2) {
+		return "SHOW BINARY LOG STATUS"
+	}
+	return "SHOW MASTER STATUS"
+}
+
+// binlogPositionOp implements data_type=binlog_position.
+func binlogPositionOp(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	v, err := db.serverVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return readBinlogPosition(ctx, db, v, false)
+}
+
+// readBinlogPosition reads the coordinates. Inside a consistent snapshot
+// MariaDB reports the coordinates of the snapshot itself; MySQL only has the
+// current ones, read immediately after the snapshot started.
+func readBinlogPosition(ctx context.Context, q execer, v serverVersion, inSnapshot bool) (*binlogPosition, error) {
+	if inSnapshot && v.MariaDB {
+		status, err := queryStatus(ctx, q, "SHOW STATUS LIKE 'binlog_snapshot_%'")
+		if err != nil {
+			return nil, binlogError(v, err)
+		}
+		if status["binlog_snapshot_file"] == "" {
+			return nil, fmt.Errorf("binary logging is disabled on the server (log_bin=OFF)")
+		}
+		pos := &binlogPosition{File: status["binlog_snapshot_file"], Consistent: true}
+		fmt.Sscan(status["binlog_snapshot_position"], &pos.Position)
+		return pos, nil
+	}
+
+	rows, err := q.QueryContext(ctx, v.binaryLogStatus())
+	if err != nil {
+		return nil, binlogError(v, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, binlogError(v, err)
+		}
+		return nil, fmt.Errorf("binary logging is disabled on the server (log_bin=OFF)")
+	}
+	values := make([]sql.NullString, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return nil, err
+	}
+	pos := &binlogPosition{}
+	for i, col := range columns {
+		switch col {
+		case "File":
+			pos.File = values[i].String
+		case "Position":
+			fmt.Sscan(values[i].String, &pos.Position)
+		case "Executed_Gtid_Set":
+			pos.GTIDExecuted = values[i].String
+		}
+	}
+	if v.MariaDB {
+		// MariaDB keeps its GTID position in a variable instead.
+		var gtid sql.NullString
+		if q.QueryRowContext(ctx, "SELECT @@gtid_binlog_pos").Scan(&gtid) == nil {
+			pos.GTIDExecuted = gtid.String
+		}
+	}
+	if inSnapshot {
+		pos.Caveat = "MySQL cannot report the coordinates of a snapshot: these were read right after it started, so transactions committed in between are missing from the rows yet precede the position. Start change capture from an earlier position and apply it idempotently, or extract during a write pause."
+	}
+	return pos, nil
+}
+
+// binlogError turns the missing privilege error into a privilegeError naming
+// the privilege the server wants.
+func binlogError(v serverVersion, err error) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1227 { // ER_SPECIFIC_ACCESS_DENIED_ERROR
+		privilege := "REPLICATION CLIENT"
+		if v.MariaDB && v.atLeast(10, 5) {
+			privilege = "BINLOG MONITOR"
+		}
+		return &privilegeError{privilegeReport{Schema: "*", Required: []string{privilege}, Missing: []string{privilege}}}
+	}
+	return fmt.Errorf("failed to read the binary log position: %v", err)
+}
+
+// queryStatus reads a SHOW STATUS or SHOW VARIABLES result into a map.
+func queryStatus(ctx context.Context, q execer, query string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	status := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		status[name] = value
+	}
+	return status, rows.Err()
+}
+
+// consistentSnapshot describes the transaction consistent_snapshot ran the
+// steps in.
+type consistentSnapshot struct {
+	StartedAt      string          `json:"started_at"` // UTC
+	BinlogPosition *binlogPosition `json:"binlog_position,omitempty"`
+}
+
+// beginConsistentSnapshot starts a read-only REPEATABLE READ transaction
+// WITH CONSISTENT SNAPSHOT on conn. The caller ends the transaction.
+func beginConsistentSnapshot(ctx context.Context, conn *sql.Conn) error {
+	for _, stmt := range []string{
+		"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to start a consistent snapshot: %v", err)
+		}
+	}
+	return nil
+}
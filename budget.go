@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Conservative result size limits; max_row_bytes and max_result_bytes
+// raise or lower them per invocation.
+const (
+	defaultMaxRowBytes    = 16 << 20
+	defaultMaxResultBytes = 256 << 20
+)
+
+// resultBudget bounds the encoded size of scanned rows so one oversized
+// column or result cannot exhaust memory. Sizes are estimated from the
+// scanned values, close to their JSON encoding without encoding them.
+type resultBudget struct {
+	maxRow, maxTotal int64
+	total, rows      int64
+}
+
+func newResultBudget(cfg settings) *resultBudget {
+	b := &resultBudget{maxRow: cfg.maxRowBytes, maxTotal: cfg.maxResultBytes}
+	if b.maxRow == 0 {
+		b.maxRow = defaultMaxRowBytes
+	}
+	if b.maxTotal == 0 {
+		b.maxTotal = defaultMaxResultBytes
+	}
+	return b
+}
+
+// resultTooLargeError stops a scan that went over budget. Rows is how many
+// rows were kept before it.
+type resultTooLargeError struct {
+	rows   int64
+	column string // the largest column of an oversized row
+	size   int64
+	limit  int64
+}
+
+func (e *resultTooLargeError) Error() string {
+	if e.column != "" {
+		return fmt.Sprintf("result too large: row %d is about %d bytes, over max_row_bytes=%d (largest column %q); stopped after %d rows",
+			e.rows+1, e.size, e.limit, e.column, e.rows)
+	}
+	return fmt.Sprintf("result too large: row %d brings the result to about %d bytes, over max_result_bytes=%d; stopped after %d rows",
+		e.rows+1, e.size, e.limit, e.rows)
+}
+
+// add accounts for one row and fails when it breaks either limit.
+func (b *resultBudget) add(columns []string, values []interface{}) error {
+	var size, largest int64
+	column := ""
+	for i, v := range values {
+		n := int64(len(columns[i])) + valueSize(v) + 4 // quotes, colon, comma
+		size += n
+		if n > largest {
+			largest, column = n, columns[i]
+		}
+	}
+	if size > b.maxRow {
+		return &resultTooLargeError{rows: b.rows, column: column, size: size, limit: b.maxRow}
+	}
+	if b.total+size > b.maxTotal {
+		return &resultTooLargeError{rows: b.rows, size: b.total + size, limit: b.maxTotal}
+	}
+	b.total += size
+	b.rows++
+	return nil
+}
+
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 4
+	case []byte:
+		return int64(len(v)) + 2
+	case string:
+		return int64(len(v)) + 2
+	case json.Number:
+		return int64(len(v))
+	case time.Time:
+		return 27
+	case int64:
+		return int64(len(strconv.FormatInt(v, 10)))
+	case float64:
+		return int64(len(strconv.FormatFloat(v, 'g', -1, 64)))
+	}
+	return 8
+}
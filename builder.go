@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ident is an identifier validated and quoted for splicing into SQL. Only
+// the constructors below make one, so a stmtBuilder can never be handed a
+// name that was not checked.
+type ident struct {
+	sql string
+}
+
+// String returns the quoted name, as error messages show it.
+func (id ident) String() string { return id.sql }
+
+// columnIdent validates and quotes a single identifier, such as a column.
+func columnIdent(name string) (ident, error) {
+	q, err := quoteIdent(name)
+	return ident{q}, err
+}
+
+// tableIdent validates and quotes a possibly schema-qualified table name.
+func tableIdent(name string) (ident, error) {
+	q, err := quoteQualifiedIdent(name)
+	return ident{q}, err
+}
+
+// qualifiedColumnIdent validates and quotes a column that may be qualified
+// by its table, such as t.status in a filter.
+func qualifiedColumnIdent(name string) (ident, error) {
+	q, err := quoteQualifiedIdent(name)
+	return ident{q}, err
+}
+
+// columnIdents validates and quotes a list of columns.
+func columnIdents(names []string) ([]ident, error) {
+	ids := make([]ident, len(names))
+	for i, name := range names {
+		var err error
+		if ids[i], err = columnIdent(name); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// routineIdent validates a stored procedure or function name, quoted as
+// routineName decides.
+func routineIdent(name string) (ident, error) {
+	q, err := routineName(name)
+	return ident{q}, err
+}
+
+// sqlText is SQL the program wrote itself, such as keywords, or built from
+// validated parts, such as a filter's WHERE clause. Untyped constants
+// convert to it implicitly; a string variable needs a conversion, which
+// marks where text from elsewhere joins a statement.
+type sqlText string
+
+// stmtBuilder assembles a statement from sqlText, idents and placeholder
+// arguments. Names only enter the SQL as idents and values only as
+// arguments.
+type stmtBuilder struct {
+	sql  strings.Builder
+	args []interface{}
+}
+
+func (b *stmtBuilder) text(s sqlText) *stmtBuilder {
+	b.sql.WriteString(string(s))
+	return b
+}
+
+func (b *stmtBuilder) ident(id ident) *stmtBuilder {
+	b.sql.WriteString(id.sql)
+	return b
+}
+
+// idents writes ids separated by commas.
+func (b *stmtBuilder) idents(ids []ident) *stmtBuilder {
+	for i, id := range ids {
+		if i > 0 {
+			b.sql.WriteString(", ")
+		}
+		b.sql.WriteString(id.sql)
+	}
+	return b
+}
+
+// number writes n, a count the program computed, such as a LIMIT.
+func (b *stmtBuilder) number(n int64) *stmtBuilder {
+	b.sql.WriteString(strconv.FormatInt(n, 10))
+	return b
+}
+
+// literal writes s as a quoted string, for the statements that take no
+// placeholders, such as account management and DDL defaults.
+func (b *stmtBuilder) literal(s string) *stmtBuilder {
+	b.sql.WriteString(quoteString(s))
+	return b
+}
+
+// where writes the WHERE clause of f, if it has one, and adds its
+// arguments.
+func (b *stmtBuilder) where(f filter) *stmtBuilder {
+	b.sql.WriteString(f.where())
+	return b.arg(f.Args...)
+}
+
+// arg adds the arguments of placeholders the SQL written so far holds.
+func (b *stmtBuilder) arg(args ...interface{}) *stmtBuilder {
+	b.args = append(b.args, args...)
+	return b
+}
+
+// values writes one comma-separated placeholder per argument and adds the
+// arguments.
+func (b *stmtBuilder) values(args []interface{}) *stmtBuilder {
+	b.sql.WriteString(placeholders(len(args)))
+	return b.arg(args...)
+}
+
+// build returns the SQL and arguments of a statement run directly.
+func (b *stmtBuilder) build() (string, []interface{}) {
+	return b.sql.String(), b.args
+}
+
+func (b *stmtBuilder) statement(isSelect bool) statement {
+	return statement{SQL: b.sql.String(), Args: b.args, IsSelect: isSelect}
+}
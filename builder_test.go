@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// unquoteIdent reverses quoteIdent.
+func unquoteIdent(q string) string {
+	return strings.ReplaceAll(q[1:len(q)-1], "``", "`")
+}
+
+func FuzzQuoteIdent(f *testing.F) {
+	for _, seed := range []string{"invoices", "a`b", "``", "x' OR 1=1 -- ", "a.b", "/*", "ünï", "?"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		q, err := quoteIdent(name)
+		if err != nil {
+			return
+		}
+		toks := tokenize(q)
+		if len(toks) != 1 || toks[0].kind != tokQuotedIdent || toks[0].text != q {
+			t.Fatalf("quoteIdent(%q) = %q lexes as %v, want one quoted identifier", name, q, toks)
+		}
+		if got := unquoteIdent(q); got != name {
+			t.Fatalf("quoteIdent(%q) = %q names %q", name, q, got)
+		}
+	})
+}
+
+// nameTokens reads the identifier tokens of a possibly qualified name from
+// toks, returning the parts they name and the tokens after them.
+func nameTokens(t *testing.T, toks []token) ([]string, []token) {
+	t.Helper()
+	var parts []string
+	for {
+		if len(toks) == 0 {
+			t.Fatal("statement ends before its object name")
+		}
+		switch tok := toks[0]; tok.kind {
+		case tokQuotedIdent:
+			parts = append(parts, unquoteIdent(tok.text))
+		case tokWord:
+			if !validPrefix(tok.text) {
+				t.Fatalf("bare name %q is not a plain identifier", tok.text)
+			}
+			parts = append(parts, tok.text)
+		default:
+			t.Fatalf("object name token %q is no identifier", tok.text)
+		}
+		toks = toks[1:]
+		if len(toks) == 0 || toks[0].text != "." {
+			return parts, toks
+		}
+		toks = toks[1:]
+	}
+}
+
+// significant drops the whitespace tokens.
+func significant(sql string) []token {
+	var toks []token
+	for _, tok := range tokenize(sql) {
+		if tok.kind != tokSpace {
+			toks = append(toks, tok)
+		}
+	}
+	return toks
+}
+
+func FuzzBuildStatement(f *testing.F) {
+	for _, seed := range []string{"invoices", "erp.invoices", "`erp`.`in``voices`", "abs", "t; DROP TABLE t", "a`b", "x()", "1e5", "a.1e5", "p(1); --"} {
+		for kind := uint8(0); kind < 3; kind++ {
+			f.Add(seed, kind, `[1, "a"]`)
+		}
+	}
+	dataTypes := []string{"table", "stored_procedure", "stored_function"}
+	f.Fuzz(func(t *testing.T, objectName string, kind uint8, parameters string) {
+		cfg := settings{dataType: dataTypes[int(kind)%len(dataTypes)], objectName: objectName, parameters: parameters}
+		stmt, err := buildStatement(cfg)
+		if err != nil {
+			return
+		}
+		toks := significant(stmt.SQL)
+		var head []string
+		switch cfg.dataType {
+		case "table":
+			head = []string{"SELECT", "*", "FROM"}
+		case "stored_procedure":
+			head = []string{"CALL"}
+		default:
+			head = []string{"SELECT"}
+		}
+		for _, want := range head {
+			if len(toks) == 0 || toks[0].text != want {
+				t.Fatalf("%q does not start with %v", stmt.SQL, head)
+			}
+			toks = toks[1:]
+		}
+		parts, rest := nameTokens(t, toks)
+		if want := splitQualified(objectName); strings.Join(parts, "\x00") != strings.Join(want, "\x00") {
+			t.Fatalf("%q names %q, want %q", stmt.SQL, parts, want)
+		}
+		if cfg.dataType == "table" {
+			if len(rest) != 0 {
+				t.Fatalf("%q has %v after the table name", stmt.SQL, rest)
+			}
+			return
+		}
+		placeholders := 0
+		for i, tok := range rest {
+			switch {
+			case i == 0 && tok.text == "(", i == len(rest)-1 && tok.text == ")":
+			case tok.kind == tokPlaceholder:
+				placeholders++
+			case tok.text == ",":
+			default:
+				t.Fatalf("%q has %q in its argument list", stmt.SQL, tok.text)
+			}
+		}
+		if len(rest) < 2 || placeholders != len(stmt.Args) {
+			t.Fatalf("%q has %d placeholders for %d arguments", stmt.SQL, placeholders, len(stmt.Args))
+		}
+	})
+}
+
+// nameShape is the significant tokens of sql with every possibly qualified
+// quoted name collapsed into one token, so two statements built from
+// different names have the same shape when the names changed nothing but
+// themselves.
+func nameShape(sql string) []token {
+	var shape []token
+	toks := significant(sql)
+	for i := 0; i < len(toks); i++ {
+		if toks[i].kind != tokQuotedIdent {
+			shape = append(shape, token{kind: toks[i].kind, text: toks[i].text})
+			continue
+		}
+		for i+2 < len(toks) && toks[i+1].text == "." && toks[i+2].kind == tokQuotedIdent {
+			i += 2
+		}
+		shape = append(shape, token{kind: tokQuotedIdent, text: "`_`"})
+	}
+	return shape
+}
+
+// sameShape fails unless got, built from fuzzed names, has the shape of
+// want, built from plain ones.
+func sameShape(t *testing.T, got, want string) {
+	t.Helper()
+	g, w := nameShape(got), nameShape(want)
+	if len(g) != len(w) {
+		t.Fatalf("%q has %d tokens, %q has %d", got, len(g), want, len(w))
+	}
+	for i := range g {
+		if g[i] != w[i] {
+			t.Fatalf("%q has %q where %q has %q", got, g[i].text, want, w[i].text)
+		}
+	}
+}
+
+// plainNames returns the stand-ins of the fuzzed columns a and b, ordered
+// as they are since values and filter objects render in column order. It
+// fails when the columns are the same, or when either is the stub's
+// primary key, as the stand-ins would not be, and for names JSON inputs
+// cannot carry.
+func plainNames(a, b string) (string, string, bool) {
+	if !utf8.ValidString(a) || !utf8.ValidString(b) || strings.EqualFold(a, b) || strings.EqualFold(a, "id") || strings.EqualFold(b, "id") {
+		return "", "", false
+	}
+	if a < b {
+		return "a", "b", true
+	}
+	return "b", "a", true
+}
+
+// oneWord reports whether name reads as itself in an order term, which
+// splits on whitespace.
+func oneWord(name string) bool {
+	fields := strings.Fields(name)
+	return len(fields) == 1 && fields[0] == name
+}
+
+// writeInputs returns the inputs of the generated statements naming table
+// and the columns a and b. Order terms are only written with terms set.
+func writeInputs(table, a, b string, terms bool) []settings {
+	var order []string
+	if terms {
+		order = []string{a + " desc", b}
+	}
+	values, _ := json.Marshal(map[string]interface{}{a: 1, b: "x"})
+	rows, _ := json.Marshal([]map[string]interface{}{{a: 1, b: nil}, {a: 2, b: "y"}})
+	filter, _ := json.Marshal([]filterCondition{
+		{Column: a, Op: "=", Value: 1},
+		{Column: b, Op: "in", Value: []interface{}{1, "x"}},
+		{Column: a, Op: "between", Value: []interface{}{1, 2}},
+		{Column: b, Op: "is_null"},
+	})
+	object, _ := json.Marshal(map[string]interface{}{a: []interface{}{1, 2}, b: nil})
+	window, _ := json.Marshal([]windowSpec{
+		{Fn: "sum", Column: a, PartitionBy: []string{b, a}, OrderBy: order, As: b},
+		{Fn: "row_number", PartitionBy: []string{a}, OrderBy: order, As: a, Frame: "rows_whole_partition"},
+	})
+	return []settings{
+		{dataType: "insert", objectName: table, values: string(rows)},
+		{dataType: "insert", objectName: table, values: string(values), tenantColumn: b, tenantValue: "x"},
+		{dataType: "update", objectName: table, values: string(values), filter: string(filter)},
+		{dataType: "update", objectName: table, values: string(values), filter: string(object), tenantColumn: a, tenantValue: "1"},
+		{dataType: "delete", objectName: table, filter: string(filter)},
+		{dataType: "delete", objectName: table, filter: string(object), softDeleteColumn: b},
+		{dataType: "table", objectName: table, filter: string(filter), window: string(window)},
+		{dataType: "table", objectName: table, filter: string(object), softDeleteColumn: a, tenantColumn: b, tenantValue: "x", limit: 10, offset: 5},
+	}
+}
+
+func FuzzGeneratedStatements(f *testing.F) {
+	for _, seed := range [][3]string{
+		{"invoices", "status", "total"},
+		{"erp.invoices", "t.status", "`total`"},
+		{"a`b", "x` = 1 OR `y", "?"},
+		{"t; DROP TABLE t", "a) OR (1=1", "b /*"},
+		{"invoices", "a desc", "b, c"},
+	} {
+		f.Add(seed[0], seed[1], seed[2])
+	}
+	f.Fuzz(func(t *testing.T, table, a, b string) {
+		plainA, plainB, ok := plainNames(a, b)
+		if !ok {
+			return
+		}
+		terms := oneWord(a) && oneWord(b)
+		plain := writeInputs("t", plainA, plainB, terms)
+		for i, cfg := range writeInputs(table, a, b, terms) {
+			stmt, err := buildStatement(cfg)
+			if err != nil {
+				continue
+			}
+			want, err := buildStatement(plain[i])
+			if err != nil {
+				t.Fatalf("input %d: %v", i, err)
+			}
+			sameShape(t, stmt.SQL, want.SQL)
+			placeholders := 0
+			for _, tok := range significant(stmt.SQL) {
+				if tok.kind == tokPlaceholder {
+					placeholders++
+				}
+			}
+			if placeholders != len(stmt.Args) {
+				t.Fatalf("%q has %d placeholders for %d arguments", stmt.SQL, placeholders, len(stmt.Args))
+			}
+		}
+
+		if !terms {
+			return
+		}
+		src := fmt.Sprintf(`SELECT * FROM t WHERE x = :x {{orderby "sort" allowed "%s, %s" default "%s"}}`, a, b, b)
+		plainSrc := fmt.Sprintf(`SELECT * FROM t WHERE x = :x {{orderby "sort" allowed "%s, %s" default "%s"}}`, plainA, plainB, plainB)
+		for _, dir := range []string{"", " desc"} {
+			sql, _, err := renderTemplate(src, map[string]interface{}{"x": 1, "sort": a + dir}, "")
+			if err != nil {
+				continue
+			}
+			want, _, err := renderTemplate(plainSrc, map[string]interface{}{"x": 1, "sort": plainA + dir}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameShape(t, sql, want)
+		}
+	})
+}
+
+// operationInputs returns the inputs of the operations that query a table
+// named table with the columns a and b.
+func operationInputs(table, a, b string) []settings {
+	set, _ := json.Marshal(map[string]interface{}{a: "claimed"})
+	filter, _ := json.Marshal(map[string]interface{}{a: "new", b: 1})
+	columns, _ := json.Marshal([]string{a, b})
+	return []settings{
+		{dataType: "claim", objectName: table, claimSet: string(set), filter: string(filter), claimedAtColumn: b, orderColumn: b, requeueStaleAfter: 60, limit: 5},
+		{dataType: "duplicates", objectName: table, columns: string(columns), collation: "utf8mb4_bin"},
+		{dataType: "kv_get", objectName: table, keyColumn: a, valueColumn: b, key: "k", scopeFilter: string(filter)},
+		{dataType: "kv_set", objectName: table, keyColumn: a, valueColumn: b, key: "k", value: "v", scopeFilter: `{"id": 1}`},
+	}
+}
+
+// operationServer answers the primary key lookup with id and the locking
+// read of claim with one row; any other query returns no rows.
+func operationServer(query string) ([]stubColumn, [][]driver.Value) {
+	switch {
+	case strings.Contains(query, "information_schema.key_column_usage"):
+		return []stubColumn{{"column_name", "VARCHAR"}}, [][]driver.Value{{[]byte("id")}}
+	case strings.Contains(query, "SKIP LOCKED"):
+		return []stubColumn{{"id", "INT"}}, [][]driver.Value{{[]byte("1")}}
+	}
+	return []stubColumn{{"id", "INT"}}, nil
+}
+
+// sentStatements runs the operation of cfg against a stub server and
+// returns the statements it sent.
+func sentStatements(t *testing.T, cfg settings) ([]string, error) {
+	ops := map[string]func(context.Context, *database, settings, *Output) (interface{}, error){
+		"claim": claim, "duplicates": findDuplicates, "kv_get": kvGet, "kv_set": kvSet,
+	}
+	db, stub := openStubDB(t)
+	stub.respond = operationServer
+	version := parseServerVersion("8.0.31")
+	if _, err := ops[cfg.dataType](context.Background(), &database{DB: db, version: &version}, cfg, &Output{}); err != nil {
+		return nil, err
+	}
+	return stub.sent(), nil
+}
+
+func FuzzGeneratedOperations(f *testing.F) {
+	for _, seed := range [][3]string{
+		{"jobs", "status", "claimed_at"},
+		{"erp.jobs", "`status`", "x` = 1 OR `y"},
+		{"jobs; --", "a) OR (1=1", "?"},
+	} {
+		f.Add(seed[0], seed[1], seed[2])
+	}
+	f.Fuzz(func(t *testing.T, table, a, b string) {
+		plainA, plainB, ok := plainNames(a, b)
+		if !ok {
+			return
+		}
+		plain := operationInputs("t", plainA, plainB)
+		for i, cfg := range operationInputs(table, a, b) {
+			sent, err := sentStatements(t, cfg)
+			if err != nil {
+				continue
+			}
+			want, err := sentStatements(t, plain[i])
+			if err != nil {
+				t.Fatalf("%s: %v", cfg.dataType, err)
+			}
+			if len(sent) != len(want) {
+				t.Fatalf("%s sent %q, want the statements of %q", cfg.dataType, sent, want)
+			}
+			for j := range sent {
+				sameShape(t, sent[j], want[j])
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// resultCache stores read results on disk, one JSON file per fingerprint.
+type resultCache struct {
+	path string
+	ttl  time.Duration
+}
+
+type cacheEntry struct {
+	CreatedAt int64           `json:"created_at"`
+	Result    json.RawMessage `json:"result"`
+}
+
+func newResultCache(dir string, ttlSeconds int, key string) *resultCache {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "mysql-plugin-cache")
+	}
+	return &resultCache{
+		path: filepath.Join(dir, key+".json"),
+		ttl:  time.Duration(ttlSeconds) * time.Second,
+	}
+}
+
+// cacheKey fingerprints the target database (without the password), the
+// statement text, its arguments and the row post-processing. Only the
+// whitespace between tokens is normalized: literals are kept so queries
+// differing in a constant, spacing included, never share an entry.
+func cacheKey(username, host string, port int, dbname string, stmt statement, shape string) string {
+	args, _ := json.Marshal(stmt.Args)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s@%s:%d/%s\n", username, host, port, dbname)
+	fmt.Fprintf(h, "%s\n", normalizeSpace(stmt.SQL))
+	h.Write(args)
+	if shape != "" {
+		fmt.Fprintf(h, "\n%s", shape)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeSpace collapses each run of whitespace between tokens to one
+// space. Whitespace inside strings, quoted identifiers and comments is
+// part of the token and stays as written.
+func normalizeSpace(sql string) string {
+	var b strings.Builder
+	for _, t := range tokenize(sql) {
+		if t.kind == tokSpace {
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			continue
+		}
+		b.WriteString(t.text)
+	}
+	return strings.TrimSuffix(b.String(), " ")
+}
+
+// resultCacheFor returns the cache entry of stmt under cfg. Besides the
+// statement the key covers what shapes the rows and the session state a
+// raw query can read, so tenants never share an entry.
+func resultCacheFor(cfg settings, stmt statement) *resultCache {
+	scan := cfg.scanning()
+	shape := cfg.shapeKey() + cfg.sessionKey() + scan.masking.key() + scan.decimals.key()
+	return newResultCache(cfg.cacheDir, cfg.cacheTTL, cacheKey(cfg.username, cfg.host, cfg.port, cfg.dbname, stmt, shape))
+}
+
+// sessionKey identifies the session state set on every connection that a
+// statement can read without naming it in its text: @tenant_value, which
+// raw queries filter on, and the session variables of dsn parameters.
+func (cfg settings) sessionKey() string {
+	var b strings.Builder
+	if cfg.tenantColumn != "" {
+		fmt.Fprintf(&b, "\ntenant=%q,%q", cfg.tenantColumn, cfg.tenantValue)
+	}
+	if _, params, ok := strings.Cut(cfg.dsn, "?"); ok {
+		fmt.Fprintf(&b, "\ndsn_params=%s", params)
+	}
+	return b.String()
+}
+
+// get returns the cached result when a fresh entry exists.
+func (c *resultCache) get() (interface{}, bool) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if time.Since(time.Unix(entry.CreatedAt, 0)) > c.ttl {
+		return nil, false
+	}
+	return entry.Result, true
+}
+
+// put writes the entry atomically so concurrent readers never observe a
+// partially written cache file.
+func (c *resultCache) put(result interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(cacheEntry{CreatedAt: time.Now().Unix(), Result: data})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, entry)
+}
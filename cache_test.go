@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResultCacheSeparatesTenants(t *testing.T) {
+	dir := t.TempDir()
+	stmt := statement{SQL: "SELECT * FROM invoices WHERE company_id = @tenant_value", IsSelect: true}
+	tenant := func(value string) settings {
+		return settings{username: "app", host: "db.internal", port: 3306, dbname: "erp", cacheDir: dir, cacheTTL: 60, tenantColumn: "company_id", tenantValue: value}
+	}
+	if err := resultCacheFor(tenant("A"), stmt).put([]map[string]interface{}{{"id": 1, "company_id": "A"}}); err != nil {
+		t.Fatal(err)
+	}
+	if result, ok := resultCacheFor(tenant("B"), stmt).get(); ok {
+		t.Fatalf("tenant B was served tenant A's rows: %s", result)
+	}
+	if _, ok := resultCacheFor(tenant("A"), stmt).get(); !ok {
+		t.Error("tenant A misses its own entry")
+	}
+
+	dsn := func(params string) settings {
+		return settings{username: "app", host: "db.internal", port: 3306, dbname: "erp", cacheDir: dir, cacheTTL: 60, dsn: "app@tcp(db.internal)/erp?" + params}
+	}
+	if err := resultCacheFor(dsn("company=%27A%27"), stmt).put([]map[string]interface{}{{"id": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resultCacheFor(dsn("company=%27B%27"), stmt).get(); ok {
+		t.Error("connections with different dsn session variables share an entry")
+	}
+}
+
+func TestCacheKeyWhitespace(t *testing.T) {
+	key := func(sql string) string {
+		return cacheKey("app", "db.internal", 3306, "erp", statement{SQL: sql}, "")
+	}
+	if key("SELECT * FROM t WHERE code = 'A  1'") == key("SELECT * FROM t WHERE code = 'A 1'") {
+		t.Error("literals differing in whitespace share a key")
+	}
+	if key("SELECT `a  b` FROM t") == key("SELECT `a b` FROM t") {
+		t.Error("quoted identifiers differing in whitespace share a key")
+	}
+	if key("SELECT *\n  FROM t\tWHERE id = 1 ") != key("SELECT * FROM t WHERE id = 1") {
+		t.Error("whitespace between tokens changes the key")
+	}
+}
+
+func TestCacheKeyZeroDateMode(t *testing.T) {
+	stmt := statement{SQL: "SELECT created_at FROM t"}
+	keys := map[string]string{}
+	for _, mode := range []string{"", "null", "string"} {
+		cfg := settings{username: "app", host: "db.internal", port: 3306, dbname: "erp", cacheDir: t.TempDir(), zeroDateMode: mode}
+		key := cacheKey(cfg.username, cfg.host, cfg.port, cfg.dbname, stmt, cfg.shapeKey())
+		if other, ok := keys[key]; ok {
+			t.Errorf("zero_date_mode %q and %q share a key", mode, other)
+		}
+		keys[key] = mode
+	}
+}
+
+func TestTemporalReplacementsAreReported(t *testing.T) {
+	scan := newTemporalScan(settings{zeroDateMode: "null"})
+	scan.convert("created_at", 0, "0000-00-00")
+	if scan.replaced() {
+		t.Error("a zero date counts as an invalid value")
+	}
+	scan.convert("created_at", 1, "2024-02-30")
+	if !scan.replaced() {
+		t.Fatal("an invalid date is not reported, so its result would be cached")
+	}
+	scan.flush()
+	if scan.replaced() {
+		t.Error("flush keeps the replacement")
+	}
+}
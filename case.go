@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// columnCases lists the column_case values.
+var columnCases = map[string]bool{"as_is": true, "camel": true, "pascal": true, "snake": true}
+
+// splitWords breaks a column name into words at underscores and at case
+// changes, so snake_case and camelCase names split alike: user_id, userId
+// and UserID all give [user id]. Digits stay with the word before them.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := -1
+	flush := func(end int) {
+		if start >= 0 && end > start {
+			words = append(words, strings.ToLower(string(runes[start:end])))
+		}
+		start = -1
+	}
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ':
+			flush(i)
+			continue
+		case start < 0:
+			start = i
+		case unicode.IsUpper(r):
+			prev := runes[i-1]
+			// A new word starts at lower->Upper (userId) and at the last
+			// capital of an acronym followed by lower case (HTTPServer).
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				flush(i)
+				start = i
+			}
+		}
+	}
+	flush(len(runes))
+	return words
+}
+
+// convertCase renders name in the given column_case.
+func convertCase(name, style string) string {
+	words := splitWords(name)
+	if len(words) == 0 || style == "" || style == "as_is" {
+		return name
+	}
+	if style == "snake" {
+		return strings.Join(words, "_")
+	}
+	var b strings.Builder
+	for i, w := range words {
+		if i == 0 && style == "camel" {
+			b.WriteString(w)
+			continue
+		}
+		r := []rune(w)
+		b.WriteString(strings.ToUpper(string(r[0])) + string(r[1:]))
+	}
+	return b.String()
+}
+
+// caseKeys maps each column to its converted name. Names that would
+// collide are suffixed with 2, 3, ... (_2 for snake); a column already in
+// the target form keeps the plain name, the rest follow in name order.
+func caseKeys(columns []string, style string) (map[string]string, []string) {
+	sorted := append([]string(nil), columns...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ci, cj := convertCase(sorted[i], style) == sorted[i], convertCase(sorted[j], style) == sorted[j]
+		if ci != cj {
+			return ci
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	keys := make(map[string]string, len(columns))
+	taken := map[string]string{}
+	var warnings []string
+	sep := ""
+	if style == "snake" {
+		sep = "_"
+	}
+	for _, col := range sorted {
+		key := convertCase(col, style)
+		if first, ok := taken[key]; ok {
+			base := key
+			for n := 2; ; n++ {
+				if key = fmt.Sprintf("%s%s%d", base, sep, n); taken[key] == "" {
+					break
+				}
+			}
+			warnings = append(warnings, fmt.Sprintf("column_case: %q and %q both convert to %q; %q is renamed to %q", first, col, base, col, key))
+		}
+		taken[key] = col
+		keys[col] = key
+	}
+	return keys, warnings
+}
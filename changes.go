@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// highWatermarkColumn carries MAX(watermark_column) of the returned rows on
+// every row; it is removed before the rows are returned.
+const highWatermarkColumn = "_changes_high_watermark"
+
+// changes implements data_type=changes: the rows of object_name whose
+// watermark_column is at or after since, oldest first or, with
+// descending=true, newest first, up to limit rows.
+//
+// The high watermark is computed by the same statement as the rows, over
+// exactly the rows returned, so rows committed meanwhile are neither
+// skipped nor counted. since is inclusive: rows sharing the previous high
+// watermark come back, and boundary_keys lists the primary keys at the new
+// high watermark so the next run can drop them.
+func changes(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	v, err := db.serverVersion(ctx)
+	if err == nil {
+		err = v.require("changes", v.windowFunctions())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	wm, err := columnIdent(cfg.watermarkColumn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watermark_column: %v", err)
+	}
+	f, err := parseFilter(cfg.filter)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.since != "" {
+		f = f.and(wm.sql+" >= ?", cfg.since)
+	}
+	if cond, args := tenantCondition(cfg); cond != "" {
+		f = f.and(cond, args...)
+	}
+	var dir sqlText
+	if cfg.descending {
+		dir = " DESC"
+	}
+	b := new(stmtBuilder).text("SELECT c.*, MAX(c.").ident(wm).text(") OVER () AS " + highWatermarkColumn + " FROM (SELECT * FROM ").ident(table).where(f).
+		text(" ORDER BY ").ident(wm).text(dir)
+	if cfg.limit > 0 {
+		b.text(" LIMIT ").number(int64(cfg.limit))
+	}
+	query, args := b.text(") AS c ORDER BY c.").ident(wm).text(dir).build()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	page, err := scanRows(rows, cfg)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var high interface{}
+	if cfg.since != "" {
+		high = cfg.since
+	}
+	for _, row := range page {
+		if row[highWatermarkColumn] != nil {
+			high = row[highWatermarkColumn]
+		}
+		delete(row, highWatermarkColumn)
+	}
+
+	boundary := []map[string]interface{}{}
+	if len(page) > 0 {
+		keys, err := primaryKeyColumns(ctx, db, cfg.objectName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the primary key of %s: %v", cfg.objectName, err)
+		}
+		if len(keys) == 0 {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s has no primary key, so boundary_keys is empty", cfg.objectName))
+		}
+		for _, row := range page {
+			if len(keys) == 0 || fmt.Sprint(row[cfg.watermarkColumn]) != fmt.Sprint(high) {
+				continue
+			}
+			key := make(map[string]interface{}, len(keys))
+			for _, k := range keys {
+				key[k] = row[k]
+			}
+			boundary = append(boundary, key)
+		}
+		if cfg.limit > 0 && int64(len(boundary)) == cfg.limit && !cfg.descending {
+			out.Warnings = append(out.Warnings, "every returned row is at the high watermark; raise limit or the next run returns the same rows")
+		}
+	}
+	return map[string]interface{}{
+		"rows":           page,
+		"count":          len(page),
+		"high_watermark": watermarkValue(high),
+		"boundary_keys":  boundary,
+	}, nil
+}
+
+// primaryKeyColumns lists the primary key columns of a possibly qualified
+// table in key order.
+func primaryKeyColumns(ctx context.Context, q execer, name string) ([]string, error) {
+	parts := splitQualified(name)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	return queryStrings(ctx, q,
+		"SELECT column_name FROM information_schema.key_column_usage WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? AND constraint_name = 'PRIMARY' ORDER BY ordinal_position",
+		schema, parts[len(parts)-1])
+}
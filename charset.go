@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// defaultConnectionCharset is sent with SET NAMES on every connection
+// unless connection_charset or the dsn names another: utf8mb4, falling back
+// to utf8 on servers without it. The handshake alone leaves the session to
+// the server's defaults, which may be latin1.
+const defaultConnectionCharset = "utf8mb4,utf8"
+
+// sessionCharsets are the character set variables of the session, output
+// with include_metadata.
+type sessionCharsets struct {
+	Client     string `json:"character_set_client"`
+	Connection string `json:"character_set_connection"`
+	Results    string `json:"character_set_results"`
+}
+
+func querySessionCharsets(ctx context.Context, q execer) (*sessionCharsets, error) {
+	var c sessionCharsets
+	var results *string // NULL when results are sent unconverted
+	err := q.QueryRowContext(ctx, "SELECT @@character_set_client, @@character_set_connection, @@character_set_results").Scan(&c.Client, &c.Connection, &results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session character sets: %v", err)
+	}
+	if results != nil {
+		c.Results = *results
+	}
+	return &c, nil
+}
+
+// unicodeCharsets rank the Unicode character sets by what they hold: utf8,
+// an alias of utf8mb3, has no characters beyond the BMP, such as emoji.
+var unicodeCharsets = map[string]int{"utf8mb3": 1, "utf8": 1, "ucs2": 1, "utf8mb4": 2, "utf16": 2, "utf16le": 2, "utf32": 2}
+
+// charsetLossy reports whether text sent in the connection character set
+// conn may hold characters a column in col cannot store: they fail with
+// error 1366 under a strict sql_mode and become ? otherwise.
+func charsetLossy(conn, col string) bool {
+	if conn == col || conn == "binary" || conn == "ascii" {
+		return false
+	}
+	connRank, colRank := unicodeCharsets[conn], unicodeCharsets[col]
+	if colRank > 0 {
+		// A single-byte connection character set is within the BMP.
+		return colRank < connRank
+	}
+	return true
+}
+
+// checkEncoding implements encoding_check for a write: it compares the
+// character sets of the target table's columns with the connection's and
+// warns about each one that may lose characters. Failing to look them up
+// is a warning too; the write runs either way.
+func checkEncoding(ctx context.Context, db *database, cfg settings, stmt statement, out *Output) {
+	_, target := requiredPrivileges(stmt.SQL)
+	if target == "" {
+		out.Warnings = append(out.Warnings, "encoding_check: could not infer the table this statement writes")
+		return
+	}
+	schema, table := splitTarget(target, cfg.dbname)
+	charsets, err := querySessionCharsets(ctx, db)
+	if err != nil {
+		out.Warnings = append(out.Warnings, "encoding_check: "+err.Error())
+		return
+	}
+	rows, err := db.QueryContext(ctx, `SELECT column_name, character_set_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ? AND character_set_name IS NOT NULL
+ORDER BY ordinal_position`, schema, table)
+	if err != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("encoding_check: failed to read the column character sets: %v", err))
+		return
+	}
+	defer rows.Close()
+	lossy := map[string][]string{} // column character set -> columns
+	for rows.Next() {
+		var col, charset string
+		if err := rows.Scan(&col, &charset); err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("encoding_check: failed to read the column character sets: %v", err))
+			return
+		}
+		if charset = strings.ToLower(charset); charsetLossy(charsets.Connection, charset) {
+			lossy[charset] = append(lossy[charset], col)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("encoding_check: failed to read the column character sets: %v", err))
+		return
+	}
+	for _, charset := range sortedKeys(lossy) {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("encoding_check: %s columns of %s: %s; the connection sends %s, and characters %s cannot hold fail with error 1366 under a strict sql_mode or are stored as ? otherwise",
+			charset, table, strings.Join(lossy[charset], ", "), charsets.Connection, charset))
+	}
+}
+
+// charsetConfig returns a driver configuration sending SET NAMES with the
+// first of charsets the server knows. mysql.Charset takes one character
+// set; only the charset parameter of a DSN takes fallbacks.
+func charsetConfig(charsets string) (*mysql.Config, error) {
+	return mysql.ParseDSN("/?charset=" + charsets)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// charsetFixture is testdata/charset/customers.json: a table with latin1,
+// utf8 and utf8mb4 columns, the multi-byte text each stores or rejects,
+// and the columns encoding_check flags for each connection character set.
+type charsetFixture struct {
+	Table   string `json:"table"`
+	Columns []struct {
+		Name     string   `json:"name"`
+		Charset  string   `json:"charset"`
+		Values   []string `json:"values"`
+		Rejected []string `json:"rejected"`
+	} `json:"columns"`
+	Checks []struct {
+		Connection string              `json:"connection"`
+		Lossy      map[string][]string `json:"lossy"`
+	} `json:"checks"`
+}
+
+func loadCharsetFixture(t *testing.T) charsetFixture {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "charset", "customers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f charsetFixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// storeText writes text to the column of a one-column stub table in
+// charset over a utf8mb4 connection and reads it back through scanRows.
+func storeText(t *testing.T, column, charset, text string) (interface{}, error) {
+	t.Helper()
+	db, stub := openStubDB(t)
+	stub.columns = []stubColumn{{column, "VARCHAR"}}
+	stub.charsets = map[string]string{column: charset}
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES (?)", text); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	result, err := scanRows(rows, settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("read %d rows back, want 1", len(result))
+	}
+	return result[0][column], nil
+}
+
+func TestCharsetRoundTrip(t *testing.T) {
+	f := loadCharsetFixture(t)
+	for _, col := range f.Columns {
+		for _, text := range col.Values {
+			got, err := storeText(t, col.Name, col.Charset, text)
+			if err != nil || got != text {
+				t.Errorf("%s column %s: %q came back as %#v, %v", col.Charset, col.Name, text, got, err)
+			}
+		}
+		for _, text := range col.Rejected {
+			_, err := storeText(t, col.Name, col.Charset, text)
+			var myErr *mysql.MySQLError
+			if !errors.As(err, &myErr) || myErr.Number != 1366 {
+				t.Errorf("%s column %s stored %q, want error 1366", col.Charset, col.Name, text)
+			}
+		}
+		// A column some utf8mb4 text fails in is one encoding_check warns
+		// about, and only such a column.
+		if lossy := charsetLossy("utf8mb4", col.Charset); lossy != (len(col.Rejected) > 0) {
+			t.Errorf("charsetLossy(utf8mb4, %s) = %v with rejected text %q", col.Charset, lossy, col.Rejected)
+		}
+	}
+}
+
+func TestCharsetLossy(t *testing.T) {
+	tests := []struct {
+		conn, col string
+		want      bool
+	}{
+		{"utf8mb4", "utf8mb4", false},
+		{"utf8mb4", "utf8", true},
+		{"utf8mb4", "utf8mb3", true},
+		{"utf8mb4", "latin1", true},
+		{"utf8mb4", "utf16", false},
+		{"utf8", "utf8mb3", false},
+		{"utf8", "utf8mb4", false},
+		{"utf8", "latin1", true},
+		{"latin1", "utf8", false},
+		{"latin1", "utf8mb4", false},
+		{"latin1", "latin2", true},
+		{"binary", "latin1", false},
+		{"ascii", "latin1", false},
+	}
+	for _, tt := range tests {
+		if got := charsetLossy(tt.conn, tt.col); got != tt.want {
+			t.Errorf("charsetLossy(%q, %q) = %v, want %v", tt.conn, tt.col, got, tt.want)
+		}
+	}
+}
+
+// charsetServer answers the queries of checkEncoding for the fixture table
+// over a connection in conn.
+func charsetServer(f charsetFixture, conn string) func(string) ([]stubColumn, [][]driver.Value) {
+	return func(query string) ([]stubColumn, [][]driver.Value) {
+		if strings.Contains(query, "@@character_set_client") {
+			return []stubColumn{{"client", "VARCHAR"}, {"connection", "VARCHAR"}, {"results", "VARCHAR"}},
+				[][]driver.Value{{[]byte(conn), []byte(conn), []byte(conn)}}
+		}
+		var data [][]driver.Value
+		for _, col := range f.Columns {
+			// information_schema may spell the character set in capitals.
+			data = append(data, []driver.Value{[]byte(col.Name), []byte(strings.ToUpper(col.Charset))})
+		}
+		return []stubColumn{{"column_name", "VARCHAR"}, {"character_set_name", "VARCHAR"}}, data
+	}
+}
+
+func TestEncodingCheck(t *testing.T) {
+	f := loadCharsetFixture(t)
+	stmt := statement{SQL: "INSERT INTO " + f.Table + " (code, name, legacy, note) VALUES (?, ?, ?, ?)"}
+	for _, check := range f.Checks {
+		t.Run(check.Connection, func(t *testing.T) {
+			db, stub := openStubDB(t)
+			stub.respond = charsetServer(f, check.Connection)
+			out := &Output{}
+			checkEncoding(context.Background(), &database{DB: db}, settings{dbname: "erp"}, stmt, out)
+			if len(out.Warnings) != len(check.Lossy) {
+				t.Fatalf("warnings %q, want one for each of %v", out.Warnings, check.Lossy)
+			}
+			for i, charset := range sortedKeys(check.Lossy) {
+				want := "encoding_check: " + charset + " columns of " + f.Table + ": " + strings.Join(check.Lossy[charset], ", ") + "; the connection sends " + check.Connection
+				if !strings.HasPrefix(out.Warnings[i], want) {
+					t.Errorf("warning %q, want it to start with %q", out.Warnings[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodingCheckUnknownTarget(t *testing.T) {
+	db, _ := openStubDB(t)
+	out := &Output{}
+	checkEncoding(context.Background(), &database{DB: db}, settings{}, statement{SQL: "SET @a = 1"}, out)
+	if len(out.Warnings) != 1 || !strings.Contains(out.Warnings[0], "could not infer the table") {
+		t.Errorf("warnings %q, want one that the target is unknown", out.Warnings)
+	}
+}
+
+func TestCharsetConfig(t *testing.T) {
+	for _, charsets := range []string{defaultConnectionCharset, "latin1", "utf8mb4"} {
+		c, err := charsetConfig(charsets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dsn := c.FormatDSN(); !strings.Contains(dsn, "charset="+charsets) {
+			t.Errorf("charsetConfig(%q) formats as %q", charsets, dsn)
+		}
+		c, err = connectionConfig(settings{host: "db.internal", port: 3306, username: "u", connectionCharset: charsets})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dsn := c.FormatDSN(); !strings.Contains(dsn, "charset="+charsets) {
+			t.Errorf("connection_charset %q connects with %q", charsets, dsn)
+		}
+	}
+}
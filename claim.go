@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// claimStateColumns are the columns filter tests for equality and
+// claim_set changes, such as status in filter {"status": "new"} with
+// claim_set {"status": "processing"}: a row holds the claim_set value while
+// it is claimed, and the filter value once requeued.
+func claimStateColumns(f filter, set map[string]interface{}) []filterCondition {
+	var state []filterCondition
+	for _, c := range f.equal {
+		if _, ok := set[c.Column]; ok {
+			state = append(state, c)
+		}
+	}
+	return state
+}
+
+// claim implements data_type=claim, the job queue pattern: up to limit rows
+// of object_name matching filter (default 1), in order_column order or
+// else primary key order, are locked with FOR UPDATE SKIP LOCKED, stamped
+// with claim_set and claimed_at_column = NOW() and returned as they are
+// after the update, all in one transaction. Concurrent callers skip each
+// other's rows instead of waiting for them or claiming them twice. No
+// claimable row is an empty result, not an error.
+//
+// requeue_stale_after_seconds first resets, in the same transaction, the
+// rows claimed longer ago than that: their claimed_at_column is older and
+// the state columns (see claimStateColumns) hold the claim_set values, and
+// they get the filter values back. They can then be claimed by this call.
+func claim(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	v, err := db.serverVersion(ctx)
+	if err == nil {
+		err = v.require("claim", v.skipLocked())
+	}
+	if err != nil {
+		return nil, err
+	}
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	set, err := parseValues(cfg.claimSet)
+	if err != nil {
+		return nil, fmt.Errorf("claim_set: %v", err)
+	}
+	f, err := parseFilter(cfg.filter)
+	if err != nil {
+		return nil, err
+	}
+	tenantCond, tenantArgs := tenantCondition(cfg)
+	if tenantCond != "" {
+		f = f.and(tenantCond, tenantArgs...)
+	}
+	limit := cfg.limit
+	if limit == 0 {
+		limit = 1
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	keys, err := primaryKeyColumns(ctx, tx, cfg.objectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the primary key: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no primary key; claim updates the rows it locked by key", cfg.objectName)
+	}
+	quotedKeys, _ := columnIdents(keys)
+
+	var requeued int64
+	if cfg.requeueStaleAfter > 0 {
+		state := claimStateColumns(f, set)
+		b := new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ")
+		for i, c := range state {
+			col, _ := columnIdent(c.Column)
+			if i > 0 {
+				b.text(", ")
+			}
+			b.ident(col).text(" = ?").arg(c.Value)
+		}
+		claimedAt, _ := columnIdent(cfg.claimedAtColumn)
+		b.text(" WHERE ").ident(claimedAt).text(" < NOW() - INTERVAL ? SECOND").arg(cfg.requeueStaleAfter)
+		for _, c := range state {
+			col, _ := columnIdent(c.Column)
+			if set[c.Column] == nil {
+				b.text(" AND ").ident(col).text(" IS NULL")
+			} else {
+				b.text(" AND ").ident(col).text(" = ?").arg(set[c.Column])
+			}
+		}
+		if tenantCond != "" {
+			b.text(" AND ").text(sqlText(tenantCond)).arg(tenantArgs...)
+		}
+		query, args := b.build()
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to requeue stale claims: %w", err)
+		}
+		requeued, _ = res.RowsAffected()
+	}
+
+	orderCols := quotedKeys
+	if cfg.orderColumn != "" {
+		col, _ := columnIdent(cfg.orderColumn)
+		orderCols = append([]ident{col}, quotedKeys...)
+	}
+	orderBy := func(b *stmtBuilder) *stmtBuilder {
+		b.text(" ORDER BY ")
+		for i, col := range orderCols {
+			if i > 0 {
+				b.text(", ")
+			}
+			b.ident(col)
+			if cfg.descending {
+				b.text(" DESC")
+			}
+		}
+		return b
+	}
+	selectKeys := new(stmtBuilder).text("SELECT ").idents(quotedKeys).text(" FROM ").ident(table).where(f)
+	orderBy(selectKeys).text(" LIMIT ").number(int64(limit)).text(" FOR UPDATE SKIP LOCKED")
+	locked, err := lockedKeys(ctx, tx, selectKeys, len(keys))
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"rows": []map[string]interface{}{}, "count": 0, "requeued": requeued}
+	if len(locked) == 0 {
+		// The requeue, if any, still commits.
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit failed: %v", err)
+		}
+		return result, nil
+	}
+
+	update := new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ")
+	for i, c := range sortedKeys(set) {
+		col, _ := columnIdent(c)
+		if i > 0 {
+			update.text(", ")
+		}
+		update.ident(col).text(" = ?").arg(set[c])
+	}
+	if cfg.claimedAtColumn != "" {
+		col, _ := columnIdent(cfg.claimedAtColumn)
+		update.text(", ").ident(col).text(" = NOW()")
+	}
+	query, args := update.text(" WHERE ").keyIn(quotedKeys, locked).build()
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to claim the rows: %w", err)
+	}
+	query, args = orderBy(new(stmtBuilder).text("SELECT * FROM ").ident(table).text(" WHERE ").keyIn(quotedKeys, locked)).build()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the claimed rows: %w", err)
+	}
+	claimed, err := scanRows(rows, cfg)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %v", err)
+	}
+	result["rows"], result["count"] = claimed, len(claimed)
+	return result, nil
+}
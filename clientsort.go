@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultClientSortRows caps the rows client_sort sorts unless max_rows
+// sets another cap: the whole result is held and sorted in memory.
+const defaultClientSortRows = 100000
+
+// sortKey is one column of client_sort.
+type sortKey struct {
+	Column string
+	Desc   bool
+}
+
+func (k sortKey) String() string {
+	if k.Desc {
+		return k.Column + " desc"
+	}
+	return k.Column + " asc"
+}
+
+// parseClientSort parses client_sort, a JSON array or comma-separated list
+// of column names, each optionally followed by asc or desc.
+func parseClientSort(raw string) ([]sortKey, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var items []string
+	if strings.HasPrefix(raw, "[") {
+		if err := json.Unmarshal([]byte(raw), &items); err != nil {
+			return nil, fmt.Errorf("client_sort must be a JSON array of columns like [\"amount desc\", \"name\"]: %v", err)
+		}
+	} else {
+		items = strings.Split(raw, ",")
+	}
+	var keys []sortKey
+	for _, item := range items {
+		fields := strings.Fields(item)
+		switch {
+		case len(fields) == 1:
+			keys = append(keys, sortKey{Column: fields[0]})
+		case len(fields) == 2 && (strings.EqualFold(fields[1], "asc") || strings.EqualFold(fields[1], "desc")):
+			keys = append(keys, sortKey{Column: fields[0], Desc: strings.EqualFold(fields[1], "desc")})
+		default:
+			return nil, fmt.Errorf("client_sort: %q must be a column name optionally followed by asc or desc", strings.TrimSpace(item))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("client_sort must name at least one column")
+	}
+	return keys, nil
+}
+
+// clientSortReport is the client_sort output field.
+type clientSortReport struct {
+	By       []string `json:"by,omitempty"`
+	Rows     int      `json:"rows"`
+	Returned int      `json:"returned"`
+}
+
+// clientSort sorts rows by client_sort and cuts them to client_limit after
+// they were fetched, for results whose statement cannot order them, such as
+// a stored procedure's. The sort is stable. NULL sorts first ascending, as
+// in MySQL. Numbers compare numerically, exactly for big and DECIMAL
+// values, and times chronologically. A column whose every value is text
+// holding a number, as DECIMAL columns are returned by default, compares
+// numerically too; other text compares byte by byte, or ignoring case with
+// client_sort_ignore_case.
+func clientSort(cfg settings, rows []map[string]interface{}, out *Output) ([]map[string]interface{}, error) {
+	keys, _ := parseClientSort(cfg.clientSort) // validated by parseSettings
+	limit := cfg.maxRows
+	if limit <= 0 {
+		limit = defaultClientSortRows
+	}
+	if len(keys) > 0 && len(rows) > limit {
+		return nil, fmt.Errorf("client_sort: the result has %d rows, more than the %d max_rows allows to sort in memory; order the rows in SQL or raise max_rows", len(rows), limit)
+	}
+	report := &clientSortReport{Rows: len(rows)}
+	if len(keys) > 0 {
+		var present []sortKey
+		for _, k := range keys {
+			if len(rows) > 0 {
+				if _, ok := rows[0][k.Column]; !ok {
+					out.Warnings = append(out.Warnings, fmt.Sprintf("client_sort: column %s is not in the result and was ignored", k.Column))
+					continue
+				}
+			}
+			present = append(present, k)
+			report.By = append(report.By, k.String())
+		}
+		numeric := make([]bool, len(present))
+		for i, k := range present {
+			numeric[i] = numericText(rows, k.Column)
+		}
+		sort.SliceStable(rows, func(a, b int) bool {
+			for i, k := range present {
+				c := compareValues(rows[a][k.Column], rows[b][k.Column], numeric[i], cfg.clientSortIgnoreCase)
+				if c != 0 {
+					return (c < 0) != k.Desc
+				}
+			}
+			return false
+		})
+	}
+	if cfg.clientLimit > 0 && len(rows) > cfg.clientLimit {
+		rows = rows[:cfg.clientLimit]
+	}
+	report.Returned = len(rows)
+	out.ClientSort = report
+	return rows, nil
+}
+
+// numericText reports whether every non-NULL value of col is text holding
+// a number.
+func numericText(rows []map[string]interface{}, col string) bool {
+	found := false
+	for _, row := range rows {
+		switch v := row[col].(type) {
+		case nil:
+		case string:
+			if _, ok := parseExact(v); !ok {
+				return false
+			}
+			found = true
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+// compareValues orders two values of one column, returning -1, 0 or 1.
+func compareValues(a, b interface{}, numeric, ignoreCase bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if ra, ok := exactNumber(a, numeric); ok {
+		if rb, ok := exactNumber(b, numeric); ok {
+			return ra.Cmp(rb)
+		}
+	}
+	sa, sb := fmt.Sprint(a), fmt.Sprint(b)
+	if ignoreCase {
+		sa, sb = strings.ToLower(sa), strings.ToLower(sb)
+	}
+	return strings.Compare(sa, sb)
+}
+
+// exactNumber returns v as an exact number when it is one, or when it is
+// text of a column holding numbers.
+func exactNumber(v interface{}, numeric bool) (*big.Rat, bool) {
+	switch v := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case uint64:
+		return new(big.Rat).SetUint64(v), true
+	case float32:
+		if r := new(big.Rat).SetFloat64(float64(v)); r != nil {
+			return r, true
+		}
+	case float64:
+		if r := new(big.Rat).SetFloat64(v); r != nil {
+			return r, true
+		}
+	case json.Number:
+		return parseExact(string(v))
+	case string:
+		if numeric {
+			return parseExact(v)
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+)
+
+// compressedOutput is the envelope for output_compression without
+// binary_stdout: the gzipped Output JSON, base64 encoded.
+type compressedOutput struct {
+	RequestID       string `json:"request_id"`
+	Compression     string `json:"compression"`
+	Payload         string `json:"payload"`
+	OriginalBytes   int64  `json:"original_bytes"`
+	CompressedBytes int    `json:"compressed_bytes"`
+}
+
+// writeOutput writes out to w, gzipped when output_compression is set. The
+// JSON is compressed while it is encoded, so only the compressed bytes are
+// held until the result is known to be complete. Failures are always
+// written as plain JSON.
+func writeOutput(w io.Writer, out *Output) error {
+	if out.compression == "" || (out.Error != "" && out.stream == nil) {
+		return encodeOutput(w, out)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	counter := &countingWriter{w: zw}
+	streamErr := encodeOutput(counter, out)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if streamErr != nil || out.Error != "" {
+		// The result is already consumed; resend the rest uncompressed.
+		out.Result = nil
+		if err := encodeOutput(w, out); err != nil {
+			return err
+		}
+		return streamErr
+	}
+
+	if out.binaryStdout {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return json.NewEncoder(w).Encode(compressedOutput{
+		RequestID:       out.RequestID,
+		Compression:     out.compression,
+		Payload:         base64.StdEncoding.EncodeToString(buf.Bytes()),
+		OriginalBytes:   counter.n,
+		CompressedBytes: buf.Len(),
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/ssh"
+)
+
+// connect opens and pings the database. With auth_method=aws_iam a fresh
+// token is generated and the connection retried once on an auth failure,
+// since a token can expire between generation and use.
+func connect(cfg settings) (*database, error) {
+	var tunnel *ssh.Client
+	if cfg.sshHost != "" {
+		var err error
+		if tunnel, err = openTunnel(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := connectDB(cfg)
+	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, err
+	}
+	return &database{DB: db, tunnel: tunnel}, nil
+}
+
+// database is an open connection pool plus the SSH tunnel it runs through,
+// if any.
+type database struct {
+	*sql.DB
+	tunnel *ssh.Client
+
+	version *serverVersion // cached by serverVersion
+}
+
+// serverVersion returns the server version, querying it only once per
+// connection pool.
+func (d *database) serverVersion(ctx context.Context) (serverVersion, error) {
+	if d.version == nil {
+		v, err := queryServerVersion(ctx, d.DB)
+		if err != nil {
+			return serverVersion{}, err
+		}
+		d.version = &v
+	}
+	return *d.version, nil
+}
+
+func (d *database) Close() error {
+	err := d.DB.Close()
+	if d.tunnel != nil {
+		d.tunnel.Close()
+	}
+	return err
+}
+
+func connectDB(cfg settings) (*sql.DB, error) {
+	if cfg.authMethod == "aws_iam" {
+		if err := applyIAMToken(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := openAndPing(cfg)
+	if err != nil && cfg.authMethod == "aws_iam" && isAccessDenied(err) {
+		logf("IAM authentication failed, retrying with a fresh token")
+		if tokenErr := applyIAMToken(&cfg); tokenErr != nil {
+			return nil, tokenErr
+		}
+		db, err = openAndPing(cfg)
+	}
+	return db, err
+}
+
+func openAndPing(cfg settings) (*sql.DB, error) {
+	conf, err := connectionConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	db := sql.OpenDB(commentConnector{connector})
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		msg := fmt.Sprintf("failed to ping db: %v", err)
+		if hint := authHint(err); hint != "" {
+			msg += " (hint: " + hint + ")"
+		}
+		return nil, &connectError{msg: msg, err: err}
+	}
+	return db, nil
+}
+
+// connectError keeps the driver error reachable for errors.As while
+// presenting the decorated message.
+type connectError struct {
+	msg string
+	err error
+}
+
+func (e *connectError) Error() string { return e.msg }
+func (e *connectError) Unwrap() error { return e.err }
+
+func isAccessDenied(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1045
+}
+
+// compressionNegotiated reports whether the session actually uses protocol
+// compression; the server may decline it even when requested.
+func compressionNegotiated(db *sql.DB) (bool, error) {
+	var name, value string
+	if err := db.QueryRow("SHOW SESSION STATUS LIKE 'Compression'").Scan(&name, &value); err != nil {
+		return false, err
+	}
+	return strings.EqualFold(value, "ON"), nil
+}
+
+// normalizeHost validates the host input and strips brackets from IPv6
+// literals. A port embedded in the host is rejected rather than guessed at,
+// since it would silently conflict with the port input.
+func normalizeHost(host string) (string, error) {
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return "", fmt.Errorf("host %q has an unterminated IPv6 bracket", host)
+		}
+		if end != len(host)-1 {
+			return "", fmt.Errorf("host %q must not include a port; use the port input", host)
+		}
+		inner := host[1:end]
+		if !isIPv6(inner) {
+			return "", fmt.Errorf("host %q is not a valid IPv6 address", host)
+		}
+		return inner, nil
+	}
+	if strings.Contains(host, ":") {
+		if isIPv6(host) {
+			return host, nil
+		}
+		return "", fmt.Errorf("host %q must not include a port; use the port input", host)
+	}
+	return host, nil
+}
+
+// isIPv6 reports whether s is an IPv6 literal, optionally with a zone
+// such as fe80::1%eth0.
+func isIPv6(s string) bool {
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(s)
+	return ip != nil && strings.Contains(s, ":")
+}
+
+// hostPort formats the address for the driver, bracketing IPv6 literals:
+// tcp([2001:db8::5]:3306).
+func hostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// connectionConfig builds the driver configuration from the settings.
+func connectionConfig(cfg settings) (*mysql.Config, error) {
+	if cfg.dsn != "" {
+		c, err := dsnConfig(cfg)
+		if err == nil {
+			applyTenantSession(c, cfg)
+		}
+		return c, err
+	}
+	c, err := charsetConfig(cfg.connectionCharset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection_charset: %v", err)
+	}
+	c.User = cfg.username
+	c.Passwd = cfg.password
+	c.Net = "tcp"
+	if cfg.sshHost != "" {
+		c.Net = sshNetwork
+	}
+	c.Addr = hostPort(cfg.host, cfg.port)
+	c.DBName = cfg.dbname
+	// zero_date_mode parses the temporal values while scanning instead.
+	c.ParseTime = cfg.zeroDateMode == ""
+	c.ClientFoundRows = cfg.foundRows
+	c.Timeout = time.Duration(cfg.connectTimeout) * time.Second
+	c.ReadTimeout = cfg.readTimeout
+	c.WriteTimeout = cfg.writeTimeout
+	if cfg.maxAllowedPacket > 0 {
+		c.MaxAllowedPacket = cfg.maxAllowedPacket
+	}
+	if cfg.compress {
+		c.Apply(mysql.EnableCompression(true))
+	}
+	c.InterpolateParams = cfg.interpolateParams
+	c.AllowCleartextPasswords = cfg.allowCleartextPasswords
+	c.AllowNativePasswords = cfg.allowNativePasswords
+	if cfg.serverPubKey != "" {
+		if err := registerServerPubKey(cfg.serverPubKey); err != nil {
+			return nil, err
+		}
+		c.ServerPubKey = serverPubKeyName
+	}
+
+	if c.TLS, err = tlsConfig(cfg); err != nil {
+		return nil, err
+	}
+	c.AllowFallbackToPlaintext = cfg.tlsMode == "preferred"
+	applyTenantSession(c, cfg)
+	return c, nil
+}
+
+// tlsConfig maps the tls input onto a crypto/tls configuration:
+// false (default) disables TLS, true verifies the server certificate,
+// skip-verify encrypts without verification and preferred additionally
+// falls back to plaintext when the server has no TLS.
+func tlsConfig(cfg settings) (*tls.Config, error) {
+	switch cfg.tlsMode {
+	case "", "false":
+		return nil, nil
+	case "skip-verify", "preferred":
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	conf := &tls.Config{ServerName: cfg.tlsServerName}
+	if cfg.tlsCA != "" {
+		pem, err := os.ReadFile(cfg.tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls_ca contains no PEM certificates")
+		}
+		conf.RootCAs = pool
+	} else if cfg.authMethod == "aws_iam" {
+		conf.RootCAs = rdsRoots
+	}
+	if cfg.tlsCert != "" || cfg.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls_cert/tls_key: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// serverPubKeyName is the name the server_pub_key input is registered under.
+const serverPubKeyName = "server_pub_key"
+
+// registerServerPubKey loads an RSA public key given either as PEM text or as
+// a path to a PEM file and registers it with the driver.
+func registerServerPubKey(value string) error {
+	data := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return fmt.Errorf("failed to read server_pub_key: %v", err)
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("server_pub_key is not PEM encoded")
+	}
+	var key *rsa.PublicKey
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("server_pub_key is not an RSA public key")
+		}
+		key = rsaKey
+	} else if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+		return fmt.Errorf("failed to parse server_pub_key: %v", err)
+	}
+	mysql.RegisterServerPubKey(serverPubKeyName, key)
+	return nil
+}
+
+// authHint turns the common authentication plugin failures into an
+// actionable suggestion, or returns "" when the error is not one of them.
+func authHint(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, mysql.ErrCleartextPassword):
+		return "the account uses a cleartext plugin (e.g. LDAP/PAM); set allow_cleartext_passwords=true, only together with tls=true"
+	case errors.Is(err, mysql.ErrNativePassword):
+		return "the account uses mysql_native_password; set allow_native_passwords=true or switch the account to caching_sha2_password"
+	case errors.Is(err, mysql.ErrOldPassword):
+		return "the account uses the pre-4.1 password hash; reset its password with a modern authentication plugin"
+	case errors.Is(err, mysql.ErrUnknownPlugin):
+		return "the account's authentication plugin is not supported; switch it with ALTER USER ... IDENTIFIED WITH caching_sha2_password"
+	case strings.Contains(msg, "secure transport") || strings.Contains(msg, "insecure transport"):
+		return "the server requires an encrypted connection; set tls=true (or skip-verify)"
+	case strings.Contains(msg, "rsa") || strings.Contains(msg, "public key"):
+		return "caching_sha2_password needs TLS or the server's RSA public key; set tls=true or supply server_pub_key"
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr string
+	}{
+		{host: "db.internal", want: "db.internal"},
+		{host: "10.0.0.5", want: "10.0.0.5"},
+		{host: "2001:db8::5", want: "2001:db8::5"},
+		{host: "[2001:db8::5]", want: "2001:db8::5"},
+		{host: "::1", want: "::1"},
+		{host: "fe80::1%eth0", want: "fe80::1%eth0"},
+		{host: "[fe80::1%eth0]", want: "fe80::1%eth0"},
+		{host: "db.internal:3307", wantErr: "must not include a port"},
+		{host: "10.0.0.5:3307", wantErr: "must not include a port"},
+		{host: "[2001:db8::5]:3307", wantErr: "must not include a port"},
+		{host: "[2001:db8::5", wantErr: "unterminated IPv6 bracket"},
+		{host: "[db.internal]", wantErr: "not a valid IPv6 address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := normalizeHost(tt.host)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("normalizeHost(%q) = %q, %v, want an error containing %q", tt.host, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("normalizeHost(%q) = %q, %v, want %q", tt.host, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostPortParses(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"db.internal", "db.internal:3306"},
+		{"10.0.0.5", "10.0.0.5:3306"},
+		{"2001:db8::5", "[2001:db8::5]:3306"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:3306"},
+	}
+	for _, tt := range tests {
+		addr := hostPort(tt.host, 3306)
+		if addr != tt.want {
+			t.Errorf("hostPort(%q) = %q, want %q", tt.host, addr, tt.want)
+		}
+		c, err := connectionConfig(settings{host: tt.host, port: 3306, username: "u", connectionCharset: defaultConnectionCharset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := mysql.ParseDSN(c.FormatDSN())
+		if err != nil {
+			t.Fatalf("the DSN for %q does not parse: %v", tt.host, err)
+		}
+		if parsed.Addr != tt.want {
+			t.Errorf("the DSN for %q has address %q, want %q", tt.host, parsed.Addr, tt.want)
+		}
+	}
+}
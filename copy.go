@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultCopyBatchSize is the number of rows per INSERT when batch_size is
+// not set.
+const defaultCopyBatchSize = 500
+
+// copyConflicts lists the on_conflict strategies; see newCopyInsert.
+var copyConflicts = map[string]bool{"error": true, "ignore": true, "update": true, "replace": true}
+
+type copyResult struct {
+	RowsRead     int64            `json:"rows_read"`
+	RowsAffected int64            `json:"rows_affected"` // as reported by the target, 2 per updated row
+	Chunks       int              `json:"chunks"`
+	FailedChunks []copyChunkError `json:"failed_chunks,omitempty"`
+	Created      bool             `json:"created,omitempty"` // create_target ran
+
+	// Watermark is the watermark_column value of the last row written,
+	// to be passed as resume_after by the next invocation.
+	Watermark interface{} `json:"watermark,omitempty"`
+
+	// BinlogPosition is set with capture_binlog_position.
+	BinlogPosition *binlogPosition `json:"binlog_position,omitempty"`
+}
+
+// copyChunkError records a chunk the target refused. The copy continues
+// with the next chunk, unless it is resumable with watermark_column: then it
+// stops so the watermark never moves past unwritten rows.
+type copyChunkError struct {
+	Chunk    int    `json:"chunk"`
+	FirstRow int64  `json:"first_row"`
+	Rows     int    `json:"rows"`
+	Error    string `json:"error"`
+}
+
+// targetSettings derives the target connection of copy_table. Without
+// target_profile every target input not given falls back to the source
+// connection, so copying into another database on the same server only
+// needs target_dbname. TLS and timeouts apply to both connections; the SSH
+// tunnel and dsn belong to the source only.
+func targetSettings(cfg settings) (settings, error) {
+	t := cfg
+	t.dsn, t.sshHost, t.profile = "", "", ""
+	if cfg.targetProfile != "" {
+		t.host, t.port, t.username, t.password, t.dbname = "", 0, "", "", ""
+		t.tlsMode, t.tlsCA, t.tlsCert, t.tlsKey, t.tlsServerName = "", "", "", "", ""
+	}
+	for _, f := range []struct {
+		field *string
+		value string
+	}{
+		{&t.host, cfg.targetHost}, {&t.username, cfg.targetUsername}, {&t.password, cfg.targetPassword}, {&t.dbname, cfg.targetDBName},
+	} {
+		if f.value != "" {
+			*f.field = f.value
+		}
+	}
+	if cfg.targetPort != 0 {
+		t.port = cfg.targetPort
+	}
+	if cfg.targetProfile != "" {
+		p, err := loadProfile(cfg.targetProfile)
+		if err == nil {
+			err = p.apply(&t)
+		}
+		if err != nil {
+			return t, fmt.Errorf("target_profile: %v", err)
+		}
+	}
+	if t.port == 0 {
+		t.port = 3306
+	}
+	if t.host == "" || t.username == "" || t.dbname == "" {
+		return t, fmt.Errorf("the target connection needs a host, username and dbname")
+	}
+	return t, nil
+}
+
+// copyTable implements data_type=copy_table: rows of object_name matching
+// filter are read from the source and inserted into target_object_name on
+// the target connection, batch_size rows per INSERT. Only one batch is held
+// in memory at a time, and max_result_bytes bounds a batch rather than the
+// whole copy. Masking and drop_columns apply to the copied rows as to any
+// result.
+//
+// With watermark_column the rows are copied in its order, after resume_after
+// when given. Rows equal to resume_after are skipped, so the column must be
+// unique, or the copy idempotent (on_conflict=ignore, update or replace) and
+// resumed from an earlier value.
+//
+// capture_binlog_position reads the source inside a consistent snapshot and
+// returns its binary log coordinates, for starting change capture where the
+// copy ends.
+func copyTable(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	tcfg, err := targetSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	target, err := connect(tcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to target: %s", redact(err.Error(), tcfg))
+	}
+	defer target.Close()
+
+	var result copyResult
+	if cfg.createTarget {
+		if err := createCopyTarget(ctx, db, target, cfg); err != nil {
+			return nil, fmt.Errorf("%s", redact(err.Error(), tcfg))
+		}
+		result.Created = true
+	}
+
+	query, args, err := copySourceQuery(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// A captured position needs the source rows read from the snapshot it
+	// was taken with, on one connection.
+	var source execer = db
+	if cfg.captureBinlogPosition {
+		v, err := db.serverVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if err := beginConsistentSnapshot(ctx, conn); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.Background(), "ROLLBACK")
+		if result.BinlogPosition, err = readBinlogPosition(ctx, conn, v, true); err != nil {
+			return nil, err
+		}
+		source = conn
+	}
+	rows, err := source.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	defer rows.Close()
+	scanner, err := newRowScanner(rows, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	var quoted []ident
+	for _, col := range scanner.columns {
+		if !scanner.masking.dropped(col) {
+			q, _ := columnIdent(col)
+			columns, quoted = append(columns, col), append(quoted, q)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("copy_table: drop_columns removes every column of %s", cfg.objectName)
+	}
+	if cfg.watermarkColumn != "" && !containsString(columns, cfg.watermarkColumn) {
+		return nil, fmt.Errorf("copy_table: watermark_column %s is not among the copied columns", cfg.watermarkColumn)
+	}
+	insert, err := newCopyInsert(cfg, quoted)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := cfg.batchSize
+	if batchSize == 0 {
+		batchSize = defaultCopyBatchSize
+	}
+	batch := make([]interface{}, 0, batchSize*len(columns))
+	var last interface{} // watermark of the last row in batch
+	failed := false
+	flush := func() {
+		n := len(batch) / len(columns)
+		if n == 0 {
+			return
+		}
+		result.Chunks++
+		progressReporter.setChunk(result.Chunks, 0)
+		query, args := insert.build(batch)
+		res, err := target.ExecContext(ctx, query, args...)
+		if err != nil {
+			result.FailedChunks = append(result.FailedChunks, copyChunkError{
+				Chunk: result.Chunks, FirstRow: result.RowsRead - int64(n) + 1, Rows: n, Error: redact(err.Error(), tcfg),
+			})
+		} else {
+			affected, _ := res.RowsAffected()
+			result.RowsAffected += affected
+			if cfg.watermarkColumn != "" {
+				result.Watermark = watermarkValue(last)
+				progressReporter.setWatermark(result.Watermark)
+			}
+		}
+		failed = err != nil
+		batch = batch[:0]
+		scanner.budget.total = 0
+	}
+
+	for {
+		row, err := scanner.next()
+		if err != nil {
+			return nil, fmt.Errorf("copy stopped after %d rows (%d chunks written): %w", result.RowsRead, result.Chunks, err)
+		}
+		if row == nil {
+			break
+		}
+		result.RowsRead++
+		for _, col := range columns {
+			batch = append(batch, row[col])
+		}
+		if cfg.watermarkColumn != "" {
+			last = row[cfg.watermarkColumn]
+		}
+		if len(batch) == cap(batch) {
+			flush()
+			if failed && cfg.watermarkColumn != "" {
+				out.Warnings = append(out.Warnings, "copy stopped at the failed chunk; resume with resume_after set to the reported watermark, or from the start without one")
+				return result, nil
+			}
+		}
+	}
+	flush()
+
+	if n := len(result.FailedChunks); n > 0 {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%d of %d chunks failed; see failed_chunks", n, result.Chunks))
+	}
+	return result, nil
+}
+
+// copySourceQuery selects columns (or every column) of object_name, scoped
+// by filter and tenant_column.
+func copySourceQuery(cfg settings) (string, []interface{}, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return "", nil, err
+	}
+	b := new(stmtBuilder).text("SELECT ")
+	if cfg.columns != "" {
+		names, err := parseIdentList(cfg.columns)
+		if err != nil {
+			return "", nil, fmt.Errorf("columns %v", err)
+		}
+		quoted, _ := columnIdents(names)
+		b.idents(quoted)
+	} else {
+		b.text("*")
+	}
+	f, err := parseFilter(cfg.filter)
+	if err != nil {
+		return "", nil, err
+	}
+	if cond, args := tenantCondition(cfg); cond != "" {
+		f = f.and(cond, args...)
+	}
+	b.text(" FROM ").ident(table)
+	if cfg.watermarkColumn == "" {
+		query, args := b.where(f).build()
+		return query, args, nil
+	}
+	col, err := columnIdent(cfg.watermarkColumn)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid watermark_column: %v", err)
+	}
+	if cfg.resumeAfter != "" {
+		f = f.and(col.sql+" > ?", cfg.resumeAfter)
+	}
+	query, args := b.where(f).text(" ORDER BY ").ident(col).build()
+	return query, args, nil
+}
+
+// copyInsert is the INSERT of copy_table into target_object_name, with
+// the statement of on_conflict.
+type copyInsert struct {
+	verb    sqlText
+	target  ident
+	columns []ident
+	update  bool // ON DUPLICATE KEY UPDATE every column
+}
+
+func newCopyInsert(cfg settings, columns []ident) (copyInsert, error) {
+	target, err := tableIdent(cfg.targetObjectName)
+	if err != nil {
+		return copyInsert{}, fmt.Errorf("invalid target_object_name: %v", err)
+	}
+	c := copyInsert{verb: "INSERT INTO", target: target, columns: columns}
+	switch cfg.onConflict {
+	case "ignore":
+		c.verb = "INSERT IGNORE INTO"
+	case "replace":
+		c.verb = "REPLACE INTO"
+	case "update":
+		c.update = true
+	}
+	return c, nil
+}
+
+// build renders the INSERT of batch, the values of whole rows.
+func (c copyInsert) build(batch []interface{}) (string, []interface{}) {
+	b := new(stmtBuilder).text(c.verb).text(" ").ident(c.target).text(" (").idents(c.columns).text(") VALUES ")
+	for i := 0; i < len(batch); i += len(c.columns) {
+		if i > 0 {
+			b.text(", ")
+		}
+		b.text("(").values(batch[i : i+len(c.columns)]).text(")")
+	}
+	if c.update {
+		b.text(" ON DUPLICATE KEY UPDATE ")
+		for i, col := range c.columns {
+			if i > 0 {
+				b.text(", ")
+			}
+			b.ident(col).text(" = VALUES(").ident(col).text(")")
+		}
+	}
+	return b.build()
+}
+
+// createCopyTarget creates target_object_name on the target from SHOW CREATE
+// TABLE of the source, keeping an existing table.
+func createCopyTarget(ctx context.Context, db, target *database, cfg settings) error {
+	source, _ := tableIdent(cfg.objectName)
+	query, _ := new(stmtBuilder).text("SHOW CREATE TABLE ").ident(source).build()
+	var name, ddl string
+	if err := db.QueryRowContext(ctx, query).Scan(&name, &ddl); err != nil {
+		return fmt.Errorf("create_target: failed to read the definition of %s (only base tables can be created): %v", source, err)
+	}
+	ddl, err := renameCreateTable(ddl, cfg.targetObjectName)
+	if err != nil {
+		return err
+	}
+	if _, err := target.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("create_target: %v", err)
+	}
+	return nil
+}
+
+// renameCreateTable points a CREATE TABLE statement at another table and
+// makes it IF NOT EXISTS.
+func renameCreateTable(ddl, name string) (string, error) {
+	quoted, err := quoteQualifiedIdent(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid target_object_name: %v", err)
+	}
+	sawTable := false
+	for _, tok := range tokenize(ddl) {
+		switch {
+		case tok.kind == tokWord && strings.EqualFold(tok.text, "TABLE"):
+			sawTable = true
+		case sawTable && (tok.kind == tokQuotedIdent || tok.kind == tokWord):
+			return ddl[:tok.pos] + "IF NOT EXISTS " + quoted + ddl[tok.pos+len(tok.text):], nil
+		}
+	}
+	return "", fmt.Errorf("create_target: unexpected SHOW CREATE TABLE output")
+}
+
+// watermarkValue renders a watermark so it can be given back as
+// resume_after; times use the MySQL literal format rather than RFC 3339.
+func watermarkValue(v interface{}) interface{} {
+	if t, ok := v.(time.Time); ok {
+		return t.Format("2006-01-02 15:04:05.999999")
+	}
+	return v
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// serverDataTypes act on the server rather than on a database, so dbname
+// may be left empty for them.
+var serverDataTypes = map[string]bool{
+	"create_database": true, "drop_database": true,
+	"create_user": true, "grant": true, "revoke": true, "drop_user": true,
+}
+
+// databaseExists reports whether the schema is present.
+func databaseExists(ctx context.Context, q execer, name string) (bool, error) {
+	var n int
+	if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = ?", name).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to look up database %s: %v", name, err)
+	}
+	return n > 0, nil
+}
+
+// createDatabase implements data_type=create_database. With migration_file
+// the script then runs on the new schema; a database that already existed
+// keeps its contents and the script is skipped.
+func createDatabase(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	name, err := columnIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	existed, err := databaseExists(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	b := new(stmtBuilder).text("CREATE DATABASE ")
+	if cfg.ifNotExists {
+		b.text("IF NOT EXISTS ")
+	}
+	b.ident(name)
+	if cfg.charset != "" {
+		b.text(" CHARACTER SET ").text(sqlText(cfg.charset))
+	}
+	if cfg.collation != "" {
+		b.text(" COLLATE ").text(sqlText(cfg.collation))
+	}
+	ddl, _ := b.build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("execution error: %w", err)
+	}
+	result := map[string]interface{}{"database": cfg.objectName, "existed": existed, "ddl": ddl}
+	if cfg.migrationFile == "" {
+		return result, nil
+	}
+	if existed {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("database %s already existed; migration_file was not run", cfg.objectName))
+		return result, nil
+	}
+	script, err := runScriptFile(ctx, db, cfg.objectName, cfg.migrationFile)
+	if err != nil {
+		// The database stays: dropping it here would hide what the script
+		// got through.
+		return nil, fmt.Errorf("database %s was created but migration_file failed: %w", cfg.objectName, err)
+	}
+	result["migration"] = script
+	return result, nil
+}
+
+// scriptRun reports a script executed by runScriptFile.
+type scriptRun struct {
+	File       string `json:"file"`
+	Statements int    `json:"statements"`
+	Executed   int    `json:"executed"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// runScriptFile runs every statement of the file in order on one connection
+// using schema. The statements autocommit: a script is typically DDL, which
+// cannot be rolled back anyway. The first failure stops the script and names
+// the statement.
+func runScriptFile(ctx context.Context, db *database, schema, path string) (*scriptRun, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	stmts, err := splitStatements(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	run := &scriptRun{File: path, Statements: len(stmts)}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return run, fmt.Errorf("database connection error: %v", err)
+	}
+	defer conn.Close()
+	name, _ := columnIdent(schema)
+	use, _ := new(stmtBuilder).text("USE ").ident(name).build()
+	if _, err := conn.ExecContext(ctx, use); err != nil {
+		return run, fmt.Errorf("execution error: %w", err)
+	}
+	started := time.Now()
+	for i, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			run.DurationMS = time.Since(started).Milliseconds()
+			return run, fmt.Errorf("%s: statement %d: %w", path, i+1, err)
+		}
+		run.Executed++
+	}
+	run.DurationMS = time.Since(started).Milliseconds()
+	return run, nil
+}
+
+// dropDatabase implements data_type=drop_database.
+func dropDatabase(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	name, err := columnIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	existed, err := databaseExists(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	b := new(stmtBuilder).text("DROP DATABASE ")
+	if cfg.ifExists {
+		b.text("IF EXISTS ")
+	}
+	ddl, _ := b.ident(name).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("execution error: %w", err)
+	}
+	return map[string]interface{}{"database": cfg.objectName, "existed": existed, "dropped": existed, "ddl": ddl}, nil
+}
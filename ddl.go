@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tableSchema is the JSON table definition accepted by create_table.
+type tableSchema struct {
+	Columns    []columnDef `json:"columns"`
+	PrimaryKey []string    `json:"primary_key"`
+	Indexes    []indexDef  `json:"indexes"`
+	Engine     string      `json:"engine"`
+	Charset    string      `json:"charset"`
+	Collation  string      `json:"collation"`
+	Comment    string      `json:"comment"`
+}
+
+type columnDef struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type"`
+	Length        int             `json:"length"`
+	Scale         int             `json:"scale"`
+	Unsigned      bool            `json:"unsigned"`
+	Values        []string        `json:"values"` // enum and set members
+	Nullable      *bool           `json:"nullable"`
+	AutoIncrement bool            `json:"auto_increment"`
+	Default       json.RawMessage `json:"default"`
+	Comment       string          `json:"comment"`
+}
+
+type indexDef struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// typeArgs describes what a whitelisted column type accepts in parentheses.
+type typeArgs int
+
+const (
+	argsNone       typeArgs = iota
+	argsLength              // optional (n)
+	argsLengthReq           // required (n)
+	argsPrecision           // optional (p) or (p,s)
+	argsValues              // enum/set members from values
+	argsFractional          // optional fractional seconds (0-6)
+)
+
+var columnTypes = map[string]typeArgs{
+	"tinyint": argsNone, "smallint": argsNone, "mediumint": argsNone, "int": argsNone, "integer": argsNone, "bigint": argsNone,
+	"bool": argsNone, "boolean": argsNone, "float": argsNone, "double": argsNone,
+	"decimal": argsPrecision, "numeric": argsPrecision,
+	"bit": argsLength, "char": argsLength, "binary": argsLength,
+	"varchar": argsLengthReq, "varbinary": argsLengthReq,
+	"tinytext": argsNone, "text": argsNone, "mediumtext": argsNone, "longtext": argsNone,
+	"tinyblob": argsNone, "blob": argsNone, "mediumblob": argsNone, "longblob": argsNone,
+	"date": argsNone, "year": argsNone, "time": argsFractional, "datetime": argsFractional, "timestamp": argsFractional,
+	"json": argsNone, "enum": argsValues, "set": argsValues,
+}
+
+var numericTypes = map[string]bool{
+	"tinyint": true, "smallint": true, "mediumint": true, "int": true, "integer": true, "bigint": true,
+	"float": true, "double": true, "decimal": true, "numeric": true,
+}
+
+var (
+	// typeRe accepts shorthand such as varchar(255), decimal(10,2) or
+	// "bigint unsigned"; anything else is rejected.
+	typeRe = regexp.MustCompile(`^([a-z]+)\s*(?:\(\s*(\d+)\s*(?:,\s*(\d+)\s*)?\))?(\s+unsigned)?$`)
+	// optionRe limits engine, charset and collation names to plain words.
+	optionRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+func parseTableSchema(raw string) (tableSchema, error) {
+	var s tableSchema
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return tableSchema{}, fmt.Errorf("schema must be a JSON table definition: %v", err)
+	}
+	if len(s.Columns) == 0 {
+		return tableSchema{}, fmt.Errorf("schema must define at least one column")
+	}
+	return s, nil
+}
+
+// createTableDDL renders the CREATE TABLE statement. Every identifier is
+// quoted and every type is checked against columnTypes, so nothing from the
+// schema is interpolated verbatim.
+func createTableDDL(name string, s tableSchema, ifNotExists, temporary bool) (string, error) {
+	table, err := tableIdent(name)
+	if err != nil {
+		return "", err
+	}
+
+	b := new(stmtBuilder).text("CREATE ")
+	if temporary {
+		b.text("TEMPORARY ")
+	}
+	b.text("TABLE ")
+	if ifNotExists {
+		b.text("IF NOT EXISTS ")
+	}
+	b.ident(table).text(" (")
+
+	defined := make(map[string]bool, len(s.Columns))
+	for i, c := range s.Columns {
+		if i > 0 {
+			b.text(",")
+		}
+		b.text("\n  ")
+		if err := b.columnDDL(c); err != nil {
+			if c.Name != "" {
+				return "", fmt.Errorf("column %q: %v", c.Name, err)
+			}
+			return "", fmt.Errorf("column %d: %v", i+1, err)
+		}
+		key := strings.ToLower(c.Name)
+		if defined[key] {
+			return "", fmt.Errorf("column %q is defined twice", c.Name)
+		}
+		defined[key] = true
+	}
+
+	keyColumns := func(what string, cols []string) ([]ident, error) {
+		if len(cols) == 0 {
+			return nil, fmt.Errorf("%s needs at least one column", what)
+		}
+		for _, col := range cols {
+			if !defined[strings.ToLower(col)] {
+				return nil, fmt.Errorf("%s references unknown column %q", what, col)
+			}
+		}
+		return columnIdents(cols)
+	}
+	if len(s.PrimaryKey) > 0 {
+		cols, err := keyColumns("primary_key", s.PrimaryKey)
+		if err != nil {
+			return "", err
+		}
+		b.text(",\n  PRIMARY KEY (").idents(cols).text(")")
+	}
+	for i, idx := range s.Indexes {
+		cols, err := keyColumns(fmt.Sprintf("index %d", i+1), idx.Columns)
+		if err != nil {
+			return "", err
+		}
+		b.text(",\n  ")
+		if idx.Unique {
+			b.text("UNIQUE ")
+		}
+		b.text("KEY ")
+		if idx.Name != "" {
+			quoted, err := columnIdent(idx.Name)
+			if err != nil {
+				return "", fmt.Errorf("index %d: %v", i+1, err)
+			}
+			b.ident(quoted).text(" ")
+		}
+		b.text("(").idents(cols).text(")")
+	}
+	b.text("\n)")
+
+	for _, opt := range []struct {
+		keyword sqlText
+		value   string
+	}{
+		{"ENGINE", s.Engine}, {"DEFAULT CHARSET", s.Charset}, {"COLLATE", s.Collation},
+	} {
+		if opt.value == "" {
+			continue
+		}
+		if !optionRe.MatchString(opt.value) {
+			return "", fmt.Errorf("invalid %s %q", strings.ToLower(string(opt.keyword)), opt.value)
+		}
+		b.text(" ").text(opt.keyword).text("=").text(sqlText(opt.value))
+	}
+	if s.Comment != "" {
+		b.text(" COMMENT=").literal(s.Comment)
+	}
+	ddl, _ := b.build()
+	return ddl, nil
+}
+
+// columnDDL writes the definition of column c.
+func (b *stmtBuilder) columnDDL(c columnDef) error {
+	name, err := columnIdent(c.Name)
+	if err != nil {
+		return err
+	}
+	typ, err := columnType(c)
+	if err != nil {
+		return err
+	}
+	var def sqlText
+	if len(c.Default) > 0 {
+		if def, err = defaultLiteral(c.Default); err != nil {
+			return err
+		}
+	}
+
+	b.ident(name).text(" ").text(typ)
+	if c.Nullable != nil && !*c.Nullable {
+		b.text(" NOT NULL")
+	} else if c.Nullable != nil {
+		b.text(" NULL")
+	}
+	if def != "" {
+		b.text(" DEFAULT ").text(def)
+	}
+	if c.AutoIncrement {
+		b.text(" AUTO_INCREMENT")
+	}
+	if c.Comment != "" {
+		b.text(" COMMENT ").literal(c.Comment)
+	}
+	return nil
+}
+
+// columnType validates the column type and renders it in canonical form.
+func columnType(c columnDef) (sqlText, error) {
+	m := typeRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(c.Type)))
+	if m == nil {
+		return "", fmt.Errorf("unsupported column type %q", c.Type)
+	}
+	base := m[1]
+	kind, ok := columnTypes[base]
+	if !ok {
+		return "", fmt.Errorf("unsupported column type %q", base)
+	}
+
+	length, scale := c.Length, c.Scale
+	if m[2] != "" {
+		length, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		scale, _ = strconv.Atoi(m[3])
+	}
+	unsigned := c.Unsigned || m[4] != ""
+	if unsigned && !numericTypes[base] {
+		return "", fmt.Errorf("%s cannot be unsigned", base)
+	}
+	if scale != 0 && kind != argsPrecision {
+		return "", fmt.Errorf("%s does not take a scale", base)
+	}
+	if len(c.Values) > 0 && kind != argsValues {
+		return "", fmt.Errorf("%s does not take values", base)
+	}
+
+	typ := strings.ToUpper(base)
+	switch kind {
+	case argsNone:
+		if length != 0 {
+			return "", fmt.Errorf("%s does not take a length", base)
+		}
+	case argsLength, argsLengthReq:
+		if length == 0 && kind == argsLengthReq {
+			return "", fmt.Errorf("%s requires a length", base)
+		}
+		if length != 0 {
+			typ += fmt.Sprintf("(%d)", length)
+		}
+	case argsPrecision:
+		if length != 0 {
+			typ += fmt.Sprintf("(%d,%d)", length, scale)
+		}
+	case argsFractional:
+		if length > 6 {
+			return "", fmt.Errorf("%s precision must be between 0 and 6", base)
+		}
+		if length != 0 {
+			typ += fmt.Sprintf("(%d)", length)
+		}
+	case argsValues:
+		if length != 0 {
+			return "", fmt.Errorf("%s does not take a length", base)
+		}
+		if len(c.Values) == 0 {
+			return "", fmt.Errorf("%s requires values", base)
+		}
+		quoted := make([]string, len(c.Values))
+		for i, v := range c.Values {
+			quoted[i] = quoteString(v)
+		}
+		typ += "(" + strings.Join(quoted, ",") + ")"
+	}
+	if unsigned {
+		typ += " UNSIGNED"
+	}
+	return sqlText(typ), nil
+}
+
+// defaultLiteral renders a JSON scalar as a SQL literal.
+func defaultLiteral(raw json.RawMessage) (sqlText, error) {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case json.Number:
+		return sqlText(v.String()), nil
+	case string:
+		return sqlText(quoteString(v)), nil
+	}
+	return "", fmt.Errorf("default must be a string, number, boolean or null")
+}
+
+// quoteString renders s as a single-quoted SQL string literal.
+func quoteString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `''`, "\x00", `\0`)
+	return "'" + r.Replace(s) + "'"
+}
+
+// createTable implements data_type=create_table and returns the DDL it ran.
+func createTable(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	s, err := parseTableSchema(cfg.tableSchema)
+	if err != nil {
+		return nil, err
+	}
+	ddl, err := createTableDDL(cfg.objectName, s, cfg.ifNotExists, cfg.temporary)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
+	if cfg.temporary {
+		out.Warnings = append(out.Warnings, "temporary tables are dropped when this run's connection closes")
+	}
+	return map[string]interface{}{"ddl": ddl}, nil
+}
+
+// tableExists reports whether name (optionally schema-qualified) exists,
+// defaulting to the connection's database.
+func tableExists(ctx context.Context, q execer, name string) (bool, error) {
+	parts := splitQualified(name)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	var n int
+	err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ?",
+		schema, parts[len(parts)-1]).Scan(&n)
+	return n > 0, err
+}
+
+// truncateTable implements data_type=truncate.
+func truncateTable(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	existed, err := tableExists(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up table: %v", err)
+	}
+	if !existed {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	ddl, _ := new(stmtBuilder).text("TRUNCATE TABLE ").ident(table).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"existed": true, "truncated": true, "ddl": ddl}, nil
+}
+
+// dropTable implements data_type=drop_table. With if_exists a missing table
+// is reported rather than treated as an error.
+func dropTable(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	existed, err := tableExists(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up table: %v", err)
+	}
+	b := new(stmtBuilder).text("DROP TABLE ")
+	if cfg.ifExists {
+		b.text("IF EXISTS ")
+	}
+	ddl, _ := b.ident(table).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"existed": existed, "dropped": existed, "ddl": ddl}, nil
+}
+
+// nextSequenceValue implements data_type=next_sequence_value for MariaDB
+// sequences.
+func nextSequenceValue(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	seq, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	v, err := db.serverVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.require("sequences", v.sequences()); err != nil {
+		return nil, err
+	}
+	var next int64
+	query, _ := new(stmtBuilder).text("SELECT NEXT VALUE FOR ").ident(seq).build()
+	if err := db.QueryRowContext(ctx, query).Scan(&next); err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	return map[string]interface{}{"sequence": cfg.objectName, "value": next}, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// decimalModes is the decimal_mode and decimal_columns inputs of an
+// invocation. DECIMAL values arrive from the server as text and never pass
+// through float64: mode string (the default) returns that text unchanged,
+// trailing zeros included, and mode number returns it as a JSON number,
+// which is only allowed when a float64 reader gets the same value back.
+type decimalModes struct {
+	mode    string            // string or number
+	columns map[string]string // per-column overrides, by result name
+}
+
+func parseDecimalModes(mode, columns string) (*decimalModes, error) {
+	d := &decimalModes{mode: mode, columns: map[string]string{}}
+	if d.mode == "" {
+		d.mode = "string"
+	}
+	if d.mode != "string" && d.mode != "number" {
+		return nil, fmt.Errorf("decimal_mode must be string or number, got %q", mode)
+	}
+	if columns != "" {
+		if err := json.Unmarshal([]byte(columns), &d.columns); err != nil {
+			return nil, fmt.Errorf(`decimal_columns must be an object of column modes like {"rate": "number"}: %v`, err)
+		}
+	}
+	for _, col := range sortedKeys(d.columns) {
+		if m := d.columns[col]; m != "string" && m != "number" {
+			return nil, fmt.Errorf("decimal_columns: %q must be string or number, got %q", col, m)
+		}
+	}
+	return d, nil
+}
+
+// numeric reports whether col is returned as a JSON number.
+func (d *decimalModes) numeric(col string) bool {
+	if d == nil {
+		return false
+	}
+	if m, ok := d.columns[col]; ok {
+		return m == "number"
+	}
+	return d.mode == "number"
+}
+
+// key is the part of the cache key that depends on the decimal handling.
+func (d *decimalModes) key() string {
+	if d == nil || (d.mode == "string" && len(d.columns) == 0) {
+		return ""
+	}
+	b, _ := json.Marshal(d.columns)
+	return fmt.Sprintf("\ndecimal=%s,%s", d.mode, b)
+}
+
+// decimalColumns marks the DECIMAL result columns returned as numbers,
+// indexed like them, or returns nil when there are none.
+func decimalColumns(rows *sql.Rows, columns []string, d *decimalModes) ([]bool, error) {
+	if d == nil || (d.mode == "string" && len(d.columns) == 0) {
+		return nil, nil
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("columns error: %v", err)
+	}
+	var numeric []bool
+	for i, col := range columns {
+		if types[i].DatabaseTypeName() == "DECIMAL" && d.numeric(col) {
+			if numeric == nil {
+				numeric = make([]bool, len(columns))
+			}
+			numeric[i] = true
+		}
+	}
+	return numeric, nil
+}
+
+// decimalNumber returns the DECIMAL text s as a JSON number, unchanged, when
+// reading it as a float64 gives back exactly the same value: 0.1 and
+// 12345.6700 qualify, an 18 digit amount does not.
+func decimalNumber(col, s string) (json.Number, error) {
+	exact, ok := parseExact(s)
+	if !ok {
+		return "", fmt.Errorf("decimal_mode=number: %s holds %q, which is not a number", col, s)
+	}
+	f, _ := exact.Float64()
+	read, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if read == nil || read.Cmp(exact) != 0 {
+		return "", fmt.Errorf("decimal_mode=number: %s holds %s, which a float64 reads back as %s; use decimal_mode=string or decimal_columns {%q: \"string\"}",
+			col, s, strconv.FormatFloat(f, 'g', -1, 64), col)
+	}
+	return json.Number(s), nil
+}
+
+// exactDecimal returns the text of a JSON number that a float64 does not
+// hold exactly, such as 12345678901234.5678, which json.Unmarshal would
+// round. Bound as text, it reaches a DECIMAL column unchanged.
+func exactDecimal(raw json.RawMessage) (string, bool) {
+	text := strings.TrimSpace(string(raw))
+	exact, ok := parseExact(text)
+	if !ok {
+		return "", false
+	}
+	f, _ := exact.Float64()
+	read, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return text, read == nil || read.Cmp(exact) != 0
+}
+
+// parseExact reads a decimal or integer without rounding, for comparisons
+// that must tell 18 digit amounts apart.
+func parseExact(s string) (*big.Rat, bool) {
+	if strings.Contains(s, "/") {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
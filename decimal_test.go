@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var amountColumns = []stubColumn{{"amount", "DECIMAL"}, {"rate", "DECIMAL"}}
+
+func TestDecimalRoundTripAsString(t *testing.T) {
+	cfg := settings{decimalMode: "string"}
+	tests := []struct {
+		parameters string
+		want       string
+	}{
+		// JSON numbers a float64 would round are bound as their text.
+		{`[12345678901234.5678, "0.1000"]`, `[{"amount":"12345678901234.5678","rate":"0.1000"}]`},
+		{`["99999999999999.9999", "-0.0000"]`, `[{"amount":"99999999999999.9999","rate":"-0.0000"}]`},
+		{`[-12345678901234.5678, "1.10"]`, `[{"amount":"-12345678901234.5678","rate":"1.10"}]`},
+		{`["0.30000000000000004441", null]`, `[{"amount":"0.30000000000000004441","rate":null}]`},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, cfg, amountColumns, tt.parameters); got != tt.want {
+			t.Errorf("%s came back as %s, want %s", tt.parameters, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalNumberModeRefusesLoss(t *testing.T) {
+	cfg := settings{decimalMode: "number", decimalColumns: `{"amount": "string"}`}
+	if got, want := roundTrip(t, cfg, amountColumns, `["12345678901234.5678", "0.25"]`), `[{"amount":"12345678901234.5678","rate":0.25}]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	for _, s := range []string{"12345678901234.5678", "0.30000000000000004441", "123456789012345678"} {
+		if _, err := decimalNumber("rate", s); err == nil {
+			t.Errorf("decimalNumber(%q) passed a value a float64 changes", s)
+		}
+	}
+	for _, s := range []string{"0.1", "12345.6700", "-0.0000", "0.5"} {
+		if n, err := decimalNumber("rate", s); err != nil || string(n) != s {
+			t.Errorf("decimalNumber(%q) = %q, %v, want it unchanged", s, n, err)
+		}
+	}
+}
+
+func TestExactDecimalParameters(t *testing.T) {
+	args, err := parseArgs(`[12345678901234.5678, 0.1, 0.5, 18446744073709551615, 3]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"12345678901234.5678", 0.1, 0.5, uint64(18446744073709551615), float64(3)}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("argument %d = %#v, want %#v", i, args[i], want[i])
+		}
+	}
+}
+
+func TestSummarizeDecimalsExactly(t *testing.T) {
+	rows := []map[string]interface{}{{"amount": "0.1"}, {"amount": "0.2"}, {"amount": "99999999999999.9999"}, {"amount": "-0.0000"}}
+	out := &Output{}
+	summary := summarizeColumns(settings{summarizeColumns: `{"amount": ["sum", "avg", "min", "max"]}`}, rows, out)
+	got, err := json.Marshal(summary.Columns["amount"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"avg":"25000000000000.07497500","max":"99999999999999.9999","min":"-0.0000","sum":"100000000000000.2999"}`; string(got) != want {
+		t.Errorf("summary = %s, want %s", got, want)
+	}
+}
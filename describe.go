@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// describeColumn is a DESCRIBE row in the shape data_type=describe returns
+// on every supported server.
+type describeColumn struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Nullable bool        `json:"nullable"`
+	Key      string      `json:"key"`
+	Default  interface{} `json:"default"`
+	Extra    string      `json:"extra"`
+}
+
+// explainRow is a row of traditional EXPLAIN output. MySQL 5.7 added
+// partitions and filtered to the default output; columns a server does not
+// return are null.
+type explainRow struct {
+	ID           interface{} `json:"id"`
+	SelectType   interface{} `json:"select_type"`
+	Table        interface{} `json:"table"`
+	Partitions   interface{} `json:"partitions"`
+	Type         interface{} `json:"type"`
+	PossibleKeys interface{} `json:"possible_keys"`
+	Key          interface{} `json:"key"`
+	KeyLen       interface{} `json:"key_len"`
+	Ref          interface{} `json:"ref"`
+	Rows         interface{} `json:"rows"`
+	Filtered     interface{} `json:"filtered"`
+	Extra        interface{} `json:"extra"`
+}
+
+// describe implements data_type=describe: DESCRIBE object_name as columns,
+// or the traditional EXPLAIN of query as plan, with the rows as the server
+// returned them under raw.
+func describe(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.objectName != "" {
+		name, err := tableIdent(cfg.objectName)
+		if err != nil {
+			return nil, err
+		}
+		query, _ := new(stmtBuilder).text("DESCRIBE ").ident(name).build()
+		raw, err := describeRows(ctx, db, cfg, query)
+		if err != nil {
+			return nil, err
+		}
+		columns := make([]describeColumn, len(raw))
+		for i, row := range raw {
+			columns[i] = normalizeDescribe(row)
+		}
+		return map[string]interface{}{"columns": columns, "raw": raw}, nil
+	}
+	args, err := parseArgs(cfg.parameters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters: %v", err)
+	}
+	explain, _ := new(stmtBuilder).text("EXPLAIN ").text(sqlText(cfg.query)).build()
+	raw, err := describeRows(ctx, db, cfg, explain, args...)
+	if err != nil {
+		return nil, err
+	}
+	plan := make([]explainRow, len(raw))
+	for i, row := range raw {
+		plan[i] = normalizeExplain(row)
+	}
+	return map[string]interface{}{"plan": plan, "raw": raw}, nil
+}
+
+func describeRows(ctx context.Context, db *database, cfg settings, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	defer rows.Close()
+	return scanRows(rows, cfg)
+}
+
+// lowerKeys returns row keyed by lower-case column names, since servers
+// differ in the case of some, such as Field and Type.
+func lowerKeys(row map[string]interface{}) map[string]interface{} {
+	lower := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		lower[strings.ToLower(col)] = v
+	}
+	return lower
+}
+
+// describeText returns a DESCRIBE or EXPLAIN value as text, "" for NULL.
+func describeText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// intDisplayWidth is the display width MySQL 8.0.19 stopped showing for
+// integer types, as in int(11) unsigned.
+var intDisplayWidth = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|integer|bigint)\(\d+\)`)
+
+func normalizeDescribe(row map[string]interface{}) describeColumn {
+	row = lowerKeys(row)
+	c := describeColumn{
+		Name:     describeText(row["field"]),
+		Type:     strings.ToLower(describeText(row["type"])),
+		Nullable: strings.EqualFold(describeText(row["null"]), "YES"),
+		Key:      strings.ToUpper(describeText(row["key"])),
+		Default:  row["default"],
+	}
+	// 8.0 keeps the width for ZEROFILL and tinyint(1), the boolean.
+	if !strings.Contains(c.Type, "zerofill") && c.Type != "tinyint(1)" && !strings.HasPrefix(c.Type, "tinyint(1) ") {
+		c.Type = intDisplayWidth.ReplaceAllString(c.Type, "$1")
+	}
+	// MariaDB writes current_timestamp() where MySQL writes CURRENT_TIMESTAMP.
+	if d, ok := c.Default.(string); ok && strings.EqualFold(strings.TrimSuffix(d, "()"), "current_timestamp") {
+		c.Default = "CURRENT_TIMESTAMP"
+	}
+	// 8.0 flags expression defaults DEFAULT_GENERATED, which 5.7 has no
+	// word for, and MariaDB writes on update current_timestamp().
+	var extra []string
+	for _, word := range strings.Fields(describeText(row["extra"])) {
+		if !strings.EqualFold(word, "DEFAULT_GENERATED") {
+			if strings.EqualFold(strings.TrimSuffix(word, "()"), "current_timestamp") {
+				word = "CURRENT_TIMESTAMP"
+			}
+			extra = append(extra, word)
+		}
+	}
+	c.Extra = strings.Join(extra, " ")
+	return c
+}
+
+func normalizeExplain(row map[string]interface{}) explainRow {
+	row = lowerKeys(row)
+	value := func(col string) interface{} {
+		s := describeText(row[col])
+		if s == "" || s == "NULL" {
+			return nil
+		}
+		return s
+	}
+	number := func(col string) interface{} {
+		s, ok := value(col).(string)
+		if !ok {
+			return nil
+		}
+		if isShowNumber(s) {
+			return json.Number(s)
+		}
+		return s
+	}
+	return explainRow{
+		ID:           number("id"),
+		SelectType:   value("select_type"),
+		Table:        value("table"),
+		Partitions:   value("partitions"),
+		Type:         value("type"),
+		PossibleKeys: value("possible_keys"),
+		Key:          value("key"),
+		KeyLen:       value("key_len"),
+		Ref:          value("ref"),
+		Rows:         number("rows"),
+		Filtered:     number("filtered"),
+		Extra:        value("extra"),
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+)
+
+// describeServer answers DESCRIBE with the rows of one column as a server
+// version returns it.
+func describeServer(row ...string) func(string) ([]stubColumn, [][]driver.Value) {
+	return func(string) ([]stubColumn, [][]driver.Value) {
+		columns := []stubColumn{{"Field", "VARCHAR"}, {"Type", "VARCHAR"}, {"Null", "VARCHAR"}, {"Key", "VARCHAR"}, {"Default", "VARCHAR"}, {"Extra", "VARCHAR"}}
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			if v != "NULL" {
+				values[i] = []byte(v)
+			}
+		}
+		return columns, [][]driver.Value{values}
+	}
+}
+
+func TestDescribeTablePrefix(t *testing.T) {
+	cfg, errs := parseSettings(inputOf("host", "h", "username", "u", "dbname", "erp", "data_type", "describe", "object_name", "erp.invoices", "table_prefix", "t001_"))
+	if len(errs) > 0 {
+		t.Fatal(errs.summary())
+	}
+	db, stub := openStubDB(t)
+	stub.respond = describeServer("id", "int", "NO", "PRI", "NULL", "auto_increment")
+	if _, err := describe(context.Background(), &database{DB: db}, cfg, &Output{}); err != nil {
+		t.Fatal(err)
+	}
+	if sent := stub.sent(); len(sent) != 1 || sent[0] != "DESCRIBE `erp`.`t001_invoices`" {
+		t.Errorf("sent %q, want DESCRIBE of the prefixed table", sent)
+	}
+}
+
+func TestDescribeNormalizesVersions(t *testing.T) {
+	const want = `[{"name":"created","type":"int unsigned","nullable":true,"key":"","default":"CURRENT_TIMESTAMP","extra":"on update CURRENT_TIMESTAMP"}]`
+	for version, row := range map[string][]string{
+		"5.7":     {"created", "int(10) unsigned", "YES", "", "CURRENT_TIMESTAMP", "on update CURRENT_TIMESTAMP"},
+		"8.0":     {"created", "int unsigned", "YES", "", "CURRENT_TIMESTAMP", "DEFAULT_GENERATED on update CURRENT_TIMESTAMP"},
+		"mariadb": {"created", "int(10) unsigned", "YES", "", "current_timestamp()", "on update current_timestamp()"},
+	} {
+		db, stub := openStubDB(t)
+		stub.respond = describeServer(row...)
+		result, err := describe(context.Background(), &database{DB: db}, settings{dataType: "describe", objectName: "t"}, &Output{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(result.(map[string]interface{})["columns"])
+		if string(got) != want {
+			t.Errorf("%s: columns %s, want %s", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxDifferences caps the rows a diff lists unless max_differences
+// is set; the counts always cover every row.
+const defaultMaxDifferences = 100
+
+// diffNormalizations are the diff_normalize options:
+//
+//	decimals  1.50 and 1.5, or 2.000 and 2, compare equal
+//	timezone  both sessions run in UTC so TIMESTAMP values compare as
+//	          instants, and all-zero fractional seconds are dropped
+//	trim      trailing spaces are ignored
+//
+// decimals and timezone apply unless diff_normalize is set; none turns
+// every option off.
+var diffNormalizations = []string{"decimals", "timezone", "trim"}
+
+func parseDiffNormalize(raw string) (map[string]bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return map[string]bool{"decimals": true, "timezone": true}, nil
+	}
+	opts := map[string]bool{}
+	for _, o := range strings.Split(raw, ",") {
+		switch o = strings.ToLower(strings.TrimSpace(o)); {
+		case o == "none":
+		case containsString(diffNormalizations, o):
+			opts[o] = true
+		default:
+			return nil, fmt.Errorf("unknown diff_normalize option %q; use %s or none", o, strings.Join(diffNormalizations, ", "))
+		}
+	}
+	return opts, nil
+}
+
+type diffChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type changedRow struct {
+	Key     map[string]interface{} `json:"key"`
+	Columns map[string]diffChange  `json:"columns"`
+}
+
+// diffResult reports a diff. Removed rows are only in the first result
+// (query on the source), added rows only in the second (compare_query, or
+// query on the target).
+type diffResult struct {
+	Matched     int64                    `json:"matched"`
+	Added       int64                    `json:"added"`
+	Removed     int64                    `json:"removed"`
+	Changed     int64                    `json:"changed"`
+	AddedRows   []map[string]interface{} `json:"added_rows"`
+	RemovedRows []map[string]interface{} `json:"removed_rows"`
+	ChangedRows []changedRow             `json:"changed_rows"`
+	Truncated   bool                     `json:"truncated,omitempty"` // more differences than max_differences
+	OnlyIn      map[string][]string      `json:"columns_only_in,omitempty"`
+}
+
+// diffSide is one ordered result being merged.
+type diffSide struct {
+	name    string
+	scanner *rowScanner
+	row     map[string]interface{}
+	key     []interface{}
+	keys    []string
+}
+
+func (s *diffSide) advance() error {
+	prev := s.key
+	row, err := s.scanner.next()
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.name, err)
+	}
+	// Only the two current rows are held, so the result budget is per row.
+	s.scanner.budget.total = 0
+	s.row, s.key = row, nil
+	if row == nil {
+		return nil
+	}
+	s.key = make([]interface{}, len(s.keys))
+	for i, k := range s.keys {
+		v, ok := row[k]
+		if !ok {
+			return fmt.Errorf("%s: key column %s is not in the result", s.name, k)
+		}
+		s.key[i] = v
+	}
+	if prev != nil {
+		switch c := compareKeys(prev, s.key); {
+		case c == 0:
+			return fmt.Errorf("%s: key_columns do not identify rows uniquely: %v appears twice", s.name, s.key)
+		case c > 0:
+			return fmt.Errorf("%s: rows did not arrive in the order this diff compares keys in (%v after %v); use key columns the server and a plain comparison order alike, such as integers", s.name, s.key, prev)
+		}
+	}
+	return nil
+}
+
+// compareKeys orders keys as the merge expects the ORDER BY to: NULL first,
+// numbers numerically and strings case-insensitively, like the default
+// collations.
+func compareKeys(a, b []interface{}) int {
+	for i := range a {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return -1
+		case b[i] == nil:
+			return 1
+		}
+		as, bs := fmt.Sprint(a[i]), fmt.Sprint(b[i])
+		ar, aok := parseExact(as)
+		br, bok := parseExact(bs)
+		if aok && bok {
+			if c := ar.Cmp(br); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := strings.Compare(strings.ToLower(as), strings.ToLower(bs)); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// normalizeDiffValue renders v for comparison under the diff_normalize
+// options.
+func normalizeDiffValue(v interface{}, opts map[string]bool) interface{} {
+	if v == nil {
+		return nil
+	}
+	s := fmt.Sprint(v)
+	if opts["trim"] {
+		s = strings.TrimRight(s, " ")
+	}
+	if opts["decimals"] && strings.Contains(s, ".") {
+		if _, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "eE") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+	}
+	// 2024-01-31 10:00:00.000000
+	if opts["timezone"] && len(s) > 20 && s[4] == '-' && s[10] == ' ' && s[19] == '.' && strings.Trim(s[20:], "0") == "" {
+		s = s[:19]
+	}
+	return s
+}
+
+// diffQuery orders the query by the key columns.
+func diffQuery(query string, keys []string) string {
+	quoted, _ := columnIdents(keys)
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	sql, _ := new(stmtBuilder).text("SELECT * FROM (").text(sqlText(query)).text(") AS diff_rows ORDER BY ").idents(quoted).build()
+	return sql
+}
+
+// openDiffSide runs one side's query on a connection of its own: the two
+// results are read at the same time.
+func openDiffSide(ctx context.Context, db *database, cfg settings, name, query string, keys []string, opts map[string]bool) (*diffSide, *sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: database connection error: %v", name, err)
+	}
+	if opts["timezone"] {
+		if _, err := conn.ExecContext(ctx, "SET time_zone = '+00:00'"); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("%s: failed to set the session time zone: %v", name, err)
+		}
+	}
+	rows, err := conn.QueryContext(ctx, diffQuery(query, keys))
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s: execution error: %w", name, err)
+	}
+	scanner, err := newRowScanner(rows, cfg)
+	if err != nil {
+		rows.Close()
+		conn.Close()
+		return nil, nil, err
+	}
+	return &diffSide{name: name, scanner: scanner, keys: keys}, conn, nil
+}
+
+// diff implements data_type=diff: query against compare_query on the same
+// server, or query on the source against the same query on the target
+// connection. Both results are read ordered by key_columns and merged, so
+// neither is held in memory.
+func diff(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	keys, _ := parseIdentList(cfg.keyColumns)
+	opts, _ := parseDiffNormalize(cfg.diffNormalize)
+	maxDiffs := cfg.maxDifferences
+	if maxDiffs == 0 {
+		maxDiffs = defaultMaxDifferences
+	}
+
+	afterDB, afterQuery, afterName := db, cfg.compareQuery, "compare_query"
+	if cfg.compareQuery == "" {
+		tcfg, err := targetSettings(cfg)
+		if err != nil {
+			return nil, err
+		}
+		target, err := connect(tcfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to target: %s", redact(err.Error(), tcfg))
+		}
+		defer target.Close()
+		afterDB, afterQuery, afterName = target, cfg.query, "target"
+	}
+	before, beforeConn, err := openDiffSide(ctx, db, cfg, "query", cfg.query, keys, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer beforeConn.Close()
+	defer before.scanner.rows.Close()
+	after, afterConn, err := openDiffSide(ctx, afterDB, cfg, afterName, afterQuery, keys, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer afterConn.Close()
+	defer after.scanner.rows.Close()
+
+	result := &diffResult{AddedRows: []map[string]interface{}{}, RemovedRows: []map[string]interface{}{}, ChangedRows: []changedRow{}}
+	var compared []string
+	onlyIn := map[string][]string{}
+	for _, col := range before.scanner.columns {
+		switch {
+		case !containsString(after.scanner.columns, col):
+			onlyIn["query"] = append(onlyIn["query"], col)
+		case !containsString(keys, col):
+			compared = append(compared, col)
+		}
+	}
+	for _, col := range after.scanner.columns {
+		if !containsString(before.scanner.columns, col) {
+			onlyIn[afterName] = append(onlyIn[afterName], col)
+		}
+	}
+	if len(onlyIn) > 0 {
+		result.OnlyIn = onlyIn
+		out.Warnings = append(out.Warnings, "the results have different columns; only the shared ones are compared, see columns_only_in")
+	}
+
+	listed := 0
+	list := func() bool {
+		if listed >= maxDiffs {
+			result.Truncated = true
+			return false
+		}
+		listed++
+		return true
+	}
+	keyMap := func(key []interface{}) map[string]interface{} {
+		m := make(map[string]interface{}, len(keys))
+		for i, k := range keys {
+			m[k] = key[i]
+		}
+		return m
+	}
+	if err := before.advance(); err != nil {
+		return nil, err
+	}
+	if err := after.advance(); err != nil {
+		return nil, err
+	}
+	for before.row != nil || after.row != nil {
+		c := 0
+		switch {
+		case after.row == nil:
+			c = -1
+		case before.row == nil:
+			c = 1
+		default:
+			c = compareKeys(before.key, after.key)
+		}
+		switch {
+		case c < 0:
+			result.Removed++
+			if list() {
+				result.RemovedRows = append(result.RemovedRows, keyMap(before.key))
+			}
+			err = before.advance()
+		case c > 0:
+			result.Added++
+			if list() {
+				result.AddedRows = append(result.AddedRows, keyMap(after.key))
+			}
+			err = after.advance()
+		default:
+			changes := map[string]diffChange{}
+			for _, col := range compared {
+				b, a := before.row[col], after.row[col]
+				if normalizeDiffValue(b, opts) != normalizeDiffValue(a, opts) {
+					changes[col] = diffChange{Before: b, After: a}
+				}
+			}
+			if len(changes) == 0 {
+				result.Matched++
+			} else {
+				result.Changed++
+				if list() {
+					result.ChangedRows = append(result.ChangedRows, changedRow{Key: keyMap(before.key), Columns: changes})
+				}
+			}
+			if err = before.advance(); err == nil {
+				err = after.advance()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
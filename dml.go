@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// parseValues decodes the values input of update: a JSON object of column
+// to new value.
+func parseValues(raw string) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, fmt.Errorf("values must be a JSON object of column to value: %v", err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("values must set at least one column")
+	}
+	return values, nil
+}
+
+// parseInsertRows decodes the values input of insert: one JSON object or an
+// array of objects that all set the same columns.
+func parseInsertRows(raw string) ([]string, [][]interface{}, error) {
+	var rows []map[string]interface{}
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &rows); err != nil {
+			return nil, nil, fmt.Errorf("values must be a JSON object or an array of objects: %v", err)
+		}
+	} else {
+		row, err := parseValues(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return nil, nil, fmt.Errorf("values must set at least one column")
+	}
+
+	cols := make([]string, 0, len(rows[0]))
+	for col := range rows[0] {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		if len(row) != len(cols) {
+			return nil, nil, fmt.Errorf("row %d sets different columns than row 1", i+1)
+		}
+		values[i] = make([]interface{}, len(cols))
+		for j, col := range cols {
+			v, ok := row[col]
+			if !ok {
+				return nil, nil, fmt.Errorf("row %d is missing column %q", i+1, col)
+			}
+			values[i][j] = v
+		}
+	}
+	return cols, values, nil
+}
+
+// buildInsert renders a single INSERT for every row in values.
+func buildInsert(cfg settings) (statement, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return statement{}, err
+	}
+	cols, rows, err := parseInsertRows(cfg.values)
+	if err != nil {
+		return statement{}, err
+	}
+	if cols, rows, err = scopeInsertRows(cfg, cols, rows); err != nil {
+		return statement{}, err
+	}
+	return insertStatement(table, cols, rows)
+}
+
+// insertStatement renders one multi-row INSERT into table.
+func insertStatement(table ident, cols []string, rows [][]interface{}) (statement, error) {
+	quoted, err := columnIdents(cols)
+	if err != nil {
+		return statement{}, fmt.Errorf("invalid column in values: %v", err)
+	}
+	b := new(stmtBuilder).text("INSERT INTO ").ident(table).text(" (").idents(quoted).text(") VALUES ")
+	for i, row := range rows {
+		if i > 0 {
+			b.text(", ")
+		}
+		b.text("(").values(row).text(")")
+	}
+	return b.statement(false), nil
+}
+
+// buildUpdate renders UPDATE object_name SET ... WHERE filter.
+func buildUpdate(cfg settings) (statement, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return statement{}, err
+	}
+	values, err := parseValues(cfg.values)
+	if err != nil {
+		return statement{}, err
+	}
+	f, err := writeFilter(cfg)
+	if err != nil {
+		return statement{}, err
+	}
+
+	b := new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ")
+	for i, col := range sortedKeys(values) {
+		quoted, err := columnIdent(col)
+		if err != nil {
+			return statement{}, fmt.Errorf("invalid column in values: %v", err)
+		}
+		if i > 0 {
+			b.text(", ")
+		}
+		b.ident(quoted).text(" = ?").arg(values[col])
+	}
+	return b.where(f).statement(false), nil
+}
+
+// countUpdateChanges counts, before data_type=update runs, the rows it
+// will change, where rows_affected under found_rows counts the rows it
+// matches. The rows are locked with FOR UPDATE, so q must be the
+// transaction of the UPDATE. A string value is compared byte for byte, as
+// the server does when it decides whether a row changed, so a change of
+// letter case counts under a case-insensitive collation; other values are
+// compared as the column's type.
+func countUpdateChanges(ctx context.Context, q execer, cfg settings) (int64, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return 0, err
+	}
+	values, err := parseValues(cfg.values)
+	if err != nil {
+		return 0, err
+	}
+	f, err := writeFilter(cfg)
+	if err != nil {
+		return 0, err
+	}
+	b := new(stmtBuilder).text("SELECT COUNT(*) FROM ").ident(table).where(f)
+	if f.SQL == "" {
+		b.text(" WHERE NOT (")
+	} else {
+		b.text(" AND NOT (")
+	}
+	for i, col := range sortedKeys(values) {
+		quoted, _ := columnIdent(col)
+		if i > 0 {
+			b.text(" AND ")
+		}
+		if _, ok := values[col].(string); ok {
+			b.text("BINARY ").ident(quoted).text(" <=> BINARY ?")
+		} else {
+			b.ident(quoted).text(" <=> ?")
+		}
+		b.arg(values[col])
+	}
+	query, args := b.text(") FOR UPDATE").build()
+	var n int64
+	if err := q.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count the rows to change: %w", err)
+	}
+	return n, nil
+}
+
+// softDelete reports how soft_delete_column was applied.
+type softDelete struct {
+	Column         string `json:"column"`
+	IncludeDeleted bool   `json:"include_deleted"`
+}
+
+// writeFilter is the filter of an update or delete. A soft delete skips
+// rows that are already marked unless include_deleted is set, and
+// tenant_column limits it to the tenant's rows.
+func writeFilter(cfg settings) (filter, error) {
+	f, err := parseFilter(cfg.filter)
+	if err != nil {
+		return filter{}, err
+	}
+	if (cfg.dataType == "delete" || cfg.dataType == "purge") && cfg.softDeleteColumn != "" && !cfg.includeDeleted {
+		col, err := columnIdent(cfg.softDeleteColumn)
+		if err != nil {
+			return filter{}, fmt.Errorf("invalid soft_delete_column: %v", err)
+		}
+		f = f.and(col.sql + " IS NULL")
+	}
+	if cond, args := tenantCondition(cfg); cond != "" {
+		f = f.and(cond, args...)
+	}
+	return f, nil
+}
+
+// buildDelete renders DELETE FROM object_name WHERE filter, or with
+// soft_delete_column an UPDATE setting that column to NOW().
+func buildDelete(cfg settings) (statement, error) {
+	b, err := deleteBuilder(cfg)
+	if err != nil {
+		return statement{}, err
+	}
+	return b.statement(false), nil
+}
+
+// deleteBuilder writes the statement of buildDelete, which purge goes on to
+// limit to a batch.
+func deleteBuilder(cfg settings) (*stmtBuilder, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := writeFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.softDeleteColumn != "" {
+		col, _ := columnIdent(cfg.softDeleteColumn)
+		return new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ").ident(col).text(" = NOW()").where(f), nil
+	}
+	return new(stmtBuilder).text("DELETE FROM ").ident(table).where(f), nil
+}
+
+// tableColumns lists the columns of a possibly qualified table in ordinal
+// order, defaulting to the connection's database.
+func tableColumns(ctx context.Context, q execer, name string) ([]string, error) {
+	parts := splitQualified(name)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	return queryStrings(ctx, q,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? ORDER BY ordinal_position",
+		schema, parts[len(parts)-1])
+}
+
+// takeSnapshot copies the rows an update or delete is about to touch into
+// snapshot_table, which must have the target's columns in the same order
+// followed by a timestamp and an operation tag column. The layout is
+// checked first so a mismatch fails before any data is touched.
+func takeSnapshot(ctx context.Context, q execer, cfg settings) (int64, error) {
+	snapshot, err := tableIdent(cfg.snapshotTable)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snapshot_table: %v", err)
+	}
+	table, _ := tableIdent(cfg.objectName)
+
+	target, err := tableColumns(ctx, q, cfg.objectName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read columns of %s: %v", table, err)
+	}
+	snap, err := tableColumns(ctx, q, cfg.snapshotTable)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read columns of %s: %v", snapshot, err)
+	}
+	if len(snap) != len(target)+2 {
+		return 0, fmt.Errorf("snapshot_table %s has %d columns, expected the %d columns of %s plus a timestamp and an operation column", snapshot, len(snap), len(target), table)
+	}
+	for i, col := range target {
+		if !strings.EqualFold(col, snap[i]) {
+			return 0, fmt.Errorf("snapshot_table column %d is %q, expected %q to match %s", i+1, snap[i], col, table)
+		}
+	}
+
+	f, err := writeFilter(cfg)
+	if err != nil {
+		return 0, err
+	}
+	query, args := new(stmtBuilder).text("INSERT INTO ").ident(snapshot).text(" SELECT *, NOW(), ? FROM ").arg(cfg.dataType).ident(table).where(f).build()
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot failed: %v", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// dsnConflicts are the inputs a dsn replaces; giving both is ambiguous.
+var dsnConflicts = []string{
+	"host", "port", "username", "password", "dbname", "profile",
+	"tls", "tls_ca", "tls_cert", "tls_key", "tls_server_name",
+	"auth_method", "allow_cleartext_passwords", "allow_native_passwords", "server_pub_key",
+	"connect_timeout_seconds", "read_timeout", "write_timeout", "max_allowed_packet", "compress", "interpolate_params",
+	"connection_charset",
+}
+
+// applyDSN validates the dsn input and copies the connection identity it
+// carries into cfg, so cache keys, summaries and redaction keep working. The
+// driver configuration itself is rebuilt from the dsn in connectionConfig.
+func applyDSN(input Input, cfg *settings, errs *validationErrors) {
+	for _, p := range input.Params {
+		name := strings.ToLower(p.InputName)
+		if strings.TrimSpace(p.CompValue) == "" {
+			continue
+		}
+		for _, c := range dsnConflicts {
+			if name == c {
+				errs.add("conflict", name, "%s cannot be combined with dsn", name)
+			}
+		}
+	}
+
+	c, err := mysql.ParseDSN(cfg.dsn)
+	if err != nil {
+		errs.add("invalid_dsn", "dsn", "%v", err)
+		return
+	}
+	cfg.username, cfg.password, cfg.dbname = c.User, c.Passwd, c.DBName
+	cfg.host, cfg.port = c.Addr, 3306
+	if host, port, err := net.SplitHostPort(c.Addr); err == nil {
+		cfg.host = host
+		cfg.port, _ = strconv.Atoi(port)
+	}
+}
+
+// dsnConfig parses the dsn input for the driver. parseTime defaults to true
+// and charset to connection_charset as everywhere else unless the dsn sets
+// them; zero_date_mode turns parseTime off.
+func dsnConfig(cfg settings) (*mysql.Config, error) {
+	dsn := cfg.dsn
+	_, params, ok := strings.Cut(dsn, "?")
+	if !strings.Contains("&"+params, "&charset=") {
+		sep := "&"
+		if !ok {
+			sep = "?"
+		}
+		dsn += sep + "charset=" + cfg.connectionCharset
+	}
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains("&"+params, "&parseTime=") {
+		c.ParseTime = true
+	}
+	if cfg.zeroDateMode != "" {
+		c.ParseTime = false
+	}
+	if cfg.foundRows {
+		c.ClientFoundRows = true
+	}
+	if cfg.sshHost != "" {
+		c.Net = sshNetwork
+	}
+	return c, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// inputOf builds an Input from name, value pairs.
+func inputOf(pairs ...string) Input {
+	var input Input
+	for i := 0; i+1 < len(pairs); i += 2 {
+		input.Params = append(input.Params, struct {
+			InputName string `json:"inputname"`
+			CompValue string `json:"compvalue"`
+		}{pairs[i], pairs[i+1]})
+	}
+	return input
+}
+
+// errorInputs returns the inputs of the errors with code.
+func errorInputs(errs validationErrors, code string) []string {
+	var inputs []string
+	for _, e := range errs {
+		if e.Code == code {
+			inputs = append(inputs, e.Input)
+		}
+	}
+	return inputs
+}
+
+func TestApplyDSNConflicts(t *testing.T) {
+	const dsn = "app:s3cret@tcp(db.internal:3307)/erp"
+	tests := []struct {
+		name  string
+		extra []string
+		want  []string
+	}{
+		{"dsn alone", nil, nil},
+		{"host and user", []string{"host", "other", "username", "root"}, []string{"host", "username"}},
+		{"case of the input name", []string{"Password", "x"}, []string{"password"}},
+		{"empty values", []string{"host", "", "port", "  "}, nil},
+		{"tls", []string{"tls", "true", "tls_ca", "/ca.pem"}, []string{"tls", "tls_ca"}},
+		{"auth", []string{"allow_cleartext_passwords", "true", "allow_native_passwords", "false", "server_pub_key", "/key.pem"},
+			[]string{"allow_cleartext_passwords", "allow_native_passwords", "server_pub_key"}},
+		{"connection_charset", []string{"connection_charset", "latin1"}, []string{"connection_charset"}},
+		{"unrelated", []string{"query", "SELECT 1", "timeout_seconds", "5"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parseSettings(inputOf(append([]string{"dsn", dsn}, tt.extra...)...))
+			got := errorInputs(errs, "conflict")
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("conflicts %q, want %q (%s)", got, tt.want, errs.summary())
+			}
+		})
+	}
+}
+
+func TestApplyDSNIdentity(t *testing.T) {
+	tests := []struct {
+		dsn            string
+		user, pass, db string
+		host           string
+		port           int
+		wantInvalid    bool
+	}{
+		{dsn: "app:s3cret@tcp(db.internal:3307)/erp", user: "app", pass: "s3cret", db: "erp", host: "db.internal", port: 3307},
+		{dsn: "app@tcp([2001:db8::5]:3306)/erp?parseTime=false", user: "app", db: "erp", host: "2001:db8::5", port: 3306},
+		{dsn: "app:p@ss:word@unix(/run/mysqld/mysqld.sock)/erp", user: "app", pass: "p@ss:word", db: "erp", host: "/run/mysqld/mysqld.sock", port: 3306},
+		{dsn: "app@tcp(db.internal)/", user: "app", host: "db.internal", port: 3306},
+		{dsn: "app:s3cret@db.internal:3307", wantInvalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			cfg := settings{dsn: tt.dsn}
+			var errs validationErrors
+			applyDSN(Input{}, &cfg, &errs)
+			if tt.wantInvalid {
+				if got := errorInputs(errs, "invalid_dsn"); len(got) != 1 {
+					t.Fatalf("errors %v, want invalid_dsn", errs)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatal(errs.summary())
+			}
+			if cfg.username != tt.user || cfg.password != tt.pass || cfg.dbname != tt.db || cfg.host != tt.host || cfg.port != tt.port {
+				t.Errorf("got %q %q %q %q %d", cfg.username, cfg.password, cfg.dbname, cfg.host, cfg.port)
+			}
+		})
+	}
+}
+
+func TestDSNConfig(t *testing.T) {
+	const base = "app:s3cret@tcp(db.internal:3307)/erp"
+	tests := []struct {
+		name  string
+		cfg   settings
+		check func(*mysql.Config) string
+	}{
+		{"forced parseTime and charset", settings{dsn: base}, func(c *mysql.Config) string {
+			if !c.ParseTime || !strings.Contains(c.FormatDSN(), "charset="+defaultConnectionCharset) {
+				return "parseTime and charset are not forced"
+			}
+			return ""
+		}},
+		{"connection_charset", settings{dsn: base + "?timeout=5s", connectionCharset: "latin1"}, func(c *mysql.Config) string {
+			if !strings.Contains(c.FormatDSN(), "charset=latin1") || c.Timeout.Seconds() != 5 {
+				return "connection_charset is not appended to the dsn parameters"
+			}
+			return ""
+		}},
+		{"dsn charset wins", settings{dsn: base + "?charset=latin1"}, func(c *mysql.Config) string {
+			if dsn := c.FormatDSN(); !strings.Contains(dsn, "charset=latin1") || strings.Contains(dsn, "utf8mb4") {
+				return "the dsn charset is overridden: " + dsn
+			}
+			return ""
+		}},
+		{"dsn parseTime wins", settings{dsn: base + "?parseTime=false"}, func(c *mysql.Config) string {
+			if c.ParseTime {
+				return "parseTime=false is overridden"
+			}
+			return ""
+		}},
+		{"zero_date_mode", settings{dsn: base + "?parseTime=true", zeroDateMode: "null"}, func(c *mysql.Config) string {
+			if c.ParseTime {
+				return "zero_date_mode leaves parseTime on"
+			}
+			return ""
+		}},
+		{"found_rows", settings{dsn: base, foundRows: true}, func(c *mysql.Config) string {
+			if !c.ClientFoundRows {
+				return "found_rows is not applied"
+			}
+			return ""
+		}},
+		{"tls skip-verify", settings{dsn: base + "?tls=skip-verify"}, func(c *mysql.Config) string {
+			if c.TLS == nil || !c.TLS.InsecureSkipVerify {
+				return "tls=skip-verify does not encrypt without verification"
+			}
+			return ""
+		}},
+		{"tls preferred", settings{dsn: base + "?tls=preferred"}, func(c *mysql.Config) string {
+			if c.TLS == nil || !c.AllowFallbackToPlaintext {
+				return "tls=preferred does not fall back to plaintext"
+			}
+			return ""
+		}},
+		{"tls true", settings{dsn: base + "?tls=true"}, func(c *mysql.Config) string {
+			if c.TLS == nil || c.TLS.InsecureSkipVerify || c.TLS.ServerName != "db.internal" {
+				return "tls=true does not verify db.internal"
+			}
+			return ""
+		}},
+		{"auth parameters", settings{dsn: base + "?allowCleartextPasswords=true&allowNativePasswords=false"}, func(c *mysql.Config) string {
+			if !c.AllowCleartextPasswords || c.AllowNativePasswords {
+				return "the password plugin parameters are not applied"
+			}
+			return ""
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg.connectionCharset == "" {
+				tt.cfg.connectionCharset = defaultConnectionCharset
+			}
+			c, err := connectionConfig(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg := tt.check(c); msg != "" {
+				t.Error(msg)
+			}
+			if _, err := mysql.ParseDSN(c.FormatDSN()); err != nil {
+				t.Errorf("the configuration formats as a DSN that does not parse: %v", err)
+			}
+		})
+	}
+	if _, err := dsnConfig(settings{dsn: base + "?tls=nosuchconfig", connectionCharset: defaultConnectionCharset}); err == nil {
+		t.Error("an unknown tls config is accepted")
+	}
+}
+
+// publicKeyPEM returns a new RSA public key in PKIX PEM.
+func publicKeyPEM(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestPasswordPluginOptions(t *testing.T) {
+	key := publicKeyPEM(t)
+	path := filepath.Join(t.TempDir(), "server.pem")
+	if err := os.WriteFile(path, []byte(key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, pubKey := range []string{key, path} {
+		cfg := settings{host: "db.internal", port: 3306, username: "u", connectionCharset: defaultConnectionCharset,
+			allowCleartextPasswords: true, allowNativePasswords: false, serverPubKey: pubKey}
+		c, err := connectionConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.AllowCleartextPasswords || c.AllowNativePasswords || c.ServerPubKey != serverPubKeyName {
+			t.Errorf("got cleartext %v, native %v, key %q", c.AllowCleartextPasswords, c.AllowNativePasswords, c.ServerPubKey)
+		}
+		// The registered key is what a DSN naming it resolves.
+		if _, err := mysql.ParseDSN(c.FormatDSN()); err != nil {
+			t.Errorf("the DSN naming the registered key does not parse: %v", err)
+		}
+	}
+	for _, bad := range []string{"-----BEGIN PUBLIC KEY-----\nnope\n-----END PUBLIC KEY-----\n", filepath.Join(t.TempDir(), "missing.pem")} {
+		if err := registerServerPubKey(bad); err == nil {
+			t.Errorf("registerServerPubKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAuthHint(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{mysql.ErrCleartextPassword, "allow_cleartext_passwords=true"},
+		{mysql.ErrNativePassword, "allow_native_passwords=true"},
+		{mysql.ErrOldPassword, "pre-4.1"},
+		{mysql.ErrUnknownPlugin, "ALTER USER"},
+		{errors.New("Error 3159 (HY000): Connections using insecure transport are prohibited while --require_secure_transport=ON."), "tls=true"},
+		{errors.New("this user requires secure transport"), "tls=true"},
+		{errors.New("failed to request the RSA public key"), "server_pub_key"},
+		{errors.New("Error 1045 (28000): Access denied for user 'u'@'h'"), ""},
+	}
+	for _, tt := range tests {
+		got := authHint(tt.err)
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("authHint(%v) = %q, want one containing %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// duplicateGroup is one set of rows sharing the same values in columns.
+type duplicateGroup struct {
+	Key   map[string]interface{}   `json:"key"`
+	Count int64                    `json:"count"`
+	Rows  []map[string]interface{} `json:"rows,omitempty"`
+}
+
+// findDuplicates implements data_type=duplicates. Rows with a NULL in any of
+// the columns are ignored unless nulls_match is set, in which case NULLs
+// compare equal. collation, when given, decides case sensitivity.
+func findDuplicates(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	names, err := parseIdentList(cfg.columns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid columns: %v", err)
+	}
+	if cfg.collation != "" && !optionRe.MatchString(cfg.collation) {
+		return nil, fmt.Errorf("invalid collation %q", cfg.collation)
+	}
+
+	cols, _ := columnIdents(names)
+	// exprs writes the grouped columns, each with the collation if any.
+	exprs := func(b *stmtBuilder, sep, suffix sqlText) *stmtBuilder {
+		for i, col := range cols {
+			if i > 0 {
+				b.text(sep)
+			}
+			b.ident(col)
+			if cfg.collation != "" {
+				b.text(" COLLATE ").text(sqlText(cfg.collation))
+			}
+			b.text(suffix)
+		}
+		return b
+	}
+	b := exprs(new(stmtBuilder).text("SELECT "), ", ", "").text(", COUNT(*) FROM ").ident(table)
+	if !cfg.nullsMatch {
+		b.text(" WHERE ")
+		for i, col := range cols {
+			if i > 0 {
+				b.text(" AND ")
+			}
+			b.ident(col).text(" IS NOT NULL")
+		}
+	}
+	query, _ := exprs(b.text(" GROUP BY "), ", ", "").text(" HAVING COUNT(*) > 1 ORDER BY COUNT(*) DESC").build()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	var groups []duplicateGroup
+	masking := cfg.scanning().masking
+	for rows.Next() {
+		vals := make([]interface{}, len(names)+1)
+		ptrs := make([]interface{}, len(vals))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g := duplicateGroup{Key: make(map[string]interface{}, len(names))}
+		for i, name := range names {
+			if masking.dropped(name) {
+				continue
+			}
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			g.Key[name] = masking.value(name, vals[i])
+		}
+		g.Count, _ = vals[len(names)].(int64)
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"groups": groups, "group_count": len(groups)}
+	if !cfg.includeRows {
+		return result, nil
+	}
+
+	// Member rows are fetched per group, capped across all groups.
+	limit := cfg.maxRows
+	if limit == 0 {
+		limit = 1000
+	}
+	var cmp sqlText = " = ?"
+	if cfg.nullsMatch {
+		cmp = " <=> ?"
+	}
+	member, _ := exprs(new(stmtBuilder).text("SELECT * FROM ").ident(table).text(" WHERE "), " AND ", cmp).text(" LIMIT ?").build()
+	q := newStmtCache(db.DB, cfg.prepared)
+	defer closeStmtCache(q, len(groups), out)
+
+	fetched := 0
+	for i := range groups {
+		if fetched >= limit {
+			result["truncated"] = true
+			break
+		}
+		args := make([]interface{}, len(names))
+		for j, name := range names {
+			args[j] = groups[i].Key[name]
+		}
+		rows, err := q.QueryContext(ctx, member, append(args, limit-fetched)...)
+		if err != nil {
+			return nil, fmt.Errorf("execution error: %v", err)
+		}
+		groups[i].Rows, err = scanRows(rows, cfg)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		fetched += len(groups[i].Rows)
+		if int64(len(groups[i].Rows)) < groups[i].Count {
+			result["truncated"] = true
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// eventInterval is the EVERY clause of create_event: a count and a unit.
+var eventInterval = regexp.MustCompile(`(?i)^\s*([1-9][0-9]*)\s+(SECOND|MINUTE|HOUR|DAY|WEEK|MONTH|QUARTER|YEAR)\s*$`)
+
+// eventChecks reports the preconditions of the event modes: an event only
+// runs while the scheduler is ON, and managing one needs EVENT on its schema.
+type eventChecks struct {
+	Scheduler      string `json:"event_scheduler"`
+	EventPrivilege *bool  `json:"event_privilege"` // null when the grants could not be read
+}
+
+// checkEvents reads both preconditions; enforce fails when managing the
+// event would not work: EVENT is missing, or the event is to be enabled
+// while the scheduler is not ON.
+func checkEvents(ctx context.Context, db *database, cfg settings, schema string, enforce bool, out *Output) (eventChecks, error) {
+	var checks eventChecks
+	if err := db.QueryRowContext(ctx, "SELECT @@GLOBAL.event_scheduler").Scan(&checks.Scheduler); err != nil {
+		return checks, fmt.Errorf("failed to read event_scheduler: %v", err)
+	}
+	report, err := evaluatePrivileges(ctx, db, []string{"EVENT"}, schema, "")
+	if err != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("could not check the EVENT privilege: %v", err))
+	} else {
+		checks.EventPrivilege = &report.Allowed
+	}
+	if !enforce {
+		return checks, nil
+	}
+	if err == nil && !report.Allowed {
+		return checks, &privilegeError{report}
+	}
+	if cfg.eventStatus != "disable" && !strings.EqualFold(checks.Scheduler, "ON") {
+		return checks, fmt.Errorf("event_scheduler is %s, so an enabled event would never run; turn it ON or set event_enabled=false", checks.Scheduler)
+	}
+	return checks, nil
+}
+
+// listEvents implements data_type=list_events for the schema named by
+// object_name, or the connection's database.
+func listEvents(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	schema := cfg.dbname
+	if cfg.objectName != "" {
+		schema = cfg.objectName
+	}
+	checks, err := checkEvents(ctx, db, cfg, schema, false, out)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT event_schema AS `+"`schema`"+`, event_name AS name, definer AS definer, event_type AS type,
+	CASE WHEN event_type = 'ONE TIME' THEN CAST(execute_at AS CHAR) ELSE CONCAT('EVERY ', interval_value, ' ', interval_field) END AS schedule,
+	CAST(starts AS CHAR) AS starts, CAST(ends AS CHAR) AS ends, status AS status, on_completion AS on_completion, CAST(last_executed AS CHAR) AS last_executed
+FROM information_schema.events WHERE event_schema = ? ORDER BY event_name`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	defer rows.Close()
+	events, err := scanRows(rows, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"events": events, "checks": checks}, nil
+}
+
+// checkEventBody runs event_body through the lexer before it is spliced
+// into CREATE EVENT and returns the statement to splice. DDL takes no
+// placeholders, and an event runs one statement, which may be a BEGIN ...
+// END block of several.
+func checkEventBody(body string) (string, error) {
+	for _, tok := range tokenize(body) {
+		if what := tok.unterminated(); what != "" {
+			return "", fmt.Errorf("event_body has an unterminated %s at byte %d", what, tok.pos)
+		}
+	}
+	if err := checkPlaceholders(statement{SQL: body}); err != nil {
+		return "", fmt.Errorf("event_body cannot use ? placeholders, since CREATE EVENT takes no parameters; write the values into the body (%v)", err)
+	}
+	stmts, err := splitStatements(body)
+	if err != nil {
+		return "", fmt.Errorf("event_body: %v", err)
+	}
+	switch {
+	case len(stmts) == 0:
+		return "", fmt.Errorf("event_body holds no statement")
+	case len(stmts) == 1:
+		return stmts[0], nil
+	}
+	if word, _ := firstKeyword(stmts[0]); word == "BEGIN" && strings.EqualFold(stmts[len(stmts)-1], "END") {
+		return strings.Join(stmts, "; "), nil
+	}
+	return "", fmt.Errorf("event_body holds %d statements, but an event runs one; wrap them in BEGIN ... END", len(stmts))
+}
+
+// eventStatement is the statement the event runs, classified as a raw
+// query would be, so read_only and lint judge it as they would the query.
+func eventStatement(cfg settings) statement {
+	body, _ := checkEventBody(cfg.eventBody)
+	return statement{SQL: body, IsSelect: isReadQuery(body)}
+}
+
+// createEventDDL renders CREATE EVENT from the event_* inputs. event_every
+// makes a recurring event, optionally bounded by event_starts and
+// event_ends; event_at a one-time one.
+func createEventDDL(cfg settings) (string, error) {
+	name, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return "", err
+	}
+	body, err := checkEventBody(cfg.eventBody)
+	if err != nil {
+		return "", err
+	}
+	b := new(stmtBuilder).text("CREATE EVENT ")
+	if cfg.ifNotExists {
+		b.text("IF NOT EXISTS ")
+	}
+	b.ident(name).text(" ON SCHEDULE ")
+	if cfg.eventAt != "" {
+		at, err := eventTimestamp("event_at", cfg.eventAt)
+		if err != nil {
+			return "", err
+		}
+		b.text("AT ").literal(at)
+	} else {
+		m := eventInterval.FindStringSubmatch(cfg.eventEvery)
+		if m == nil {
+			return "", fmt.Errorf("event_every must be a count and a unit such as 1 DAY, got %q", cfg.eventEvery)
+		}
+		b.text("EVERY ").text(sqlText(m[1])).text(" ").text(sqlText(strings.ToUpper(m[2])))
+		for _, bound := range []struct {
+			clause     sqlText
+			input, val string
+		}{{"STARTS", "event_starts", cfg.eventStarts}, {"ENDS", "event_ends", cfg.eventEnds}} {
+			if bound.val == "" {
+				continue
+			}
+			ts, err := eventTimestamp(bound.input, bound.val)
+			if err != nil {
+				return "", err
+			}
+			b.text(" ").text(bound.clause).text(" ").literal(ts)
+		}
+	}
+	if cfg.eventStatus == "disable" {
+		b.text(" DISABLE")
+	} else {
+		b.text(" ENABLE")
+	}
+	// The body is the statement the event runs, checked by checkEventBody.
+	ddl, _ := b.text(" DO ").text(sqlText(body)).build()
+	return ddl, nil
+}
+
+// eventTimestamp checks a schedule timestamp and formats it for a literal:
+// DDL cannot take placeholders.
+func eventTimestamp(input, val string) (string, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t.Format("2006-01-02 15:04:05"), nil
+		}
+	}
+	return "", fmt.Errorf("%s must be a timestamp such as 2025-01-31 23:00:00, got %q", input, val)
+}
+
+// createEvent implements data_type=create_event and returns SHOW CREATE
+// EVENT together with the checks.
+func createEvent(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.lint {
+		var err error
+		if out.Lint, err = lintStatement(ctx, db, cfg, eventStatement(cfg).SQL); err != nil {
+			return nil, err
+		}
+	}
+	schema, _ := splitTarget(cfg.objectName, cfg.dbname)
+	checks, err := checkEvents(ctx, db, cfg, schema, true, out)
+	if err != nil {
+		return nil, err
+	}
+	ddl, err := createEventDDL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	return showCreateEvent(ctx, db, cfg, checks)
+}
+
+// alterEvent implements data_type=alter_event, enabling or disabling the
+// event.
+func alterEvent(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	schema, _ := splitTarget(cfg.objectName, cfg.dbname)
+	checks, err := checkEvents(ctx, db, cfg, schema, true, out)
+	if err != nil {
+		return nil, err
+	}
+	name, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	status := sqlText(" ENABLE")
+	if cfg.eventStatus == "disable" {
+		status = " DISABLE"
+	}
+	ddl, _ := new(stmtBuilder).text("ALTER EVENT ").ident(name).text(status).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	return showCreateEvent(ctx, db, cfg, checks)
+}
+
+func showCreateEvent(ctx context.Context, db *database, cfg settings, checks eventChecks) (interface{}, error) {
+	name, _ := tableIdent(cfg.objectName)
+	query, _ := new(stmtBuilder).text("SHOW CREATE EVENT ").ident(name).build()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("event changed but SHOW CREATE EVENT failed: %v", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	// The column count differs between servers; the definition is the
+	// fourth column everywhere.
+	values := make([]sql.NullString, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if !rows.Next() || len(columns) < 4 {
+		return nil, fmt.Errorf("event changed but SHOW CREATE EVENT returned nothing")
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"event": values[0].String, "create_event": values[3].String, "checks": checks}, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCreateEventBody(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    string // the DDL after DO
+		wantErr string
+	}{
+		{body: "DELETE FROM sessions WHERE expires < NOW()", want: "DELETE FROM sessions WHERE expires < NOW()"},
+		{body: "DELETE FROM sessions WHERE expires < NOW();\n", want: "DELETE FROM sessions WHERE expires < NOW()"},
+		{body: "UPDATE t SET note = 'why?; not' -- really?\n", want: "UPDATE t SET note = 'why?; not' -- really?"},
+		{body: "BEGIN\n  DELETE FROM a;\n  IF ROW_COUNT() > 0 THEN INSERT INTO log VALUES (NOW()); END IF;\nEND", want: "BEGIN\n  DELETE FROM a; IF ROW_COUNT() > 0 THEN INSERT INTO log VALUES (NOW()); END IF; END"},
+		{body: "DELIMITER $$\nBEGIN DELETE FROM a; DELETE FROM b; END$$\n", want: "BEGIN DELETE FROM a; DELETE FROM b; END"},
+		{body: "DELETE FROM sessions WHERE id = ?", wantErr: "cannot use ? placeholders"},
+		{body: "DELETE FROM a; DROP TABLE b", wantErr: "holds 2 statements"},
+		{body: "BEGIN DELETE FROM a; END; DROP TABLE b", wantErr: "holds 3 statements"},
+		{body: "UPDATE t SET note = 'open", wantErr: "unterminated string at byte 20"},
+		{body: "UPDATE t SET note = 'it\\'", wantErr: "unterminated string"},
+		{body: "UPDATE `t SET a = 1", wantErr: "unterminated quoted identifier at byte 7"},
+		{body: "DELETE FROM a /* keep", wantErr: "unterminated comment"},
+		{body: "  ;  ", wantErr: "holds no statement"},
+	}
+	for _, tt := range tests {
+		cfg := settings{dataType: "create_event", objectName: "erp.purge", eventEvery: "1 DAY", eventBody: tt.body}
+		ddl, err := createEventDDL(cfg)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("body %q: err = %v, want one containing %q", tt.body, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("body %q: %v", tt.body, err)
+			continue
+		}
+		if _, got, _ := strings.Cut(ddl, " ENABLE DO "); got != tt.want {
+			t.Errorf("body %q: DDL %q, want DO %q", tt.body, ddl, tt.want)
+		}
+	}
+}
+
+func TestCreateEventBodyValidation(t *testing.T) {
+	_, errs := parseSettings(inputOf("host", "h", "username", "u", "dbname", "erp", "data_type", "create_event",
+		"object_name", "purge", "event_every", "1 DAY", "event_body", "DELETE FROM a WHERE id = ?"))
+	if got := errorInputs(errs, "invalid_query"); len(got) != 1 || got[0] != "event_body" {
+		t.Errorf("errors %v, want invalid_query on event_body", errs)
+	}
+	if got := errorInputs(errs, "invalid_schedule"); len(got) != 0 {
+		t.Errorf("the body error is also reported as a schedule error: %v", errs)
+	}
+}
+
+func TestCreateEventReadOnly(t *testing.T) {
+	for body, want := range map[string]string{
+		"DELETE FROM sessions WHERE expires < NOW()": "refusing to run an event_body that may modify data",
+		"SELECT COUNT(*) FROM sessions":              "refusing to run data_type=create_event",
+	} {
+		out := run(inputOf("host", "h", "username", "u", "dbname", "erp", "data_type", "create_event", "read_only", "true",
+			"object_name", "purge", "event_every", "1 DAY", "event_body", body))
+		if !strings.Contains(out.Error, want) {
+			t.Errorf("body %q: error %q, want one containing %q", body, out.Error, want)
+		}
+	}
+}
+
+func TestCreateEventLint(t *testing.T) {
+	cfg, errs := parseSettings(inputOf("host", "h", "username", "u", "dbname", "erp", "data_type", "create_event",
+		"object_name", "purge", "event_every", "1 DAY", "event_body", "DELETE FROM sessions;", "lint", "true", "lint_fail_on", "missing_where"))
+	if len(errs) > 0 {
+		t.Fatal(errs.summary())
+	}
+	db, stub := openStubDB(t)
+	out := &Output{}
+	_, err := createEvent(context.Background(), &database{DB: db}, cfg, out)
+	var lErr *lintError
+	if !errors.As(err, &lErr) {
+		t.Fatalf("err = %v, want a lint error", err)
+	}
+	if len(out.Lint) != 1 || out.Lint[0].Rule != "missing_where" {
+		t.Errorf("lint %+v, want missing_where", out.Lint)
+	}
+	if sent := stub.sent(); len(sent) != 0 {
+		t.Errorf("sent %q before the body passed lint", sent)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// readInput decodes the Input JSON from path, or from stdin when path is empty.
+func readInput(path string) (Input, error) {
+	var r io.Reader = os.Stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Input{}, fmt.Errorf("failed to open input: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return decodeInput(r)
+}
+
+// writeFileAtomic writes data to a temp file in the target directory and
+// renames it into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := createFileAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// atomicFile is a temp file that replaces its target on Commit.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func createFileAtomic(path string) (*atomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: tmp, path: path}, nil
+}
+
+// Commit flushes the temp file and renames it over the target.
+func (f *atomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
+
+// Abort discards the temp file; after a successful Commit it is a no-op.
+func (f *atomicFile) Abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
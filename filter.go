@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// filter is a WHERE condition compiled from the filter DSL. SQL is empty
+// when there is nothing to filter on.
+//
+// The DSL is either an object of equality conditions,
+//
+//	{"company_id": 3, "status": ["open", "held"], "deleted_at": null}
+//
+// where arrays mean IN and null means IS NULL, or an array of conditions
+//
+//	[{"column": "total", "op": ">=", "value": 100}, {"column": "note", "op": "is_not_null"}]
+//
+// where the geo operator (see geo.go) matches rows within a radius of a
+// point. Conditions are combined with AND. Columns are always quoted and
+// values always bound as parameters.
+type filter struct {
+	SQL  string
+	Args []interface{}
+
+	// equal holds the column/value pairs of plain equality conditions, used
+	// by callers that must write the scope back (e.g. kv_set).
+	equal []filterCondition
+
+	// geo is the geo condition, if any.
+	geo *geoFilter
+}
+
+type filterCondition struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+
+	// geo only
+	Latitude   string `json:"latitude"`
+	Longitude  string `json:"longitude"`
+	SRID       *int   `json:"srid"`
+	DistanceAs string `json:"distance_as"`
+}
+
+func parseFilter(raw string) (filter, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return filter{}, nil
+	}
+
+	var conds []filterCondition
+	if strings.HasPrefix(raw, "{") {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+			return filter{}, fmt.Errorf("filter must be a JSON object or array: %v", err)
+		}
+		// Sorted so the same filter always renders the same SQL.
+		cols := make([]string, 0, len(obj))
+		for col := range obj {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		for _, col := range cols {
+			op := "="
+			switch obj[col].(type) {
+			case []interface{}:
+				op = "in"
+			case nil:
+				op = "is_null"
+			}
+			conds = append(conds, filterCondition{Column: col, Op: op, Value: obj[col]})
+		}
+	} else {
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&conds); err != nil {
+			return filter{}, fmt.Errorf("filter must be a JSON object or array: %v", err)
+		}
+	}
+
+	var f filter
+	var parts []string
+	for i, c := range conds {
+		var sql string
+		var args []interface{}
+		var err error
+		if c.op() == "geo" {
+			if f.geo != nil {
+				return filter{}, fmt.Errorf("filter condition %d: only one geo condition is allowed", i+1)
+			}
+			if f.geo, err = c.geoFilter(); err == nil {
+				sql, args = f.geo.condition()
+			}
+		} else {
+			sql, args, err = c.render()
+		}
+		if err != nil {
+			return filter{}, fmt.Errorf("filter condition %d: %v", i+1, err)
+		}
+		parts = append(parts, sql)
+		f.Args = append(f.Args, args...)
+		if c.op() == "=" {
+			f.equal = append(f.equal, c)
+		}
+	}
+	f.SQL = strings.Join(parts, " AND ")
+	return f, nil
+}
+
+func (c filterCondition) op() string {
+	op := strings.ToLower(strings.TrimSpace(c.Op))
+	if op == "" || op == "eq" {
+		return "="
+	}
+	return op
+}
+
+func (c filterCondition) render() (string, []interface{}, error) {
+	if c.Latitude != "" || c.Longitude != "" || c.SRID != nil || c.DistanceAs != "" {
+		return "", nil, fmt.Errorf("latitude, longitude, srid and distance_as only apply to op geo")
+	}
+	col, err := qualifiedColumnIdent(c.Column)
+	if err != nil {
+		return "", nil, err
+	}
+	b := new(stmtBuilder).ident(col)
+	list := func() ([]interface{}, error) {
+		values, ok := c.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("%s needs a non-empty array value", c.op())
+		}
+		return values, nil
+	}
+
+	switch op := c.op(); op {
+	case "=", "!=", "<>", "<", "<=", ">", ">=", "like", "not_like":
+		if c.Value == nil {
+			return "", nil, fmt.Errorf("%s needs a value; use is_null for NULL", op)
+		}
+		if _, ok := c.Value.([]interface{}); ok {
+			return "", nil, fmt.Errorf("%s needs a scalar value", op)
+		}
+		// op is one of the operators of this case.
+		b.text(" ").text(sqlText(strings.ToUpper(strings.ReplaceAll(op, "_", " ")))).text(" ?").arg(c.Value)
+	case "in", "not_in":
+		values, err := list()
+		if err != nil {
+			return "", nil, err
+		}
+		if op == "not_in" {
+			b.text(" NOT")
+		}
+		b.text(" IN (").values(values).text(")")
+	case "between":
+		values, err := list()
+		if err != nil || len(values) != 2 {
+			return "", nil, fmt.Errorf("between needs a [low, high] array value")
+		}
+		b.text(" BETWEEN ? AND ?").arg(values...)
+	case "is_null":
+		b.text(" IS NULL")
+	case "is_not_null":
+		b.text(" IS NOT NULL")
+	default:
+		return "", nil, fmt.Errorf("unsupported operator %q", c.Op)
+	}
+	sql, args := b.build()
+	return sql, args, nil
+}
+
+// and appends cond to the filter with AND.
+func (f filter) and(cond string, args ...interface{}) filter {
+	if f.SQL == "" {
+		f.SQL = cond
+	} else {
+		f.SQL += " AND " + cond
+	}
+	f.Args = append(append([]interface{}{}, f.Args...), args...)
+	return f
+}
+
+// where renders the filter as a WHERE clause, or nothing when empty.
+func (f filter) where() string {
+	if f.SQL == "" {
+		return ""
+	}
+	return " WHERE " + f.SQL
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// fingerprint identifies the shape of a statement independent of its literal
+// values, matching how APM tools group queries.
+type fingerprint struct {
+	Normalized string `json:"normalized"`
+	Hash       string `json:"hash"`
+}
+
+func newFingerprint(sqlText string) *fingerprint {
+	normalized := normalizeSQL(sqlText)
+	sum := sha256.Sum256([]byte(normalized))
+	return &fingerprint{Normalized: normalized, Hash: hex.EncodeToString(sum[:8])}
+}
+
+// normalizeSQL replaces string and numeric literals with ?, collapses IN lists
+// to a single placeholder, drops comments and collapses whitespace.
+// Identifiers and keywords are left as written.
+func normalizeSQL(sqlText string) string {
+	type part struct {
+		text  string
+		space bool // whitespace preceded this part in the source
+	}
+	var parts []part
+	space := false
+	for _, tok := range tokenize(sqlText) {
+		switch tok.kind {
+		case tokSpace, tokComment:
+			space = len(parts) > 0
+			continue
+		case tokString, tokNumber:
+			// Charset introducers and hex/bit prefixes: _utf8'x', N'x', X'ff', b'01'
+			if tok.kind == tokString && len(parts) > 0 && !space && isLiteralPrefix(parts[len(parts)-1].text) {
+				space = parts[len(parts)-1].space
+				parts = parts[:len(parts)-1]
+			}
+			parts = append(parts, part{"?", space})
+		default:
+			parts = append(parts, part{tok.text, space})
+		}
+		space = false
+	}
+
+	// Collapse IN (?, ?, ...) into IN (?)
+	for i := 0; i+2 < len(parts); i++ {
+		if !strings.EqualFold(parts[i].text, "IN") || parts[i+1].text != "(" {
+			continue
+		}
+		j := i + 2
+		for j+1 < len(parts) && parts[j].text == "?" && parts[j+1].text == "," {
+			j += 2
+		}
+		if j+1 < len(parts) && parts[j].text == "?" && parts[j+1].text == ")" && j > i+2 {
+			parts = append(parts[:i+3], parts[j+1:]...)
+		}
+	}
+
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 && p.space {
+			b.WriteByte(' ')
+		}
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
+
+func isLiteralPrefix(word string) bool {
+	switch strings.ToLower(word) {
+	case "n", "x", "b":
+		return true
+	}
+	return strings.HasPrefix(word, "_")
+}
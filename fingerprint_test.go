@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// golden compares got with testdata/name, or rewrites the file with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the output; rerun with -update if the change is intended\ngot:\n%s", path, got)
+	}
+}
+
+var fingerprintCases = []string{
+	"SELECT * FROM invoices WHERE id = 42",
+	"select *\n  from invoices\twhere id=7 -- by id",
+	"SELECT name FROM customers WHERE status = 'open' AND credit > 1.5e3",
+	"SELECT * FROM t WHERE id IN (1, 2, 3, 4)",
+	"SELECT * FROM t WHERE id IN (?, ?) AND code IN ('a')",
+	"SELECT /* hint */ a FROM t WHERE b = _utf8mb4'x' OR c = N'y' OR d = X'ff' OR e = b'01'",
+	"SELECT `weird``name`, \"dq\" FROM `erp`.`t` WHERE x = -5",
+	"INSERT INTO t (a, b) VALUES (1, 'two'), (3, 'four')",
+	"UPDATE t SET a = a + 1 WHERE id = ? # trailing",
+	"CALL close_period(2026, 'Q3')",
+}
+
+func TestFingerprintGolden(t *testing.T) {
+	type entry struct {
+		SQL        string `json:"sql"`
+		Normalized string `json:"normalized"`
+		Hash       string `json:"hash"`
+	}
+	var entries []entry
+	for _, sql := range fingerprintCases {
+		fp := newFingerprint(sql)
+		entries = append(entries, entry{sql, fp.Normalized, fp.Hash})
+	}
+	var got bytes.Buffer
+	enc := json.NewEncoder(&got)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "fingerprint.golden.json", got.Bytes())
+}
+
+func TestFingerprintIgnoresLiterals(t *testing.T) {
+	a := newFingerprint("SELECT * FROM t WHERE id IN (1, 2) AND s = 'x'")
+	b := newFingerprint("SELECT *  FROM t WHERE id IN (9,8,7) AND s = 'other' /* c */")
+	if a.Hash != b.Hash {
+		t.Errorf("%q and %q fingerprint differently", a.Normalized, b.Normalized)
+	}
+}
+
+func BenchmarkFingerprint(b *testing.B) {
+	sql := "SELECT i.id, i.number, c.name FROM invoices i JOIN customers c ON c.id = i.customer_id " +
+		"WHERE i.status IN ('open', 'overdue', 'disputed') AND i.total > 1000.50 AND i.created_at >= '2026-01-01' " +
+		"/* report */ ORDER BY i.created_at DESC LIMIT 100"
+	b.ReportAllocs()
+	b.SetBytes(int64(len(sql)))
+	for i := 0; i < b.N; i++ {
+		newFingerprint(sql)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// fkNode is a table in the foreign key tree. Constraint, Columns,
+// ReferencedColumns and DeleteRule describe the edge to its parent and are
+// empty for the root.
+type fkNode struct {
+	Table             string    `json:"table"`
+	Constraint        string    `json:"constraint,omitempty"`
+	Columns           []string  `json:"columns,omitempty"`
+	ReferencedColumns []string  `json:"referenced_columns,omitempty"`
+	DeleteRule        string    `json:"delete_rule,omitempty"`
+	Cycle             bool      `json:"cycle,omitempty"` // not expanded: already on the path
+	Children          []*fkNode `json:"children,omitempty"`
+
+	schema, name string
+}
+
+// fkReferences lists the foreign keys pointing at schema.table.
+func fkReferences(ctx context.Context, q execer, schema, table string) ([]*fkNode, error) {
+	rows, err := q.QueryContext(ctx, `SELECT kcu.constraint_name, kcu.table_schema, kcu.table_name, kcu.column_name, kcu.referenced_column_name, rc.delete_rule
+		FROM information_schema.key_column_usage kcu
+		JOIN information_schema.referential_constraints rc
+		  ON rc.constraint_schema = kcu.constraint_schema AND rc.constraint_name = kcu.constraint_name
+		WHERE kcu.referenced_table_schema = ? AND kcu.referenced_table_name = ?
+		ORDER BY kcu.table_schema, kcu.table_name, kcu.constraint_name, kcu.ordinal_position`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []*fkNode
+	var last *fkNode
+	for rows.Next() {
+		var constraint, refSchema, refTable, col, refCol, rule string
+		if err := rows.Scan(&constraint, &refSchema, &refTable, &col, &refCol, &rule); err != nil {
+			return nil, err
+		}
+		// Composite keys arrive as one row per column.
+		if last == nil || last.Constraint != constraint || last.schema != refSchema || last.name != refTable {
+			last = &fkNode{Table: refSchema + "." + refTable, Constraint: constraint, DeleteRule: rule, schema: refSchema, name: refTable}
+			refs = append(refs, last)
+		}
+		last.Columns = append(last.Columns, col)
+		last.ReferencedColumns = append(last.ReferencedColumns, refCol)
+	}
+	return refs, rows.Err()
+}
+
+// buildFKTree expands the references of node recursively. Tables already on
+// the path are marked as cycles instead of being followed.
+func buildFKTree(ctx context.Context, q execer, node *fkNode, path map[string]bool) error {
+	key := strings.ToLower(node.Table)
+	if path[key] {
+		node.Cycle = true
+		return nil
+	}
+	path[key] = true
+	defer delete(path, key)
+
+	children, err := fkReferences(ctx, q, node.schema, node.name)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := buildFKTree(ctx, q, child, path); err != nil {
+			return err
+		}
+	}
+	node.Children = children
+	return nil
+}
+
+// plannedDelete is one statement of a cascade plan.
+type plannedDelete struct {
+	Table        string        `json:"table"`
+	SQL          string        `json:"sql"`
+	Args         []interface{} `json:"args"`
+	RowsAffected *int64        `json:"rows_affected,omitempty"`
+}
+
+// cascadePlan orders the deletes children first. Each child is restricted
+// by a subquery on its parent's condition, so every statement only touches
+// rows reachable from the filtered root rows.
+func cascadePlan(node *fkNode, f filter, warnings *[]string) ([]plannedDelete, error) {
+	table, err := tableIdent(node.Table)
+	if err != nil {
+		return nil, err
+	}
+	var plan []plannedDelete
+	for _, child := range node.Children {
+		if child.Cycle {
+			*warnings = append(*warnings, fmt.Sprintf("cascade_plan: %s references %s in a cycle and was not planned", child.Table, node.Table))
+			continue
+		}
+		cols, _ := columnIdents(child.Columns)
+		refCols, _ := columnIdents(child.ReferencedColumns)
+		cond, _ := new(stmtBuilder).text("(").idents(cols).text(") IN (SELECT ").idents(refCols).text(" FROM ").ident(table).
+			text(sqlText(f.where())).text(")").build()
+		sub, err := cascadePlan(child, filter{SQL: cond, Args: f.Args}, warnings)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, sub...)
+	}
+	del, _ := new(stmtBuilder).text("DELETE FROM ").ident(table).text(sqlText(f.where())).build()
+	return append(plan, plannedDelete{Table: node.Table, SQL: del, Args: f.Args}), nil
+}
+
+// fkGraph implements data_type=fk_graph. With cascade_plan it also returns
+// the ordered deletes for the rows matched by filter, and with execute runs
+// them in one transaction.
+func fkGraph(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	schema, name := splitTarget(cfg.objectName, cfg.dbname)
+	root := &fkNode{Table: schema + "." + name, schema: schema, name: name}
+	if err := buildFKTree(ctx, db, root, map[string]bool{}); err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %v", err)
+	}
+	if !cfg.cascadePlan {
+		return root, nil
+	}
+
+	f, err := parseFilter(cfg.filter)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := cascadePlan(root, f, &out.Warnings)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"graph": root, "plan": plan, "executed": false}
+	if !cfg.execute {
+		return result, nil
+	}
+	if cfg.readOnly {
+		return nil, &readOnlyError{"a cascade plan"}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for i := range plan {
+		res, err := tx.ExecContext(ctx, plan[i].SQL, plan[i].Args...)
+		if err != nil {
+			return nil, fmt.Errorf("delete from %s failed: %v", plan[i].Table, err)
+		}
+		n, _ := res.RowsAffected()
+		plan[i].RowsAffected = &n
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %v", err)
+	}
+	result["executed"] = true
+	return result, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxGenerateRows caps row_count for data_type=generate.
+const maxGenerateRows = 1000000
+
+// maxPlaceholders is the most parameters MySQL accepts in one prepared
+// statement; generate shrinks its batches to stay below it.
+const maxPlaceholders = 65535
+
+// generator is the spec of one generated column, an entry of generators:
+//
+//	{"id": {"type": "sequence", "start": 1000},
+//	 "code": {"type": "pattern", "pattern": "CUST-#####"},
+//	 "tier": {"type": "one_of", "values": ["gold", "silver"], "null_ratio": 0.2},
+//	 "customer_id": {"type": "from_query", "query": "SELECT id FROM customers"}}
+//
+// The types are sequence (start, step), random_int (min, max inclusive),
+// random_decimal (min, max, scale, default 2), uuid, one_of (values),
+// pattern (# is a digit, ? a letter A-Z, \ takes the next character
+// literally), date_between (from, to as YYYY-MM-DD, inclusive) and
+// from_query, a random value of the first column of a SELECT, such as the
+// keys of a parent table. null_ratio makes that share of any column NULL.
+type generator struct {
+	Type      string        `json:"type"`
+	Start     *int64        `json:"start"`
+	Step      *int64        `json:"step"`
+	Min       *float64      `json:"min"`
+	Max       *float64      `json:"max"`
+	Scale     *int          `json:"scale"`
+	Values    []interface{} `json:"values"`
+	Pattern   string        `json:"pattern"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Query     string        `json:"query"`
+	NullRatio float64       `json:"null_ratio"`
+
+	column   string
+	from, to time.Time
+}
+
+// parseGenerators parses the generators input into columns in name order,
+// so a seed always draws the same values for the same spec.
+func parseGenerators(raw string) ([]*generator, error) {
+	var spec map[string]*generator
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf(`generators must be a JSON object of column generators like {"code": {"type": "pattern", "pattern": "CUST-#####"}}: %v`, err)
+	}
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("generators names no column")
+	}
+	gens := make([]*generator, 0, len(spec))
+	for _, col := range sortedKeys(spec) {
+		g := spec[col]
+		if g == nil {
+			return nil, fmt.Errorf("generators: %s: generator must be an object", col)
+		}
+		if _, err := quoteIdent(col); err != nil {
+			return nil, fmt.Errorf("generators: %v", err)
+		}
+		g.column = col
+		if err := g.validate(); err != nil {
+			return nil, fmt.Errorf("generators: %s: %v", col, err)
+		}
+		gens = append(gens, g)
+	}
+	return gens, nil
+}
+
+func (g *generator) validate() error {
+	if g.NullRatio < 0 || g.NullRatio > 1 {
+		return fmt.Errorf("null_ratio must be between 0 and 1")
+	}
+	switch g.Type {
+	case "sequence":
+		if g.Step != nil && *g.Step == 0 {
+			return fmt.Errorf("step must not be 0")
+		}
+	case "random_int", "random_decimal":
+		if g.Min == nil || g.Max == nil {
+			return fmt.Errorf("%s requires min and max", g.Type)
+		}
+		if *g.Min > *g.Max {
+			return fmt.Errorf("min must not be above max")
+		}
+		if g.Type == "random_int" && (*g.Min != float64(int64(*g.Min)) || *g.Max != float64(int64(*g.Max))) {
+			return fmt.Errorf("random_int min and max must be integers")
+		}
+		if g.Scale != nil && (*g.Scale < 0 || *g.Scale > 30) {
+			return fmt.Errorf("scale must be between 0 and 30")
+		}
+	case "uuid":
+	case "one_of":
+		if len(g.Values) == 0 {
+			return fmt.Errorf("one_of requires values")
+		}
+	case "pattern":
+		if g.Pattern == "" {
+			return fmt.Errorf("pattern requires pattern")
+		}
+	case "date_between":
+		var err error
+		if g.from, err = time.Parse("2006-01-02", g.From); err != nil {
+			return fmt.Errorf("from must be a date like 2024-01-31")
+		}
+		if g.to, err = time.Parse("2006-01-02", g.To); err != nil {
+			return fmt.Errorf("to must be a date like 2024-01-31")
+		}
+		if g.to.Before(g.from) {
+			return fmt.Errorf("to must not be before from")
+		}
+	case "from_query":
+		if !isSelectQuery(g.Query) {
+			return fmt.Errorf("from_query requires query, a SELECT statement")
+		}
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unknown type %q; use sequence, random_int, random_decimal, uuid, one_of, pattern, date_between or from_query", g.Type)
+	}
+	return nil
+}
+
+// value draws the value of row i, counting from 0.
+func (g *generator) value(rng *rand.Rand, i int64) interface{} {
+	if g.NullRatio > 0 && rng.Float64() < g.NullRatio {
+		return nil
+	}
+	switch g.Type {
+	case "sequence":
+		start, step := int64(1), int64(1)
+		if g.Start != nil {
+			start = *g.Start
+		}
+		if g.Step != nil {
+			step = *g.Step
+		}
+		return start + i*step
+	case "random_int":
+		min, max := int64(*g.Min), int64(*g.Max)
+		return min + rng.Int63n(max-min+1)
+	case "random_decimal":
+		scale := 2
+		if g.Scale != nil {
+			scale = *g.Scale
+		}
+		// Formatted, so the column gets exactly scale digits.
+		return strconv.FormatFloat(*g.Min+rng.Float64()*(*g.Max-*g.Min), 'f', scale, 64)
+	case "uuid":
+		b := make([]byte, 16)
+		rng.Read(b)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	case "one_of", "from_query":
+		return g.Values[rng.Intn(len(g.Values))]
+	case "pattern":
+		var b strings.Builder
+		for j := 0; j < len(g.Pattern); j++ {
+			switch c := g.Pattern[j]; {
+			case c == '#':
+				b.WriteByte(byte('0' + rng.Intn(10)))
+			case c == '?':
+				b.WriteByte(byte('A' + rng.Intn(26)))
+			case c == '\\' && j+1 < len(g.Pattern):
+				j++
+				b.WriteByte(g.Pattern[j])
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return b.String()
+	case "date_between":
+		days := int(g.to.Sub(g.from).Hours() / 24)
+		return g.from.AddDate(0, 0, rng.Intn(days+1)).Format("2006-01-02")
+	}
+	return nil
+}
+
+// loadParentKeys runs the from_query generators, whose values are then
+// drawn like one_of. Without ORDER BY the server may return the keys in a
+// different order from run to run, and the same seed then draws others.
+func loadParentKeys(ctx context.Context, db *database, gens []*generator) error {
+	for _, g := range gens {
+		if g.Type != "from_query" {
+			continue
+		}
+		rows, err := db.QueryContext(ctx, g.Query)
+		if err != nil {
+			return fmt.Errorf("generators: %s: from_query failed: %v", g.column, err)
+		}
+		g.Values = nil
+		for rows.Next() {
+			var v interface{}
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return fmt.Errorf("generators: %s: from_query must select one column: %v", g.column, err)
+			}
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			g.Values = append(g.Values, v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(g.Values) == 0 {
+			return fmt.Errorf("generators: %s: from_query returned no rows to draw from", g.column)
+		}
+	}
+	return nil
+}
+
+// generate implements data_type=generate: row_count rows of generated
+// values inserted into object_name, batch_size rows per INSERT (default
+// 500). Columns without a generator get their defaults. The seed input
+// makes a dataset reproducible; without it a seed is picked and reported.
+// Each INSERT commits on its own. dry_run, on unless set to false, returns
+// the first sample_size rows instead.
+func generate(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.readOnly && !cfg.dryRun {
+		return nil, &readOnlyError{"data_type=generate without dry_run"}
+	}
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	gens, err := parseGenerators(cfg.generators)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadParentKeys(ctx, db, gens); err != nil {
+		return nil, err
+	}
+	seed := cfg.seed
+	if !cfg.seedSet {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	cols := make([]string, len(gens))
+	for i, g := range gens {
+		cols[i] = g.column
+	}
+	next := func(i int64) []interface{} {
+		row := make([]interface{}, len(gens))
+		for j, g := range gens {
+			row[j] = g.value(rng, i)
+		}
+		return row
+	}
+
+	if cfg.dryRun {
+		samples := cfg.sampleSize
+		if samples == 0 {
+			samples = 10
+		}
+		if int64(samples) > cfg.rowCount {
+			samples = int(cfg.rowCount)
+		}
+		rows := make([][]interface{}, samples)
+		for i := range rows {
+			rows[i] = next(int64(i))
+		}
+		scopedCols, rows, err := scopeInsertRows(cfg, cols, rows)
+		if err != nil {
+			return nil, err
+		}
+		sample := make([]map[string]interface{}, len(rows))
+		for i, row := range rows {
+			sample[i] = make(map[string]interface{}, len(scopedCols))
+			for j, col := range scopedCols {
+				sample[i][col] = row[j]
+			}
+		}
+		return map[string]interface{}{"dry_run": true, "seed": seed, "row_count": cfg.rowCount, "sample": sample}, nil
+	}
+
+	batchSize := cfg.batchSize
+	if batchSize == 0 {
+		batchSize = defaultCopyBatchSize
+	}
+	width := len(cols)
+	if cfg.tenantColumn != "" {
+		width++
+	}
+	if batchSize*width > maxPlaceholders {
+		batchSize = maxPlaceholders / width
+	}
+	var inserted, batches int64
+	for done := int64(0); done < cfg.rowCount; {
+		n := cfg.rowCount - done
+		if n > int64(batchSize) {
+			n = int64(batchSize)
+		}
+		rows := make([][]interface{}, n)
+		for i := range rows {
+			rows[i] = next(done + int64(i))
+		}
+		batchCols, rows, err := scopeInsertRows(cfg, cols, rows)
+		if err != nil {
+			return nil, err
+		}
+		stmt, err := insertStatement(table, batchCols, rows)
+		if err != nil {
+			return nil, err
+		}
+		res, err := db.ExecContext(ctx, stmt.SQL, stmt.Args...)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: execution error: %w (%d rows inserted by the earlier batches)", batches+1, err, inserted)
+		}
+		affected, _ := res.RowsAffected()
+		inserted += affected
+		batches++
+		done += n
+	}
+	return map[string]interface{}{"seed": seed, "rows_inserted": inserted, "batches": batches}, nil
+}
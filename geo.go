@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Earth radii of the two geo strategies. ST_Distance_Sphere defaults to
+// 6370986 m; haversine uses the IUGG mean radius.
+const (
+	sphereEarthRadiusKM    = 6370.986
+	haversineEarthRadiusKM = 6371.0088
+)
+
+// geoFilter is a geo condition of the filter DSL, matching the rows within
+// radius_km of a point:
+//
+//	{"op": "geo", "column": "location", "value": {"lat": -6.2, "lng": 106.8, "radius_km": 5}}
+//	{"op": "geo", "latitude": "lat", "longitude": "lng", "value": {...}, "distance_as": "distance_km"}
+//
+// A POINT column is compared with ST_Distance_Sphere (MySQL 5.7); srid
+// gives the center point the column's SRID, such as 4326, which MySQL 8.0
+// requires to match. Latitude and longitude columns are compared with the
+// haversine formula. With data_type=table, distance_as adds the distance
+// in km as a column of that name and orders the rows by it.
+//
+// The exported fields are reported in the geo output field.
+type geoFilter struct {
+	Strategy       string  `json:"strategy"` // st_distance_sphere or haversine
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	RadiusKM       float64 `json:"radius_km"`
+	EarthRadiusKM  float64 `json:"earth_radius_km"`
+	Accuracy       string  `json:"accuracy"`
+	DistanceColumn string  `json:"distance_column,omitempty"`
+
+	expr     string // distance in km
+	exprArgs []interface{}
+}
+
+type geoCenter struct {
+	Lat      *float64 `json:"lat"`
+	Lng      *float64 `json:"lng"`
+	RadiusKM *float64 `json:"radius_km"`
+}
+
+func (c filterCondition) geoFilter() (*geoFilter, error) {
+	b, _ := json.Marshal(c.Value)
+	var center geoCenter
+	if err := json.Unmarshal(b, &center); err != nil || center.Lat == nil || center.Lng == nil || center.RadiusKM == nil {
+		return nil, fmt.Errorf(`geo needs a value like {"lat": -6.2, "lng": 106.8, "radius_km": 5}`)
+	}
+	lat, lng, radius := *center.Lat, *center.Lng, *center.RadiusKM
+	switch {
+	case lat < -90 || lat > 90:
+		return nil, fmt.Errorf("geo lat must be between -90 and 90, got %v", lat)
+	case lng < -180 || lng > 180:
+		return nil, fmt.Errorf("geo lng must be between -180 and 180, got %v", lng)
+	case !(radius > 0) || math.IsInf(radius, 0):
+		return nil, fmt.Errorf("geo radius_km must be positive, got %v", radius)
+	}
+	g := &geoFilter{Latitude: lat, Longitude: lng, RadiusKM: radius}
+	if c.DistanceAs != "" {
+		if _, err := quoteIdent(c.DistanceAs); err != nil {
+			return nil, fmt.Errorf("geo distance_as %v", err)
+		}
+		g.DistanceColumn = c.DistanceAs
+	}
+
+	switch {
+	case c.Column != "" && (c.Latitude != "" || c.Longitude != ""):
+		return nil, fmt.Errorf("geo takes either column, a POINT, or latitude and longitude columns")
+	case c.Column != "":
+		col, err := qualifiedColumnIdent(c.Column)
+		if err != nil {
+			return nil, err
+		}
+		b := new(stmtBuilder).text("ST_Distance_Sphere(").ident(col).text(", ")
+		if c.SRID != nil {
+			if *c.SRID < 0 {
+				return nil, fmt.Errorf("geo srid must not be negative")
+			}
+			b.text("ST_SRID(POINT(?, ?), ").number(int64(*c.SRID)).text(")")
+		} else {
+			b.text("POINT(?, ?)")
+		}
+		g.Strategy, g.EarthRadiusKM = "st_distance_sphere", sphereEarthRadiusKM
+		g.Accuracy = "great-circle distance on a sphere, computed by the server; within about 0.5% of the distance on the WGS 84 ellipsoid"
+		g.expr, g.exprArgs = b.text(") / 1000").arg(lng, lat).build()
+	case c.Latitude != "" && c.Longitude != "":
+		if c.SRID != nil {
+			return nil, fmt.Errorf("geo srid applies to a POINT column")
+		}
+		latCol, err := qualifiedColumnIdent(c.Latitude)
+		if err != nil {
+			return nil, err
+		}
+		lngCol, err := qualifiedColumnIdent(c.Longitude)
+		if err != nil {
+			return nil, err
+		}
+		g.Strategy, g.EarthRadiusKM = "haversine", haversineEarthRadiusKM
+		g.Accuracy = "haversine formula on a sphere in double precision; within about 0.5% of the distance on the WGS 84 ellipsoid, and about 0.0004% longer than st_distance_sphere for its larger radius"
+		g.expr, g.exprArgs = new(stmtBuilder).text(sqlText(strconv.FormatFloat(haversineEarthRadiusKM, 'g', -1, 64))).
+			text(" * 2 * ASIN(SQRT(POWER(SIN(RADIANS(").ident(latCol).text(" - ?) / 2), 2) + COS(RADIANS(?)) * COS(RADIANS(").ident(latCol).
+			text(")) * POWER(SIN(RADIANS(").ident(lngCol).text(" - ?) / 2), 2)))").arg(lat, lat, lng).build()
+	default:
+		return nil, fmt.Errorf("geo needs column, a POINT, or both latitude and longitude columns")
+	}
+	return g, nil
+}
+
+// condition renders the radius test.
+func (g *geoFilter) condition() (string, []interface{}) {
+	return g.expr + " <= ?", append(append([]interface{}{}, g.exprArgs...), g.RadiusKM)
+}
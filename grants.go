@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// grant is one parsed line of SHOW GRANTS output.
+type grant struct {
+	Privileges []string // upper case; ALL PRIVILEGES is stored as ALL
+	ObjectType string   // TABLE, FUNCTION or PROCEDURE
+	Schema     string   // * for global grants; may contain LIKE wildcards
+	Object     string   // * for schema level grants
+}
+
+var (
+	grantOnRe   = regexp.MustCompile(`(?is)^GRANT\s+(.+?)\s+ON\s+(?:(TABLE|FUNCTION|PROCEDURE)\s+)?(\S+)\s+TO\s+`)
+	grantRoleRe = regexp.MustCompile(`(?is)^GRANT\s+(.+?)\s+TO\s+`)
+)
+
+// parseGrant parses a privilege grant. Role grants (GRANT `r`@`%` TO ...)
+// are returned as roles instead.
+func parseGrant(line string) (g grant, roles []string, ok bool) {
+	if m := grantOnRe.FindStringSubmatch(line); m != nil {
+		g.ObjectType = strings.ToUpper(m[2])
+		if g.ObjectType == "" {
+			g.ObjectType = "TABLE"
+		}
+		for _, p := range splitOutsideParens(m[1]) {
+			p = strings.ToUpper(strings.TrimSpace(p))
+			// Column level grants such as SELECT (a, b) do not cover the table.
+			if strings.Contains(p, "(") {
+				continue
+			}
+			if p == "ALL PRIVILEGES" {
+				p = "ALL"
+			}
+			g.Privileges = append(g.Privileges, p)
+		}
+		target := m[3]
+		if target == "*.*" || target == "*" {
+			g.Schema, g.Object = "*", "*"
+		} else {
+			parts := splitQualified(target)
+			if len(parts) != 2 {
+				return grant{}, nil, false
+			}
+			g.Schema, g.Object = parts[0], parts[1]
+		}
+		return g, nil, true
+	}
+	if m := grantRoleRe.FindStringSubmatch(line); m != nil {
+		for _, r := range splitOutsideParens(m[1]) {
+			roles = append(roles, strings.TrimSpace(r))
+		}
+		return grant{}, roles, false
+	}
+	return grant{}, nil, false
+}
+
+func splitOutsideParens(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// covers reports whether the grant gives priv on schema.object. An empty
+// object asks for schema level access.
+func (g grant) covers(priv, schema, object string) bool {
+	has := false
+	for _, p := range g.Privileges {
+		if p == priv || p == "ALL" {
+			has = true
+			break
+		}
+	}
+	if !has {
+		return false
+	}
+	if g.Schema == "*" {
+		return true
+	}
+	if g.Object == "*" {
+		// Schema level grants treat _ and % as wildcards unless escaped.
+		return likeMatch(g.Schema, schema)
+	}
+	if object == "" || !strings.EqualFold(g.Schema, schema) || !strings.EqualFold(g.Object, object) {
+		return false
+	}
+	if priv == "EXECUTE" {
+		return g.ObjectType == "PROCEDURE" || g.ObjectType == "FUNCTION"
+	}
+	return g.ObjectType == "TABLE"
+}
+
+// likeMatch matches s against a MySQL LIKE pattern, case-insensitively.
+func likeMatch(pattern, s string) bool {
+	var re strings.Builder
+	re.WriteString("(?is)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '%':
+			re.WriteString(".*")
+		case c == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	ok, _ := regexp.MatchString(re.String(), s)
+	return ok
+}
+
+// privilegeReport is the verdict returned by check_privileges.
+type privilegeReport struct {
+	Allowed  bool     `json:"allowed"`
+	Schema   string   `json:"schema"`
+	Object   string   `json:"object,omitempty"`
+	Required []string `json:"required"`
+	Missing  []string `json:"missing"`
+	Roles    []string `json:"roles,omitempty"`
+	Grants   []string `json:"grants"`
+}
+
+// privilegeError is returned when a preflight finds missing grants.
+type privilegeError struct{ report privilegeReport }
+
+func (e *privilegeError) Error() string {
+	target := e.report.Schema
+	if e.report.Object != "" {
+		target += "." + e.report.Object
+	}
+	return fmt.Sprintf("missing privileges: %s on %s", strings.Join(e.report.Missing, ", "), target)
+}
+
+// currentGrants reads the grants of the current user. On MySQL 8 the default
+// roles are activated first and their privileges expanded with USING.
+func currentGrants(ctx context.Context, db *database) ([]string, []string, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	// Older servers and MariaDB list the active grants without USING.
+	var roles []string
+	if v, err := db.serverVersion(ctx); err == nil && v.roles() {
+		conn.ExecContext(ctx, "SET ROLE DEFAULT")
+		var current sql.NullString
+		if err := conn.QueryRowContext(ctx, "SELECT CURRENT_ROLE()").Scan(&current); err == nil && current.Valid && current.String != "" && current.String != "NONE" {
+			roles = splitOutsideParens(current.String)
+		}
+	}
+
+	query := "SHOW GRANTS FOR CURRENT_USER()"
+	lines, err := queryStrings(ctx, conn, query+usingRoles(roles))
+	if err != nil && len(roles) > 0 {
+		lines, err = queryStrings(ctx, conn, query)
+	}
+	return lines, roles, err
+}
+
+func usingRoles(roles []string) string {
+	if len(roles) == 0 {
+		return ""
+	}
+	return " USING " + strings.Join(roles, ", ")
+}
+
+// queryStrings returns the first column of every row.
+func queryStrings(ctx context.Context, q execer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// evaluatePrivileges checks the required privileges against the current
+// user's grants.
+func evaluatePrivileges(ctx context.Context, db *database, required []string, schema, object string) (privilegeReport, error) {
+	lines, roles, err := currentGrants(ctx, db)
+	if err != nil {
+		return privilegeReport{}, fmt.Errorf("failed to read grants: %v", err)
+	}
+
+	var grants []grant
+	for _, line := range lines {
+		if g, moreRoles, ok := parseGrant(line); ok {
+			grants = append(grants, g)
+		} else {
+			roles = appendUnique(roles, moreRoles...)
+		}
+	}
+
+	report := privilegeReport{Schema: schema, Object: object, Required: required, Missing: []string{}, Roles: roles, Grants: lines}
+	for _, priv := range required {
+		covered := false
+		for _, g := range grants {
+			if g.covers(priv, schema, object) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			report.Missing = append(report.Missing, priv)
+		}
+	}
+	report.Allowed = len(report.Missing) == 0
+	return report, nil
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// checkPrivileges implements data_type=check_privileges: it reports whether
+// the current user holds the privilege input (default SELECT, comma separated)
+// on object_name, or on the schema when no object is given.
+func checkPrivileges(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	required := []string{"SELECT"}
+	if cfg.privilege != "" {
+		required = nil
+		for _, p := range strings.Split(cfg.privilege, ",") {
+			if p = strings.ToUpper(strings.TrimSpace(p)); p != "" {
+				required = append(required, p)
+			}
+		}
+	}
+	schema, object := cfg.dbname, ""
+	if cfg.objectName != "" {
+		schema, object = splitTarget(cfg.objectName, cfg.dbname)
+	}
+	return evaluatePrivileges(ctx, db, required, schema, object)
+}
+
+// splitTarget splits name into schema and object, defaulting the schema.
+func splitTarget(name, defaultSchema string) (string, string) {
+	parts := splitQualified(name)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return defaultSchema, parts[0]
+}
+
+// preflightPrivileges verifies grants for a write before it runs. Statements
+// whose target cannot be inferred are let through with a warning.
+func preflightPrivileges(ctx context.Context, db *database, cfg settings, stmt statement, out *Output) error {
+	required, target := requiredPrivileges(stmt.SQL)
+	if len(required) == 0 || target == "" {
+		out.Warnings = append(out.Warnings, "preflight_privileges: could not infer the privileges this statement needs")
+		return nil
+	}
+	schema, object := splitTarget(target, cfg.dbname)
+
+	report, err := evaluatePrivileges(ctx, db, required, schema, object)
+	if err != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("preflight_privileges: %v", err))
+		return nil
+	}
+	if !report.Allowed {
+		return &privilegeError{report}
+	}
+	return nil
+}
+
+// requiredPrivileges infers the privileges and target object of a DML or
+// CALL statement.
+func requiredPrivileges(sqlText string) ([]string, string) {
+	var words []token
+	for _, tok := range tokenize(sqlText) {
+		if tok.kind != tokSpace && tok.kind != tokComment {
+			words = append(words, tok)
+		}
+	}
+	if len(words) == 0 {
+		return nil, ""
+	}
+
+	// targetAfter returns the (possibly qualified) name following keyword.
+	targetAfter := func(keyword string) string {
+		for i, w := range words {
+			if w.kind == tokWord && strings.EqualFold(w.text, keyword) {
+				return qualifiedNameAt(words, i+1)
+			}
+		}
+		return ""
+	}
+
+	switch strings.ToUpper(words[0].text) {
+	case "INSERT":
+		return []string{"INSERT"}, targetAfter("INTO")
+	case "REPLACE":
+		return []string{"INSERT", "DELETE"}, targetAfter("INTO")
+	case "DELETE":
+		return []string{"DELETE"}, targetAfter("FROM")
+	case "UPDATE":
+		i := 1
+		for i < len(words) && (strings.EqualFold(words[i].text, "LOW_PRIORITY") || strings.EqualFold(words[i].text, "IGNORE")) {
+			i++
+		}
+		return []string{"UPDATE"}, qualifiedNameAt(words, i)
+	case "CALL":
+		return []string{"EXECUTE"}, qualifiedNameAt(words, 1)
+	}
+	return nil, ""
+}
+
+// qualifiedNameAt reads name or schema.name starting at words[i].
+func qualifiedNameAt(words []token, i int) string {
+	isName := func(t token) bool { return t.kind == tokWord || t.kind == tokQuotedIdent }
+	if i >= len(words) || !isName(words[i]) {
+		return ""
+	}
+	name := words[i].text
+	if i+2 < len(words) && words[i+1].text == "." && isName(words[i+2]) {
+		name += "." + words[i+2].text
+	}
+	return name
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// grouping turns header/detail join rows into one parent per distinct key
+// with the detail columns in a children array. Columns are named as they
+// appear in the output, after transform, nesting and column_case.
+type grouping struct {
+	keys        []string
+	childrenKey string
+	maxChildren int
+}
+
+func newGrouping(cfg settings) *grouping {
+	if cfg.groupByColumns == "" {
+		return nil
+	}
+	keys, _ := parseIdentList(cfg.groupByColumns) // validated by parseSettings
+	g := &grouping{keys: keys, childrenKey: cfg.childrenKey, maxChildren: cfg.maxChildren}
+	if g.childrenKey == "" {
+		g.childrenKey = "children"
+	}
+	return g
+}
+
+// apply groups rows in order of first appearance, keeping the row order
+// within each group. A column is a header column when it is constant within
+// every group, so all parents have the same shape; when no group has more
+// than one row only the keys are. Groups over max_children keep the first
+// rows and get <children_key>_truncated.
+func (g *grouping) apply(rows []map[string]interface{}) ([]map[string]interface{}, []string) {
+	if len(rows) == 0 {
+		return rows, nil
+	}
+	for _, key := range g.keys {
+		if _, ok := rows[0][key]; !ok {
+			return rows, []string{fmt.Sprintf("group_by_columns: column %q is not in the result; rows are not grouped", key)}
+		}
+	}
+	var warnings []string
+	if _, ok := rows[0][g.childrenKey]; ok {
+		warnings = append(warnings, fmt.Sprintf("children_key: the result column %q is replaced by the children array", g.childrenKey))
+	}
+
+	isKey := map[string]bool{}
+	for _, key := range g.keys {
+		isKey[key] = true
+	}
+	var order []string
+	groups := map[string][]map[string]interface{}{}
+	for _, row := range rows {
+		k := g.groupKey(row)
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], row)
+	}
+
+	header := map[string]bool{}
+	multi := len(order) < len(rows)
+	for col := range rows[0] {
+		header[col] = multi && !isKey[col]
+	}
+	for _, members := range groups {
+		for col, ok := range header {
+			if !ok {
+				continue
+			}
+			for _, row := range members[1:] {
+				if !reflect.DeepEqual(row[col], members[0][col]) {
+					header[col] = false
+					break
+				}
+			}
+		}
+	}
+
+	parents := make([]map[string]interface{}, 0, len(order))
+	for _, k := range order {
+		members := groups[k]
+		parent := map[string]interface{}{}
+		for col, v := range members[0] {
+			if isKey[col] || header[col] {
+				parent[col] = v
+			}
+		}
+		if g.maxChildren > 0 && len(members) > g.maxChildren {
+			members = members[:g.maxChildren]
+			parent[g.childrenKey+"_truncated"] = true
+		}
+		children := make([]map[string]interface{}, 0, len(members))
+		for _, row := range members {
+			child := map[string]interface{}{}
+			for col, v := range row {
+				if !isKey[col] && !header[col] {
+					child[col] = v
+				}
+			}
+			children = append(children, child)
+		}
+		parent[g.childrenKey] = children
+		parents = append(parents, parent)
+	}
+	return parents, warnings
+}
+
+// groupKey encodes the key values; JSON keeps 1 and "1" apart.
+func (g *grouping) groupKey(row map[string]interface{}) string {
+	values := make([]interface{}, len(g.keys))
+	for i, key := range g.keys {
+		values[i] = row[key]
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
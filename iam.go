@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+// authTokenProvider generates short-lived database passwords such as RDS IAM
+// authentication tokens.
+type authTokenProvider interface {
+	Token(ctx context.Context, endpoint, region, user string) (string, error)
+}
+
+// iamTokens is set by iam_aws.go when the binary is built with -tags aws;
+// default builds carry no AWS dependency.
+var iamTokens authTokenProvider
+
+// rdsRoots is the RDS CA bundle embedded by iam_aws.go, used in place of the
+// system roots, which RDS certificates do not chain to, when tls_ca is not
+// set. It is nil in default builds and in aws builds made without the
+// bundle.
+var rdsRoots *x509.CertPool
+
+// applyIAMToken replaces the password with a fresh RDS IAM token and enforces
+// the connection settings IAM authentication requires.
+func applyIAMToken(cfg *settings) error {
+	if iamTokens == nil {
+		return fmt.Errorf("auth_method=aws_iam is not available in this build (rebuild with -tags aws)")
+	}
+
+	region := cfg.awsRegion
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return fmt.Errorf("aws_region is required for auth_method=aws_iam")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	token, err := iamTokens.Token(ctx, hostPort(cfg.host, cfg.port), region, cfg.username)
+	if err != nil {
+		return fmt.Errorf("failed to generate IAM auth token: %v", err)
+	}
+	cfg.password = token
+
+	// RDS sends the token with mysql_clear_password, which is only safe over
+	// TLS; verification uses the RDS CA bundle given in tls_ca, or else the
+	// one built in.
+	if cfg.tlsMode == "" || cfg.tlsMode == "false" || cfg.tlsMode == "preferred" {
+		cfg.tlsMode = "true"
+	}
+	if cfg.tlsCA == "" && rdsRoots == nil {
+		logf("auth_method=aws_iam without tls_ca in a build without the RDS CA bundle (see rdsca/README.md): verifying RDS certificates against the system roots")
+	}
+	cfg.allowCleartextPasswords = true
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build aws
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"embed"
+	"io/fs"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+)
+
+//go:generate curl -fsSL -o rdsca/global-bundle.pem https://truststore.pki.rds.amazonaws.com/global/global-bundle.pem
+
+// rdsCA holds the RDS CA bundle; see rdsca/README.md.
+//
+//go:embed rdsca
+var rdsCA embed.FS
+
+func init() {
+	iamTokens = rdsTokenProvider{}
+	rdsRoots = rdsCertPool(rdsCA)
+}
+
+// rdsCertPool returns the certificates of the *.pem files in the rdsca
+// directory of fsys, or nil when there are none.
+func rdsCertPool(fsys fs.FS) *x509.CertPool {
+	files, _ := fs.Glob(fsys, "rdsca/*.pem")
+	pool := x509.NewCertPool()
+	found := false
+	for _, name := range files {
+		if pem, err := fs.ReadFile(fsys, name); err == nil && pool.AppendCertsFromPEM(pem) {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return pool
+}
+
+// rdsTokenProvider signs RDS IAM tokens with the default AWS credential chain
+// (environment, shared config/credentials files, container and instance roles).
+type rdsTokenProvider struct{}
+
+func (rdsTokenProvider) Token(ctx context.Context, endpoint, region, user string) (string, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return "", err
+	}
+	return auth.BuildAuthToken(ctx, endpoint, region, user, awsCfg.Credentials)
+}
@@ -0,0 +1,49 @@
+//go:build aws
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRDSBundleEmbedded(t *testing.T) {
+	if rdsRoots == nil {
+		t.Fatal("the build carries no RDS CA bundle; run go generate -tags aws ./... (see rdsca/README.md)")
+	}
+	conf, err := tlsConfig(settings{authMethod: "aws_iam", tlsMode: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RootCAs != rdsRoots {
+		t.Error("aws_iam without tls_ca does not verify against the RDS bundle")
+	}
+}
+
+func TestRDSCertPool(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test CA"}, NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if pool := rdsCertPool(fstest.MapFS{"rdsca/README.md": {Data: []byte("no bundle")}}); pool != nil {
+		t.Error("a directory without PEM files gives a pool")
+	}
+	if pool := rdsCertPool(fstest.MapFS{"rdsca/global-bundle.pem": {Data: ca}}); pool == nil {
+		t.Error("the bundle gives no pool")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Writes given an idempotency_key record the key and their result in
+// idempotencyTable, in the transaction of the write itself: the key commits
+// exactly when the write does. A retry finds the key, skips the write and
+// gets the stored result back with idempotent_replay.
+
+const (
+	idempotencyTable      = "_component_idempotency"
+	maxIdempotencyKey     = 191 // fits a utf8mb4 primary key on every supported server
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+const createIdempotencyTable = "CREATE TABLE IF NOT EXISTS `" + idempotencyTable + "` (" +
+	"idempotency_key VARCHAR(191) NOT NULL PRIMARY KEY, " +
+	"request_hash CHAR(64) NOT NULL, " +
+	"result LONGTEXT NULL, " +
+	"created_at DATETIME NOT NULL, " +
+	"expires_at DATETIME NOT NULL, " +
+	"KEY expires_at (expires_at))"
+
+// idempotencyHash identifies what a key was first used for, so reusing it for
+// a different write is refused rather than answered with an unrelated result.
+func idempotencyHash(sqlText string, args []interface{}) string {
+	b, _ := json.Marshal(args)
+	sum := sha256.Sum256(append([]byte(sqlText+"\x00"), b...))
+	return hex.EncodeToString(sum[:])
+}
+
+// ensureIdempotencyTable creates idempotencyTable with
+// create_idempotency_table. It runs before the write's transaction begins,
+// since DDL would commit it.
+func ensureIdempotencyTable(ctx context.Context, q execer, cfg settings) error {
+	if !cfg.createIdempotencyTable {
+		return nil
+	}
+	if _, err := q.ExecContext(ctx, createIdempotencyTable); err != nil {
+		return fmt.Errorf("failed to create %s: %v", idempotencyTable, err)
+	}
+	return nil
+}
+
+// claimIdempotencyKey inserts idempotency_key inside tx. It returns the stored
+// result when an earlier write already committed under the key; the caller
+// then skips the write. A concurrent attempt holding the key makes the insert
+// wait until that attempt commits or rolls back.
+func claimIdempotencyKey(ctx context.Context, tx *sql.Tx, cfg settings, hash string) (json.RawMessage, error) {
+	ttl := time.Duration(cfg.idempotencyTTL) * time.Second
+	if ttl == 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	// An expired key no longer protects anything; let this write reuse it.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM `"+idempotencyTable+"` WHERE idempotency_key = ? AND expires_at < NOW()", cfg.idempotencyKey); err != nil {
+		return nil, idempotencyTableError(err)
+	}
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO `"+idempotencyTable+"` (idempotency_key, request_hash, created_at, expires_at) VALUES (?, ?, NOW(), NOW() + INTERVAL ? SECOND)",
+		cfg.idempotencyKey, hash, int64(ttl/time.Second))
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1062 { // ER_DUP_ENTRY
+		var stored string
+		var result sql.NullString
+		if err := tx.QueryRowContext(ctx, "SELECT request_hash, result FROM `"+idempotencyTable+"` WHERE idempotency_key = ?", cfg.idempotencyKey).Scan(&stored, &result); err != nil {
+			return nil, fmt.Errorf("failed to read idempotency_key %q: %v", cfg.idempotencyKey, err)
+		}
+		if stored != hash {
+			return nil, fmt.Errorf("idempotency_key %q was already used for a different write", cfg.idempotencyKey)
+		}
+		if !result.Valid {
+			return json.RawMessage("null"), nil
+		}
+		return json.RawMessage(result.String), nil
+	}
+	if err != nil {
+		return nil, idempotencyTableError(err)
+	}
+	return nil, nil
+}
+
+// storeIdempotentResult saves the result of the write under its key, in the
+// same transaction.
+func storeIdempotentResult(ctx context.Context, tx *sql.Tx, cfg settings, result interface{}) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode the result for idempotency_key: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE `"+idempotencyTable+"` SET result = ? WHERE idempotency_key = ?", string(b), cfg.idempotencyKey); err != nil {
+		return fmt.Errorf("failed to store the result for idempotency_key: %v", err)
+	}
+	return nil
+}
+
+func idempotencyTableError(err error) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1146 { // ER_NO_SUCH_TABLE
+		return fmt.Errorf("%s does not exist; set create_idempotency_table=true to create it", idempotencyTable)
+	}
+	return fmt.Errorf("idempotency_key: %v", err)
+}
+
+// idempotencyCleanup implements data_type=idempotency_cleanup, deleting the
+// expired keys.
+func idempotencyCleanup(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM `"+idempotencyTable+"` WHERE expires_at < NOW()")
+	if err != nil {
+		return nil, idempotencyTableError(err)
+	}
+	deleted, _ := res.RowsAffected()
+	return map[string]int64{"rows_affected": deleted}, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// quoteIdent wraps a single identifier in backticks, doubling embedded
+// backticks. Empty names and NUL bytes are rejected.
+func quoteIdent(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("identifier is empty")
+	}
+	if len(name) > 64 {
+		return "", fmt.Errorf("identifier %q exceeds 64 characters", name)
+	}
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("identifier contains a NUL byte")
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`", nil
+}
+
+// quoteQualifiedIdent quotes a possibly schema-qualified name such as
+// erp.invoices. Parts already wrapped in backticks are accepted as-is.
+func quoteQualifiedIdent(name string) (string, error) {
+	parts := splitQualified(name)
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	for i, part := range parts {
+		q, err := quoteIdent(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = q
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// splitQualified splits on dots outside backtick quotes and strips the quotes.
+func splitQualified(name string) []string {
+	var parts []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '`' && inQuote && i+1 < len(name) && name[i+1] == '`':
+			cur.WriteByte('`')
+			i++
+		case c == '`':
+			inQuote = !inQuote
+		case c == '.' && !inQuote:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(parts, cur.String())
+}
+
+// parseIdentList reads a column list given either as a JSON array of names
+// or as a comma separated string.
+func parseIdentList(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	var names []string
+	if strings.HasPrefix(raw, "[") {
+		if err := json.Unmarshal([]byte(raw), &names); err != nil {
+			return nil, fmt.Errorf("must be a JSON array of column names: %v", err)
+		}
+	} else if raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("must name at least one column")
+	}
+	for _, name := range names {
+		if _, err := quoteIdent(name); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// validPrefix reports whether a table_prefix only uses the characters of an
+// unquoted identifier, so it is also safe to splice into a query template.
+func validPrefix(prefix string) bool {
+	for _, c := range prefix {
+		if !(c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return prefix != ""
+}
+
+// routineName renders the object_name of a stored procedure or function.
+// A name of plain identifier characters is left unquoted, since a quoted
+// name never resolves to a built-in function such as ABS; any other name,
+// and one starting with a digit, which could read as a number such as
+// 1e5, is quoted, so it can only ever name a routine.
+func routineName(name string) (string, error) {
+	parts := splitQualified(name)
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	for _, part := range parts {
+		if !validPrefix(part) || isDigit(part[0]) || strings.ContainsRune(name, '`') {
+			return quoteQualifiedIdent(name)
+		}
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// prefixObjectName applies table_prefix to the table part of a possibly
+// qualified name and quotes the result: erp.invoices becomes
+// `erp`.`t001_invoices`.
+func prefixObjectName(name, prefix string) (string, error) {
+	parts := splitQualified(name)
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	parts[len(parts)-1] = prefix + parts[len(parts)-1]
+	for i, part := range parts {
+		q, err := quoteIdent(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = q
+	}
+	return strings.Join(parts, "."), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// defaultMaxInputBytes caps the Input JSON read from stdin or --input.
+// Payloads carrying many rows, such as bulk inserts, raise it with
+// --max-input-bytes.
+const defaultMaxInputBytes = 8 << 20
+
+// maxInputBytes is the --max-input-bytes flag.
+var maxInputBytes int64 = defaultMaxInputBytes
+
+// decodeInput reads at most maxInputBytes from r and decodes them as one
+// JSON document. A failure names the byte offset, line and column, and
+// where it is known the JSON path being read, such as params[3].compvalue;
+// it also says when the input ended early or hit the size limit. Anything
+// but whitespace after the document is an error rather than ignored.
+func decodeInput(r io.Reader) (Input, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxInputBytes+1))
+	if err != nil {
+		return Input{}, fmt.Errorf("failed to read input: %v", err)
+	}
+	if int64(len(data)) > maxInputBytes {
+		return Input{}, fmt.Errorf("failed to decode input: it is larger than the %d bytes --max-input-bytes allows and was not read further; raise the limit for large payloads such as insert rows", maxInputBytes)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return Input{}, fmt.Errorf("failed to decode input: the input is empty")
+	}
+
+	var input Input
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&input); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		offset := int64(len(data))
+		msg := err.Error()
+		switch {
+		case errors.As(err, &syntaxErr):
+			offset = syntaxErr.Offset
+		case errors.As(err, &typeErr):
+			offset = typeErr.Offset
+			msg = fmt.Sprintf("expected a JSON %s, found a %s", jsonKind(typeErr.Type), typeErr.Value)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			msg = "the input ends in the middle of the JSON document, and may have been truncated"
+		}
+		return Input{}, fmt.Errorf("failed to decode input: %s, %s", msg, describeOffset(data, offset))
+	}
+	if rest := dec.InputOffset(); len(bytes.TrimSpace(data[rest:])) > 0 {
+		start := rest + int64(len(data[rest:])-len(bytes.TrimLeft(data[rest:], " \t\r\n")))
+		return Input{}, fmt.Errorf("failed to decode input: unexpected data after the JSON document at byte %d (%s)", start, inputPosition(data, start))
+	}
+	return input, nil
+}
+
+// jsonKind names the JSON value a Go type of Input decodes from.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	}
+	return "number"
+}
+
+// describeOffset locates offset in data for a decode error, with the
+// JSON path being read there when there is one.
+func describeOffset(data []byte, offset int64) string {
+	s := fmt.Sprintf("at byte %d (%s)", offset, inputPosition(data, offset))
+	if path := jsonPathAt(data, offset); path != "" {
+		s += " in " + path
+	}
+	return s
+}
+
+// inputPosition returns the line and column of offset in data.
+func inputPosition(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, column := lineColumn(string(data), int(offset))
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+// jsonFrame is an object or array being read by jsonPathAt.
+type jsonFrame struct {
+	array   bool
+	index   int
+	key     string
+	keyNext bool // an object expecting a key rather than a value
+}
+
+// jsonPathAt returns the path of the value being read at offset, such as
+// params[3].compvalue, by reading the tokens up to there or to the first
+// error. It returns "" at the top level.
+func jsonPathAt(data []byte, offset int64) string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var stack []jsonFrame
+	for dec.InputOffset() < offset {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			if top != nil && top.array {
+				top.index++
+			}
+			stack = append(stack, jsonFrame{array: tok == json.Delim('['), index: -1, keyNext: tok == json.Delim('{')})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 && !stack[len(stack)-1].array {
+				stack[len(stack)-1].keyNext = true
+			}
+		default:
+			switch {
+			case top == nil:
+			case top.array:
+				top.index++
+			case top.keyNext:
+				top.key, _ = tok.(string)
+				top.keyNext = false
+			default:
+				top.keyNext = true
+			}
+		}
+	}
+	var path bytes.Buffer
+	for _, f := range stack {
+		switch {
+		case f.array && f.index >= 0:
+			fmt.Fprintf(&path, "[%d]", f.index)
+		case !f.array && f.key != "":
+			if path.Len() > 0 {
+				path.WriteByte('.')
+			}
+			path.WriteString(f.key)
+		}
+	}
+	return path.String()
+}
@@ -0,0 +1,2102 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed plugin.json
+var pluginManifest []byte
+
+// inputAliases maps names people commonly guess to the real input, so a typo
+// of "database" still suggests dbname.
+var inputAliases = map[string]string{
+	"database": "dbname",
+	"db":       "dbname",
+	"user":     "username",
+	"pass":     "password",
+	"sql":      "query",
+	"params":   "parameters",
+	"table":    "object_name",
+}
+
+// knownInputs returns the input names declared in plugin.json.
+func knownInputs() []string {
+	var manifest struct {
+		Details []struct {
+			InputName string `json:"inputname"`
+		} `json:"details"`
+	}
+	json.Unmarshal(pluginManifest, &manifest)
+	names := make([]string, 0, len(manifest.Details))
+	for _, d := range manifest.Details {
+		names = append(names, d.InputName)
+	}
+	return names
+}
+
+// unknownInputsError builds the error for unrecognized input names, with a
+// suggestion for each name that is close to a known input.
+func unknownInputsError(unknown []string) error {
+	known := knownInputs()
+	var suggestions []string
+	for _, name := range unknown {
+		if s := suggestInput(name, known); s != "" {
+			suggestions = append(suggestions, s)
+		}
+	}
+	msg := "unknown inputs: " + strings.Join(unknown, ", ")
+	if len(suggestions) > 0 {
+		msg += " — did you mean " + strings.Join(suggestions, ", ") + "?"
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+func suggestInput(name string, known []string) string {
+	candidates := make(map[string]string, len(known)+len(inputAliases))
+	for _, k := range known {
+		candidates[k] = k
+	}
+	for alias, target := range inputAliases {
+		candidates[alias] = target
+	}
+
+	keys := make([]string, 0, len(candidates))
+	for k := range candidates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	best, bestDist := "", 0
+	for _, k := range keys {
+		d := editDistance(strings.ToLower(name), k)
+		if d <= 2 && d < len(k) && (best == "" || d < bestDist) {
+			best, bestDist = candidates[k], d
+		}
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance: Levenshtein plus
+// adjacent transpositions, so "prot" is one edit from "port".
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// settings holds the component inputs after extraction and validation.
+type settings struct {
+	requestID string // correlates output, logs and SQL comments
+
+	host       string
+	port       int
+	username   string
+	password   string
+	dbname     string
+	dataType   string // query, table, stored_procedure, stored_function
+	objectName string
+	query      string
+	parameters string // JSON array of arguments
+
+	tlsMode       string // false, true, skip-verify, preferred
+	tlsCA         string // path to a PEM CA bundle
+	tlsCert       string // path to a PEM client certificate
+	tlsKey        string // path to the client certificate key
+	tlsServerName string
+
+	authMethod string // password (default) or aws_iam
+	awsRegion  string
+
+	allowCleartextPasswords bool
+	allowNativePasswords    bool
+	serverPubKey            string // PEM text or path to the server's RSA public key
+
+	connectTimeout int // seconds, 0 uses the driver default
+	queryTimeout   int // seconds, 0 waits indefinitely
+
+	sshHost                  string
+	sshPort                  int
+	sshUser                  string
+	sshKey                   string // path or PEM
+	sshKeyPassphrase         string
+	sshPassword              string
+	sshKnownHosts            string // defaults to ~/.ssh/known_hosts
+	sshInsecureIgnoreHostKey bool
+
+	compress         bool
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	maxAllowedPacket int // bytes, 0 uses the driver default
+
+	profile                    string // named entry in the --config file
+	requireExplicitCredentials bool   // disables the MYSQL_* environment fallback
+
+	cacheTTL    int    // seconds, 0 disables caching
+	cacheDir    string // directory holding cached results
+	cacheBypass bool
+
+	includeFingerprint bool
+	slowQueryMS        int64 // 0 disables slow query flagging
+	traceparent        string
+
+	privilege           string // privileges checked by check_privileges, or granted and revoked
+	preflightPrivileges bool   // verify grants before running a write
+
+	tableSchema string // JSON table definition for create_table
+	ifNotExists bool
+	temporary   bool
+	ifExists    bool
+	confirm     string // must repeat object_name for truncate and drop_table
+	readOnly    bool
+	steps       string // JSON array of statements for data_type=steps
+
+	consistentSnapshot bool // steps: reads only, in one consistent snapshot
+
+	diagnoseLocks bool // attach lock waits and the latest deadlock to 1205/1213
+
+	idempotencyKey         string // insert, update, steps: skip a write already committed
+	idempotencyTTL         int    // seconds the key is kept, default defaultIdempotencyTTL
+	createIdempotencyTable bool
+
+	outbox string // insert, update, steps: JSON event written with the change
+
+	eventEvery  string // create_event: recurring interval, e.g. 1 DAY
+	eventAt     string // create_event: one-time timestamp
+	eventStarts string
+	eventEnds   string
+	eventBody   string
+	eventStatus string // enable or disable, from event_enabled
+
+	triggerTable  string // create_trigger
+	triggerTiming string // BEFORE or AFTER
+	triggerEvent  string // INSERT, UPDATE or DELETE
+	triggerBody   string
+
+	userName     string // create_user, grant, revoke, drop_user
+	userHost     string // host pattern, default %
+	authPlugin   string
+	userPassword string // from user_password or the variable named by password_env
+	passwordEnv  string
+
+	charset       string // create_database
+	migrationFile string // create_database: script run on the new database
+
+	migrationsDir string // migrate: NNN_description.sql files
+	migrateStatus bool   // migrate: report applied and pending only
+
+	baselineVersion   int64 // migrate: record versions up to it as applied without running them
+	rollbackTo        int64 // migrate: undo applied versions above it
+	rollbackRequested bool
+
+	operation string // maintain_table: analyze, optimize or check; partitions: list, add_range, drop or truncate_partition
+	maxTables int    // maintain_table: most tables one run may touch
+
+	partitionName  string // partitions; comma separated for drop and truncate_partition
+	partitionValue string // partitions add_range: VALUES LESS THAN
+
+	maxBatches          int // purge and archive: 0 runs until no rows remain
+	sleepBetweenBatches int // purge and archive: milliseconds
+	maxRuntime          int // purge and archive: seconds before no further batch starts
+
+	archiveTable string // archive: receives the rows before they are deleted
+
+	anonymizeSpec string // anonymize: JSON array of {table, filter, set}
+
+	rowCount   int64  // generate
+	generators string // generate: JSON object of column generators
+	seed       int64  // generate: random seed, when seedSet
+	seedSet    bool
+
+	lint               bool   // lint the statement before it runs
+	lintFailOn         string // rule ids that fail instead of warning
+	lintLargeTableRows int    // table_rows from which table statistics confirm findings
+
+	keyColumns     string // diff: comma separated columns identifying a row
+	compareQuery   string // diff: second query on the same server
+	maxDifferences int    // diff: most rows listed
+	diffNormalize  string // diff: comma separated normalizations, or none
+
+	checks string // assert: JSON array of checks
+
+	series         string // next_number: counters row, {year} replaced
+	seriesColumn   string // next_number: unique column naming the series
+	counterColumn  string // next_number: last number allocated
+	blockSize      int    // next_number: numbers allocated at once
+	seriesStart    int64  // next_number: first number of a new series, when seriesStartSet
+	seriesStartSet bool
+	numberPrefix   string // next_number: prepended to each number, {year} replaced
+	numberPadding  int    // next_number: zero padded width of the digits
+
+	lock string // table, steps: share or update for locking reads, with nowait or skip_locked
+
+	claimSet          string // claim: JSON object of the columns a claim stamps
+	claimedAtColumn   string // claim: set to NOW() on claim
+	orderColumn       string // claim: rows are claimed in this order
+	requeueStaleAfter int64  // claim: seconds after which a claim expires
+
+	idColumn     string // tree: row id, default id
+	parentColumn string // tree: parent row id, default parent_id
+	rootFilter   string // tree: filter DSL selecting the roots
+	rootIDs      string // tree: JSON array of root ids
+	maxDepth     int    // tree: levels below (or above) the roots, 0 for all
+	direction    string // tree: descendants (default) or ancestors
+	nestChildren bool   // tree: nest the rows under children
+
+	decimalMode    string // string (default) or number
+	decimalColumns string // JSON: decimal mode per column
+	bigIntMode     string // number (default), safe or string
+	zeroDateMode   string // null or string; empty leaves dates to the driver
+	onScanError    string // abort (default), skip or null
+	foundRows      bool   // rows_affected counts matched rows (CLIENT_FOUND_ROWS)
+	countStrategy  string // snapshot page total_rows: exact, calc_found_rows or estimate
+	normalizeShow  bool   // stable keys and numbers for SHOW TABLE STATUS, INDEX, PROCESSLIST, VARIABLES
+
+	connectionCharset string // SET NAMES character sets, fallbacks after commas
+	includeMetadata   bool   // output the session character sets
+	encodingCheck     bool   // warn before a write about columns that cannot hold the connection's characters
+
+	preserveColumnOrder bool // encode row keys in column order
+
+	clientSort           string // columns to sort fetched rows by, with asc or desc
+	clientSortIgnoreCase bool
+	clientLimit          int    // rows kept after client_sort
+	summarizeColumns     string // JSON object of columns and the aggregates appended as summary
+
+	lockName    string // steps: advisory lock held for the whole run
+	lockTimeout int    // seconds GET_LOCK waits, 0 fails at once
+
+	selectQuery     string // view or materialized table definition
+	orReplace       bool
+	definerSecurity bool
+
+	keyColumn   string // kv_get/kv_set table layout
+	valueColumn string
+	key         string
+	value       string
+	scopeFilter string // filter DSL narrowing kv rows, e.g. by company_id
+	strict      bool   // kv_get fails on a missing key, any other write on warnings
+	parseJSON   bool
+
+	strictWarnings bool // writes, steps and archive batches fail on warnings
+
+	filter        string // filter DSL for update and delete
+	values        string // JSON object of column to value for update
+	snapshotTable string // receives the previous rows of update and delete
+
+	softDeleteColumn string // timestamp column marking deleted rows
+	includeDeleted   bool
+
+	cascadePlan bool // fk_graph: plan the deletes for filter
+	execute     bool // fk_graph: run the cascade plan
+
+	fix          string // integrity_check: delete or nullify orphans
+	dryRun       bool
+	sampleSize   int
+	checkTimeout int // seconds per integrity check or maintain_table table
+
+	columns     string // column list, JSON array or comma separated
+	includeRows bool
+	maxRows     int
+	nullsMatch  bool
+	collation   string // duplicates comparison, create_database default
+
+	rowKeys     string // pivot: grouping columns
+	pivotColumn string
+	aggregate   string
+	pivotLimit  int
+
+	idColumns    string // unpivot: columns repeated on every output row
+	valueColumns string
+	keyName      string
+	valueName    string
+	castTo       string
+
+	window string // window function definitions for data_type=table
+
+	dsn string // used verbatim instead of the individual connection inputs
+
+	tablePrefix string // prepended to object_name, e.g. t001_
+	stripPrefix bool   // list_tables returns names without table_prefix
+
+	targetHost       string // copy_table target; empty inputs use the source's
+	targetPort       int
+	targetUsername   string
+	targetPassword   string
+	targetDBName     string
+	targetProfile    string
+	targetObjectName string
+	batchSize        int    // rows per INSERT, default defaultCopyBatchSize; rows per purge batch
+	onConflict       string // error, ignore, update or replace
+	createTarget     bool
+	watermarkColumn  string // copy_table order and resume column
+	resumeAfter      string // copy rows after this watermark
+	since            string // changes: inclusive lower watermark
+	descending       bool   // changes, claim: newest first
+
+	captureBinlogPosition bool // copy_table: read inside a consistent snapshot
+
+	tenantColumn        string // column scoping every row to tenantValue
+	tenantValue         string
+	requireTenantFilter bool // refuse statements that are not tenant scoped
+
+	prepared          bool   // reuse prepared statements within the invocation
+	interpolateParams bool   // bind parameters client-side, e.g. behind ProxySQL
+	streamEncode      bool   // encode read results while scanning them
+	transform         string // JSON: rename, map_values, exclude_columns
+	queryTemplate     bool   // query has {{...}} directives and :name placeholders
+	queryName         string // saved query from the --queries file
+	progressInterval  int    // seconds between progress records, 0 disables
+	progressFile      string // progress records go here instead of stderr
+	maxRowBytes       int64  // 0 means defaultMaxRowBytes
+	maxResultBytes    int64  // 0 means defaultMaxResultBytes
+	truncateColumns   string // JSON: characters kept per column
+	maskColumns       string // JSON: mask rule per column
+	dropColumns       string // columns removed from every result
+	columnCase        string // as_is, camel, pascal or snake
+	nestByPrefix      bool
+	nestSeparator     string // default __
+	nestPrefixes      string // JSON: column prefix -> object name
+	nullCollapse      bool
+	groupByColumns    string // parent key columns for one-to-many grouping
+	childrenKey       string
+	maxChildren       int
+	outputCompression string
+
+	snapshot        bool   // materialize the query for paging
+	snapshotID      string // page through or release this snapshot
+	snapshotRelease bool
+	snapshotCleanup int   // drop snapshots older than this many seconds
+	limit, offset   int64 // snapshot page
+	binaryStdout    bool  // write compressed output as raw bytes
+
+	auditTable      string
+	auditContext    string // JSON object with user, flow id, reason
+	auditBestEffort bool
+
+	includeStatement       bool
+	includeParameterValues bool
+
+	// scan is the row scanning of the invocation, set by run and shared by
+	// the copies of its settings; see scanning.
+	scan *scanConfig
+}
+
+// validationError describes one invalid input. Codes are stable so callers
+// can branch on them.
+type validationError struct {
+	Code    string `json:"code"`
+	Input   string `json:"input"`
+	Message string `json:"message"`
+}
+
+type validationErrors []validationError
+
+func (v *validationErrors) add(code, input, format string, args ...interface{}) {
+	*v = append(*v, validationError{Code: code, Input: input, Message: fmt.Sprintf(format, args...)})
+}
+
+// summary joins the messages into the single error string, folding unknown
+// inputs into one "did you mean" message.
+func (v validationErrors) summary() string {
+	var unknown, msgs []string
+	for _, e := range v {
+		if e.Code == "unknown_input" {
+			unknown = append(unknown, e.Input)
+			continue
+		}
+		msgs = append(msgs, e.Message)
+	}
+	if len(unknown) > 0 {
+		msgs = append([]string{unknownInputsError(unknown).Error()}, msgs...)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// parseSettings extracts the inputs and collects every validation problem
+// instead of stopping at the first one.
+func parseSettings(input Input) (settings, validationErrors) {
+	cfg := settings{dataType: "query", allowNativePasswords: true, dryRun: true, prepared: true, connectionCharset: defaultConnectionCharset}
+	var errs validationErrors
+	var unknown []string
+	ignoreUnknown := false
+
+	// Extract parameters
+	for _, p := range input.Params {
+		val := strings.TrimSpace(p.CompValue)
+		name := strings.ToLower(p.InputName)
+		switch name {
+		case "host":
+			cfg.host = val
+		case "port":
+			if val != "" {
+				port, err := strconv.Atoi(val)
+				if err != nil || port < 1 || port > 65535 {
+					errs.add("invalid_port", name, "port must be a number between 1 and 65535, got %q", val)
+				}
+				cfg.port = port
+			}
+		case "username":
+			cfg.username = val
+		case "password":
+			cfg.password = val
+		case "dbname":
+			cfg.dbname = val
+		case "data_type":
+			if val != "" {
+				cfg.dataType = strings.ToLower(val)
+			}
+		case "object_name":
+			cfg.objectName = val
+		case "query":
+			cfg.query = val
+		case "parameters":
+			cfg.parameters = val
+		case "tls":
+			cfg.tlsMode = strings.ToLower(val)
+		case "tls_ca":
+			cfg.tlsCA = val
+		case "tls_cert":
+			cfg.tlsCert = val
+		case "tls_key":
+			cfg.tlsKey = val
+		case "tls_server_name":
+			cfg.tlsServerName = val
+		case "auth_method":
+			cfg.authMethod = strings.ToLower(val)
+		case "aws_region":
+			cfg.awsRegion = val
+		case "allow_cleartext_passwords":
+			cfg.allowCleartextPasswords = parseBool(val)
+		case "allow_native_passwords":
+			if val != "" {
+				cfg.allowNativePasswords = parseBool(val)
+			}
+		case "server_pub_key":
+			cfg.serverPubKey = val
+		case "connect_timeout_seconds":
+			cfg.connectTimeout = int(parseIntInput(&errs, name, val))
+		case "query_timeout_seconds":
+			cfg.queryTimeout = int(parseIntInput(&errs, name, val))
+		case "profile":
+			cfg.profile = val
+		case "ssh_host":
+			cfg.sshHost = val
+		case "ssh_port":
+			cfg.sshPort = int(parseIntInput(&errs, name, val))
+		case "ssh_user":
+			cfg.sshUser = val
+		case "ssh_key":
+			cfg.sshKey = val
+		case "ssh_key_passphrase":
+			cfg.sshKeyPassphrase = val
+		case "ssh_password":
+			cfg.sshPassword = val
+		case "ssh_known_hosts":
+			cfg.sshKnownHosts = val
+		case "ssh_insecure_ignore_host_key":
+			cfg.sshInsecureIgnoreHostKey = parseBool(val)
+		case "compress":
+			cfg.compress = parseBool(val)
+		case "read_timeout":
+			cfg.readTimeout = parseDurationInput(&errs, name, val)
+		case "write_timeout":
+			cfg.writeTimeout = parseDurationInput(&errs, name, val)
+		case "max_allowed_packet":
+			cfg.maxAllowedPacket = int(parseIntInput(&errs, name, val))
+		case "require_explicit_credentials":
+			cfg.requireExplicitCredentials = parseBool(val)
+		case "cache_ttl_seconds":
+			cfg.cacheTTL = int(parseIntInput(&errs, name, val))
+		case "cache_dir":
+			cfg.cacheDir = val
+		case "cache_bypass":
+			cfg.cacheBypass = parseBool(val)
+		case "include_fingerprint":
+			cfg.includeFingerprint = parseBool(val)
+		case "slow_query_ms":
+			cfg.slowQueryMS = parseIntInput(&errs, name, val)
+		case "traceparent":
+			cfg.traceparent = val
+		case "privilege":
+			cfg.privilege = val
+		case "preflight_privileges":
+			cfg.preflightPrivileges = parseBool(val)
+		case "schema":
+			cfg.tableSchema = val
+		case "if_not_exists":
+			cfg.ifNotExists = parseBool(val)
+		case "temporary":
+			cfg.temporary = parseBool(val)
+		case "if_exists":
+			cfg.ifExists = parseBool(val)
+		case "confirm":
+			cfg.confirm = val
+		case "steps":
+			cfg.steps = val
+		case "idempotency_key":
+			cfg.idempotencyKey = val
+		case "idempotency_ttl_seconds":
+			cfg.idempotencyTTL = int(parseIntInput(&errs, name, val))
+		case "create_idempotency_table":
+			cfg.createIdempotencyTable = parseBool(val)
+		case "trigger_table":
+			cfg.triggerTable = val
+		case "trigger_timing":
+			cfg.triggerTiming = strings.ToUpper(val)
+		case "trigger_event":
+			cfg.triggerEvent = strings.ToUpper(val)
+		case "trigger_body":
+			cfg.triggerBody = val
+		case "user_name":
+			cfg.userName = val
+		case "user_host":
+			cfg.userHost = val
+		case "auth_plugin":
+			cfg.authPlugin = strings.ToLower(val)
+		case "user_password":
+			cfg.userPassword = val
+		case "password_env":
+			cfg.passwordEnv = val
+		case "charset":
+			cfg.charset = strings.ToLower(val)
+		case "migration_file":
+			cfg.migrationFile = val
+		case "migrations_dir":
+			cfg.migrationsDir = val
+		case "status":
+			cfg.migrateStatus = parseBool(val)
+		case "baseline_version":
+			cfg.baselineVersion = parseIntInput(&errs, name, val)
+		case "operation":
+			cfg.operation = strings.ToLower(val)
+		case "max_batches":
+			cfg.maxBatches = int(parseIntInput(&errs, name, val))
+		case "sleep_between_batches_ms":
+			cfg.sleepBetweenBatches = int(parseIntInput(&errs, name, val))
+		case "max_runtime_seconds":
+			cfg.maxRuntime = int(parseIntInput(&errs, name, val))
+		case "partition_name":
+			cfg.partitionName = val
+		case "partition_value":
+			cfg.partitionValue = val
+		case "max_tables":
+			cfg.maxTables = int(parseIntInput(&errs, name, val))
+		case "rollback_to":
+			cfg.rollbackTo = parseIntInput(&errs, name, val)
+			cfg.rollbackRequested = val != ""
+		case "event_every":
+			cfg.eventEvery = val
+		case "event_at":
+			cfg.eventAt = val
+		case "event_starts":
+			cfg.eventStarts = val
+		case "event_ends":
+			cfg.eventEnds = val
+		case "event_body":
+			cfg.eventBody = val
+		case "event_enabled":
+			if val != "" {
+				cfg.eventStatus = "disable"
+				if parseBool(val) {
+					cfg.eventStatus = "enable"
+				}
+			}
+		case "outbox":
+			cfg.outbox = val
+		case "lock_name":
+			cfg.lockName = val
+		case "lock_timeout":
+			cfg.lockTimeout = int(parseIntInput(&errs, name, val))
+		case "diagnose_locks":
+			cfg.diagnoseLocks = parseBool(val)
+		case "consistent_snapshot":
+			cfg.consistentSnapshot = parseBool(val)
+		case "select_query":
+			cfg.selectQuery = val
+		case "or_replace":
+			cfg.orReplace = parseBool(val)
+		case "definer_security":
+			cfg.definerSecurity = parseBool(val)
+		case "key_column":
+			cfg.keyColumn = val
+		case "value_column":
+			cfg.valueColumn = val
+		case "key":
+			cfg.key = val
+		case "value":
+			cfg.value = val
+		case "scope_filter":
+			cfg.scopeFilter = val
+		case "strict":
+			cfg.strict = parseBool(val)
+		case "strict_warnings":
+			cfg.strictWarnings = parseBool(val)
+		case "parse_json":
+			cfg.parseJSON = parseBool(val)
+		case "filter":
+			cfg.filter = val
+		case "values":
+			cfg.values = val
+		case "snapshot_table":
+			cfg.snapshotTable = val
+		case "archive_table":
+			cfg.archiveTable = val
+		case "anonymize_spec":
+			cfg.anonymizeSpec = val
+		case "row_count":
+			cfg.rowCount = parseIntInput(&errs, name, val)
+		case "generators":
+			cfg.generators = val
+		case "seed":
+			cfg.seed = parseIntInput(&errs, name, val)
+			cfg.seedSet = val != ""
+		case "lint":
+			cfg.lint = parseBool(val)
+		case "lint_fail_on":
+			cfg.lintFailOn = val
+		case "lint_large_table_rows":
+			cfg.lintLargeTableRows = int(parseIntInput(&errs, name, val))
+		case "key_columns":
+			cfg.keyColumns = val
+		case "compare_query":
+			cfg.compareQuery = val
+		case "max_differences":
+			cfg.maxDifferences = int(parseIntInput(&errs, name, val))
+		case "diff_normalize":
+			cfg.diffNormalize = val
+		case "checks":
+			cfg.checks = val
+		case "series":
+			cfg.series = val
+		case "series_column":
+			cfg.seriesColumn = val
+		case "counter_column":
+			cfg.counterColumn = val
+		case "block_size":
+			cfg.blockSize = int(parseIntInput(&errs, name, val))
+		case "series_start":
+			cfg.seriesStart = parseIntInput(&errs, name, val)
+			cfg.seriesStartSet = val != ""
+		case "number_prefix":
+			cfg.numberPrefix = val
+		case "number_padding":
+			cfg.numberPadding = int(parseIntInput(&errs, name, val))
+		case "lock":
+			cfg.lock = val
+		case "claim_set":
+			cfg.claimSet = val
+		case "claimed_at_column":
+			cfg.claimedAtColumn = val
+		case "order_column":
+			cfg.orderColumn = val
+		case "requeue_stale_after_seconds":
+			cfg.requeueStaleAfter = parseIntInput(&errs, name, val)
+		case "id_column":
+			cfg.idColumn = val
+		case "parent_column":
+			cfg.parentColumn = val
+		case "root_filter":
+			cfg.rootFilter = val
+		case "root_ids":
+			cfg.rootIDs = val
+		case "max_depth":
+			cfg.maxDepth = int(parseIntInput(&errs, name, val))
+		case "direction":
+			cfg.direction = strings.ToLower(strings.TrimSpace(val))
+		case "nest_children":
+			cfg.nestChildren = parseBool(val)
+		case "decimal_mode":
+			cfg.decimalMode = strings.ToLower(strings.TrimSpace(val))
+		case "decimal_columns":
+			cfg.decimalColumns = val
+		case "big_int_mode":
+			cfg.bigIntMode = strings.ToLower(strings.TrimSpace(val))
+		case "zero_date_mode":
+			cfg.zeroDateMode = strings.ToLower(strings.TrimSpace(val))
+		case "on_scan_error":
+			cfg.onScanError = strings.ToLower(strings.TrimSpace(val))
+		case "found_rows":
+			cfg.foundRows = parseBool(val)
+		case "count_strategy":
+			cfg.countStrategy = strings.ToLower(strings.TrimSpace(val))
+		case "normalize_show":
+			cfg.normalizeShow = parseBool(val)
+		case "connection_charset":
+			if val = strings.ToLower(strings.ReplaceAll(val, " ", "")); val != "" {
+				cfg.connectionCharset = val
+			}
+		case "include_metadata":
+			cfg.includeMetadata = parseBool(val)
+		case "encoding_check":
+			cfg.encodingCheck = parseBool(val)
+		case "preserve_column_order":
+			cfg.preserveColumnOrder = parseBool(val)
+		case "client_sort":
+			cfg.clientSort = val
+		case "client_sort_ignore_case":
+			cfg.clientSortIgnoreCase = parseBool(val)
+		case "client_limit":
+			cfg.clientLimit = int(parseIntInput(&errs, name, val))
+		case "summarize_columns":
+			cfg.summarizeColumns = val
+		case "soft_delete_column":
+			cfg.softDeleteColumn = val
+		case "include_deleted":
+			cfg.includeDeleted = parseBool(val)
+		case "cascade_plan":
+			cfg.cascadePlan = parseBool(val)
+		case "execute":
+			cfg.execute = parseBool(val)
+		case "fix":
+			cfg.fix = strings.ToLower(val)
+		case "dry_run":
+			cfg.dryRun = parseBool(val)
+		case "sample_size":
+			cfg.sampleSize = int(parseIntInput(&errs, name, val))
+		case "check_timeout_seconds":
+			cfg.checkTimeout = int(parseIntInput(&errs, name, val))
+		case "columns":
+			cfg.columns = val
+		case "include_rows":
+			cfg.includeRows = parseBool(val)
+		case "max_rows":
+			cfg.maxRows = int(parseIntInput(&errs, name, val))
+		case "nulls_match":
+			cfg.nullsMatch = parseBool(val)
+		case "collation":
+			cfg.collation = val
+		case "row_keys":
+			cfg.rowKeys = val
+		case "pivot_column":
+			cfg.pivotColumn = val
+		case "aggregate":
+			cfg.aggregate = val
+		case "pivot_limit":
+			cfg.pivotLimit = int(parseIntInput(&errs, name, val))
+		case "id_columns":
+			cfg.idColumns = val
+		case "value_columns":
+			cfg.valueColumns = val
+		case "key_name":
+			cfg.keyName = val
+		case "value_name":
+			cfg.valueName = val
+		case "cast_to":
+			cfg.castTo = val
+		case "window":
+			cfg.window = val
+		case "dsn":
+			cfg.dsn = val
+		case "interpolate_params":
+			cfg.interpolateParams = parseBool(val)
+		case "output_compression":
+			cfg.outputCompression = strings.ToLower(val)
+		case "binary_stdout":
+			cfg.binaryStdout = parseBool(val)
+		case "snapshot":
+			cfg.snapshot = parseBool(val)
+		case "snapshot_id":
+			cfg.snapshotID = strings.ToLower(val)
+		case "snapshot_release":
+			cfg.snapshotRelease = parseBool(val)
+		case "snapshot_cleanup_seconds":
+			cfg.snapshotCleanup = int(parseIntInput(&errs, name, val))
+		case "limit":
+			cfg.limit = parseIntInput(&errs, name, val)
+		case "offset":
+			cfg.offset = parseIntInput(&errs, name, val)
+		case "group_by_columns":
+			cfg.groupByColumns = val
+		case "children_key":
+			cfg.childrenKey = val
+		case "max_children":
+			cfg.maxChildren = int(parseIntInput(&errs, name, val))
+		case "nest_by_prefix":
+			cfg.nestByPrefix = parseBool(val)
+		case "nest_separator":
+			cfg.nestSeparator = val
+		case "nest_prefixes":
+			cfg.nestPrefixes = val
+		case "null_collapse":
+			cfg.nullCollapse = parseBool(val)
+		case "column_case":
+			cfg.columnCase = strings.ToLower(val)
+		case "progress_interval_seconds":
+			cfg.progressInterval = int(parseIntInput(&errs, name, val))
+		case "progress_file":
+			cfg.progressFile = val
+		case "max_row_bytes":
+			cfg.maxRowBytes = parseIntInput(&errs, name, val)
+		case "target_host":
+			cfg.targetHost = val
+		case "target_port":
+			cfg.targetPort = int(parseIntInput(&errs, name, val))
+		case "target_username":
+			cfg.targetUsername = val
+		case "target_password":
+			cfg.targetPassword = val
+		case "target_dbname":
+			cfg.targetDBName = val
+		case "target_profile":
+			cfg.targetProfile = val
+		case "target_object_name":
+			cfg.targetObjectName = val
+		case "batch_size":
+			cfg.batchSize = int(parseIntInput(&errs, name, val))
+		case "on_conflict":
+			cfg.onConflict = strings.ToLower(val)
+		case "watermark_column":
+			cfg.watermarkColumn = val
+		case "capture_binlog_position":
+			cfg.captureBinlogPosition = parseBool(val)
+		case "since":
+			cfg.since = val
+		case "descending":
+			cfg.descending = parseBool(val)
+		case "resume_after":
+			cfg.resumeAfter = val
+		case "create_target":
+			cfg.createTarget = parseBool(val)
+		case "table_prefix":
+			cfg.tablePrefix = val
+		case "strip_prefix":
+			cfg.stripPrefix = parseBool(val)
+		case "tenant_column":
+			cfg.tenantColumn = val
+		case "tenant_value":
+			cfg.tenantValue = val
+		case "require_tenant_filter":
+			cfg.requireTenantFilter = parseBool(val)
+		case "mask_columns":
+			cfg.maskColumns = val
+		case "drop_columns":
+			cfg.dropColumns = val
+		case "truncate_columns":
+			cfg.truncateColumns = val
+		case "max_result_bytes":
+			cfg.maxResultBytes = parseIntInput(&errs, name, val)
+		case "query_name":
+			cfg.queryName = val
+		case "query_template":
+			cfg.queryTemplate = parseBool(val)
+		case "transform":
+			cfg.transform = val
+		case "stream_encode":
+			cfg.streamEncode = parseBool(val)
+		case "prepared":
+			if val != "" {
+				cfg.prepared = parseBool(val)
+			}
+		case "read_only":
+			cfg.readOnly = parseBool(val)
+		case "audit_table":
+			cfg.auditTable = val
+		case "audit_context":
+			cfg.auditContext = val
+		case "audit_best_effort":
+			cfg.auditBestEffort = parseBool(val)
+		case "request_id":
+			if val != "" && !validRequestID(val) {
+				errs.add("invalid_request_id", name, "request_id must be at most 128 printable characters")
+			} else {
+				cfg.requestID = val
+			}
+		case "include_statement":
+			cfg.includeStatement = parseBool(val)
+		case "include_parameter_values":
+			cfg.includeParameterValues = parseBool(val)
+		case "ignore_unknown_inputs":
+			ignoreUnknown = parseBool(val)
+		default:
+			if p.InputName != "" {
+				unknown = append(unknown, p.InputName)
+			}
+		}
+	}
+
+	if cfg.requestID == "" {
+		cfg.requestID = newRequestID()
+	}
+
+	if !ignoreUnknown {
+		known := knownInputs()
+		for _, name := range unknown {
+			msg := fmt.Sprintf("unknown input %q", name)
+			if s := suggestInput(name, known); s != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", s)
+			}
+			errs.add("unknown_input", name, "%s", msg)
+		}
+	}
+
+	if cfg.dsn != "" {
+		applyDSN(input, &cfg, &errs)
+	}
+
+	// Explicit inputs win over the profile, which wins over the environment.
+	if cfg.profile != "" {
+		if p, err := loadProfile(cfg.profile); err != nil {
+			errs.add("invalid_profile", "profile", "%v", err)
+		} else if err := p.apply(&cfg); err != nil {
+			errs.add("invalid_profile", "profile", "%v", err)
+		}
+	}
+	if !cfg.requireExplicitCredentials {
+		applyEnvDefaults(&cfg, &errs)
+	}
+
+	// Validate connection params
+	for _, req := range []struct{ name, env, val string }{
+		{"host", "MYSQL_HOST", cfg.host},
+		{"username", "MYSQL_USER", cfg.username},
+		{"dbname", "MYSQL_DATABASE", cfg.dbname},
+	} {
+		if req.name == "dbname" && serverDataTypes[cfg.dataType] {
+			continue
+		}
+		// Linting without table statistics never connects.
+		if cfg.dataType == "lint" && cfg.lintLargeTableRows == 0 {
+			continue
+		}
+		if req.val == "" && cfg.dsn == "" {
+			if cfg.requireExplicitCredentials {
+				errs.add("required", req.name, "%s is required", req.name)
+			} else {
+				errs.add("required", req.name, "%s is required (set the input or the %s environment variable)", req.name, req.env)
+			}
+		}
+	}
+	if cfg.port == 0 {
+		cfg.port = 3306
+	}
+	if cfg.host != "" {
+		if host, err := normalizeHost(cfg.host); err != nil {
+			errs.add("invalid_host", "host", "%v", err)
+		} else {
+			cfg.host = host
+		}
+	}
+	if cfg.sshHost != "" {
+		if cfg.sshPort == 0 {
+			cfg.sshPort = 22
+		}
+		if cfg.sshUser == "" {
+			errs.add("required", "ssh_user", "ssh_user is required when ssh_host is set")
+		}
+		if cfg.sshKey == "" && cfg.sshPassword == "" {
+			errs.add("required", "ssh_key", "ssh_key or ssh_password is required when ssh_host is set")
+		}
+	}
+	switch cfg.authMethod {
+	case "", "password", "aws_iam":
+	default:
+		errs.add("invalid_choice", "auth_method", "auth_method must be password or aws_iam, got %q", cfg.authMethod)
+	}
+	switch cfg.tlsMode {
+	case "", "false", "true", "skip-verify", "preferred":
+	default:
+		errs.add("invalid_choice", "tls", "tls must be one of false, true, skip-verify, preferred, got %q", cfg.tlsMode)
+	}
+	switch cfg.outputCompression {
+	case "", "none", "gzip":
+	default:
+		errs.add("invalid_choice", "output_compression", "output_compression must be none or gzip, got %q", cfg.outputCompression)
+	}
+	if cfg.outputCompression == "none" {
+		cfg.outputCompression = ""
+	}
+	if cfg.binaryStdout && cfg.outputCompression == "" {
+		errs.add("conflict", "binary_stdout", "binary_stdout requires output_compression")
+	}
+
+	applySavedQuery(&cfg, &errs)
+
+	// Validate the inputs needed by the chosen data_type
+	switch cfg.dataType {
+	case "table", "stored_procedure", "stored_function":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+		if cfg.dataType == "table" && cfg.parameters != "" {
+			errs.add("conflict", "parameters", "parameters cannot be combined with data_type=table")
+		}
+		if cfg.dataType == "table" {
+			if _, err := parseFilter(cfg.filter); err != nil {
+				errs.add("invalid_filter", "filter", "%v", err)
+			}
+		} else if cfg.filter != "" {
+			errs.add("conflict", "filter", "filter cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "create_table":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.tableSchema == "" {
+			errs.add("required", "schema", "schema is required for %s", cfg.dataType)
+		} else if s, err := parseTableSchema(cfg.tableSchema); err != nil {
+			errs.add("invalid_schema", "schema", "%v", err)
+		} else if _, err := createTableDDL(cfg.objectName, s, cfg.ifNotExists, cfg.temporary); err != nil && cfg.objectName != "" {
+			errs.add("invalid_schema", "schema", "%v", err)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "create_user", "grant", "revoke", "drop_user":
+		validateUser(&cfg, &errs)
+	case "maintain_table":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if _, ok := maintenanceStatements[cfg.operation]; !ok {
+			errs.add("invalid_choice", "operation", "operation must be one of analyze, optimize, check, got %q", cfg.operation)
+		}
+		if cfg.maxTables < 0 {
+			errs.add("invalid_number", "max_tables", "max_tables must be positive")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "partitions":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		switch cfg.operation {
+		case "list":
+		case "add_range":
+			if cfg.partitionName == "" || strings.Contains(cfg.partitionName, ",") {
+				errs.add("required", "partition_name", "add_range needs one partition_name")
+			}
+			if cfg.partitionValue == "" {
+				errs.add("required", "partition_value", "add_range needs partition_value, the VALUES LESS THAN bound")
+			} else if !validPartitionBound(cfg.partitionValue) {
+				errs.add("invalid_partition_value", "partition_value", "partition_value %q must be integers, MAXVALUE, string literals or a function of one literal", cfg.partitionValue)
+			}
+		case "drop", "truncate_partition":
+			if cfg.partitionName == "" {
+				errs.add("required", "partition_name", "partition_name is required for operation=%s", cfg.operation)
+			} else if cfg.confirm != cfg.partitionName {
+				errs.add("confirmation_required", "confirm", "confirm must repeat partition_name (%q) for operation=%s", cfg.partitionName, cfg.operation)
+			}
+		default:
+			errs.add("invalid_choice", "operation", "operation must be one of list, add_range, drop, truncate_partition, got %q", cfg.operation)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "migrate":
+		if cfg.migrationsDir == "" {
+			errs.add("required", "migrations_dir", "migrations_dir is required for %s", cfg.dataType)
+		}
+		switch {
+		case cfg.baselineVersion < 0:
+			errs.add("invalid_number", "baseline_version", "baseline_version must be positive")
+		case cfg.rollbackTo < 0:
+			errs.add("invalid_number", "rollback_to", "rollback_to must not be negative")
+		case cfg.baselineVersion > 0 && cfg.rollbackRequested:
+			errs.add("conflict", "rollback_to", "rollback_to cannot be combined with baseline_version")
+		case cfg.migrateStatus && (cfg.baselineVersion > 0 || cfg.rollbackRequested):
+			errs.add("conflict", "status", "status only reports and cannot be combined with baseline_version or rollback_to")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "create_database":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.charset != "" && !optionRe.MatchString(cfg.charset) {
+			errs.add("invalid_charset", "charset", "invalid charset %q", cfg.charset)
+		}
+		if cfg.collation != "" && !optionRe.MatchString(cfg.collation) {
+			errs.add("invalid_collation", "collation", "invalid collation %q", cfg.collation)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "truncate", "drop_table", "create_trigger", "drop_trigger", "drop_database":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		} else if cfg.confirm != cfg.objectName {
+			// The interlock guards against a flow wired to the wrong table.
+			errs.add("confirmation_required", "confirm", "confirm must repeat object_name (%q) for data_type=%s", cfg.objectName, cfg.dataType)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "steps":
+		if cfg.steps == "" {
+			errs.add("required", "steps", "steps is required for %s", cfg.dataType)
+		} else if steps, err := parseSteps(cfg.steps); err != nil {
+			errs.add("invalid_steps", "steps", "%v", err)
+		} else if cfg.consistentSnapshot {
+			for i, s := range steps {
+				if !(statement{SQL: s.Query, IsSelect: isReadQuery(s.Query)}).cacheable() {
+					errs.add("conflict", "steps", "step %d may modify data; consistent_snapshot only runs reads", i+1)
+				}
+			}
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "acquire_lock", "release_lock":
+		// GET_LOCK locks belong to the connection, and every invocation
+		// opens its own and closes it on exit: a standalone acquire would
+		// be released before the caller could rely on it. It needs a
+		// server mode keeping the connection between invocations, which
+		// this plugin does not have.
+		errs.add("unsupported_mode", "data_type", "data_type=%s needs a connection that outlives the invocation, which only a server mode provides; use lock_name with data_type=steps to hold the lock for the run", cfg.dataType)
+	case "list_triggers", "show_trigger":
+		if cfg.dataType == "show_trigger" && cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "list_events", "list_tables":
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "create_event":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		_, bodyErr := checkEventBody(cfg.eventBody)
+		if cfg.eventBody == "" {
+			errs.add("required", "event_body", "event_body is required for %s", cfg.dataType)
+		} else if bodyErr != nil {
+			errs.add("invalid_query", "event_body", "%v", bodyErr)
+		}
+		switch {
+		case (cfg.eventEvery == "") == (cfg.eventAt == ""):
+			errs.add("required", "event_every", "exactly one of event_every and event_at is required for %s", cfg.dataType)
+		case cfg.eventAt != "" && (cfg.eventStarts != "" || cfg.eventEnds != ""):
+			errs.add("conflict", "event_starts", "event_starts and event_ends bound a recurring event and cannot be combined with event_at")
+		case cfg.objectName != "" && bodyErr == nil:
+			if _, err := createEventDDL(cfg); err != nil {
+				errs.add("invalid_schedule", "event_every", "%v", err)
+			}
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "alter_event":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.eventStatus == "" {
+			errs.add("required", "event_enabled", "event_enabled is required for %s", cfg.dataType)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "create_view", "materialize_view":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.selectQuery == "" {
+			errs.add("required", "select_query", "select_query is required for %s", cfg.dataType)
+		} else if !isSelectQuery(cfg.selectQuery) {
+			errs.add("invalid_query", "select_query", "select_query must be a SELECT statement")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "kv_get", "kv_set":
+		for _, req := range []struct{ name, val string }{
+			{"object_name", cfg.objectName}, {"key_column", cfg.keyColumn}, {"value_column", cfg.valueColumn}, {"key", cfg.key},
+		} {
+			if req.val == "" {
+				errs.add("required", req.name, "%s is required for %s", req.name, cfg.dataType)
+			}
+		}
+		if _, err := parseFilter(cfg.scopeFilter); err != nil {
+			errs.add("invalid_filter", "scope_filter", "%v", err)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "insert":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.values == "" {
+			errs.add("required", "values", "values is required for insert")
+		} else if _, _, err := parseInsertRows(cfg.values); err != nil {
+			errs.add("invalid_values", "values", "%v", err)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "next_sequence_value":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "update", "delete", "purge", "archive":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		// An empty filter would touch every row; that has to be written as a
+		// query instead.
+		if cfg.filter == "" {
+			errs.add("required", "filter", "filter is required for %s", cfg.dataType)
+		} else if _, err := parseFilter(cfg.filter); err != nil {
+			errs.add("invalid_filter", "filter", "%v", err)
+		}
+		if cfg.dataType == "update" {
+			if cfg.values == "" {
+				errs.add("required", "values", "values is required for update")
+			} else if _, err := parseValues(cfg.values); err != nil {
+				errs.add("invalid_values", "values", "%v", err)
+			}
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "fk_graph":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.cascadePlan && cfg.filter == "" {
+			errs.add("required", "filter", "filter is required for cascade_plan")
+		} else if _, err := parseFilter(cfg.filter); err != nil {
+			errs.add("invalid_filter", "filter", "%v", err)
+		}
+		if cfg.execute && !cfg.cascadePlan {
+			errs.add("conflict", "execute", "execute requires cascade_plan=true")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "integrity_check":
+		if cfg.fix != "" && cfg.fix != "delete" && cfg.fix != "nullify" {
+			errs.add("invalid_choice", "fix", "fix must be one of delete, nullify, got %q", cfg.fix)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "binlog_position", "idempotency_cleanup":
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "changes":
+		for _, req := range []struct{ name, val string }{{"object_name", cfg.objectName}, {"watermark_column", cfg.watermarkColumn}} {
+			if req.val == "" {
+				errs.add("required", req.name, "%s is required for %s", req.name, cfg.dataType)
+			}
+		}
+		if _, err := parseFilter(cfg.filter); err != nil {
+			errs.add("invalid_filter", "filter", "%v", err)
+		}
+		if cfg.limit < 0 {
+			errs.add("invalid_number", "limit", "limit must not be negative")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "anonymize":
+		if cfg.anonymizeSpec == "" {
+			errs.add("required", "anonymize_spec", "anonymize_spec is required for anonymize")
+		} else if _, err := parseAnonymizeSpec(cfg.anonymizeSpec, cfg); err != nil {
+			errs.add("invalid_anonymize_spec", "anonymize_spec", "%v", err)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "generate":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.rowCount <= 0 || cfg.rowCount > maxGenerateRows {
+			errs.add("invalid_number", "row_count", "row_count must be between 1 and %d", maxGenerateRows)
+		}
+		if cfg.generators == "" {
+			errs.add("required", "generators", "generators is required for %s", cfg.dataType)
+		} else if _, err := parseGenerators(cfg.generators); err != nil {
+			errs.add("invalid_generators", "generators", "%v", err)
+		}
+		if cfg.batchSize < 0 {
+			errs.add("invalid_number", "batch_size", "batch_size must be positive")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "diff":
+		for _, q := range []struct{ name, val string }{{"query", cfg.query}, {"compare_query", cfg.compareQuery}} {
+			if q.val != "" && !isSelectQuery(q.val) {
+				errs.add("invalid_query", q.name, "%s must be a SELECT statement for diff", q.name)
+			}
+		}
+		if cfg.query == "" {
+			errs.add("required", "query", "query is required for diff")
+		}
+		hasTarget := cfg.targetHost != "" || cfg.targetPort != 0 || cfg.targetUsername != "" || cfg.targetDBName != "" || cfg.targetProfile != ""
+		switch {
+		case cfg.compareQuery != "" && hasTarget:
+			errs.add("conflict", "compare_query", "compare_query compares on the source connection and cannot be combined with target inputs")
+		case cfg.compareQuery == "" && !hasTarget:
+			errs.add("required", "compare_query", "diff needs compare_query, or target inputs to run query on another connection")
+		}
+		if cfg.keyColumns == "" {
+			errs.add("required", "key_columns", "key_columns is required for diff")
+		} else if _, err := parseIdentList(cfg.keyColumns); err != nil {
+			errs.add("invalid_columns", "key_columns", "key_columns %v", err)
+		}
+		if cfg.maxDifferences < 0 {
+			errs.add("invalid_number", "max_differences", "max_differences must not be negative")
+		}
+		if _, err := parseDiffNormalize(cfg.diffNormalize); err != nil {
+			errs.add("invalid_choice", "diff_normalize", "%v", err)
+		}
+	case "assert":
+		if cfg.checks == "" {
+			errs.add("required", "checks", "checks is required for %s", cfg.dataType)
+		} else if _, err := parseAssertions(cfg.checks); err != nil {
+			errs.add("invalid_checks", "checks", "%v", err)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s; each check has its own", cfg.dataType)
+		}
+	case "claim":
+		for _, req := range []struct{ name, val string }{{"object_name", cfg.objectName}, {"filter", cfg.filter}, {"claim_set", cfg.claimSet}} {
+			if req.val == "" {
+				errs.add("required", req.name, "%s is required for %s", req.name, cfg.dataType)
+			}
+		}
+		f, err := parseFilter(cfg.filter)
+		if err != nil {
+			errs.add("invalid_filter", "filter", "%v", err)
+		}
+		set, setErr := parseValues(cfg.claimSet)
+		if cfg.claimSet != "" && setErr != nil {
+			errs.add("invalid_values", "claim_set", "claim_set: %v", setErr)
+		}
+		for col := range set {
+			if _, err := quoteIdent(col); err != nil {
+				errs.add("invalid_columns", "claim_set", "claim_set: %v", err)
+			} else if cfg.tenantColumn != "" && strings.EqualFold(col, cfg.tenantColumn) {
+				errs.add("conflict", "claim_set", "claim_set may not set tenant column %s", cfg.tenantColumn)
+			}
+		}
+		for _, c := range []struct{ name, val string }{{"claimed_at_column", cfg.claimedAtColumn}, {"order_column", cfg.orderColumn}} {
+			if c.val == "" {
+				continue
+			}
+			if _, err := quoteIdent(c.val); err != nil {
+				errs.add("invalid_columns", c.name, "%s %v", c.name, err)
+			}
+		}
+		if cfg.limit < 0 {
+			errs.add("invalid_number", "limit", "limit must not be negative")
+		}
+		switch {
+		case cfg.requeueStaleAfter < 0:
+			errs.add("invalid_number", "requeue_stale_after_seconds", "requeue_stale_after_seconds must not be negative")
+		case cfg.requeueStaleAfter == 0:
+		case cfg.claimedAtColumn == "":
+			errs.add("required", "claimed_at_column", "requeue_stale_after_seconds needs claimed_at_column to tell when a row was claimed")
+		case err == nil && setErr == nil && len(claimStateColumns(f, set)) == 0:
+			errs.add("conflict", "requeue_stale_after_seconds", "requeue_stale_after_seconds needs a column that filter tests for equality and claim_set changes, such as filter {\"status\": \"new\"} with claim_set {\"status\": \"processing\"}, to tell claimed rows apart")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "tree":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		for _, c := range []struct{ name, val string }{{"id_column", cfg.idColumn}, {"parent_column", cfg.parentColumn}} {
+			if c.val == "" {
+				continue
+			}
+			if _, err := quoteIdent(c.val); err != nil {
+				errs.add("invalid_columns", c.name, "%s %v", c.name, err)
+			}
+		}
+		if _, err := parseFilter(cfg.rootFilter); err != nil {
+			errs.add("invalid_filter", "root_filter", "root_filter: %v", err)
+		}
+		if cfg.rootIDs != "" {
+			if _, err := parseRootIDs(cfg.rootIDs); err != nil {
+				errs.add("invalid_root_ids", "root_ids", "%v", err)
+			}
+		}
+		if cfg.maxDepth < 0 {
+			errs.add("invalid_number", "max_depth", "max_depth must not be negative")
+		}
+		switch cfg.direction {
+		case "", "descendants":
+		case "ancestors":
+			if cfg.rootFilter == "" && cfg.rootIDs == "" {
+				errs.add("required", "root_ids", "direction=ancestors needs root_ids or root_filter to start from")
+			}
+			if cfg.nestChildren {
+				errs.add("conflict", "nest_children", "nest_children nests descendants and cannot be combined with direction=ancestors")
+			}
+		default:
+			errs.add("invalid_choice", "direction", "direction must be descendants or ancestors, got %q", cfg.direction)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "next_number":
+		for _, req := range []struct{ name, val string }{{"object_name", cfg.objectName}, {"series", cfg.series}} {
+			if req.val == "" {
+				errs.add("required", req.name, "%s is required for %s", req.name, cfg.dataType)
+			}
+		}
+		for _, c := range []struct{ name, val string }{{"series_column", cfg.seriesColumn}, {"counter_column", cfg.counterColumn}} {
+			if c.val == "" {
+				continue
+			}
+			if _, err := quoteIdent(c.val); err != nil {
+				errs.add("invalid_columns", c.name, "%s %v", c.name, err)
+			}
+		}
+		if cfg.blockSize < 0 || cfg.blockSize > maxBlockSize {
+			errs.add("invalid_number", "block_size", "block_size must be between 1 and %d", maxBlockSize)
+		}
+		if cfg.seriesStart < 0 {
+			errs.add("invalid_number", "series_start", "series_start must not be negative")
+		}
+		if cfg.numberPadding < 0 || cfg.numberPadding > 20 {
+			errs.add("invalid_number", "number_padding", "number_padding must be between 0 and 20")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "copy_table":
+		for _, req := range []struct{ name, val string }{{"object_name", cfg.objectName}, {"target_object_name", cfg.targetObjectName}} {
+			if req.val == "" {
+				errs.add("required", req.name, "%s is required for %s", req.name, cfg.dataType)
+			}
+		}
+		if _, err := parseFilter(cfg.filter); err != nil {
+			errs.add("invalid_filter", "filter", "%v", err)
+		}
+		if cfg.columns != "" {
+			if _, err := parseIdentList(cfg.columns); err != nil {
+				errs.add("invalid_columns", "columns", "columns %v", err)
+			}
+		}
+		if cfg.batchSize < 0 {
+			errs.add("invalid_number", "batch_size", "batch_size must be positive")
+		}
+		if cfg.watermarkColumn != "" {
+			if _, err := quoteIdent(cfg.watermarkColumn); err != nil {
+				errs.add("invalid_identifier", "watermark_column", "invalid watermark_column: %v", err)
+			} else if m, _ := newColumnMasking(cfg); m.masked(cfg.watermarkColumn) || m.dropped(cfg.watermarkColumn) {
+				errs.add("conflict", "watermark_column", "watermark_column %s cannot be masked or dropped", cfg.watermarkColumn)
+			}
+		} else if cfg.resumeAfter != "" {
+			errs.add("required", "watermark_column", "resume_after requires watermark_column")
+		}
+		if cfg.onConflict == "" {
+			cfg.onConflict = "error"
+		} else if !copyConflicts[cfg.onConflict] {
+			errs.add("invalid_choice", "on_conflict", "on_conflict must be one of error, ignore, update, replace, got %q", cfg.onConflict)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "duplicates":
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s", cfg.dataType)
+		}
+		if cfg.columns == "" {
+			errs.add("required", "columns", "columns is required for %s", cfg.dataType)
+		} else if _, err := parseIdentList(cfg.columns); err != nil {
+			errs.add("invalid_columns", "columns", "columns %v", err)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "pivot":
+		if (cfg.objectName == "") == (cfg.selectQuery == "") {
+			errs.add("required", "object_name", "exactly one of object_name or select_query is required for %s", cfg.dataType)
+		}
+		for _, req := range []struct{ name, val string }{
+			{"row_keys", cfg.rowKeys}, {"pivot_column", cfg.pivotColumn}, {"value_column", cfg.valueColumn},
+		} {
+			if req.val == "" {
+				errs.add("required", req.name, "%s is required for %s", req.name, cfg.dataType)
+			}
+		}
+		if cfg.aggregate != "" && !pivotAggregates[strings.ToLower(cfg.aggregate)] {
+			errs.add("invalid_choice", "aggregate", "aggregate must be one of sum, count, avg, min, max, got %q", cfg.aggregate)
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "unpivot":
+		if (cfg.objectName == "") == (cfg.selectQuery == "") {
+			errs.add("required", "object_name", "exactly one of object_name or select_query is required for %s", cfg.dataType)
+		}
+		for _, list := range []struct{ name, val string }{{"id_columns", cfg.idColumns}, {"value_columns", cfg.valueColumns}} {
+			if list.val == "" {
+				errs.add("required", list.name, "%s is required for %s", list.name, cfg.dataType)
+			} else if _, err := parseIdentList(list.val); err != nil {
+				errs.add("invalid_columns", list.name, "%s %v", list.name, err)
+			}
+		}
+		if cfg.castTo != "" {
+			if _, err := castType(cfg.castTo); err != nil {
+				errs.add("invalid_choice", "cast_to", "%v", err)
+			}
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	case "result_schema":
+		if (cfg.objectName == "") == (cfg.query == "") {
+			errs.add("required", "query", "exactly one of query or object_name is required for %s", cfg.dataType)
+		} else if cfg.query != "" && !isSelectQuery(cfg.query) {
+			errs.add("invalid_query", "query", "query must be a SELECT statement for %s", cfg.dataType)
+		}
+	case "describe":
+		if (cfg.objectName == "") == (cfg.query == "") {
+			errs.add("required", "query", "exactly one of query or object_name is required for %s", cfg.dataType)
+		} else if words := strings.Fields(strings.ToUpper(cfg.query)); len(words) > 0 && (words[0] == "EXPLAIN" || words[0] == "DESCRIBE" || words[0] == "DESC") {
+			errs.add("invalid_query", "query", "query must be the statement to explain, without EXPLAIN, for %s", cfg.dataType)
+		}
+	case "check_privileges":
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+		}
+	default:
+		// Paging, releasing and cleaning up snapshots work without a query.
+		if cfg.query == "" && cfg.queryName == "" && (cfg.snapshot || !cfg.snapshotMode()) {
+			errs.add("required", "query", "query is required")
+		}
+	}
+
+	if cfg.queryTemplate {
+		validateTemplate(cfg, &errs)
+	} else if _, err := parseArgs(cfg.parameters); err != nil {
+		errs.add("invalid_parameters", "parameters", "invalid parameters: %v", err)
+	}
+	if cfg.window != "" {
+		if cfg.dataType != "table" {
+			errs.add("conflict", "window", "window requires data_type=table")
+		} else if _, err := windowProjection(cfg.window); err != nil {
+			errs.add("invalid_window", "window", "%v", err)
+		}
+	}
+	validateSnapshot(&cfg, &errs)
+	if cfg.progressInterval < 0 {
+		errs.add("invalid_number", "progress_interval_seconds", "progress_interval_seconds must not be negative")
+	} else if cfg.progressFile != "" && cfg.progressInterval == 0 {
+		errs.add("conflict", "progress_file", "progress_file requires progress_interval_seconds")
+	}
+	if cfg.maxRowBytes < 0 {
+		errs.add("invalid_number", "max_row_bytes", "max_row_bytes must be positive")
+	}
+	if cfg.maxResultBytes < 0 {
+		errs.add("invalid_number", "max_result_bytes", "max_result_bytes must be positive")
+	}
+	if _, err := parseTransform(cfg.transform); err != nil {
+		errs.add("invalid_transform", "transform", "%v", err)
+	}
+	validateTenant(cfg, &errs)
+	validateIdempotency(cfg, &errs)
+	if cfg.dataType == "create_trigger" {
+		if cfg.triggerTable == "" {
+			errs.add("required", "trigger_table", "trigger_table is required for %s", cfg.dataType)
+		}
+		if !triggerTimings[cfg.triggerTiming] {
+			errs.add("invalid_choice", "trigger_timing", "trigger_timing must be one of BEFORE, AFTER, got %q", cfg.triggerTiming)
+		}
+		if !triggerEvents[cfg.triggerEvent] {
+			errs.add("invalid_choice", "trigger_event", "trigger_event must be one of INSERT, UPDATE, DELETE, got %q", cfg.triggerEvent)
+		}
+		if cfg.triggerBody == "" {
+			errs.add("required", "trigger_body", "trigger_body is required for %s", cfg.dataType)
+		}
+	}
+	if cfg.outbox != "" {
+		if cfg.dataType != "insert" && cfg.dataType != "update" && cfg.dataType != "steps" {
+			errs.add("conflict", "outbox", "outbox requires data_type=insert, update or steps")
+		} else if cfg.consistentSnapshot {
+			errs.add("conflict", "outbox", "outbox records a write and cannot be combined with consistent_snapshot")
+		}
+		if _, err := parseOutbox(cfg.outbox); err != nil {
+			errs.add("invalid_outbox", "outbox", "%v", err)
+		}
+	}
+	if cfg.lockName != "" {
+		if cfg.dataType != "steps" {
+			errs.add("conflict", "lock_name", "lock_name requires data_type=steps")
+		}
+		if len(cfg.lockName) > maxLockName {
+			errs.add("invalid_lock_name", "lock_name", "lock_name must be at most %d characters", maxLockName)
+		}
+	}
+	if cfg.lockTimeout < 0 {
+		errs.add("invalid_number", "lock_timeout", "lock_timeout must not be negative")
+	} else if cfg.lockTimeout > 0 && cfg.lockName == "" {
+		errs.add("required", "lock_name", "lock_timeout requires lock_name")
+	}
+	if cfg.dataType == "purge" || cfg.dataType == "archive" {
+		if cfg.batchSize < 0 {
+			errs.add("invalid_number", "batch_size", "batch_size must be positive")
+		}
+		if cfg.maxBatches < 0 {
+			errs.add("invalid_number", "max_batches", "max_batches must not be negative")
+		}
+		if cfg.sleepBetweenBatches < 0 {
+			errs.add("invalid_number", "sleep_between_batches_ms", "sleep_between_batches_ms must not be negative")
+		}
+		if cfg.maxRuntime < 0 {
+			errs.add("invalid_number", "max_runtime_seconds", "max_runtime_seconds must not be negative")
+		}
+		if cfg.softDeleteColumn != "" && cfg.includeDeleted {
+			// Marked rows would match again and the loop would never end.
+			errs.add("conflict", "include_deleted", "include_deleted cannot be combined with a soft-deleting purge")
+		}
+	} else if cfg.maxBatches != 0 || cfg.sleepBetweenBatches != 0 || cfg.maxRuntime != 0 {
+		errs.add("conflict", "max_batches", "max_batches, sleep_between_batches_ms and max_runtime_seconds require data_type=purge or archive")
+	}
+	if cfg.dataType == "archive" {
+		if cfg.archiveTable == "" {
+			errs.add("required", "archive_table", "archive_table is required for archive")
+		} else if _, err := quoteQualifiedIdent(cfg.archiveTable); err != nil {
+			errs.add("invalid_identifier", "archive_table", "invalid archive_table: %v", err)
+		} else if strings.EqualFold(cfg.archiveTable, cfg.objectName) {
+			errs.add("conflict", "archive_table", "archive_table must differ from object_name")
+		}
+		if cfg.softDeleteColumn != "" {
+			errs.add("conflict", "soft_delete_column", "soft_delete_column cannot be combined with data_type=archive, which deletes the rows it moves")
+		}
+	} else if cfg.archiveTable != "" {
+		errs.add("conflict", "archive_table", "archive_table requires data_type=archive")
+	}
+	if cfg.anonymizeSpec != "" && cfg.dataType != "anonymize" {
+		errs.add("conflict", "anonymize_spec", "anonymize_spec requires data_type=anonymize")
+	}
+	if _, op := operations[cfg.dataType]; cfg.lint && op && cfg.dataType != "create_event" {
+		errs.add("conflict", "lint", "lint applies to statements; data_type=%s runs none of its own, use data_type=lint to check a query", cfg.dataType)
+	}
+	if cfg.lintFailOn != "" || cfg.lintLargeTableRows != 0 {
+		if !cfg.lint && cfg.dataType != "lint" {
+			errs.add("conflict", "lint_fail_on", "lint_fail_on and lint_large_table_rows require lint=true or data_type=lint")
+		}
+		if _, err := parseLintFailOn(cfg.lintFailOn); err != nil {
+			errs.add("invalid_choice", "lint_fail_on", "lint_fail_on: %v", err)
+		}
+		if cfg.lintLargeTableRows < 0 {
+			errs.add("invalid_number", "lint_large_table_rows", "lint_large_table_rows must not be negative")
+		}
+	}
+	if (cfg.keyColumns != "" || cfg.compareQuery != "" || cfg.maxDifferences != 0 || cfg.diffNormalize != "") && cfg.dataType != "diff" {
+		errs.add("conflict", "key_columns", "key_columns, compare_query, max_differences and diff_normalize require data_type=diff")
+	}
+	if lock, err := parseLock(cfg.lock); err != nil {
+		errs.add("invalid_choice", "lock", "%v", err)
+	} else if lock.strength != "" {
+		switch {
+		case cfg.dataType != "table" && cfg.dataType != "steps":
+			errs.add("conflict", "lock", "lock requires data_type=table or steps; a query can name its own locking clause")
+		case cfg.consistentSnapshot:
+			errs.add("conflict", "lock", "lock cannot be combined with consistent_snapshot, whose transaction is read only")
+		case cfg.cacheTTL > 0:
+			errs.add("conflict", "lock", "lock cannot be combined with cache_ttl_seconds: a cached result holds no locks")
+		case cfg.dataType == "steps":
+			if steps, err := parseSteps(cfg.steps); err == nil {
+				for i, s := range steps {
+					if isSelectQuery(s.Query) && hasLockingClause(s.Query) {
+						errs.add("conflict", "lock", "step %d already has a locking clause; remove it or the lock input", i+1)
+					}
+				}
+			}
+		}
+	}
+	if f, err := parseFilter(cfg.filter); err == nil && f.geo != nil && f.geo.DistanceColumn != "" && cfg.dataType != "table" {
+		errs.add("conflict", "filter", "geo distance_as adds a column to data_type=table and cannot be used with data_type=%s", cfg.dataType)
+	}
+	// strict fails kv_get on a missing key and everything else on warnings;
+	// strict_warnings asks for the warning check alone.
+	strictInput := "strict_warnings"
+	if cfg.strict && cfg.dataType != "kv_get" {
+		if !cfg.strictWarnings {
+			strictInput = "strict"
+		}
+		cfg.strictWarnings = true
+	}
+	if _, isOperation := operations[cfg.dataType]; cfg.strictWarnings && isOperation && cfg.dataType != "steps" && cfg.dataType != "archive" {
+		errs.add("conflict", strictInput, "%s checks the warnings of plain writes, steps and archive batches and cannot be used with data_type=%s", strictInput, cfg.dataType)
+	}
+	if cfg.clientSort != "" || cfg.clientLimit > 0 {
+		if cfg.dataType != "query" && cfg.dataType != "table" && cfg.dataType != "stored_procedure" {
+			errs.add("conflict", "client_sort", "client_sort and client_limit apply to the rows of data_type=query, table and stored_procedure, not data_type=%s", cfg.dataType)
+		}
+	}
+	if cfg.summarizeColumns != "" && cfg.dataType != "query" && cfg.dataType != "table" && cfg.dataType != "stored_procedure" {
+		errs.add("conflict", "summarize_columns", "summarize_columns applies to the rows of data_type=query, table and stored_procedure, not data_type=%s", cfg.dataType)
+	}
+	if _, isOperation := operations[cfg.dataType]; cfg.foundRows && isOperation && cfg.dataType != "steps" {
+		errs.add("conflict", "found_rows", "found_rows changes what rows_affected counts for plain statements and steps, and cannot be used with data_type=%s, which reads the counts itself", cfg.dataType)
+	}
+	if (cfg.idColumn != "" || cfg.parentColumn != "" || cfg.rootFilter != "" || cfg.rootIDs != "" || cfg.maxDepth != 0 || cfg.direction != "" || cfg.nestChildren) && cfg.dataType != "tree" {
+		errs.add("conflict", "id_column", "id_column, parent_column, root_filter, root_ids, max_depth, direction and nest_children require data_type=tree")
+	}
+	if (cfg.claimSet != "" || cfg.claimedAtColumn != "" || cfg.orderColumn != "" || cfg.requeueStaleAfter != 0) && cfg.dataType != "claim" {
+		errs.add("conflict", "claim_set", "claim_set, claimed_at_column, order_column and requeue_stale_after_seconds require data_type=claim")
+	}
+	if (cfg.series != "" || cfg.seriesColumn != "" || cfg.counterColumn != "" || cfg.blockSize != 0 || cfg.seriesStartSet || cfg.numberPrefix != "" || cfg.numberPadding != 0) && cfg.dataType != "next_number" {
+		errs.add("conflict", "series", "series, series_column, counter_column, block_size, series_start, number_prefix and number_padding require data_type=next_number")
+	}
+	if cfg.checks != "" && cfg.dataType != "assert" {
+		errs.add("conflict", "checks", "checks requires data_type=assert")
+	}
+	if (cfg.rowCount != 0 || cfg.generators != "" || cfg.seedSet) && cfg.dataType != "generate" {
+		errs.add("conflict", "generators", "row_count, generators and seed require data_type=generate")
+	}
+	if (cfg.migrateStatus || cfg.baselineVersion != 0 || cfg.rollbackRequested) && cfg.dataType != "migrate" {
+		errs.add("conflict", "status", "status, baseline_version and rollback_to require data_type=migrate")
+	}
+	if cfg.migrationFile != "" && cfg.dataType != "create_database" {
+		errs.add("conflict", "migration_file", "migration_file requires data_type=create_database")
+	}
+	if cfg.consistentSnapshot && cfg.dataType != "steps" {
+		errs.add("conflict", "consistent_snapshot", "consistent_snapshot requires data_type=steps")
+	}
+	if cfg.captureBinlogPosition && cfg.dataType != "copy_table" {
+		errs.add("conflict", "capture_binlog_position", "capture_binlog_position requires data_type=copy_table; use data_type=binlog_position for the current coordinates")
+	}
+	applyTablePrefix(&cfg, &errs)
+	if _, err := newColumnMasking(cfg); err != nil {
+		errs.add("invalid_masking", "mask_columns", "%v", err)
+	}
+	if _, err := parseTruncateColumns(cfg.truncateColumns); err != nil {
+		errs.add("invalid_truncate_columns", "truncate_columns", "%v", err)
+	}
+	if _, err := parseDecimalModes(cfg.decimalMode, ""); err != nil {
+		errs.add("invalid_choice", "decimal_mode", "%v", err)
+	} else if _, err := parseDecimalModes(cfg.decimalMode, cfg.decimalColumns); err != nil {
+		errs.add("invalid_decimal_columns", "decimal_columns", "%v", err)
+	}
+	if cfg.bigIntMode != "" && !bigIntModes[cfg.bigIntMode] {
+		errs.add("invalid_choice", "big_int_mode", "big_int_mode must be number, safe or string, got %q", cfg.bigIntMode)
+	}
+	if cfg.zeroDateMode != "" && cfg.zeroDateMode != "null" && cfg.zeroDateMode != "string" {
+		errs.add("invalid_choice", "zero_date_mode", "zero_date_mode must be null or string, got %q", cfg.zeroDateMode)
+	}
+	switch cfg.onScanError {
+	case "", "abort", "skip", "null":
+	default:
+		errs.add("invalid_choice", "on_scan_error", "on_scan_error must be abort, skip or null, got %q", cfg.onScanError)
+	}
+	if _, err := parseClientSort(cfg.clientSort); err != nil {
+		errs.add("invalid_client_sort", "client_sort", "%v", err)
+	}
+	if _, err := parseSummarizeColumns(cfg.summarizeColumns); err != nil {
+		errs.add("invalid_summarize_columns", "summarize_columns", "%v", err)
+	}
+	if cfg.clientLimit < 0 {
+		errs.add("invalid_number", "client_limit", "client_limit must not be negative")
+	}
+	for _, charset := range strings.Split(cfg.connectionCharset, ",") {
+		if !optionRe.MatchString(charset) {
+			errs.add("invalid_charset", "connection_charset", "invalid connection_charset %q", cfg.connectionCharset)
+			break
+		}
+	}
+	if _, err := newNesting(cfg); err != nil {
+		errs.add("invalid_nesting", "nest_prefixes", "%v", err)
+	} else if !cfg.nestByPrefix && (cfg.nestSeparator != "" || cfg.nestPrefixes != "" || cfg.nullCollapse) {
+		errs.add("conflict", "nest_by_prefix", "nest_separator, nest_prefixes and null_collapse require nest_by_prefix=true")
+	}
+	if cfg.groupByColumns != "" {
+		if _, err := parseIdentList(cfg.groupByColumns); err != nil {
+			errs.add("invalid_columns", "group_by_columns", "group_by_columns %v", err)
+		}
+		if cfg.snapshotMode() {
+			errs.add("conflict", "group_by_columns", "group_by_columns cannot be combined with snapshots, whose pages would split groups")
+		}
+	} else if cfg.childrenKey != "" || cfg.maxChildren != 0 {
+		errs.add("conflict", "group_by_columns", "children_key and max_children require group_by_columns")
+	}
+	if cfg.columnCase != "" && !columnCases[cfg.columnCase] {
+		errs.add("invalid_choice", "column_case", "column_case must be one of as_is, camel, pascal, snake, got %q", cfg.columnCase)
+	}
+	if cfg.snapshotTable != "" && cfg.dataType != "update" && cfg.dataType != "delete" {
+		errs.add("conflict", "snapshot_table", "snapshot_table requires data_type=update or delete")
+	}
+	if ctx, err := parseAuditContext(cfg.auditContext); err != nil {
+		errs.add("invalid_json", "audit_context", "%v", err)
+	} else {
+		cfg.auditContext = ctx
+	}
+
+	return cfg, errs
+}
+
+// prefixedDataTypes are the data_types whose object_name gets table_prefix.
+var prefixedDataTypes = map[string]bool{"table": true, "insert": true, "update": true, "delete": true, "describe": true, "purge": true, "archive": true, "generate": true, "next_number": true, "claim": true, "tree": true}
+
+// applyTablePrefix prepends table_prefix to object_name. A query_template
+// uses it through {{prefix}} instead, and list_tables as a filter.
+func applyTablePrefix(cfg *settings, errs *validationErrors) {
+	if cfg.stripPrefix && (cfg.dataType != "list_tables" || cfg.tablePrefix == "") {
+		errs.add("conflict", "strip_prefix", "strip_prefix requires data_type=list_tables with table_prefix")
+	}
+	if cfg.tablePrefix == "" {
+		return
+	}
+	if !validPrefix(cfg.tablePrefix) {
+		errs.add("invalid_identifier", "table_prefix", "table_prefix may only contain letters, digits, _ and $, got %q", cfg.tablePrefix)
+		return
+	}
+	switch {
+	case prefixedDataTypes[cfg.dataType]:
+		if cfg.objectName == "" {
+			return
+		}
+		name, err := prefixObjectName(cfg.objectName, cfg.tablePrefix)
+		if err != nil {
+			errs.add("invalid_identifier", "object_name", "object_name with table_prefix: %v", err)
+			return
+		}
+		cfg.objectName = name
+	case cfg.queryTemplate, cfg.dataType == "list_tables":
+	default:
+		errs.add("conflict", "table_prefix", "table_prefix requires a data_type naming a table, such as table, insert, update, delete or describe, list_tables, or a query_template using {{prefix}}")
+	}
+}
+
+func validateTemplate(cfg settings, errs *validationErrors) {
+	if cfg.dataType != "query" || cfg.snapshotMode() {
+		errs.add("conflict", "query_template", "query_template requires data_type=query without snapshots")
+		return
+	}
+	if _, err := parseTemplate(cfg.query); err != nil {
+		errs.add("invalid_template", "query", "%v", err)
+	}
+	if _, err := parseTemplateParams(cfg.parameters); err != nil {
+		errs.add("invalid_parameters", "parameters", "%v", err)
+	}
+}
+
+func validateSnapshot(cfg *settings, errs *validationErrors) {
+	if cfg.snapshotID == "" && cfg.dataType != "table" && (cfg.offset != 0 || cfg.limit != 0 && cfg.dataType != "changes" && cfg.dataType != "claim") {
+		errs.add("required", "snapshot_id", "limit and offset page through a snapshot or data_type=table and otherwise require snapshot_id")
+	}
+	if cfg.countStrategy != "" {
+		if !countStrategies[cfg.countStrategy] {
+			errs.add("invalid_choice", "count_strategy", "count_strategy must be exact, calc_found_rows or estimate, got %q", cfg.countStrategy)
+		} else if cfg.snapshotID == "" {
+			errs.add("required", "snapshot_id", "count_strategy counts the rows of a snapshot page and requires snapshot_id")
+		}
+	}
+	if cfg.snapshotID == "" && cfg.snapshotRelease {
+		errs.add("required", "snapshot_id", "snapshot_id is required for snapshot_release")
+	}
+	if !cfg.snapshotMode() {
+		return
+	}
+	if cfg.dataType != "query" {
+		errs.add("conflict", "snapshot", "snapshots require data_type=query")
+	}
+	if cfg.snapshot && cfg.snapshotID != "" {
+		errs.add("conflict", "snapshot_id", "snapshot_id cannot be combined with snapshot=true")
+	}
+	if cfg.snapshotID != "" {
+		if _, ok := snapshotCreated(cfg.snapshotID); !ok {
+			errs.add("invalid_snapshot_id", "snapshot_id", "snapshot_id must be the 32 hex digit id returned by snapshot=true")
+		}
+		if cfg.query != "" {
+			errs.add("conflict", "query", "query cannot be combined with snapshot_id")
+		}
+	}
+	if cfg.limit < 0 || cfg.offset < 0 || cfg.snapshotCleanup < 0 {
+		errs.add("invalid_number", "limit", "limit, offset and snapshot_cleanup_seconds must not be negative")
+	}
+}
+
+func validRequestID(id string) bool {
+	if len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// applyEnvDefaults fills connection settings that were not given as inputs
+// from MYSQL_* environment variables, so containers can bake them in.
+func applyEnvDefaults(cfg *settings, errs *validationErrors) {
+	strDefaults := []struct {
+		field *string
+		env   string
+	}{
+		{&cfg.host, "MYSQL_HOST"},
+		{&cfg.username, "MYSQL_USER"},
+		{&cfg.password, "MYSQL_PASSWORD"},
+		{&cfg.dbname, "MYSQL_DATABASE"},
+		{&cfg.tlsMode, "MYSQL_TLS"},
+		{&cfg.tlsCA, "MYSQL_TLS_CA"},
+		{&cfg.tlsCert, "MYSQL_TLS_CERT"},
+		{&cfg.tlsKey, "MYSQL_TLS_KEY"},
+		{&cfg.tlsServerName, "MYSQL_TLS_SERVER_NAME"},
+	}
+	for _, d := range strDefaults {
+		if *d.field == "" {
+			*d.field = strings.TrimSpace(os.Getenv(d.env))
+		}
+	}
+	cfg.tlsMode = strings.ToLower(cfg.tlsMode)
+
+	intDefaults := []struct {
+		field *int
+		input string
+		env   string
+	}{
+		{&cfg.port, "port", "MYSQL_PORT"},
+		{&cfg.connectTimeout, "connect_timeout_seconds", "MYSQL_CONNECT_TIMEOUT"},
+		{&cfg.queryTimeout, "query_timeout_seconds", "MYSQL_QUERY_TIMEOUT"},
+	}
+	for _, d := range intDefaults {
+		val := strings.TrimSpace(os.Getenv(d.env))
+		if *d.field != 0 || val == "" {
+			continue
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			errs.add("invalid_number", d.input, "%s environment variable must be a whole number, got %q", d.env, val)
+			continue
+		}
+		*d.field = n
+	}
+}
+
+// parseDurationInput accepts Go durations such as 30s or 2m; a bare number
+// is taken as seconds.
+func parseDurationInput(errs *validationErrors, name, val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(val); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		errs.add("invalid_duration", name, "%s must be a duration such as 30s, got %q", name, val)
+	}
+	return d
+}
+
+// parseIntInput parses an optional integer input, recording an error when the
+// value is not a number.
+func parseIntInput(errs *validationErrors, name, val string) int64 {
+	if val == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		errs.add("invalid_number", name, "%s must be a whole number, got %q", name, val)
+	}
+	return n
+}
+
+func validateIdempotency(cfg settings, errs *validationErrors) {
+	if cfg.idempotencyKey == "" {
+		if cfg.idempotencyTTL != 0 || cfg.createIdempotencyTable {
+			errs.add("required", "idempotency_key", "idempotency_ttl_seconds and create_idempotency_table require idempotency_key")
+		}
+		return
+	}
+	switch {
+	case cfg.dataType != "insert" && cfg.dataType != "update" && cfg.dataType != "steps" && cfg.dataType != "next_number":
+		errs.add("conflict", "idempotency_key", "idempotency_key requires data_type=insert, update, steps or next_number")
+	case cfg.consistentSnapshot:
+		errs.add("conflict", "idempotency_key", "idempotency_key protects writes and cannot be combined with consistent_snapshot")
+	}
+	if len(cfg.idempotencyKey) > maxIdempotencyKey {
+		errs.add("invalid_idempotency_key", "idempotency_key", "idempotency_key must be at most %d characters", maxIdempotencyKey)
+	}
+	if cfg.idempotencyTTL < 0 {
+		errs.add("invalid_number", "idempotency_ttl_seconds", "idempotency_ttl_seconds must not be negative")
+	}
+}
+
+// validateUser checks the account inputs of create_user, grant, revoke and
+// drop_user, defaulting user_host to % and resolving password_env.
+func validateUser(cfg *settings, errs *validationErrors) {
+	if cfg.userName == "" {
+		errs.add("required", "user_name", "user_name is required for %s", cfg.dataType)
+	} else if len(cfg.userName) > 32 {
+		errs.add("invalid_user_name", "user_name", "user_name must be at most 32 characters")
+	}
+	if cfg.userHost == "" {
+		cfg.userHost = "%"
+	} else if len(cfg.userHost) > 255 {
+		errs.add("invalid_user_host", "user_host", "user_host must be at most 255 characters")
+	}
+	if cfg.dataType == "create_user" {
+		if cfg.authPlugin != "" && !optionRe.MatchString(cfg.authPlugin) {
+			errs.add("invalid_auth_plugin", "auth_plugin", "auth_plugin must be a plugin name such as caching_sha2_password, got %q", cfg.authPlugin)
+		}
+		if cfg.passwordEnv != "" {
+			if cfg.userPassword != "" {
+				errs.add("conflict", "password_env", "password_env cannot be combined with user_password")
+			} else if cfg.userPassword = os.Getenv(cfg.passwordEnv); cfg.userPassword == "" {
+				errs.add("required", "password_env", "environment variable %s named by password_env is empty or unset", cfg.passwordEnv)
+			}
+		}
+	} else if cfg.authPlugin != "" || cfg.userPassword != "" || cfg.passwordEnv != "" {
+		errs.add("conflict", "user_password", "auth_plugin, user_password and password_env require data_type=create_user")
+	}
+	switch cfg.dataType {
+	case "grant", "revoke":
+		if cfg.privilege == "" {
+			errs.add("required", "privilege", "privilege is required for %s", cfg.dataType)
+		} else if _, err := privilegeList(cfg.privilege); err != nil {
+			errs.add("invalid_privilege", "privilege", "%v", err)
+		}
+		if cfg.objectName == "" {
+			errs.add("required", "object_name", "object_name is required for %s: *.*, schema.* or schema.table", cfg.dataType)
+		} else if _, err := grantTarget(cfg.objectName); err != nil {
+			errs.add("invalid_object_name", "object_name", "%v", err)
+		}
+	case "drop_user":
+		if cfg.userName != "" && cfg.confirm != cfg.userName {
+			errs.add("confirmation_required", "confirm", "confirm must repeat user_name (%q) for data_type=drop_user", cfg.userName)
+		}
+	}
+	if cfg.query != "" {
+		errs.add("conflict", "query", "query cannot be combined with data_type=%s", cfg.dataType)
+	}
+}
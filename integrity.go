@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// foreignKey is one (possibly composite) foreign key constraint.
+type foreignKey struct {
+	Constraint        string   `json:"constraint"`
+	Table             string   `json:"table"`
+	Columns           []string `json:"columns"`
+	ReferencedTable   string   `json:"referenced_table"`
+	ReferencedColumns []string `json:"referenced_columns"`
+}
+
+// schemaForeignKeys lists the foreign keys declared in schema, optionally
+// only those of one table.
+func schemaForeignKeys(ctx context.Context, q execer, schema, table string) ([]foreignKey, error) {
+	query := `SELECT constraint_name, table_name, column_name, referenced_table_schema, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = ? AND referenced_table_name IS NOT NULL`
+	args := []interface{}{schema}
+	if table != "" {
+		query += " AND table_name = ?"
+		args = append(args, table)
+	}
+	rows, err := q.QueryContext(ctx, query+" ORDER BY table_name, constraint_name, ordinal_position", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fks []foreignKey
+	for rows.Next() {
+		var constraint, tbl, col, refSchema, refTable, refCol string
+		if err := rows.Scan(&constraint, &tbl, &col, &refSchema, &refTable, &refCol); err != nil {
+			return nil, err
+		}
+		table, refName := schema+"."+tbl, refSchema+"."+refTable
+		if n := len(fks); n == 0 || fks[n-1].Constraint != constraint || fks[n-1].Table != table {
+			fks = append(fks, foreignKey{Constraint: constraint, Table: table, ReferencedTable: refName})
+		}
+		fk := &fks[len(fks)-1]
+		fk.Columns = append(fk.Columns, col)
+		fk.ReferencedColumns = append(fk.ReferencedColumns, refCol)
+	}
+	return fks, rows.Err()
+}
+
+// orphanJoin is the anti-join shared by the count, sample and fix
+// statements: child rows with a complete key and no matching parent. Rows
+// with any NULL key column are not constrained by MySQL and are skipped.
+type orphanJoin struct {
+	child, parent ident
+	columns, refs []ident
+}
+
+func (fk foreignKey) orphanJoin() (orphanJoin, error) {
+	child, err := tableIdent(fk.Table)
+	if err != nil {
+		return orphanJoin{}, err
+	}
+	parent, err := tableIdent(fk.ReferencedTable)
+	if err != nil {
+		return orphanJoin{}, err
+	}
+	columns, _ := columnIdents(fk.Columns)
+	refs, _ := columnIdents(fk.ReferencedColumns)
+	return orphanJoin{child, parent, columns, refs}, nil
+}
+
+// orphanTables writes the joined tables, the child aliased c and the
+// parent p.
+func (b *stmtBuilder) orphanTables(j orphanJoin) *stmtBuilder {
+	b.ident(j.child).text(" c LEFT JOIN ").ident(j.parent).text(" p ON ")
+	for i := range j.columns {
+		if i > 0 {
+			b.text(" AND ")
+		}
+		b.text("c.").ident(j.columns[i]).text(" = p.").ident(j.refs[i])
+	}
+	return b
+}
+
+// orphanWhere writes the WHERE clause that keeps the orphans.
+func (b *stmtBuilder) orphanWhere(j orphanJoin) *stmtBuilder {
+	b.text(" WHERE ")
+	for _, col := range j.columns {
+		b.text("c.").ident(col).text(" IS NOT NULL AND ")
+	}
+	return b.text("p.").ident(j.refs[0]).text(" IS NULL")
+}
+
+func (fk foreignKey) fixSQL(fix string) (string, error) {
+	join, err := fk.orphanJoin()
+	if err != nil {
+		return "", err
+	}
+	if fix == "delete" {
+		query, _ := new(stmtBuilder).text("DELETE c FROM ").orphanTables(join).orphanWhere(join).build()
+		return query, nil
+	}
+	// UPDATE ... JOIN puts SET before WHERE.
+	b := new(stmtBuilder).text("UPDATE ").orphanTables(join).text(" SET ")
+	for i, col := range join.columns {
+		if i > 0 {
+			b.text(", ")
+		}
+		b.text("c.").ident(col).text(" = NULL")
+	}
+	query, _ := b.orphanWhere(join).build()
+	return query, nil
+}
+
+// integrityCheck is the report for one constraint.
+type integrityCheck struct {
+	foreignKey
+	Orphans int64                    `json:"orphans"`
+	Samples []map[string]interface{} `json:"samples,omitempty"`
+	Error   string                   `json:"error,omitempty"`
+	FixSQL  string                   `json:"fix_sql,omitempty"`
+	Fixed   *int64                   `json:"fixed,omitempty"`
+}
+
+// checkOrphans counts and samples the orphans of fk, bounded by the per-check
+// timeout so one huge table cannot stall the report.
+func checkOrphans(ctx context.Context, q execer, cfg settings, fk foreignKey, samples int, timeout time.Duration) integrityCheck {
+	check := integrityCheck{foreignKey: fk}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	join, err := fk.orphanJoin()
+	if err == nil {
+		query, _ := new(stmtBuilder).text("SELECT COUNT(*) FROM ").orphanTables(join).orphanWhere(join).build()
+		err = q.QueryRowContext(ctx, query).Scan(&check.Orphans)
+	}
+	if err == nil && check.Orphans > 0 && samples > 0 {
+		b := new(stmtBuilder).text("SELECT DISTINCT ")
+		for i, col := range join.columns {
+			if i > 0 {
+				b.text(", ")
+			}
+			b.text("c.").ident(col)
+		}
+		query, _ := b.text(" FROM ").orphanTables(join).orphanWhere(join).text(" LIMIT ").number(int64(samples)).build()
+		rows, qerr := q.QueryContext(ctx, query)
+		if err = qerr; err == nil {
+			check.Samples, err = scanRows(rows, cfg)
+			rows.Close()
+		}
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			check.Error = fmt.Sprintf("check timed out after %s", timeout)
+		} else {
+			check.Error = err.Error()
+		}
+	}
+	return check
+}
+
+// integrityCheckOp implements data_type=integrity_check over object_name or,
+// without it, every foreign key of the database. fix=delete|nullify repairs
+// the orphans in one transaction, but only with dry_run=false.
+func integrityCheckOp(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	schema, table := cfg.dbname, ""
+	if cfg.objectName != "" {
+		schema, table = splitTarget(cfg.objectName, cfg.dbname)
+	}
+	fks, err := schemaForeignKeys(ctx, db, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %v", err)
+	}
+
+	samples := cfg.sampleSize
+	if samples == 0 {
+		samples = 10
+	}
+	checks := make([]integrityCheck, len(fks))
+	for i, fk := range fks {
+		checks[i] = checkOrphans(ctx, db, cfg, fk, samples, time.Duration(cfg.checkTimeout)*time.Second)
+		if cfg.fix != "" && checks[i].Orphans > 0 {
+			if checks[i].FixSQL, err = fk.fixSQL(cfg.fix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	result := map[string]interface{}{"schema": schema, "checks": checks, "dry_run": cfg.dryRun}
+	if cfg.fix == "" || cfg.dryRun {
+		return result, nil
+	}
+	if cfg.readOnly {
+		return nil, &readOnlyError{"fix=" + cfg.fix}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for i := range checks {
+		if checks[i].FixSQL == "" {
+			continue
+		}
+		res, err := tx.ExecContext(ctx, checks[i].FixSQL)
+		if err != nil {
+			return nil, fmt.Errorf("fix of %s failed: %v", checks[i].Constraint, err)
+		}
+		n, _ := res.RowsAffected()
+		checks[i].Fixed = &n
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %v", err)
+	}
+	return result, nil
+}
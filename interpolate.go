@@ -0,0 +1,32 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checkInterpolatable rejects parameters the driver cannot interpolate
+// client-side. JSON decoding only yields strings, numbers, booleans, nulls,
+// arrays and objects; the last two have no SQL literal form. Byte slices
+// never come from JSON, and the driver itself refuses interpolateParams on
+// connections using a multibyte charset where escaping would be unsafe.
+func checkInterpolatable(args []interface{}) error {
+	for i, a := range args {
+		switch a.(type) {
+		case []interface{}, map[string]interface{}:
+			return fmt.Errorf("parameter %d is a JSON %s, which cannot be interpolated client-side; pass it as a JSON string instead", i+1, jsonTypeName(a))
+		}
+	}
+	return nil
+}
+
+// interpolationHint explains driver errors that only occur with
+// interpolate_params=true.
+func interpolationHint(err error) error {
+	if errors.Is(err, driver.ErrSkip) || strings.Contains(err.Error(), "driver does not support") {
+		return fmt.Errorf("%v (interpolate_params=true: the driver could not interpolate this statement client-side; check the placeholder count or disable interpolate_params)", err)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// kvTarget resolves the quoted table and key/value columns of a kv mode.
+func kvTarget(cfg settings) (table, keyCol, valueCol ident, err error) {
+	if table, err = tableIdent(cfg.objectName); err != nil {
+		return
+	}
+	if keyCol, err = columnIdent(cfg.keyColumn); err != nil {
+		err = fmt.Errorf("invalid key_column: %v", err)
+		return
+	}
+	if valueCol, err = columnIdent(cfg.valueColumn); err != nil {
+		err = fmt.Errorf("invalid value_column: %v", err)
+	}
+	return
+}
+
+// kvGet implements data_type=kv_get. A missing key yields null unless strict
+// is set.
+func kvGet(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, keyCol, valueCol, err := kvTarget(cfg)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := parseFilter(cfg.scopeFilter)
+	if err != nil {
+		return nil, err
+	}
+	where := scope.and(keyCol.sql+" = ?", cfg.key)
+
+	// Two rows are fetched so an under-scoped key is reported, not guessed.
+	query, args := new(stmtBuilder).text("SELECT ").ident(valueCol).text(" FROM ").ident(table).where(where).text(" LIMIT 2").build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	defer rows.Close()
+
+	var values []sql.NullString
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(values) == 0:
+		if cfg.strict {
+			return nil, fmt.Errorf("key %q not found in %s", cfg.key, table)
+		}
+		return nil, nil
+	case len(values) > 1:
+		return nil, fmt.Errorf("key %q matches more than one row in %s; narrow it with scope_filter", cfg.key, table)
+	case !values[0].Valid:
+		return nil, nil
+	}
+
+	if cfg.parseJSON {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(values[0].String), &parsed); err == nil {
+			return parsed, nil
+		}
+		out.Warnings = append(out.Warnings, fmt.Sprintf("value of %q is not valid JSON; returned as a string", cfg.key))
+	}
+	return values[0].String, nil
+}
+
+// kvSet implements data_type=kv_set as INSERT ... ON DUPLICATE KEY UPDATE,
+// so the table needs a unique key over the scope columns and key_column.
+// The scope filter may only contain equality conditions, whose columns are
+// written alongside the key.
+func kvSet(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, keyCol, valueCol, err := kvTarget(cfg)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := parseFilter(cfg.scopeFilter)
+	if err != nil {
+		return nil, err
+	}
+	if len(scope.equal) != len(scope.Args) {
+		return nil, fmt.Errorf("scope_filter for kv_set may only contain equality conditions")
+	}
+
+	cols := []ident{keyCol, valueCol}
+	values := []interface{}{cfg.key, cfg.value}
+	for _, c := range scope.equal {
+		col, _ := qualifiedColumnIdent(c.Column)
+		cols = append(cols, col)
+		values = append(values, c.Value)
+	}
+	query, args := new(stmtBuilder).text("INSERT INTO ").ident(table).text(" (").idents(cols).text(") VALUES (").values(values).
+		text(") ON DUPLICATE KEY UPDATE ").ident(valueCol).text(" = VALUES(").ident(valueCol).text(")").build()
+
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %w", err)
+	}
+	// MySQL reports 1 for an insert, 2 for an update and 0 when unchanged.
+	affected, _ := res.RowsAffected()
+	return map[string]interface{}{
+		"key":      cfg.key,
+		"inserted": affected == 1,
+		"updated":  affected == 2,
+	}, nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// lintRules are the rule ids the linter reports and lint_fail_on accepts.
+var lintRules = []string{"select_star", "missing_where", "implicit_cross_join", "leading_wildcard_like", "non_sargable"}
+
+// nonSargableFunctions are functions that, wrapped around a column in a
+// condition, keep MySQL from using an index on it.
+var nonSargableFunctions = map[string]bool{
+	"DATE": true, "YEAR": true, "MONTH": true, "DAY": true, "HOUR": true, "DATE_FORMAT": true, "TO_DAYS": true, "UNIX_TIMESTAMP": true,
+	"LOWER": true, "UPPER": true, "LCASE": true, "UCASE": true, "TRIM": true, "LTRIM": true, "RTRIM": true,
+	"SUBSTRING": true, "SUBSTR": true, "LEFT": true, "RIGHT": true, "CONCAT": true, "REPLACE": true,
+	"IFNULL": true, "COALESCE": true, "CAST": true, "CONVERT": true, "ABS": true, "ROUND": true, "FLOOR": true, "CEIL": true,
+}
+
+// lintWarning is one finding. Position is the byte offset of the offending
+// token in the statement; Line and Column count from 1.
+type lintWarning struct {
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+	Position int    `json:"position"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+
+	tables []string // the statement's tables, for the size and index lookups
+	column string   // non_sargable: the wrapped column
+}
+
+// lintError is returned when a rule named in lint_fail_on fires.
+type lintError struct{ failed []lintWarning }
+
+func (e *lintError) Error() string {
+	parts := make([]string, len(e.failed))
+	for i, w := range e.failed {
+		parts[i] = fmt.Sprintf("%s at %d:%d: %s", w.Rule, w.Line, w.Column, w.Message)
+	}
+	return "lint failed: " + strings.Join(parts, "; ")
+}
+
+// lintScope tracks one parenthesis depth of a statement: whether a FROM
+// clause, a WHERE, or a comma in the FROM clause was seen at that depth.
+type lintScope struct {
+	inFrom bool
+	where  bool
+	comma  *token
+	inCond bool // in a WHERE or ON condition
+}
+
+// lintSQL checks a statement without a connection. It works on tokens, not
+// a parse tree, so it errs towards silence on constructs it cannot follow.
+// Statements separated by ; are checked one by one.
+func lintSQL(sqlText string) []lintWarning {
+	var sig []token
+	for _, tok := range tokenize(sqlText) {
+		if tok.kind != tokSpace && tok.kind != tokComment {
+			sig = append(sig, tok)
+		}
+	}
+	warnings := []lintWarning{}
+	start := 0
+	for i := 0; i <= len(sig); i++ {
+		if i == len(sig) || sig[i].kind == tokPunct && sig[i].text == ";" {
+			if i > start {
+				warnings = append(warnings, lintStatementTokens(sig[start:i])...)
+			}
+			start = i + 1
+		}
+	}
+	for i := range warnings {
+		warnings[i].Line, warnings[i].Column = lineColumn(sqlText, warnings[i].Position)
+	}
+	return warnings
+}
+
+func lintStatementTokens(toks []token) []lintWarning {
+	var warnings []lintWarning
+	word := func(i int) string {
+		if i < 0 || i >= len(toks) || toks[i].kind != tokWord {
+			return ""
+		}
+		return strings.ToUpper(toks[i].text)
+	}
+	punct := func(i int) string {
+		if i < 0 || i >= len(toks) || toks[i].kind != tokPunct {
+			return ""
+		}
+		return toks[i].text
+	}
+	tables := lintTables(toks)
+	add := func(rule string, tok token, format string, args ...interface{}) {
+		warnings = append(warnings, lintWarning{Rule: rule, Message: fmt.Sprintf(format, args...), Position: tok.pos, tables: tables})
+	}
+
+	switch word(0) {
+	case "UPDATE", "DELETE":
+		hasWhere := false
+		depth := 0
+		for i := range toks {
+			switch {
+			case punct(i) == "(":
+				depth++
+			case punct(i) == ")":
+				depth--
+			case depth == 0 && word(i) == "WHERE":
+				hasWhere = true
+			}
+		}
+		if !hasWhere {
+			add("missing_where", toks[0], "%s without WHERE changes every row", word(0))
+		}
+	}
+
+	scopes := []*lintScope{{}}
+	closeScope := func(s *lintScope) {
+		if s.comma != nil && !s.where {
+			add("implicit_cross_join", *s.comma, "comma join without a WHERE condition is a cross join")
+		}
+	}
+	for i, tok := range toks {
+		s := scopes[len(scopes)-1]
+		switch w := word(i); {
+		case punct(i) == "(":
+			scopes = append(scopes, &lintScope{})
+			continue
+		case punct(i) == ")":
+			if len(scopes) > 1 {
+				closeScope(s)
+				scopes = scopes[:len(scopes)-1]
+			}
+			continue
+		case w == "FROM":
+			s.inFrom = true
+		case w == "WHERE":
+			s.inFrom, s.where, s.inCond = false, true, true
+		case w == "ON":
+			s.inFrom, s.inCond = false, true
+		case w == "JOIN":
+			s.inFrom, s.inCond = false, false
+		case w == "GROUP" || w == "ORDER" || w == "LIMIT" || w == "HAVING" || w == "WINDOW" || w == "SET":
+			s.inFrom, s.inCond = false, false
+		case w == "UNION" || w == "EXCEPT" || w == "INTERSECT":
+			closeScope(s)
+			*s = lintScope{}
+		case punct(i) == "," && s.inFrom && s.comma == nil:
+			t := tok
+			s.comma = &t
+		}
+
+		switch {
+		case punct(i) == "*":
+			prev, next := i-1, i+1
+			afterSelect := word(prev) == "SELECT" || word(prev) == "DISTINCT" || word(prev) == "ALL" || punct(prev) == "." ||
+				punct(prev) == "," && selectListed(toks, i)
+			endsItem := word(next) == "FROM" || punct(next) == "," || punct(next) == ")" || next == len(toks)
+			// EXISTS (SELECT * ...) reads no columns.
+			inExists := false
+			for j := prev; j >= 0 && j > prev-3; j-- {
+				if word(j) == "SELECT" {
+					inExists = punct(j-1) == "(" && word(j-2) == "EXISTS"
+					break
+				}
+			}
+			if afterSelect && endsItem && !inExists {
+				add("select_star", tok, "SELECT * returns every column; list the columns the caller needs")
+			}
+		case word(i) == "LIKE" && i+1 < len(toks) && toks[i+1].kind == tokString && strings.HasPrefix(toks[i+1].text[1:], "%"):
+			add("leading_wildcard_like", toks[i+1], "LIKE pattern starting with %% cannot use an index and scans the table")
+		case s.inCond && toks[i].kind == tokWord && nonSargableFunctions[word(i)] && punct(i+1) == "(":
+			if col, end := wrappedColumn(toks, i+1); col != "" && isComparison(toks, end+1) {
+				w := lintWarning{Rule: "non_sargable", Message: fmt.Sprintf("%s() around column %s keeps an index on it from being used", word(i), col), Position: tok.pos, tables: tables, column: col}
+				warnings = append(warnings, w)
+			}
+		}
+	}
+	for i := len(scopes) - 1; i >= 0; i-- {
+		closeScope(scopes[i])
+	}
+	return warnings
+}
+
+// selectListed reports whether the * at i, preceded by a comma, is in a
+// select list rather than, say, a function's argument list.
+func selectListed(toks []token, i int) bool {
+	depth := 0
+	for j := i - 1; j >= 0; j-- {
+		if toks[j].kind == tokPunct {
+			switch toks[j].text {
+			case ")":
+				depth++
+			case "(":
+				if depth == 0 {
+					return j+1 < len(toks) && strings.EqualFold(toks[j+1].text, "SELECT")
+				}
+				depth--
+			}
+		}
+		if depth == 0 && toks[j].kind == tokWord && strings.EqualFold(toks[j].text, "SELECT") {
+			return true
+		}
+	}
+	return false
+}
+
+// wrappedColumn returns the column when the parenthesis at open holds a
+// column as its first argument, along with the index of the closing
+// parenthesis.
+func wrappedColumn(toks []token, open int) (string, int) {
+	depth, end := 0, -1
+	for j := open; j < len(toks); j++ {
+		if toks[j].kind != tokPunct {
+			continue
+		}
+		if toks[j].text == "(" {
+			depth++
+		} else if toks[j].text == ")" {
+			if depth--; depth == 0 {
+				end = j
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return "", -1
+	}
+	j := open + 1
+	var name []string
+	for j < end {
+		t := toks[j]
+		if t.kind != tokWord && t.kind != tokQuotedIdent {
+			return "", end
+		}
+		name = append(name, strings.Trim(t.text, "`"))
+		j++
+		if j < end && toks[j].kind == tokPunct && toks[j].text == "." {
+			j++
+			continue
+		}
+		break
+	}
+	if len(name) == 0 || j < end && !(toks[j].kind == tokPunct && toks[j].text == ",") && !strings.EqualFold(toks[j].text, "AS") {
+		return "", end
+	}
+	switch strings.ToUpper(name[len(name)-1]) {
+	case "NULL", "TRUE", "FALSE", "CURRENT_DATE", "CURRENT_TIMESTAMP", "NOW":
+		return "", end
+	}
+	return name[len(name)-1], end
+}
+
+// isComparison reports whether the token at i starts a comparison.
+func isComparison(toks []token, i int) bool {
+	if i >= len(toks) {
+		return false
+	}
+	t := toks[i]
+	if t.kind == tokPunct {
+		return strings.ContainsAny(t.text, "=<>!")
+	}
+	switch strings.ToUpper(t.text) {
+	case "LIKE", "IN", "BETWEEN", "NOT", "IS", "REGEXP", "RLIKE":
+		return t.kind == tokWord
+	}
+	return false
+}
+
+// lintTables lists the tables a statement names after FROM, JOIN, UPDATE
+// and INTO and in comma joins, as written.
+func lintTables(toks []token) []string {
+	var tables []string
+	inFrom := false
+	for i := 0; i < len(toks); i++ {
+		w := ""
+		if toks[i].kind == tokWord {
+			w = strings.ToUpper(toks[i].text)
+		}
+		takeNext := false
+		switch {
+		case w == "FROM":
+			inFrom, takeNext = true, true
+		case w == "JOIN" || w == "UPDATE" || w == "INTO":
+			takeNext = true
+		case w == "WHERE" || w == "GROUP" || w == "ORDER" || w == "LIMIT" || w == "HAVING" || w == "ON" || w == "SET":
+			inFrom = false
+		case inFrom && toks[i].kind == tokPunct && toks[i].text == ",":
+			takeNext = true
+		}
+		if !takeNext || i+1 >= len(toks) {
+			continue
+		}
+		j := i + 1
+		if toks[j].kind != tokWord && toks[j].kind != tokQuotedIdent {
+			continue
+		}
+		name := strings.Trim(toks[j].text, "`")
+		if j+2 < len(toks) && toks[j+1].kind == tokPunct && toks[j+1].text == "." && (toks[j+2].kind == tokWord || toks[j+2].kind == tokQuotedIdent) {
+			name += "." + strings.Trim(toks[j+2].text, "`")
+		}
+		tables = appendUnique(tables, name)
+	}
+	return tables
+}
+
+func lineColumn(s string, pos int) (int, int) {
+	line := strings.Count(s[:pos], "\n") + 1
+	return line, pos - strings.LastIndex(s[:pos], "\n")
+}
+
+// refineLint drops the warnings that table statistics show to be harmless:
+// a leading wildcard on tables all under lint_large_table_rows, and a
+// function around a column that no index of the statement's tables covers.
+func refineLint(ctx context.Context, q execer, cfg settings, warnings []lintWarning) ([]lintWarning, error) {
+	kept := warnings[:0]
+	for _, w := range warnings {
+		keep := true
+		switch w.Rule {
+		case "leading_wildcard_like":
+			large := len(w.tables) == 0
+			for _, table := range w.tables {
+				schema, name := splitTarget(table, "")
+				var rows *int64
+				err := q.QueryRowContext(ctx, "SELECT MAX(table_rows) FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?", schema, name).Scan(&rows)
+				if err != nil {
+					return nil, fmt.Errorf("lint: failed to read the size of %s: %v", table, err)
+				}
+				// An unknown table, a view or a CTE is given the benefit of
+				// the doubt.
+				if rows == nil || *rows >= int64(cfg.lintLargeTableRows) {
+					large = true
+				}
+			}
+			keep = large
+		case "non_sargable":
+			indexed := len(w.tables) == 0
+			for _, table := range w.tables {
+				schema, name := splitTarget(table, "")
+				var n int
+				err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ? AND column_name = ?", schema, name, w.column).Scan(&n)
+				if err != nil {
+					return nil, fmt.Errorf("lint: failed to read the indexes of %s: %v", table, err)
+				}
+				if n > 0 {
+					indexed = true
+				}
+			}
+			keep = indexed
+		}
+		if keep {
+			kept = append(kept, w)
+		}
+	}
+	return kept, nil
+}
+
+// lintStatement lints sqlText, refining the findings with table statistics
+// when lint_large_table_rows is set and q is a connection. It returns a
+// lintError when a rule of lint_fail_on fired.
+func lintStatement(ctx context.Context, q execer, cfg settings, sqlText string) ([]lintWarning, error) {
+	warnings := lintSQL(sqlText)
+	if q != nil && cfg.lintLargeTableRows > 0 {
+		var err error
+		if warnings, err = refineLint(ctx, q, cfg, warnings); err != nil {
+			return nil, err
+		}
+	}
+	failOn, _ := parseLintFailOn(cfg.lintFailOn)
+	var failed []lintWarning
+	for _, w := range warnings {
+		if failOn[w.Rule] {
+			failed = append(failed, w)
+		}
+	}
+	if len(failed) > 0 {
+		return warnings, &lintError{failed}
+	}
+	return warnings, nil
+}
+
+// parseLintFailOn parses lint_fail_on: rule ids, comma separated, or all.
+func parseLintFailOn(raw string) (map[string]bool, error) {
+	rules := map[string]bool{}
+	for _, r := range strings.Split(raw, ",") {
+		r = strings.ToLower(strings.TrimSpace(r))
+		switch {
+		case r == "":
+		case r == "all":
+			for _, rule := range lintRules {
+				rules[rule] = true
+			}
+		case containsString(lintRules, r):
+			rules[r] = true
+		default:
+			return nil, fmt.Errorf("unknown lint rule %q; use %s or all", r, strings.Join(lintRules, ", "))
+		}
+	}
+	return rules, nil
+}
+
+// lintOperation implements data_type=lint: the query is checked, never
+// run. Without lint_large_table_rows it is called without a connection.
+func lintOperation(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	var q execer
+	if db != nil {
+		q = db
+	}
+	warnings, err := lintStatement(ctx, q, cfg, cfg.query)
+	if err != nil {
+		out.Lint = warnings
+		return nil, err
+	}
+	return map[string]interface{}{"warnings": warnings}, nil
+}
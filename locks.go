@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	errLockWaitTimeout = 1205 // ER_LOCK_WAIT_TIMEOUT
+	errLockDeadlock    = 1213 // ER_LOCK_DEADLOCK
+
+	// lockDiagnosisTimeout bounds the diagnostic queries, which run after
+	// the invocation's own deadline may already have passed.
+	lockDiagnosisTimeout = 5 * time.Second
+
+	// maxDeadlockReport bounds the LATEST DETECTED DEADLOCK text kept.
+	maxDeadlockReport = 8 << 10
+)
+
+// lockDiagnosis is attached to a failed statement with diagnose_locks when
+// the server reported a lock wait timeout or a deadlock. Diagnostics that
+// could not run are listed in Problems; they never replace the error.
+type lockDiagnosis struct {
+	Error int        `json:"error"`
+	Waits []lockWait `json:"waits,omitempty"`
+
+	// Holders are the open transactions holding row locks. The failed
+	// statement no longer waits, so its blocker usually shows up here
+	// rather than in Waits.
+	Holders []lockHolder `json:"holders,omitempty"`
+
+	Deadlock *deadlockReport `json:"deadlock,omitempty"`
+	Problems []string        `json:"problems,omitempty"`
+}
+
+type lockWait struct {
+	WaitingThreadID  int64  `json:"waiting_thread_id"`
+	WaitingQuery     string `json:"waiting_query,omitempty"`
+	BlockingThreadID int64  `json:"blocking_thread_id"`
+	BlockingQuery    string `json:"blocking_query,omitempty"`
+	WaitSeconds      int64  `json:"wait_seconds"`
+}
+
+type lockHolder struct {
+	ThreadID    int64  `json:"thread_id"`
+	Query       string `json:"query,omitempty"` // empty while the transaction is idle
+	Started     string `json:"started"`
+	RowsLocked  int64  `json:"rows_locked"`
+	LockStructs int64  `json:"lock_structs"`
+}
+
+// deadlockReport summarizes the LATEST DETECTED DEADLOCK section of SHOW
+// ENGINE INNODB STATUS; Text is the section itself, truncated.
+type deadlockReport struct {
+	Participants []deadlockParticipant `json:"participants"`
+	RolledBack   int                   `json:"rolled_back,omitempty"` // participant number InnoDB chose as victim
+	Text         string                `json:"text"`
+	Truncated    bool                  `json:"truncated,omitempty"`
+}
+
+type deadlockParticipant struct {
+	Transaction int    `json:"transaction"`
+	ThreadID    int64  `json:"thread_id"`
+	Query       string `json:"query,omitempty"`
+}
+
+// lockErrorNumber returns 1205 or 1213 when err is a lock wait timeout or a
+// deadlock, and 0 otherwise.
+func lockErrorNumber(err error) int {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && (myErr.Number == errLockWaitTimeout || myErr.Number == errLockDeadlock) {
+		return int(myErr.Number)
+	}
+	return 0
+}
+
+// diagnoseLocks attaches a lockDiagnosis to out when diagnose_locks is set
+// and err is a lock error. It runs on its own bounded context, so a timed
+// out invocation can still be diagnosed.
+func diagnoseLocks(db *database, cfg settings, err error, out *Output) {
+	number := lockErrorNumber(err)
+	if !cfg.diagnoseLocks || number == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lockDiagnosisTimeout)
+	defer cancel()
+
+	d := &lockDiagnosis{Error: number}
+	problem := func(what string, err error) {
+		d.Problems = append(d.Problems, fmt.Sprintf("%s: %s", what, redact(err.Error(), cfg)))
+	}
+	if d.Waits, err = lockWaits(ctx, db); err != nil {
+		problem("lock waits", err)
+	}
+	if d.Holders, err = lockHolders(ctx, db); err != nil {
+		problem("lock holders", err)
+	}
+	// The latest deadlock is only this failure's for 1213; after a lock wait
+	// timeout it would describe some earlier, unrelated one.
+	if number == errLockDeadlock {
+		if d.Deadlock, err = latestDeadlock(ctx, db); err != nil {
+			problem("SHOW ENGINE INNODB STATUS", err)
+		}
+	}
+	out.LockDiagnosis = d
+}
+
+// lockWaits reads the current lock waits from sys.innodb_lock_waits,
+// falling back to performance_schema.data_lock_waits where the sys schema is
+// missing.
+func lockWaits(ctx context.Context, q execer) ([]lockWait, error) {
+	rows, err := q.QueryContext(ctx, "SELECT waiting_pid, waiting_query, blocking_pid, blocking_query, wait_age_secs FROM sys.innodb_lock_waits ORDER BY wait_age_secs DESC")
+	if err != nil {
+		rows, err = q.QueryContext(ctx, `SELECT r.trx_mysql_thread_id, r.trx_query, b.trx_mysql_thread_id, b.trx_query, TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW())
+FROM performance_schema.data_lock_waits w
+JOIN information_schema.innodb_trx r ON r.trx_id = w.REQUESTING_ENGINE_TRANSACTION_ID
+JOIN information_schema.innodb_trx b ON b.trx_id = w.BLOCKING_ENGINE_TRANSACTION_ID
+ORDER BY r.trx_wait_started`)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var waits []lockWait
+	for rows.Next() {
+		var w lockWait
+		var waiting, blocking sql.NullString
+		var age sql.NullInt64
+		if err := rows.Scan(&w.WaitingThreadID, &waiting, &w.BlockingThreadID, &blocking, &age); err != nil {
+			return nil, err
+		}
+		w.WaitingQuery, w.BlockingQuery, w.WaitSeconds = waiting.String, blocking.String, age.Int64
+		waits = append(waits, w)
+	}
+	return waits, rows.Err()
+}
+
+// lockHolders lists the transactions holding row locks, oldest first.
+func lockHolders(ctx context.Context, q execer) ([]lockHolder, error) {
+	rows, err := q.QueryContext(ctx, "SELECT trx_mysql_thread_id, trx_query, CAST(trx_started AS CHAR), trx_rows_locked, trx_lock_structs FROM information_schema.innodb_trx WHERE trx_rows_locked > 0 OR trx_lock_structs > 0 ORDER BY trx_started")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var holders []lockHolder
+	for rows.Next() {
+		var h lockHolder
+		var query sql.NullString
+		if err := rows.Scan(&h.ThreadID, &query, &h.Started, &h.RowsLocked, &h.LockStructs); err != nil {
+			return nil, err
+		}
+		h.Query = query.String
+		holders = append(holders, h)
+	}
+	return holders, rows.Err()
+}
+
+// latestDeadlock reads the LATEST DETECTED DEADLOCK section of SHOW ENGINE
+// INNODB STATUS, or nil when the server has seen no deadlock.
+func latestDeadlock(ctx context.Context, q execer) (*deadlockReport, error) {
+	var typ, name, status string
+	if err := q.QueryRowContext(ctx, "SHOW ENGINE INNODB STATUS").Scan(&typ, &name, &status); err != nil {
+		return nil, err
+	}
+	section := deadlockSection(status)
+	if section == "" {
+		return nil, nil
+	}
+	report := parseDeadlock(section)
+	if len(section) > maxDeadlockReport {
+		section, report.Truncated = section[:maxDeadlockReport], true
+	}
+	report.Text = section
+	return report, nil
+}
+
+// deadlockSection cuts the body of the LATEST DETECTED DEADLOCK section out of
+// the status text. Sections are headed by their name between two dashed
+// lines.
+func deadlockSection(status string) string {
+	const header = "LATEST DETECTED DEADLOCK"
+	start := strings.Index(status, header)
+	if start < 0 {
+		return ""
+	}
+	body := strings.TrimLeft(status[start+len(header):], "-\n")
+	// The next section starts with a dashed line followed by its name.
+	if end := nextSection.FindStringIndex(body); end != nil {
+		body = body[:end[0]]
+	}
+	return strings.TrimSpace(body)
+}
+
+var (
+	nextSection         = regexp.MustCompile(`\n-{4,}\n[A-Z][A-Z /]+\n-{4,}\n`)
+	deadlockTransaction = regexp.MustCompile(`^\*\*\* \((\d+)\) TRANSACTION:`)
+	deadlockThread      = regexp.MustCompile(`MySQL thread id (\d+)`)
+	deadlockVictim      = regexp.MustCompile(`\*\*\* WE ROLL BACK TRANSACTION \((\d+)\)`)
+)
+
+// parseDeadlock picks the participants out of a deadlock section: each
+// "*** (n) TRANSACTION:" block names the thread and, after that line, the
+// statement it was running, up to the next "***" line.
+func parseDeadlock(section string) *deadlockReport {
+	report := &deadlockReport{Participants: []deadlockParticipant{}}
+	var current *deadlockParticipant
+	inQuery := false
+	var query []string
+	finish := func() {
+		if current != nil {
+			current.Query = strings.TrimSpace(strings.Join(query, "\n"))
+			report.Participants = append(report.Participants, *current)
+		}
+		current, inQuery, query = nil, false, nil
+	}
+	for _, line := range strings.Split(section, "\n") {
+		if m := deadlockTransaction.FindStringSubmatch(line); m != nil {
+			finish()
+			n, _ := strconv.Atoi(m[1])
+			current = &deadlockParticipant{Transaction: n}
+			continue
+		}
+		if m := deadlockVictim.FindStringSubmatch(line); m != nil {
+			report.RolledBack, _ = strconv.Atoi(m[1])
+		}
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(line, "***") {
+			inQuery = false
+			continue
+		}
+		if m := deadlockThread.FindStringSubmatch(line); m != nil && current.ThreadID == 0 {
+			current.ThreadID, _ = strconv.ParseInt(m[1], 10, 64)
+			inQuery = true
+			continue
+		}
+		if inQuery {
+			query = append(query, line)
+		}
+	}
+	finish()
+	return report
+}
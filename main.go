@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
-
-	_ "github.com/go-sql-driver/mysql"
+	"time"
 )
 
 type Input struct {
@@ -18,208 +20,885 @@ type Input struct {
 }
 
 type Output struct {
-	Result interface{} `json:"result"`
-	Error  string      `json:"error"`
+	Result    interface{} `json:"result"`
+	Error     string      `json:"error"`
+	RequestID string      `json:"request_id"`
+
+	// ErrorClass categorizes failures that callers may want to handle
+	// differently, e.g. ssh_tunnel.
+	ErrorClass string `json:"error_class,omitempty"`
+
+	Cache string `json:"cache,omitempty"`
+
+	Fingerprint *fingerprint `json:"fingerprint,omitempty"`
+
+	// Lint holds the findings of lint=true, or of data_type=lint when a
+	// rule of lint_fail_on failed it.
+	Lint []lintWarning `json:"lint,omitempty"`
+
+	Slow       bool            `json:"slow,omitempty"`
+	DurationMS int64           `json:"duration_ms,omitempty"`
+	Plan       json.RawMessage `json:"plan,omitempty"`
+
+	Span *span `json:"span,omitempty"`
+
+	CompressionNegotiated *bool `json:"compression_negotiated,omitempty"`
+
+	ServerVersion *serverVersion `json:"server_version,omitempty"`
+
+	Timing *timing `json:"timing,omitempty"`
+
+	Statement *statementSummary `json:"statement,omitempty"`
+
+	// RenderedSQL is the SQL a query_template produced, values elided.
+	RenderedSQL string `json:"rendered_sql,omitempty"`
+
+	// SoftDelete is set when soft_delete_column changed the statement.
+	SoftDelete *softDelete `json:"soft_delete,omitempty"`
+
+	// TenantScope is set when tenant_column scoped the statement.
+	TenantScope *tenantScope `json:"tenant_scope,omitempty"`
+
+	// IdempotentReplay is set when idempotency_key had already committed:
+	// the write was skipped and Result is the one stored then.
+	IdempotentReplay bool `json:"idempotent_replay,omitempty"`
+
+	// OutboxID is the id of the event row written with outbox.
+	OutboxID int64 `json:"outbox_id,omitempty"`
+
+	// LockDiagnosis is set with diagnose_locks when the statement failed on
+	// a lock wait timeout or deadlock.
+	LockDiagnosis *lockDiagnosis `json:"lock_diagnosis,omitempty"`
+
+	// ConsistentSnapshot is set when steps ran with consistent_snapshot.
+	ConsistentSnapshot *consistentSnapshot `json:"consistent_snapshot,omitempty"`
+
+	// Geo describes the geo condition of filter and how accurate it is.
+	Geo *geoFilter `json:"geo,omitempty"`
+
+	// StrictWarnings lists the warnings that failed a write under
+	// strict_warnings.
+	StrictWarnings []strictWarning `json:"strict_warnings,omitempty"`
+
+	// ScanErrors reports the rows on_scan_error skipped or patched.
+	ScanErrors *scanErrorReport `json:"scan_errors,omitempty"`
+
+	// Charsets are the session character sets, with include_metadata.
+	Charsets *sessionCharsets `json:"charsets,omitempty"`
+
+	// ClientSort reports the sort and limit client_sort and client_limit
+	// applied to the fetched rows.
+	ClientSort *clientSortReport `json:"client_sort,omitempty"`
+
+	// Summary holds the summarize_columns aggregates of the result rows.
+	Summary *resultSummary `json:"summary,omitempty"`
+
+	// UnstablePagination is set when the query reads a page of rows it
+	// does not order.
+	UnstablePagination bool `json:"unstable_pagination,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Errors lists every validation problem; Error carries the same
+	// messages joined into one string.
+	Errors validationErrors `json:"errors,omitempty"`
+
+	stream *rowStream // set instead of Result with stream_encode
+
+	compression  string // output_compression
+	binaryStdout bool
+
+	// exitCode is the exit status once the output is written, set by
+	// data_type=assert when a required check failed.
+	exitCode int
 }
 
 func main() {
-	var input Input
-	if err := json.NewDecoder(os.Stdin).Decode(&input); err != nil {
-		json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("failed to decode input: %v", err)})
-		return
-	}
-
-	var (
-		host       string
-		port       int
-		username   string
-		password   string
-		dbname     string
-		dataType   = "query" // query, table, stored_procedure, stored_function
-		objectName string
-		query      string
-		parameters string // JSON array of arguments
-	)
-
-	// Extract parameters
-	for _, p := range input.Params {
-		val := strings.TrimSpace(p.CompValue)
-		switch strings.ToLower(p.InputName) {
-		case "host":
-			host = val
-		case "port":
-			fmt.Sscanf(val, "%d", &port)
-		case "username":
-			username = val
-		case "password":
-			password = val
-		case "dbname":
-			dbname = val
-		case "data_type":
-			if val != "" {
-				dataType = strings.ToLower(val)
+	inputPath := flag.String("input", "", "read the Input JSON from this file instead of stdin")
+	outputPath := flag.String("output", "", "write the Output JSON to this file instead of stdout")
+	flag.StringVar(&configPath, "config", "", "JSON or TOML file with connection profiles")
+	flag.StringVar(&queriesPath, "queries", "", "JSON or TOML file with saved queries for query_name")
+	flag.StringVar(&policyPath, "policy", "", "JSON or TOML file with mask_columns and drop_columns enforced on every result")
+	flag.Int64Var(&maxInputBytes, "max-input-bytes", defaultMaxInputBytes, "largest Input JSON to read; raise it for large payloads such as insert rows")
+	flag.Parse()
+
+	var out Output
+	if input, err := readInput(*inputPath); err != nil {
+		out = Output{Error: err.Error(), RequestID: newRequestID()}
+	} else {
+		out = run(input)
+	}
+
+	// A streamed result can fail after output has started; the error is in
+	// the JSON and the exit code tells the caller not to trust the rows.
+	// Progress reporting covers writing the output and ends after it.
+	if *outputPath == "" {
+		err := writeOutput(progressReporter.writer(os.Stdout), &out)
+		progressReporter.stop(out.Error)
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(out.exitCode)
+	}
+
+	// With --output the JSON goes to the file and stdout gets a status line.
+	f, err := createFileAtomic(*outputPath)
+	if err == nil {
+		defer f.Abort()
+		streamErr := writeOutput(progressReporter.writer(f), &out)
+		err = f.Commit()
+		progressReporter.stop(out.Error)
+		if err == nil && streamErr != nil {
+			fmt.Printf("error request_id=%s output=%s\n", out.RequestID, *outputPath)
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Printf("error request_id=%s: failed to write output: %v\n", out.RequestID, err)
+		os.Exit(1)
+	}
+	status := "ok"
+	if out.Error != "" {
+		status = "error"
+	}
+	fmt.Printf("%s request_id=%s output=%s\n", status, out.RequestID, *outputPath)
+	os.Exit(out.exitCode)
+}
+
+func run(input Input) (out Output) {
+	cfg, errs := parseSettings(input)
+	defer func() {
+		if cfg.scan != nil {
+			out.Warnings = append(out.Warnings, cfg.scan.temporals.flush()...)
+			out.ScanErrors = cfg.scan.errors.result()
+		}
+		out.Error = redact(out.Error, cfg)
+		for i, w := range out.Warnings {
+			out.Warnings[i] = redact(w, cfg)
+		}
+	}()
+	out.RequestID = cfg.requestID
+	out.compression, out.binaryStdout = cfg.outputCompression, cfg.binaryStdout
+	logPrefix = fmt.Sprintf("request_id=%s ", cfg.requestID)
+	if cfg.includeStatement {
+		out.Statement = newStatementSummary(cfg)
+	}
+	if len(errs) > 0 {
+		out.Error, out.Errors = errs.summary(), errs
+		return out
+	}
+	if cfg.progressInterval > 0 {
+		progressReporter = startProgress(cfg, &out)
+	}
+	cfg.scan = newScanConfig(cfg)
+	if out.TenantScope = cfg.tenantScope(); out.TenantScope == nil && cfg.tenantColumn != "" && cfg.dataType != "query" {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("tenant_column does not scope data_type=%s", cfg.dataType))
+	}
+
+	if f, err := parseFilter(cfg.filter); err == nil {
+		out.Geo = f.geo
+	}
+
+	// Tracing is best effort: a malformed header only produces a warning.
+	var sp *span
+	if cfg.traceparent != "" {
+		tc, err := parseTraceparent(cfg.traceparent)
+		if err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("ignoring traceparent: %v", err))
+		} else {
+			sp = newSpan(tc)
+			logPrefix += fmt.Sprintf("trace_id=%s span_id=%s ", sp.TraceID, sp.SpanID)
+			defer func() {
+				sp.finish(out.Error)
+				out.Span = sp
+			}()
+		}
+	}
+	traceComment = sqlComment(cfg.requestID, sp)
+
+	// Operations are data_types implemented over the connection rather than
+	// as a single generated statement.
+	op, ok := operations[cfg.dataType]
+	readOp := readOperations[cfg.dataType]
+	if cfg.snapshotMode() {
+		// resultSnapshot checks read_only itself: only paging is a read.
+		op, ok, readOp = resultSnapshot, true, true
+	}
+	if ok && cfg.dataType == "lint" && cfg.lintLargeTableRows == 0 {
+		result, err := lintOperation(context.Background(), nil, cfg, &out)
+		if err != nil {
+			out.fail(err)
+			return out
+		}
+		out.Result = result
+		return out
+	}
+	if ok {
+		if cfg.readOnly && !readOp {
+			what := "data_type=" + cfg.dataType
+			if cfg.dataType == "create_event" && !eventStatement(cfg).cacheable() {
+				// The body is refused as the same raw query would be.
+				what = "an event_body that may modify data"
+			}
+			out.fail(&readOnlyError{what})
+			return out
+		}
+		db, ctx, cancel, err := openSession(cfg, &out)
+		if err != nil {
+			out.fail(err)
+			return out
+		}
+		defer db.Close()
+		defer cancel()
+
+		result, err := op(ctx, db, cfg, &out)
+		if err != nil {
+			diagnoseLocks(db, cfg, err, &out)
+			out.fail(err)
+			return out
+		}
+		out.Result = result
+		return out
+	}
+
+	stmt, err := buildStatement(cfg)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	// A mismatch is reported here, with the placeholders found, instead of
+	// by the driver or the server after a round trip.
+	if err := checkPlaceholders(stmt); err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	if out.Statement != nil {
+		out.Statement.setStatement(stmt, cfg.includeParameterValues)
+	}
+	if cfg.queryTemplate {
+		out.RenderedSQL = stmt.SQL
+	}
+	if cfg.dataType == "query" && isSelectQuery(stmt.SQL) && unstablePagination(stmt.SQL) {
+		out.UnstablePagination = true
+		out.Warnings = append(out.Warnings, "unstable_pagination: the query pages with LIMIT and OFFSET but has no ORDER BY, so rows may repeat or go missing between pages; order by a unique key")
+	}
+	if cfg.interpolateParams {
+		if err := checkInterpolatable(stmt.Args); err != nil {
+			out.Error = err.Error()
+			return out
+		}
+	}
+	if cfg.softDeleteColumn != "" && (cfg.dataType == "table" || cfg.dataType == "delete") {
+		out.SoftDelete = &softDelete{Column: cfg.softDeleteColumn, IncludeDeleted: cfg.includeDeleted}
+	}
+
+	// Stored procedures count as writes here for the same reason they are
+	// never cached: they may modify data.
+	if cfg.readOnly && !stmt.cacheable() {
+		out.fail(&readOnlyError{"a statement that may modify data"})
+		return out
+	}
+
+	if cfg.includeFingerprint {
+		out.Fingerprint = newFingerprint(stmt.SQL)
+	}
+	if cfg.lint && cfg.lintLargeTableRows == 0 {
+		if out.Lint, err = lintStatement(context.Background(), nil, cfg, stmt.SQL); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+
+	// Serve reads from the result cache when enabled.
+	var cache *resultCache
+	if cfg.cacheTTL > 0 {
+		switch {
+		// The cache holds the rows only, not their summary.
+		case cfg.cacheBypass || !stmt.cacheable() || cfg.summarizeColumns != "":
+			out.Cache = "bypass"
+		default:
+			cache = resultCacheFor(cfg, stmt)
+			if result, ok := cache.get(); ok {
+				out.Result, out.Cache = result, "hit"
+				return out
 			}
-		case "object_name":
-			objectName = val
-		case "query":
-			query = val
-		case "parameters":
-			parameters = val
+			out.Cache = "miss"
 		}
 	}
 
-	// Validate connection params
-	if host == "" || username == "" || dbname == "" {
-		json.NewEncoder(os.Stdout).Encode(Output{Error: "host, username, and dbname are required"})
-		return
+	db, ctx, cancel, err := openSession(cfg, &out)
+	if err != nil {
+		out.fail(err)
+		return out
 	}
-	if port == 0 {
-		port = 3306
+	// A streamed result takes over the connection and releases it once it
+	// has been written.
+	defer func() {
+		if out.stream == nil {
+			cancel()
+			db.Close()
+		}
+	}()
+
+	if stmt.page != nil {
+		if stmt, err = orderTablePage(ctx, db, cfg, stmt, &out); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+
+	// Window functions would otherwise fail with a bare syntax error.
+	if cfg.window != "" {
+		v, err := db.serverVersion(ctx)
+		if err == nil {
+			err = v.require("window", v.windowFunctions())
+		}
+		if err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+
+	if cfg.lint && cfg.lintLargeTableRows > 0 {
+		if out.Lint, err = lintStatement(ctx, db, cfg, stmt.SQL); err != nil {
+			out.fail(err)
+			return out
+		}
 	}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", username, password, host, port, dbname)
-	db, err := sql.Open("mysql", dsn)
+	if cfg.preflightPrivileges && !stmt.IsSelect {
+		if err := preflightPrivileges(ctx, db, cfg, stmt, &out); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+	if cfg.encodingCheck && !stmt.IsSelect {
+		checkEncoding(ctx, db, cfg, stmt, &out)
+	}
+
+	execStmt, returning, err := prepareExec(cfg, stmt, func() (serverVersion, error) { return db.serverVersion(ctx) })
 	if err != nil {
-		json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("failed to connect: %v", err)})
-		return
+		out.fail(err)
+		return out
+	}
+	lock, _ := parseLock(cfg.lock)
+	if lock.strength != "" {
+		out.Warnings = append(out.Warnings, "lock holds its row locks only while the SELECT runs; use data_type=steps to keep them for later statements")
+	}
+	if out.Statement != nil {
+		out.Statement.SQL = execStmt.SQL
 	}
-	defer db.Close()
 
-	if err := db.Ping(); err != nil {
-		json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("failed to ping db: %v", err)})
-		return
+	// Audited, snapshotted, idempotent and outbox writes run in a
+	// transaction together with their audit row, snapshot, idempotency key
+	// and event so the change and its record commit (or roll back) as one.
+	var q execer = db
+	var tx *sql.Tx
+	// strict_warnings needs the statement and SHOW WARNINGS on one
+	// connection, and a transaction to roll back; found_rows counts the rows
+	// an update changes in the transaction that runs it.
+	if ((cfg.auditTable != "" || cfg.snapshotTable != "" || cfg.idempotencyKey != "" || cfg.outbox != "" || (cfg.foundRows && cfg.dataType == "update")) && !stmt.IsSelect) || (cfg.strictWarnings && !stmt.cacheable()) {
+		if err := ensureIdempotencyTable(ctx, db, cfg); err != nil {
+			out.fail(err)
+			return out
+		}
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			out.Error = fmt.Sprintf("failed to begin transaction: %v", err)
+			return out
+		}
+		defer tx.Rollback()
+		q = tx
 	}
 
-	var rows *sql.Rows
-	var execResult sql.Result
-	isSelect := false
+	if cfg.idempotencyKey != "" && tx != nil {
+		replay, err := claimIdempotencyKey(ctx, tx, cfg, idempotencyHash(stmt.SQL, stmt.Args))
+		if err != nil {
+			out.fail(err)
+			return out
+		}
+		if replay != nil {
+			out.Result, out.IdempotentReplay = replay, true
+			return out
+		}
+	}
 
-	switch dataType {
+	var snapshotRows int64
+	if cfg.snapshotTable != "" {
+		if snapshotRows, err = takeSnapshot(ctx, tx, cfg); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+
+	var outbox *outboxSpec
+	var outboxRows *outboxLock
+	if cfg.outbox != "" && tx != nil {
+		outbox, _ = parseOutbox(cfg.outbox)
+		if outboxRows, err = lockOutboxRows(ctx, tx, cfg, outbox); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+
+	// Plain reads can be encoded while they are scanned instead of being
+	// collected first. Features needing the whole result keep the old path.
+	if cfg.streamEncode && tx == nil && execStmt.IsSelect && !returning && cache == nil && cfg.slowQueryMS == 0 && cfg.groupByColumns == "" && cfg.clientSort == "" && cfg.clientLimit == 0 && cfg.summarizeColumns == "" {
+		rows, err := q.QueryContext(ctx, execStmt.SQL, execStmt.Args...)
+		if err != nil {
+			out.Error = fmt.Sprintf("execution error: %v", err)
+			out.ErrorClass = errorClass(lock.classify(err))
+			return out
+		}
+		out.stream = &rowStream{
+			rows:   rows,
+			cfg:    cfg,
+			shaper: newRowShaper(cfg),
+			close:  func() { rows.Close(); cancel(); db.Close() },
+			redact: func(msg string) string { return redact(msg, cfg) },
+		}
+		return out
+	}
+
+	var changed int64
+	if cfg.foundRows && cfg.dataType == "update" {
+		if changed, err = countUpdateChanges(ctx, tx, cfg); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+
+	started := time.Now()
+	result, err := execute(ctx, q, cfg, execStmt)
+	if err != nil {
+		if cfg.interpolateParams {
+			err = interpolationHint(err)
+		}
+		err = lock.classify(err)
+		if tx != nil {
+			// Release this statement's own locks before looking at others'.
+			tx.Rollback()
+		}
+		diagnoseLocks(db, cfg, err, &out)
+		out.fail(err)
+		return out
+	}
+	if cfg.strictWarnings && tx != nil {
+		if err := checkStrict(ctx, tx); err != nil {
+			out.fail(err)
+			return out
+		}
+	}
+	if m, ok := result.(map[string]int64); ok && cfg.foundRows && cfg.dataType == "update" {
+		m["matched"], m["changed"] = m["rows_affected"], changed
+	}
+	if m, ok := result.(map[string]int64); ok && cfg.snapshotTable != "" {
+		m["snapshot_rows"] = snapshotRows
+	}
+	if rows, ok := result.([]map[string]interface{}); ok {
+		if cfg.clientSort != "" || cfg.clientLimit > 0 {
+			if rows, err = clientSort(cfg, rows, &out); err != nil {
+				out.fail(err)
+				return out
+			}
+		}
+		if cfg.summarizeColumns != "" {
+			out.Summary = summarizeColumns(cfg, rows, &out)
+		}
+		if shaper := newRowShaper(cfg); shaper != nil {
+			rows = shaper.shapeAll(rows)
+			out.Warnings = append(out.Warnings, shaper.warnings...)
+		}
+		if g := newGrouping(cfg); g != nil {
+			var warnings []string
+			rows, warnings = g.apply(rows)
+			out.Warnings = append(out.Warnings, warnings...)
+		}
+		result = cfg.scan.order.rows(rows)
+		if returning {
+			result = map[string]interface{}{"rows_affected": int64(len(rows)), "returning": cfg.scan.order.rows(rows)}
+		}
+	}
+
+	if tx != nil {
+		if cfg.auditTable != "" {
+			if err := writeAudit(ctx, tx, cfg.auditTable, stmt, cfg.objectName, result, cfg.auditContext); err != nil {
+				if !cfg.auditBestEffort {
+					out.Error = fmt.Sprintf("audit failed: %v", err)
+					return out
+				}
+				out.Warnings = append(out.Warnings, fmt.Sprintf("audit failed: %v", err))
+			}
+		}
+		if outbox != nil {
+			if out.OutboxID, err = recordOutbox(ctx, tx, cfg, outbox, outboxRows, result); err != nil {
+				out.fail(err)
+				return out
+			}
+		}
+		if cfg.idempotencyKey != "" {
+			if err := storeIdempotentResult(ctx, tx, cfg, result); err != nil {
+				out.fail(err)
+				return out
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			out.Error = fmt.Sprintf("commit failed: %v", err)
+			return out
+		}
+	}
+
+	if elapsed := time.Since(started).Milliseconds(); cfg.slowQueryMS > 0 && elapsed > cfg.slowQueryMS {
+		out.Slow, out.DurationMS = true, elapsed
+		if stmt.explainable() {
+			// A failed EXPLAIN (e.g. missing privileges) must not fail the run.
+			if v, err := db.serverVersion(ctx); err == nil && !v.explainJSON() {
+				out.Warnings = append(out.Warnings, (&unsupportedError{"EXPLAIN FORMAT=JSON", v}).Error())
+			} else if plan, err := explainPlan(db.DB, execStmt); err != nil {
+				out.Warnings = append(out.Warnings, fmt.Sprintf("explain failed: %v", err))
+			} else {
+				out.Plan = plan
+			}
+		}
+	}
+
+	// A result with skipped or patched rows, or with invalid temporal
+	// values, is not cached: a hit could not report them.
+	if cache != nil && cfg.scan.errors.result() == nil && !cfg.scan.temporals.replaced() {
+		if err := cache.put(result); err != nil {
+			logf("cache write failed: %v", err)
+		}
+	}
+	out.Result = result
+	return out
+}
+
+// openSession connects and prepares the context statements run under,
+// bounded by query_timeout_seconds when set.
+func openSession(cfg settings, out *Output) (*database, context.Context, context.CancelFunc, error) {
+	db, err := connect(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// A server that cannot compress is not an error, only worth a warning.
+	if cfg.compress {
+		if ok, err := compressionNegotiated(db.DB); err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("could not determine compression status: %v", err))
+		} else {
+			out.CompressionNegotiated = &ok
+			if !ok {
+				out.Warnings = append(out.Warnings, "compression was requested but not negotiated by the server")
+			}
+		}
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if cfg.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.queryTimeout)*time.Second)
+	}
+
+	// Captured once here; feature checks reuse the cached value.
+	if v, err := db.serverVersion(ctx); err != nil {
+		out.Warnings = append(out.Warnings, err.Error())
+	} else {
+		out.ServerVersion = &v
+	}
+	if cfg.includeMetadata {
+		if out.Charsets, err = querySessionCharsets(ctx, db); err != nil {
+			out.Warnings = append(out.Warnings, err.Error())
+		}
+	}
+	return db, ctx, cancel, nil
+}
+
+// fail records err on the output, deriving error_class from known error types.
+func (o *Output) fail(err error) {
+	o.Error = err.Error()
+	o.ErrorClass = errorClass(err)
+	var stErr *strictError
+	if errors.As(err, &stErr) {
+		o.StrictWarnings = stErr.warnings
+	}
+}
+
+// errorClass maps typed errors onto the error_class output field.
+func errorClass(err error) string {
+	var tErr *tunnelError
+	var pErr *privilegeError
+	var rErr *readOnlyError
+	var uErr *unsupportedError
+	var sErr *resultTooLargeError
+	var lErr *lockTimeoutError
+	var ltErr *lintError
+	var luErr *lockUnavailableError
+	var stErr *strictError
+	switch {
+	case errors.As(err, &tErr):
+		return "ssh_tunnel"
+	case errors.As(err, &pErr):
+		return "missing_privileges"
+	case errors.As(err, &rErr):
+		return "read_only"
+	case errors.As(err, &uErr):
+		return "unsupported_server"
+	case errors.As(err, &sErr):
+		return "result_too_large"
+	case errors.As(err, &lErr):
+		return "lock_timeout"
+	case errors.As(err, &ltErr):
+		return "lint"
+	case errors.As(err, &luErr):
+		return "lock_unavailable"
+	case errors.As(err, &stErr):
+		return "strict_warnings"
+	}
+	return ""
+}
+
+// statement is the SQL resolved from the data_type inputs, ready to run.
+type statement struct {
+	SQL      string
+	Args     []interface{}
+	IsSelect bool
+
+	page *tablePage // a data_type=table page, ordered once connected
+}
+
+// cacheable reports whether the statement is a pure read. CALL is treated as
+// a write since procedures may modify data even when they return rows.
+func (s statement) cacheable() bool {
+	if !s.IsSelect {
+		return false
+	}
+	return !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(s.SQL)), "CALL")
+}
+
+// prepareExec returns stmt as it runs: with the lock clause and, for an
+// insert on a server that has it, RETURNING *, each added to the SQL the
+// step before built. The trace comment is added by commentConn, after
+// fingerprinting and cache lookup, so it never changes the identity of the
+// statement. version is called only when the server version matters.
+// returning reports whether RETURNING was added.
+func prepareExec(cfg settings, stmt statement, version func() (serverVersion, error)) (statement, bool, error) {
+	execStmt := stmt
+	returning := false
+	lock, _ := parseLock(cfg.lock)
+	if lock.strength != "" {
+		v, err := version()
+		var clause string
+		if err == nil {
+			clause, err = lock.clause(v)
+		}
+		if err != nil {
+			return statement{}, false, err
+		}
+		execStmt.SQL, _ = withLockClause(execStmt.SQL, clause)
+	}
+	if cfg.dataType == "insert" {
+		// MariaDB reports every generated key with RETURNING, where
+		// LastInsertId only has the first one.
+		if v, err := version(); err == nil && v.returning() {
+			execStmt.SQL += " RETURNING *"
+			execStmt.IsSelect, returning = true, true
+		}
+	}
+	return execStmt, returning, nil
+}
+
+func buildStatement(cfg settings) (statement, error) {
+	objectName, query, parameters := cfg.objectName, cfg.query, cfg.parameters
+	switch cfg.dataType {
 	case "table":
 		if objectName == "" {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: "object_name is required for table"})
-			return
+			return statement{}, fmt.Errorf("object_name is required for table")
 		}
 		// Basic SELECT * FROM table limiting mostly for safety? No, let's dump all.
-		rows, err = db.Query(fmt.Sprintf("SELECT * FROM %s", objectName))
-		isSelect = true
+		table, err := tableIdent(objectName)
+		if err != nil {
+			return statement{}, err
+		}
+		b := new(stmtBuilder).text("SELECT *")
+		if cfg.window != "" {
+			exprs, err := windowProjection(cfg.window)
+			if err != nil {
+				return statement{}, err
+			}
+			b.text(", ").text(exprs)
+		}
+		f, err := parseFilter(cfg.filter)
+		if err != nil {
+			return statement{}, err
+		}
+		var distance ident
+		if f.geo != nil && f.geo.DistanceColumn != "" {
+			if distance, err = columnIdent(f.geo.DistanceColumn); err != nil {
+				return statement{}, err
+			}
+			b.text(", ").text(sqlText(f.geo.expr)).text(" AS ").ident(distance).arg(f.geo.exprArgs...)
+		}
+		if cfg.softDeleteColumn != "" && !cfg.includeDeleted {
+			col, err := columnIdent(cfg.softDeleteColumn)
+			if err != nil {
+				return statement{}, fmt.Errorf("invalid soft_delete_column: %v", err)
+			}
+			f = f.and(col.sql + " IS NULL")
+		}
+		if cond, args := tenantCondition(cfg); cond != "" {
+			f = f.and(cond, args...)
+		}
+		b.text(" FROM ").ident(table).where(f)
+		var order []ident
+		if distance.sql != "" {
+			order = append(order, distance)
+		}
+		if cfg.limit > 0 || cfg.offset > 0 {
+			page := &tablePage{head: b.sql.String(), order: order, limit: cfg.limit, offset: cfg.offset}
+			stmt := page.statement(nil)
+			stmt.Args = b.args
+			return stmt, nil
+		}
+		if len(order) > 0 {
+			b.text(" ORDER BY ").ident(distance)
+		}
+		return b.statement(true), nil
 
 	case "stored_procedure":
 		if objectName == "" {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: "object_name is required for stored_procedure"})
-			return
+			return statement{}, fmt.Errorf("object_name is required for stored_procedure")
 		}
 		args, err := parseArgs(parameters)
 		if err != nil {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("invalid parameters: %v", err)})
-			return
-		}
-
-		placeholders := make([]string, len(args))
-		for i := range args {
-			placeholders[i] = "?"
+			return statement{}, fmt.Errorf("invalid parameters: %v", err)
 		}
 
-		q := fmt.Sprintf("CALL %s(%s)", objectName, strings.Join(placeholders, ","))
-		rows, err = db.Query(q, args...)
 		// Stored procedures might return rows or might just execute.
 		// If it has a result set, current driver should handle it via Query.
 		// If no result set, it might error "no rows in result set" or return empty.
-		isSelect = true
+		name, err := routineIdent(objectName)
+		if err != nil {
+			return statement{}, err
+		}
+		return new(stmtBuilder).text("CALL ").ident(name).text("(").values(args).text(")").statement(true), nil
 
 	case "stored_function":
 		if objectName == "" {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: "object_name is required for stored_function"})
-			return
+			return statement{}, fmt.Errorf("object_name is required for stored_function")
 		}
 		args, err := parseArgs(parameters)
 		if err != nil {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("invalid parameters: %v", err)})
-			return
+			return statement{}, fmt.Errorf("invalid parameters: %v", err)
 		}
 
-		placeholders := make([]string, len(args))
-		for i := range args {
-			placeholders[i] = "?"
+		// SELECT func(args)
+		name, err := routineIdent(objectName)
+		if err != nil {
+			return statement{}, err
 		}
+		return new(stmtBuilder).text("SELECT ").ident(name).text("(").values(args).text(")").statement(true), nil
 
-		// SELECT func(args)
-		q := fmt.Sprintf("SELECT %s(%s)", objectName, strings.Join(placeholders, ","))
-		rows, err = db.Query(q, args...)
-		isSelect = true
+	case "update":
+		return buildUpdate(cfg)
+
+	case "insert":
+		return buildInsert(cfg)
+
+	case "delete":
+		return buildDelete(cfg)
 
 	case "query":
 		fallthrough
 	default:
 		if query == "" {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: "query is required"})
-			return
-		}
-		isSelect = strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "SELECT")
-		// Also SHOW, DESCRIBE, EXPLAIN are queries
-		if !isSelect {
-			cmd := strings.ToUpper(strings.TrimSpace(query))
-			if strings.HasPrefix(cmd, "SHOW") || strings.HasPrefix(cmd, "DESCRIBE") || strings.HasPrefix(cmd, "EXPLAIN") || strings.HasPrefix(cmd, "CALL") {
-				isSelect = true
+			return statement{}, fmt.Errorf("query is required")
+		}
+		if cfg.queryTemplate {
+			params, err := parseTemplateParams(parameters)
+			if err != nil {
+				return statement{}, err
 			}
+			sqlText, args, err := renderTemplate(query, params, cfg.tablePrefix)
+			if err != nil {
+				return statement{}, err
+			}
+			return statement{SQL: sqlText, Args: args, IsSelect: isReadQuery(sqlText)}, nil
 		}
-
-		if isSelect {
-			rows, err = db.Query(query)
-		} else {
-			execResult, err = db.Exec(query)
+		args, err := parseArgs(parameters)
+		if err != nil {
+			return statement{}, fmt.Errorf("invalid parameters: %v", err)
 		}
+		return statement{SQL: query, Args: args, IsSelect: isReadQuery(query)}, nil
 	}
+}
 
-	if err != nil {
-		json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("execution error: %v", err)})
-		return
-	}
-
-	if isSelect && rows != nil {
-		defer rows.Close()
-		columns, err := rows.Columns()
-		if err != nil {
-			json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("columns error: %v", err)})
-			return
+func isReadQuery(query string) bool {
+	cmd := strings.ToUpper(strings.TrimSpace(query))
+	// Also SHOW, DESCRIBE, EXPLAIN are queries
+	for _, prefix := range []string{"SELECT", "SHOW", "DESCRIBE", "EXPLAIN", "CALL"} {
+		if strings.HasPrefix(cmd, prefix) {
+			return true
 		}
+	}
+	return false
+}
 
-		results := make([]map[string]interface{}, 0)
-		for rows.Next() {
-			columnPointers := make([]interface{}, len(columns))
-			for i := range columns {
-				columnPointers[i] = new(interface{})
-			}
-
-			if err := rows.Scan(columnPointers...); err != nil {
-				json.NewEncoder(os.Stdout).Encode(Output{Error: fmt.Sprintf("scan error: %v", err)})
-				return
-			}
+func placeholders(n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = "?"
+	}
+	return strings.Join(p, ",")
+}
 
-			m := make(map[string]interface{})
-			for i, colName := range columns {
-				val := *(columnPointers[i].(*interface{}))
+// execer is satisfied by *sql.DB, *sql.Tx and *sql.Conn.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-				// Handle []byte for strings
-				if b, ok := val.([]byte); ok {
-					m[colName] = string(b)
-				} else {
-					m[colName] = val
-				}
-			}
-			results = append(results, m)
+// execute runs the statement and converts its outcome into the result payload.
+func execute(ctx context.Context, q execer, cfg settings, stmt statement) (interface{}, error) {
+	if !stmt.IsSelect {
+		execResult, err := q.ExecContext(ctx, stmt.SQL, stmt.Args...)
+		if err != nil {
+			return nil, fmt.Errorf("execution error: %w", err)
 		}
-		json.NewEncoder(os.Stdout).Encode(Output{Result: results})
-
-	} else if execResult != nil {
 		id, _ := execResult.LastInsertId()
 		affected, _ := execResult.RowsAffected()
-		json.NewEncoder(os.Stdout).Encode(Output{Result: map[string]int64{
+		return map[string]int64{
 			"last_insert_id": id,
 			"rows_affected":  affected,
-		}})
-	} else {
-		json.NewEncoder(os.Stdout).Encode(Output{Result: "OK"})
+		}, nil
+	}
+
+	rows, err := q.QueryContext(ctx, stmt.SQL, stmt.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %w", err)
+	}
+	if rows == nil {
+		return "OK", nil
+	}
+	defer rows.Close()
+	return scanRows(rows, cfg)
+}
+
+func scanRows(rows *sql.Rows, cfg settings) ([]map[string]interface{}, error) {
+	scanner, err := newRowScanner(rows, cfg)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]map[string]interface{}, 0)
+	for {
+		m, err := scanner.next()
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			return results, nil
+		}
+		results = append(results, m)
 	}
 }
 
@@ -234,5 +913,35 @@ func parseArgs(paramStr string) ([]interface{}, error) {
 		// For now strictly JSON array for complex types support
 		return nil, err
 	}
+	// Integers beyond 2^53, such as BIGINT UNSIGNED ids, keep every digit,
+	// and so do amounts a float64 cannot hold, such as DECIMAL(18,4) ones.
+	var raw []json.RawMessage
+	json.Unmarshal([]byte(paramStr), &raw)
+	for i, a := range args {
+		if _, ok := a.(float64); ok {
+			if n, ok := exactInteger(raw[i]); ok {
+				args[i] = n
+			} else if text, ok := exactDecimal(raw[i]); ok {
+				args[i] = text
+			}
+		}
+	}
 	return args, nil
 }
+
+func parseBool(val string) bool {
+	switch strings.ToLower(val) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// logPrefix is prepended to every stderr line, carrying the request id and,
+// when set, the trace ids.
+var logPrefix string
+
+// logf writes a diagnostic line to stderr; stdout is reserved for the Output JSON.
+func logf(format string, args ...interface{}) {
+	fmt.Fprintln(os.Stderr, logPrefix+fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func testSpan(t *testing.T) *span {
+	t.Helper()
+	tc, err := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSpan(tc)
+}
+
+func fixedVersion(raw string) func() (serverVersion, error) {
+	return func() (serverVersion, error) { return parseServerVersion(raw), nil }
+}
+
+// withTrace sets traceComment for one test.
+func withTrace(t *testing.T, requestID string, sp *span) {
+	t.Helper()
+	traceComment = sqlComment(requestID, sp)
+	t.Cleanup(func() { traceComment = "" })
+}
+
+// sendStatement prepares stmt like run does and sends it over a stub
+// connection, returning the SQL the server received.
+func sendStatement(t *testing.T, cfg settings, stmt statement, version string) (string, bool) {
+	t.Helper()
+	execStmt, returning, err := prepareExec(cfg, stmt, fixedVersion(version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, stub := openStubDB(t)
+	if execStmt.IsSelect {
+		rows, err := db.QueryContext(context.Background(), execStmt.SQL, execStmt.Args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	} else if _, err := db.ExecContext(context.Background(), execStmt.SQL, execStmt.Args...); err != nil {
+		t.Fatal(err)
+	}
+	sent := stub.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %q, want one statement", sent)
+	}
+	return sent[0], returning
+}
+
+func TestInsertReturningWithTraceparent(t *testing.T) {
+	withTrace(t, "req-1", testSpan(t))
+	cfg := settings{dataType: "insert", requestID: "req-1"}
+	stmt := statement{SQL: "INSERT INTO t (a) VALUES (?)", Args: []interface{}{1}}
+	sent, returning := sendStatement(t, cfg, stmt, "10.6.12-MariaDB")
+	if !returning {
+		t.Fatal("returning not set on MariaDB 10.6")
+	}
+	if !strings.HasPrefix(sent, "/*request_id='req-1',traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("sent %q without the trace comment", sent)
+	}
+	if !strings.HasSuffix(sent, "INSERT INTO t (a) VALUES (?) RETURNING *") {
+		t.Errorf("sent %q without RETURNING *", sent)
+	}
+}
+
+func TestInsertWithoutReturning(t *testing.T) {
+	cfg := settings{dataType: "insert", requestID: "req-1"}
+	stmt := statement{SQL: "INSERT INTO t (a) VALUES (?)"}
+	got, returning, err := prepareExec(cfg, stmt, fixedVersion("8.0.31"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if returning || got.IsSelect || strings.Contains(got.SQL, "RETURNING") {
+		t.Errorf("MySQL 8.0 has no RETURNING, got %q", got.SQL)
+	}
+}
+
+func TestLockWithTraceparent(t *testing.T) {
+	withTrace(t, "req-1", testSpan(t))
+	cfg := settings{dataType: "query", lock: "update,nowait", requestID: "req-1"}
+	stmt := statement{SQL: "SELECT * FROM t WHERE id = ?", Args: []interface{}{1}, IsSelect: true}
+	sent, _ := sendStatement(t, cfg, stmt, "8.0.31")
+	if !strings.HasPrefix(sent, "/*request_id='req-1',traceparent=") {
+		t.Errorf("sent %q without the trace comment", sent)
+	}
+	if !strings.HasSuffix(sent, "SELECT * FROM t WHERE id = ? FOR UPDATE NOWAIT") {
+		t.Errorf("sent %q without the lock clause", sent)
+	}
+}
+
+func TestRequestIDCommentWithoutTraceparent(t *testing.T) {
+	withTrace(t, "req 2'x", nil)
+	db, stub := openStubDB(t)
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM t WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "CREATE TEMPORARY TABLE tmp (a INT)"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	for _, sent := range stub.sent() {
+		if !strings.HasPrefix(sent, "/*request_id='req%202%27x'*/ ") {
+			t.Errorf("sent %q without the escaped request_id comment", sent)
+		}
+	}
+	if len(stub.sent()) != 2 {
+		t.Errorf("sent %q, want two statements", stub.sent())
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultMaxTables caps how many tables one maintain_table run may touch
+// unless max_tables is set.
+const defaultMaxTables = 50
+
+// maintenanceStatements maps the operation input to its statement.
+var maintenanceStatements = map[string]sqlText{"analyze": "ANALYZE TABLE", "optimize": "OPTIMIZE TABLE", "check": "CHECK TABLE"}
+
+type maintenanceMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// tableMaintenance is the outcome for one table: ok, error or timeout. The
+// server's info and note rows, such as InnoDB's "doing recreate + analyze
+// instead" for OPTIMIZE, are reported apart from the errors.
+type tableMaintenance struct {
+	Table      string               `json:"table"`
+	Status     string               `json:"status"`
+	Messages   []maintenanceMessage `json:"messages,omitempty"`
+	Notes      []string             `json:"notes,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	DurationMS int64                `json:"duration_ms"`
+}
+
+// maintenanceTables resolves object_name: a table, a comma separated list,
+// or a LIKE pattern with % matched against the base tables of the schema.
+func maintenanceTables(ctx context.Context, db *database, cfg settings) ([]string, error) {
+	if !strings.Contains(cfg.objectName, "%") {
+		var names []string
+		for _, name := range strings.Split(cfg.objectName, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = appendUnique(names, name)
+			}
+		}
+		return names, nil
+	}
+	parts := splitQualified(cfg.objectName)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	names, err := queryStrings(ctx, db,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = COALESCE(?, DATABASE()) AND table_type = 'BASE TABLE' AND table_name LIKE ? ORDER BY table_name",
+		schema, parts[len(parts)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the tables matching %s: %v", cfg.objectName, err)
+	}
+	if len(parts) == 2 {
+		for i, name := range names {
+			names[i] = parts[0] + "." + name
+		}
+	}
+	return names, nil
+}
+
+// maintainTable implements data_type=maintain_table, running the operation
+// table by table so each gets check_timeout_seconds. A timeout only stops
+// the wait: the server finishes a statement it has started. One failing
+// table does not stop the others.
+func maintainTable(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.readOnly && cfg.operation != "check" {
+		return nil, &readOnlyError{"operation=" + cfg.operation}
+	}
+	tables, err := maintenanceTables(ctx, db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no table matches %s", cfg.objectName)
+	}
+	maxTables := cfg.maxTables
+	if maxTables == 0 {
+		maxTables = defaultMaxTables
+	}
+	if len(tables) > maxTables {
+		return nil, fmt.Errorf("%s matches %d tables, over max_tables (%d)", cfg.objectName, len(tables), maxTables)
+	}
+	results := make([]tableMaintenance, len(tables))
+	failed := 0
+	for i, table := range tables {
+		results[i] = maintainOne(ctx, db, cfg, table)
+		if results[i].Status != "ok" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%d of %d tables did not complete %s", failed, len(tables), cfg.operation))
+	}
+	return map[string]interface{}{"operation": cfg.operation, "tables": results}, nil
+}
+
+func maintainOne(ctx context.Context, db *database, cfg settings, table string) (result tableMaintenance) {
+	result = tableMaintenance{Table: table, Status: "ok"}
+	name, err := tableIdent(table)
+	if err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+	timeout := time.Duration(cfg.checkTimeout) * time.Second
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	defer func() { result.DurationMS = time.Since(started).Milliseconds() }()
+	query, _ := new(stmtBuilder).text(maintenanceStatements[cfg.operation]).text(" ").ident(name).build()
+	rows, err := db.QueryContext(ctx, query)
+	if err == nil {
+		var messages []map[string]interface{}
+		if messages, err = scanRows(rows, cfg); err == nil {
+			for _, m := range messages {
+				msgType, text := fmt.Sprint(m["Msg_type"]), fmt.Sprint(m["Msg_text"])
+				switch strings.ToLower(msgType) {
+				case "info", "note":
+					result.Notes = append(result.Notes, text)
+					continue
+				case "error":
+					result.Status, result.Error = "error", text
+				case "status":
+					// OK, or "Table is already up to date" for a
+					// table with nothing to do.
+					if !strings.EqualFold(text, "OK") && !strings.Contains(strings.ToLower(text), "up to date") && result.Status == "ok" {
+						result.Status, result.Error = "error", text
+					}
+				}
+				result.Messages = append(result.Messages, maintenanceMessage{msgType, text})
+			}
+		}
+		rows.Close()
+	}
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Status = "timeout"
+			result.Error = fmt.Sprintf("no result after %s; the server may still complete the statement", timeout)
+		}
+	}
+	return result
+}
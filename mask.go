@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// policyPath is the data policy file given with --policy;
+// MYSQL_COMPONENT_POLICY is used when the flag is absent. Its mask_columns
+// and drop_columns apply to every invocation and cannot be relaxed by the
+// inputs, which may only add columns.
+var policyPath string
+
+type policyFile struct {
+	MaskColumns map[string]string `json:"mask_columns" toml:"mask_columns"`
+	DropColumns []string          `json:"drop_columns" toml:"drop_columns"`
+
+	// Account management: wildcard user_host patterns and privileges that
+	// grant may hand out, and whether *.* may be granted at all.
+	GrantHosts        []string `json:"grant_hosts" toml:"grant_hosts"`
+	GrantPrivileges   []string `json:"grant_privileges" toml:"grant_privileges"`
+	AllowGlobalGrants bool     `json:"allow_global_grants" toml:"allow_global_grants"`
+}
+
+// defaultMaskToken replaces a value masked with the plain "token" rule.
+const defaultMaskToken = "****"
+
+// maskRule is a parsed mask_columns rule:
+//
+//	token           the value becomes ****
+//	token:<text>    the value becomes <text>
+//	keep_last:<n>   all but the last n characters become *
+//	keep_first:<n>  all but the first n characters become *
+//
+// The keep rules preserve length and punctuation, so a masked card number
+// or e-mail address keeps its shape.
+type maskRule struct {
+	token string
+	keep  int
+	last  bool
+}
+
+func parseMaskRule(rule string) (maskRule, error) {
+	kind, arg, hasArg := strings.Cut(rule, ":")
+	switch kind {
+	case "token":
+		if !hasArg {
+			return maskRule{token: defaultMaskToken}, nil
+		}
+		if arg == "" {
+			return maskRule{}, fmt.Errorf("token: needs a replacement text")
+		}
+		return maskRule{token: arg}, nil
+	case "keep_last", "keep_first":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return maskRule{}, fmt.Errorf("%s needs a positive character count, got %q", kind, arg)
+		}
+		return maskRule{keep: n, last: kind == "keep_last"}, nil
+	}
+	return maskRule{}, fmt.Errorf("unknown rule %q; use token, token:<text>, keep_last:<n> or keep_first:<n>", rule)
+}
+
+// apply masks a scanned value. NULL stays NULL; anything else becomes a
+// string.
+func (r maskRule) apply(v interface{}) interface{} {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if r.token != "" {
+		return r.token
+	}
+	runes := []rune(s)
+	for i, c := range runes {
+		kept := i < r.keep
+		if r.last {
+			kept = i >= len(runes)-r.keep
+		}
+		if !kept && (c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c > 0x7f) {
+			runes[i] = '*'
+		}
+	}
+	return string(runes)
+}
+
+// columnMasking is the combined policy and mask_columns/drop_columns of an
+// invocation. Columns are matched by their result name, case-insensitively;
+// a column renamed by an alias in the query is not recognized. It is
+// applied while rows are scanned so no result shape, cache entry or output
+// sees the values.
+type columnMasking struct {
+	masks map[string]maskRule
+	drops map[string]bool
+}
+
+// newColumnMasking merges the policy file with the inputs. A column the
+// policy masks keeps the policy rule.
+func newColumnMasking(cfg settings) (*columnMasking, error) {
+	m := &columnMasking{masks: map[string]maskRule{}, drops: map[string]bool{}}
+	policy, err := loadPolicy()
+	if err != nil {
+		return nil, err
+	}
+	var inputMasks map[string]string
+	if cfg.maskColumns != "" {
+		if err := json.Unmarshal([]byte(cfg.maskColumns), &inputMasks); err != nil {
+			return nil, fmt.Errorf(`mask_columns must be an object of column rules like {"salary": "token", "card": "keep_last:4"}: %v`, err)
+		}
+	}
+	var drops []string
+	if cfg.dropColumns != "" {
+		if drops, err = parseIdentList(cfg.dropColumns); err != nil {
+			return nil, fmt.Errorf("drop_columns %v", err)
+		}
+	}
+	for _, masks := range []map[string]string{inputMasks, policy.MaskColumns} {
+		for _, col := range sortedKeys(masks) {
+			rule, err := parseMaskRule(masks[col])
+			if err != nil {
+				return nil, fmt.Errorf("mask_columns: %s: %v", col, err)
+			}
+			m.masks[strings.ToLower(col)] = rule
+		}
+	}
+	for _, col := range append(drops, policy.DropColumns...) {
+		m.drops[strings.ToLower(col)] = true
+	}
+	if len(m.masks) == 0 && len(m.drops) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// loadPolicy reads the policy file, which is optional.
+func loadPolicy() (policyFile, error) {
+	path := policyPath
+	if path == "" {
+		path = os.Getenv("MYSQL_COMPONENT_POLICY")
+	}
+	if path == "" {
+		return policyFile{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policyFile{}, fmt.Errorf("failed to read policy file: %v", err)
+	}
+	var file policyFile
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &file)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return policyFile{}, fmt.Errorf("failed to parse policy file %s: %v", filepath.Base(path), err)
+	}
+	return file, nil
+}
+
+func (m *columnMasking) dropped(col string) bool {
+	return m != nil && m.drops[strings.ToLower(col)]
+}
+
+// value masks v when col has a rule.
+func (m *columnMasking) value(col string, v interface{}) interface{} {
+	if m == nil {
+		return v
+	}
+	if rule, ok := m.masks[strings.ToLower(col)]; ok {
+		return rule.apply(v)
+	}
+	return v
+}
+
+func (m *columnMasking) masked(col string) bool {
+	if m == nil {
+		return false
+	}
+	_, ok := m.masks[strings.ToLower(col)]
+	return ok
+}
+
+// key identifies the masking in cache keys: cached results are stored
+// masked, and the policy file can change between invocations.
+func (m *columnMasking) key() string {
+	if m == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, col := range sortedKeys(m.masks) {
+		r := m.masks[col]
+		fmt.Fprintf(&b, "\nmask=%s,%q,%d,%t", col, r.token, r.keep, r.last)
+	}
+	for _, col := range sortedKeys(m.drops) {
+		fmt.Fprintf(&b, "\ndrop=%s", col)
+	}
+	return b.String()
+}
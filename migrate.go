@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// data_type=migrate applies the NNN_description.sql files of migrations_dir
+// in version order and records each in migrationsTable. The ledger is
+// append-only: baseline_version records files as applied without running
+// them, and rollback_to runs the paired NNN_description.down.sql files and
+// records each as a down entry, so a version is applied when its latest
+// entry is not a down one. A file whose
+// statements are all DML runs in one transaction together with its ledger
+// row. A file containing DDL cannot: MySQL commits implicitly around every
+// DDL statement, so a failure part way leaves the earlier statements applied
+// and the file unrecorded, to be fixed by hand before the next run.
+
+const migrationsTable = "schema_migrations"
+
+const createMigrationsTable = "CREATE TABLE IF NOT EXISTS `" + migrationsTable + "` (" +
+	"id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, " +
+	"version BIGINT NOT NULL, " +
+	"filename VARCHAR(255) NOT NULL, " +
+	"checksum CHAR(64) NOT NULL, " +
+	"duration_ms BIGINT NOT NULL, " +
+	"applied_at DATETIME NOT NULL, " +
+	"direction VARCHAR(8) NOT NULL DEFAULT 'up', " + // up, baseline or down
+	"KEY version (version))"
+
+var (
+	migrationFileRe     = regexp.MustCompile(`^([0-9]+)_([A-Za-z0-9_-]+)\.sql$`)
+	downMigrationFileRe = regexp.MustCompile(`^([0-9]+)_([A-Za-z0-9_-]+)\.down\.sql$`)
+)
+
+// implicitCommitStatements start statements that commit the transaction
+// they run in.
+var implicitCommitStatements = map[string]bool{
+	"CREATE": true, "ALTER": true, "DROP": true, "RENAME": true, "TRUNCATE": true,
+	"GRANT": true, "REVOKE": true, "LOCK": true, "UNLOCK": true,
+	"START": true, "BEGIN": true, "COMMIT": true, "ROLLBACK": true,
+}
+
+type migrationFile struct {
+	Version  int64  `json:"version"`
+	Filename string `json:"filename"`
+	Checksum string `json:"checksum"`
+	DownFile string `json:"down_file,omitempty"`
+	stmts    []string
+
+	downChecksum string
+	downStmts    []string
+}
+
+// appliedMigration is a ledger row, with the file it came from when that is
+// still present.
+type appliedMigration struct {
+	Version          int64  `json:"version"`
+	Filename         string `json:"filename"`
+	Checksum         string `json:"checksum"`
+	AppliedAt        string `json:"applied_at"`
+	Direction        string `json:"direction"` // up or baseline
+	ChecksumMismatch bool   `json:"checksum_mismatch,omitempty"`
+	Missing          bool   `json:"missing_file,omitempty"`
+}
+
+// migrationRun reports one file applied by migrate.
+type migrationRun struct {
+	Version       int64  `json:"version"`
+	Filename      string `json:"filename"`
+	Direction     string `json:"direction"`
+	Statements    int    `json:"statements"`
+	Transactional bool   `json:"transactional"`
+	DurationMS    int64  `json:"duration_ms"`
+}
+
+// readMigrations lists the migration files of dir in version order.
+func readMigrations(dir string, out *Output) ([]migrationFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations_dir: %v", err)
+	}
+	var files []migrationFile
+	seen := map[int64]string{}
+	downs := map[int64]string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		if m := downMigrationFileRe.FindStringSubmatch(e.Name()); m != nil {
+			version, err := strconv.ParseInt(m[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: version out of range", e.Name())
+			}
+			if other, ok := downs[version]; ok {
+				return nil, fmt.Errorf("%s and %s share version %d", other, e.Name(), version)
+			}
+			downs[version] = e.Name()
+			continue
+		}
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s is not named NNN_description.sql and was ignored", e.Name()))
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: version out of range", e.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("%s and %s share version %d", other, e.Name(), version)
+		}
+		seen[version] = e.Name()
+		checksum, stmts, err := readMigrationScript(dir, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, migrationFile{Version: version, Filename: e.Name(), Checksum: checksum, stmts: stmts})
+	}
+	for i := range files {
+		name, ok := downs[files[i].Version]
+		if !ok {
+			continue
+		}
+		delete(downs, files[i].Version)
+		var err error
+		files[i].DownFile = name
+		if files[i].downChecksum, files[i].downStmts, err = readMigrationScript(dir, name); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range downs {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%s has no matching up migration and was ignored", name))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
+
+// readMigrationScript returns the checksum and the statements of a file.
+func readMigrationScript(dir, name string) (string, []string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	stmts, err := splitStatements(string(b))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", name, err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), stmts, nil
+}
+
+// readLedger returns the applied migrations by version: those whose latest
+// entry is not a rollback. A missing ledger table means nothing has been
+// applied yet; one created before rollbacks were recorded has no direction
+// column and only up entries.
+func readLedger(ctx context.Context, q execer) (map[int64]appliedMigration, error) {
+	direction := "'up'"
+	if ok, err := ledgerHasDirection(ctx, q); err != nil {
+		return nil, err
+	} else if ok {
+		direction = "direction"
+	}
+	rows, err := q.QueryContext(ctx, "SELECT version, filename, checksum, CAST(applied_at AS CHAR), "+direction+" FROM `"+migrationsTable+"` ORDER BY id")
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1146 { // ER_NO_SUCH_TABLE
+		return map[int64]appliedMigration{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", migrationsTable, err)
+	}
+	defer rows.Close()
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Filename, &a.Checksum, &a.AppliedAt, &a.Direction); err != nil {
+			return nil, err
+		}
+		if a.Direction == "down" {
+			delete(applied, a.Version)
+		} else {
+			applied[a.Version] = a
+		}
+	}
+	return applied, rows.Err()
+}
+
+func ledgerHasDirection(ctx context.Context, q execer) (bool, error) {
+	var n int
+	if err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = 'direction'",
+		migrationsTable).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to read the columns of %s: %v", migrationsTable, err)
+	}
+	return n > 0, nil
+}
+
+// ensureMigrationsTable creates the ledger, or adds the direction column to
+// one created without it.
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create %s: %v", migrationsTable, err)
+	}
+	ok, err := ledgerHasDirection(ctx, conn)
+	if err != nil || ok {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "ALTER TABLE `"+migrationsTable+"` ADD COLUMN direction VARCHAR(8) NOT NULL DEFAULT 'up'"); err != nil {
+		return fmt.Errorf("failed to add the direction column to %s: %v", migrationsTable, err)
+	}
+	return nil
+}
+
+// compareLedger splits the files into pending ones and the ledger rows,
+// flagging applied files that changed since or are gone.
+func compareLedger(files []migrationFile, applied map[int64]appliedMigration) ([]migrationFile, []appliedMigration) {
+	var pending []migrationFile
+	byVersion := map[int64]migrationFile{}
+	for _, f := range files {
+		byVersion[f.Version] = f
+		if _, ok := applied[f.Version]; !ok {
+			pending = append(pending, f)
+		}
+	}
+	ledger := make([]appliedMigration, 0, len(applied))
+	for _, a := range applied {
+		if f, ok := byVersion[a.Version]; !ok {
+			a.Missing = true
+		} else if f.Checksum != a.Checksum {
+			a.ChecksumMismatch = true
+		}
+		ledger = append(ledger, a)
+	}
+	sort.Slice(ledger, func(i, j int) bool { return ledger[i].Version < ledger[j].Version })
+	return pending, ledger
+}
+
+// transactional reports whether every statement can share one transaction.
+func transactional(stmts []string) bool {
+	for _, stmt := range stmts {
+		for _, tok := range tokenize(stmt) {
+			if tok.kind == tokSpace || tok.kind == tokComment {
+				continue
+			}
+			if implicitCommitStatements[strings.ToUpper(tok.text)] {
+				return false
+			}
+			break
+		}
+	}
+	return true
+}
+
+// migrate implements data_type=migrate, and with status=true reports the
+// applied and pending migrations without changing anything. baseline_version
+// first records the files up to it, then the rest run as usual. A checksum
+// mismatch on an applied file stops the run: the ledger no longer describes
+// what the file would do.
+func migrate(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	files, err := readMigrations(cfg.migrationsDir, out)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.migrateStatus {
+		applied, err := readLedger(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		pending, ledger := compareLedger(files, applied)
+		return map[string]interface{}{"applied": ledger, "pending": pendingList(pending)}, nil
+	}
+	if cfg.readOnly {
+		return nil, &readOnlyError{"data_type=migrate without status=true"}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+	defer conn.Close()
+	// One run at a time per database; a second one fails at once rather
+	// than applying the same files after the first.
+	lockCfg := cfg
+	lockCfg.lockName, lockCfg.lockTimeout = migrationsTable+":"+cfg.dbname, 0
+	if len(lockCfg.lockName) > maxLockName {
+		lockCfg.lockName = lockCfg.lockName[:maxLockName]
+	}
+	release, err := acquireAdvisoryLock(ctx, conn, lockCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := readLedger(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	pending, ledger := compareLedger(files, applied)
+	var current int64
+	for _, a := range ledger {
+		if a.ChecksumMismatch {
+			return nil, fmt.Errorf("%s was changed after it was applied (checksum mismatch); restore it or add a new migration", a.Filename)
+		}
+		if a.Missing {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("applied migration %s is no longer in migrations_dir", a.Filename))
+		}
+		current = a.Version
+	}
+	if cfg.rollbackRequested {
+		return rollbackMigrations(ctx, conn, cfg, files, ledger, out)
+	}
+
+	runs := []migrationRun{}
+	if cfg.baselineVersion > 0 {
+		var entries int
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM `"+migrationsTable+"`").Scan(&entries); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", migrationsTable, err)
+		}
+		if entries > 0 {
+			return nil, fmt.Errorf("baseline_version adopts a database without migration history, but %s already has %d entries", migrationsTable, entries)
+		}
+		var rest []migrationFile
+		for _, f := range pending {
+			if f.Version > cfg.baselineVersion {
+				rest = append(rest, f)
+				continue
+			}
+			if _, err := conn.ExecContext(ctx,
+				"INSERT INTO `"+migrationsTable+"` (version, filename, checksum, duration_ms, applied_at, direction) VALUES (?, ?, ?, 0, NOW(), 'baseline')",
+				f.Version, f.Filename, f.Checksum); err != nil {
+				return nil, fmt.Errorf("%s: failed to record the baseline: %v", f.Filename, err)
+			}
+			runs = append(runs, migrationRun{Version: f.Version, Filename: f.Filename, Direction: "baseline", Statements: len(f.stmts)})
+			current = f.Version
+		}
+		pending = rest
+	}
+	for _, f := range pending {
+		if f.Version < current {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s is older than the applied version %d and runs out of order", f.Filename, current))
+		}
+		run, err := applyMigration(ctx, conn, f.Version, f.Filename, f.Checksum, f.stmts, "up")
+		if err != nil {
+			return nil, fmt.Errorf("%v (%d earlier migration(s) of this run were applied)", err, len(runs))
+		}
+		runs = append(runs, run)
+		if f.Version > current {
+			current = f.Version
+		}
+	}
+	if len(runs) == 0 && len(pending) == 0 {
+		out.Warnings = append(out.Warnings, "no pending migrations")
+	}
+	return map[string]interface{}{"applied": runs, "current_version": current}, nil
+}
+
+// rollbackMigrations implements rollback_to: the applied versions above it
+// are undone newest first with their down files. Every one of them needs a
+// down file, checked before anything runs.
+func rollbackMigrations(ctx context.Context, conn *sql.Conn, cfg settings, files []migrationFile, ledger []appliedMigration, out *Output) (interface{}, error) {
+	byVersion := map[int64]migrationFile{}
+	for _, f := range files {
+		byVersion[f.Version] = f
+	}
+	var current int64
+	var undo []migrationFile
+	for i := len(ledger) - 1; i >= 0; i-- {
+		a := ledger[i]
+		if a.Version <= cfg.rollbackTo {
+			if current == 0 {
+				current = a.Version
+			}
+			continue
+		}
+		f, ok := byVersion[a.Version]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("cannot roll back past %s: the file is no longer in migrations_dir", a.Filename)
+		case a.Direction == "baseline":
+			return nil, fmt.Errorf("cannot roll back past %s: it was recorded by baseline_version, not run", a.Filename)
+		case f.DownFile == "":
+			return nil, fmt.Errorf("cannot roll back past %s: it has no down file", a.Filename)
+		}
+		undo = append(undo, f)
+	}
+	if len(undo) == 0 {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("no applied migrations above version %d to roll back", cfg.rollbackTo))
+	}
+	runs := []migrationRun{}
+	for _, f := range undo {
+		run, err := applyMigration(ctx, conn, f.Version, f.DownFile, f.downChecksum, f.downStmts, "down")
+		if err != nil {
+			return nil, fmt.Errorf("%v (%d earlier rollback(s) of this run were applied)", err, len(runs))
+		}
+		runs = append(runs, run)
+	}
+	return map[string]interface{}{"rolled_back": runs, "current_version": current}, nil
+}
+
+// applyMigration runs one script and records it in the ledger in direction,
+// inside one transaction when the statements allow it.
+func applyMigration(ctx context.Context, conn *sql.Conn, version int64, filename, checksum string, stmts []string, direction string) (migrationRun, error) {
+	run := migrationRun{Version: version, Filename: filename, Direction: direction, Statements: len(stmts), Transactional: transactional(stmts)}
+	started := time.Now()
+	var q execer = conn
+	var tx *sql.Tx
+	if run.Transactional {
+		var err error
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return run, fmt.Errorf("%s: failed to begin transaction: %v", filename, err)
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+	for i, stmt := range stmts {
+		if _, err := q.ExecContext(ctx, stmt); err != nil {
+			if !run.Transactional && i > 0 {
+				return run, fmt.Errorf("%s: statement %d: %w; the file contains DDL, so statements 1-%d stay applied and the file is not recorded", filename, i+1, err, i)
+			}
+			return run, fmt.Errorf("%s: statement %d: %w", filename, i+1, err)
+		}
+	}
+	run.DurationMS = time.Since(started).Milliseconds()
+	if _, err := q.ExecContext(ctx,
+		"INSERT INTO `"+migrationsTable+"` (version, filename, checksum, duration_ms, applied_at, direction) VALUES (?, ?, ?, ?, NOW(), ?)",
+		version, filename, checksum, run.DurationMS, direction); err != nil {
+		return run, fmt.Errorf("%s: failed to record the migration: %v", filename, err)
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return run, fmt.Errorf("%s: commit failed: %v", filename, err)
+		}
+	}
+	return run, nil
+}
+
+func pendingList(files []migrationFile) []migrationFile {
+	if files == nil {
+		return []migrationFile{}
+	}
+	return files
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// nesting groups columns into nested objects, either by splitting names on
+// a separator (customer__city -> customer.city, any depth) or by a map of
+// column prefixes to object names ({"customer_": "customer"}, one level).
+type nesting struct {
+	separator string
+	prefixes  map[string]string
+	collapse  bool // an object whose values are all NULL becomes null
+
+	paths map[string][]string // per column, from the first row
+}
+
+func newNesting(cfg settings) (*nesting, error) {
+	if !cfg.nestByPrefix {
+		return nil, nil
+	}
+	n := &nesting{separator: cfg.nestSeparator, collapse: cfg.nullCollapse}
+	if n.separator == "" {
+		n.separator = "__"
+	}
+	if cfg.nestPrefixes != "" {
+		if err := json.Unmarshal([]byte(cfg.nestPrefixes), &n.prefixes); err != nil {
+			return nil, fmt.Errorf("nest_prefixes must be a JSON object of column prefix to object name: %v", err)
+		}
+		for prefix, name := range n.prefixes {
+			if prefix == "" || name == "" {
+				return nil, fmt.Errorf("nest_prefixes: prefixes and object names must not be empty")
+			}
+		}
+	}
+	return n, nil
+}
+
+// plan decides each column's path. A group whose name is also a plain
+// column cannot be created; its columns stay flat and a warning says so.
+func (n *nesting) plan(columns []string) []string {
+	sort.Strings(columns)
+	// Longest prefix first so customer_addr_ wins over customer_.
+	prefixes := sortedKeys(n.prefixes)
+	sort.SliceStable(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	n.paths = make(map[string][]string, len(columns))
+	for _, col := range columns {
+		path := []string{col}
+		if n.prefixes != nil {
+			for _, prefix := range prefixes {
+				if rest := strings.TrimPrefix(col, prefix); rest != col && rest != "" {
+					path = []string{n.prefixes[prefix], rest}
+					break
+				}
+			}
+		} else if parts := strings.Split(col, n.separator); len(parts) > 1 && !containsEmpty(parts) {
+			path = parts
+		}
+		n.paths[col] = path
+	}
+
+	var warnings []string
+	for _, col := range columns {
+		path := n.paths[col]
+		for i := 1; i < len(path); i++ {
+			if conflict := n.leafAt(path[:i]); conflict != "" {
+				warnings = append(warnings, fmt.Sprintf("nest_by_prefix: %q stays flat because column %q already holds %q", col, conflict, strings.Join(path[:i], ".")))
+				n.paths[col] = []string{col}
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// leafAt returns the column that is a plain value at path, if any.
+func (n *nesting) leafAt(path []string) string {
+	for col, p := range n.paths {
+		if len(p) == len(path) && strings.Join(p, "\x00") == strings.Join(path, "\x00") {
+			return col
+		}
+	}
+	return ""
+}
+
+func containsEmpty(parts []string) bool {
+	for _, p := range parts {
+		if p == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *nesting) apply(row map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		path, ok := n.paths[col]
+		if !ok || len(path) == 1 {
+			out[col] = v
+			continue
+		}
+		m := out
+		for _, key := range path[:len(path)-1] {
+			child, ok := m[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				m[key] = child
+			}
+			m = child
+		}
+		m[path[len(path)-1]] = v
+	}
+	if n.collapse {
+		collapseNulls(out)
+	}
+	return out
+}
+
+// collapseNulls replaces nested objects whose values are all NULL (the
+// unmatched side of a LEFT JOIN) with null and reports whether m itself is
+// all NULL.
+func collapseNulls(m map[string]interface{}) bool {
+	allNull := true
+	for key, v := range m {
+		if child, ok := v.(map[string]interface{}); ok {
+			if collapseNulls(child) {
+				m[key] = nil
+			} else {
+				allNull = false
+			}
+		} else if v != nil {
+			allNull = false
+		}
+	}
+	return allNull
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBlockSize caps block_size for data_type=next_number.
+const maxBlockSize = 10000
+
+// The counters table of next_number has one row per series, keyed by a
+// unique series_column, with counter_column holding the last number
+// allocated.
+const (
+	defaultSeriesColumn  = "series"
+	defaultCounterColumn = "last_number"
+)
+
+// yearPlaceholder in series or number_prefix is replaced with the current
+// year. A series like INVOICE-{year} then starts over at series_start each
+// January, as its row for the new year does not exist yet.
+const yearPlaceholder = "{year}"
+
+// allocation is the result of next_number. Number and Numbers carry
+// number_prefix and number_padding; First and Last are the raw counter
+// values.
+type allocation struct {
+	Series  string   `json:"series"`
+	First   int64    `json:"first"`
+	Last    int64    `json:"last"`
+	Number  string   `json:"number"`
+	Numbers []string `json:"numbers,omitempty"` // every number, when block_size is above 1
+	Created bool     `json:"created,omitempty"` // the series row did not exist yet
+}
+
+// formatNumber renders n as a document number.
+func formatNumber(cfg settings, n int64, year string) string {
+	digits := strconv.FormatInt(n, 10)
+	if pad := cfg.numberPadding - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	return strings.ReplaceAll(cfg.numberPrefix, yearPlaceholder, year) + digits
+}
+
+// nextNumber implements data_type=next_number: block_size consecutive
+// numbers of series are allocated from the counters table object_name in
+// one transaction, the series row locked with SELECT ... FOR UPDATE so
+// concurrent callers queue on it instead of drawing the same numbers. A
+// missing series row is created so that its first number is series_start.
+// The counter only moves when the transaction commits, so a failed call
+// leaves no gap; with idempotency_key a retry of a call that did commit
+// gets the same numbers back.
+func nextNumber(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	seriesCol, counterCol := defaultSeriesColumn, defaultCounterColumn
+	if cfg.seriesColumn != "" {
+		seriesCol = cfg.seriesColumn
+	}
+	if cfg.counterColumn != "" {
+		counterCol = cfg.counterColumn
+	}
+	seriesQ, err := columnIdent(seriesCol)
+	if err != nil {
+		return nil, err
+	}
+	counterQ, err := columnIdent(counterCol)
+	if err != nil {
+		return nil, err
+	}
+	block, start := int64(cfg.blockSize), cfg.seriesStart
+	if block == 0 {
+		block = 1
+	}
+	if !cfg.seriesStartSet {
+		start = 1
+	}
+	year := strconv.Itoa(time.Now().Year())
+	series := strings.ReplaceAll(cfg.series, yearPlaceholder, year)
+
+	if err := ensureIdempotencyTable(ctx, db, cfg); err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if cfg.idempotencyKey != "" {
+		hash := idempotencyHash("next_number", []interface{}{cfg.objectName, series, block})
+		replay, err := claimIdempotencyKey(ctx, tx, cfg, hash)
+		if err != nil {
+			return nil, err
+		}
+		if replay != nil {
+			out.IdempotentReplay = true
+			return replay, nil
+		}
+	}
+
+	result := &allocation{Series: series}
+	selectSQL, _ := new(stmtBuilder).text("SELECT ").ident(counterQ).text(" FROM ").ident(table).text(" WHERE ").ident(seriesQ).text(" = ? FOR UPDATE").build()
+	last, found, err := lockCounter(ctx, tx, selectSQL, series)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// A concurrent caller creating the same series makes this insert
+		// wait for its commit; the update then leaves its row as it is.
+		insertSQL, args := new(stmtBuilder).text("INSERT INTO ").ident(table).text(" (").idents([]ident{seriesQ, counterQ}).text(") VALUES (").
+			values([]interface{}{series, start - 1}).text(") ON DUPLICATE KEY UPDATE ").ident(seriesQ).text(" = ").ident(seriesQ).build()
+		res, err := tx.ExecContext(ctx, insertSQL, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create series %q: %w", series, err)
+		}
+		n, _ := res.RowsAffected()
+		result.Created = n == 1
+		if last, found, err = lockCounter(ctx, tx, selectSQL, series); err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("series %q was created but cannot be read back", series)
+		}
+	}
+	if last > 1<<63-1-block {
+		return nil, fmt.Errorf("series %q is exhausted: %d numbers cannot follow %d", series, block, last)
+	}
+	result.First, result.Last = last+1, last+block
+	// The lock already keeps the counter at last; the condition makes sure
+	// of it where the table does not lock rows, as with MyISAM.
+	updateSQL, args := new(stmtBuilder).text("UPDATE ").ident(table).text(" SET ").ident(counterQ).text(" = ?").arg(result.Last).
+		text(" WHERE ").ident(seriesQ).text(" = ?").arg(series).text(" AND ").ident(counterQ).text(" = ?").arg(last).build()
+	res, err := tx.ExecContext(ctx, updateSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to advance series %q: %w", series, err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return nil, fmt.Errorf("series %q changed while it was locked; object_name must use a storage engine with row locks, such as InnoDB", series)
+	}
+
+	result.Number = formatNumber(cfg, result.First, year)
+	if block > 1 {
+		result.Numbers = make([]string, 0, block)
+		for n := result.First; n <= result.Last; n++ {
+			result.Numbers = append(result.Numbers, formatNumber(cfg, n, year))
+		}
+	}
+	if cfg.idempotencyKey != "" {
+		if err := storeIdempotentResult(ctx, tx, cfg, result); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %v", err)
+	}
+	return result, nil
+}
+
+// lockCounter reads and locks the counter of series. More than one row
+// means series_column is not unique, and numbers could be handed out twice.
+func lockCounter(ctx context.Context, tx *sql.Tx, selectSQL, series string) (int64, bool, error) {
+	rows, err := tx.QueryContext(ctx, selectSQL, series)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to lock series %q: %w", series, err)
+	}
+	defer rows.Close()
+	var last sql.NullInt64
+	found := false
+	for rows.Next() {
+		if found {
+			return 0, false, fmt.Errorf("series %q has more than one row; series_column needs a unique key", series)
+		}
+		if err := rows.Scan(&last); err != nil {
+			return 0, false, fmt.Errorf("series %q: the counter must be an integer: %v", series, err)
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, false, err
+	}
+	if found && !last.Valid {
+		return 0, false, fmt.Errorf("series %q: the counter is NULL", series)
+	}
+	return last.Int64, found, nil
+}
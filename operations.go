@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// operation implements a data_type that needs more than one generated
+// statement. It may append warnings to out; the returned value becomes the
+// result.
+type operation func(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error)
+
+var operations = map[string]operation{
+	"check_privileges":    checkPrivileges,
+	"create_table":        createTable,
+	"truncate":            truncateTable,
+	"drop_table":          dropTable,
+	"steps":               runSteps,
+	"create_view":         createView,
+	"materialize_view":    materializeView,
+	"kv_get":              kvGet,
+	"kv_set":              kvSet,
+	"fk_graph":            fkGraph,
+	"integrity_check":     integrityCheckOp,
+	"duplicates":          findDuplicates,
+	"pivot":               pivot,
+	"unpivot":             unpivot,
+	"next_sequence_value": nextSequenceValue,
+	"result_schema":       resultSchema,
+	"copy_table":          copyTable,
+	"changes":             changes,
+	"binlog_position":     binlogPositionOp,
+	"idempotency_cleanup": idempotencyCleanup,
+	"list_events":         listEvents,
+	"list_tables":         listTables,
+	"list_triggers":       listTriggers,
+	"show_trigger":        showTrigger,
+	"create_trigger":      createTrigger,
+	"drop_trigger":        dropTrigger,
+	"create_user":         createUser,
+	"grant":               grantPrivileges,
+	"revoke":              grantPrivileges,
+	"drop_user":           dropUser,
+	"create_database":     createDatabase,
+	"drop_database":       dropDatabase,
+	"migrate":             migrate,
+	"maintain_table":      maintainTable,
+	"partitions":          partitions,
+	"purge":               purge,
+	"archive":             archive,
+	"anonymize":           anonymize,
+	"generate":            generate,
+	"lint":                lintOperation,
+	"validate":            validateStatement,
+	"diff":                diff,
+	"assert":              assertChecks,
+	"next_number":         nextNumber,
+	"claim":               claim,
+	"tree":                tree,
+	"create_event":        createEvent,
+	"alter_event":         alterEvent,
+	"describe":            describe,
+}
+
+// readOperations lists the operations that remain available with
+// read_only=true.
+var readOperations = map[string]bool{
+	"check_privileges": true,
+	"kv_get":           true,
+	"fk_graph":         true, // checks read_only itself before executing a plan
+	"integrity_check":  true, // likewise before applying a fix
+	"duplicates":       true,
+	"pivot":            true,
+	"unpivot":          true,
+	"result_schema":    true,
+	"changes":          true,
+	"binlog_position":  true,
+	"list_events":      true,
+	"list_tables":      true,
+	"migrate":          true, // checks read_only itself unless status=true
+	"maintain_table":   true, // likewise unless operation=check
+	"partitions":       true, // likewise unless operation=list
+	"purge":            true, // likewise unless dry_run
+	"archive":          true, // likewise unless dry_run
+	"anonymize":        true, // likewise unless dry_run
+	"generate":         true, // likewise unless dry_run
+	"lint":             true, // the query is checked, never run
+	"validate":         true, // prepared and closed, never executed
+	"diff":             true, // two SELECTs
+	"assert":           true, // SELECTs only
+	"tree":             true,
+	"list_triggers":    true,
+	"show_trigger":     true,
+	"describe":         true, // DESCRIBE or EXPLAIN, never the statement
+}
+
+// readOnlyError is returned when read_only=true forbids a write or DDL.
+type readOnlyError struct{ what string }
+
+func (e *readOnlyError) Error() string {
+	return fmt.Sprintf("read_only is set: refusing to run %s", e.what)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// columnOrder is preserve_column_order for the current invocation: rows
+// are encoded with their keys in the order the server returned the
+// columns, instead of the sorted order of a Go map. Keys renamed by
+// normalize_show, transform or column_case keep the place of their
+// column, a _truncated flag follows its column, and keys that are no
+// column, such as nested objects, come last in sorted order.
+type columnOrder struct {
+	cfg  settings
+	rank map[string]int // key -> position, two per column
+}
+
+func newColumnOrder(cfg settings) *columnOrder {
+	if !cfg.preserveColumnOrder {
+		return nil
+	}
+	return &columnOrder{cfg: cfg}
+}
+
+// record notes the columns of a result. The first result scanned fixes
+// the order.
+func (o *columnOrder) record(columns []string) {
+	if o == nil || o.rank != nil {
+		return
+	}
+	show := newShowNormalizer(o.cfg)
+	t, _ := parseTransform(o.cfg.transform)
+	o.rank = make(map[string]int, 2*len(columns))
+	for i, col := range columns {
+		names := []string{col}
+		if show != nil {
+			name := strings.ToLower(col)
+			if key, ok := show.family.keys[name]; ok {
+				name = key
+			}
+			names = append(names, name)
+		}
+		if t != nil && t.Rename[col] != "" {
+			names = append(names, t.Rename[col])
+		}
+		for _, name := range names {
+			names = append(names, convertCase(name, o.cfg.columnCase))
+		}
+		for _, name := range names {
+			if _, ok := o.rank[name]; !ok {
+				o.rank[name] = 2 * i
+			}
+			if _, ok := o.rank[name+"_truncated"]; !ok {
+				o.rank[name+"_truncated"] = 2*i + 1
+			}
+		}
+	}
+}
+
+// row returns row for encoding, ordered when preserve_column_order is set.
+func (o *columnOrder) row(row map[string]interface{}) interface{} {
+	if o == nil {
+		return row
+	}
+	return orderedRow{row, o}
+}
+
+// rows returns rows for encoding, ordered when preserve_column_order is
+// set.
+func (o *columnOrder) rows(rows []map[string]interface{}) interface{} {
+	if o == nil {
+		return rows
+	}
+	ordered := make([]orderedRow, len(rows))
+	for i, row := range rows {
+		ordered[i] = orderedRow{row, o}
+	}
+	return ordered
+}
+
+// unorderedRows returns the rows of a result whether or not rows put them in
+// column order.
+func unorderedRows(result interface{}) ([]map[string]interface{}, bool) {
+	switch rows := result.(type) {
+	case []map[string]interface{}:
+		return rows, true
+	case []orderedRow:
+		plain := make([]map[string]interface{}, len(rows))
+		for i, row := range rows {
+			plain[i] = row.values
+		}
+		return plain, true
+	}
+	return nil, false
+}
+
+// orderedRow is a row encoded with its keys in column order.
+type orderedRow struct {
+	values map[string]interface{}
+	order  *columnOrder
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	keys := sortedKeys(r.values)
+	sort.SliceStable(keys, func(i, j int) bool {
+		ri, iok := r.order.rank[keys[i]]
+		rj, jok := r.order.rank[keys[j]]
+		if iok != jok {
+			return iok
+		}
+		return iok && ri < rj
+	})
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
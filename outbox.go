@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultMaxOutboxPayload bounds the event payload unless max_payload_bytes
+// is set, well below the server's max_allowed_packet.
+const defaultMaxOutboxPayload = 1 << 20
+
+// outboxSpec is the outbox input: an event row written to Table in the
+// transaction of the business write. The table is expected to have the
+// columns event_type, payload and created_at, and an auto-increment id.
+type outboxSpec struct {
+	Table           string          `json:"table"`
+	EventType       string          `json:"event_type"`
+	PayloadFrom     string          `json:"payload_from"` // rows (default) or custom
+	Payload         json.RawMessage `json:"payload"`
+	MaxPayloadBytes int             `json:"max_payload_bytes"`
+}
+
+func parseOutbox(raw string) (*outboxSpec, error) {
+	var spec outboxSpec
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("outbox must be a JSON object of {\"table\", \"event_type\", \"payload_from\", \"payload\", \"max_payload_bytes\"}: %v", err)
+	}
+	if _, err := quoteQualifiedIdent(spec.Table); err != nil {
+		return nil, fmt.Errorf("outbox table: %v", err)
+	}
+	if spec.EventType == "" {
+		return nil, fmt.Errorf("outbox event_type is required")
+	}
+	switch spec.PayloadFrom {
+	case "", "rows":
+		spec.PayloadFrom = "rows"
+		if len(spec.Payload) > 0 {
+			return nil, fmt.Errorf("outbox payload requires payload_from=custom")
+		}
+	case "custom":
+		if len(spec.Payload) == 0 {
+			return nil, fmt.Errorf("outbox payload is required with payload_from=custom")
+		}
+	default:
+		return nil, fmt.Errorf("outbox payload_from must be rows or custom, got %q", spec.PayloadFrom)
+	}
+	if spec.MaxPayloadBytes < 0 {
+		return nil, fmt.Errorf("outbox max_payload_bytes must not be negative")
+	}
+	if spec.MaxPayloadBytes == 0 {
+		spec.MaxPayloadBytes = defaultMaxOutboxPayload
+	}
+	return &spec, nil
+}
+
+// outboxLock holds the primary keys of the rows an update is about to change.
+type outboxLock struct {
+	columns []ident
+	keys    [][]interface{}
+}
+
+// lockOutboxRows locks the rows an update with payload_from=rows is about
+// to change and returns their primary keys, so they can be read back
+// afterwards even when the update changes the columns its filter matched.
+// Other writes need no lock and get nil.
+func lockOutboxRows(ctx context.Context, tx *sql.Tx, cfg settings, spec *outboxSpec) (*outboxLock, error) {
+	if spec == nil || spec.PayloadFrom != "rows" || cfg.dataType != "update" {
+		return nil, nil
+	}
+	columns, keys, err := outboxKeys(ctx, tx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &outboxLock{columns, keys}, nil
+}
+
+// recordOutbox writes the event for the business write that returned
+// result and returns the outbox row id.
+func recordOutbox(ctx context.Context, tx *sql.Tx, cfg settings, spec *outboxSpec, lock *outboxLock, result interface{}) (int64, error) {
+	var rows []map[string]interface{}
+	var err error
+	switch {
+	case spec.PayloadFrom == "custom":
+	case cfg.dataType == "insert":
+		rows, err = outboxInsertedRows(ctx, tx, cfg, result)
+	case cfg.dataType == "update":
+		rows, err = outboxUpdatedRows(ctx, tx, cfg, lock.columns, lock.keys)
+	default:
+		// steps: the result of the last step kept.
+		var ok bool
+		if rows, ok = unorderedRows(result); !ok {
+			err = fmt.Errorf("outbox payload_from=rows needs the last step not marked discard_result to return rows")
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return writeOutbox(ctx, tx, spec, rows)
+}
+
+func outboxKeys(ctx context.Context, tx *sql.Tx, cfg settings) ([]ident, [][]interface{}, error) {
+	keys, err := primaryKeyColumns(ctx, tx, cfg.objectName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the primary key of %s: %v", cfg.objectName, err)
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("outbox payload_from=rows needs a primary key on %s to read the updated rows back", cfg.objectName)
+	}
+	table, _ := tableIdent(cfg.objectName)
+	quoted, _ := columnIdents(keys)
+	f, err := writeFilter(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	query, args := new(stmtBuilder).text("SELECT ").idents(quoted).text(" FROM ").ident(table).where(f).text(" FOR UPDATE").build()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock the rows to update: %v", err)
+	}
+	defer rows.Close()
+	var values [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, len(keys))
+		targets := make([]interface{}, len(keys))
+		for i := range row {
+			targets[i] = &row[i]
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, nil, err
+		}
+		values = append(values, row)
+	}
+	return quoted, values, rows.Err()
+}
+
+// outboxUpdatedRows reads the rows identified by outboxKeys.
+func outboxUpdatedRows(ctx context.Context, tx *sql.Tx, cfg settings, columns []ident, keys [][]interface{}) ([]map[string]interface{}, error) {
+	if len(keys) == 0 {
+		return []map[string]interface{}{}, nil
+	}
+	table, _ := tableIdent(cfg.objectName)
+	query, args := new(stmtBuilder).text("SELECT * FROM ").ident(table).text(" WHERE ").keyIn(columns, keys).build()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the updated rows: %v", err)
+	}
+	defer rows.Close()
+	return scanRows(rows, cfg)
+}
+
+// outboxInsertedRows returns the rows RETURNING read back where the server
+// has it, and otherwise rebuilds them from values, filling in the
+// auto-increment column from the first generated id: the ids of one
+// multi-row INSERT follow each other auto_increment_increment apart.
+func outboxInsertedRows(ctx context.Context, tx *sql.Tx, cfg settings, result interface{}) ([]map[string]interface{}, error) {
+	if m, ok := result.(map[string]interface{}); ok {
+		if rows, ok := unorderedRows(m["returning"]); ok {
+			return rows, nil
+		}
+	}
+	cols, values, err := parseInsertRows(cfg.values)
+	if err == nil {
+		cols, values, err = scopeInsertRows(cfg, cols, values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var firstID int64
+	if m, ok := result.(map[string]int64); ok {
+		firstID = m["last_insert_id"]
+	}
+	var auto string
+	if firstID > 0 {
+		parts := splitQualified(cfg.objectName)
+		schema := interface{}(nil)
+		if len(parts) == 2 {
+			schema = parts[0]
+		}
+		names, err := queryStrings(ctx, tx,
+			"SELECT column_name FROM information_schema.columns WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? AND extra LIKE '%auto_increment%'",
+			schema, parts[len(parts)-1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the auto-increment column of %s: %v", cfg.objectName, err)
+		}
+		if len(names) == 1 && !containsString(cols, names[0]) {
+			auto = names[0]
+		}
+	}
+	increment := int64(1)
+	if auto != "" && len(values) > 1 {
+		if err := tx.QueryRowContext(ctx, "SELECT @@SESSION.auto_increment_increment").Scan(&increment); err != nil {
+			return nil, fmt.Errorf("failed to read auto_increment_increment: %v", err)
+		}
+	}
+	rows := make([]map[string]interface{}, len(values))
+	for i, row := range values {
+		rows[i] = make(map[string]interface{}, len(cols)+1)
+		for j, col := range cols {
+			rows[i][col] = row[j]
+		}
+		if auto != "" {
+			rows[i][auto] = firstID + int64(i)*increment
+		}
+	}
+	return rows, nil
+}
+
+// writeOutbox inserts the event row and returns its id. A row value without
+// a JSON form fails the write instead of producing a partial event.
+func writeOutbox(ctx context.Context, tx *sql.Tx, spec *outboxSpec, rows []map[string]interface{}) (int64, error) {
+	payload := []byte(spec.Payload)
+	if spec.PayloadFrom == "rows" {
+		var err error
+		if payload, err = json.Marshal(rows); err != nil {
+			return 0, fmt.Errorf("outbox: failed to encode the affected rows: %v", err)
+		}
+	}
+	if len(payload) > spec.MaxPayloadBytes {
+		return 0, fmt.Errorf("outbox payload is %d bytes, over max_payload_bytes (%d)", len(payload), spec.MaxPayloadBytes)
+	}
+	table, _ := tableIdent(spec.Table)
+	query, args := new(stmtBuilder).text("INSERT INTO ").ident(table).text(" (event_type, payload, created_at) VALUES (?, ?, NOW())").arg(spec.EventType, string(payload)).build()
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("outbox: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	return id, nil
+}
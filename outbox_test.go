@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// insertedRows runs outboxInsertedRows over a stub server whose table has
+// the auto-increment column id and the given auto_increment_increment.
+func insertedRows(t *testing.T, values string, increment string, result interface{}) string {
+	t.Helper()
+	db, stub := openStubDB(t)
+	stub.respond = func(query string) ([]stubColumn, [][]driver.Value) {
+		if strings.Contains(query, "auto_increment_increment") {
+			return []stubColumn{{"increment", "BIGINT"}}, [][]driver.Value{{[]byte(increment)}}
+		}
+		return []stubColumn{{"column_name", "VARCHAR"}}, [][]driver.Value{{[]byte("id")}}
+	}
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	rows, err := outboxInsertedRows(ctx, tx, settings{dataType: "insert", objectName: "invoices", values: values}, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestOutboxInsertedIDs(t *testing.T) {
+	const values = `[{"number": "A"}, {"number": "B"}, {"number": "C"}]`
+	tests := []struct {
+		increment string
+		want      string
+	}{
+		{"1", `[{"id":41,"number":"A"},{"id":42,"number":"B"},{"id":43,"number":"C"}]`},
+		// Galera and multi-primary setups space the ids.
+		{"3", `[{"id":41,"number":"A"},{"id":44,"number":"B"},{"id":47,"number":"C"}]`},
+	}
+	for _, tt := range tests {
+		result := map[string]int64{"last_insert_id": 41, "rows_affected": 3}
+		if got := insertedRows(t, values, tt.increment, result); got != tt.want {
+			t.Errorf("auto_increment_increment=%s: rows %s, want %s", tt.increment, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxReturningRows(t *testing.T) {
+	returned := []map[string]interface{}{{"id": int64(7), "number": "A"}, {"id": int64(9), "number": "B"}}
+	const want = `[{"id":7,"number":"A"},{"id":9,"number":"B"}]`
+	order := newColumnOrder(settings{preserveColumnOrder: true})
+	order.record([]string{"number", "id"})
+	for name, rows := range map[string]interface{}{"plain": returned, "preserve_column_order": order.rows(returned)} {
+		result := map[string]interface{}{"rows_affected": int64(2), "returning": rows}
+		if got := insertedRows(t, `[{"number": "A"}, {"number": "B"}]`, "1", result); got != want {
+			t.Errorf("%s: rows %s, want the RETURNING rows %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// unstablePagination reports whether query reads a page, with OFFSET or
+// LIMIT offset, count, but does not order the rows it pages through. Without
+// a top-level ORDER BY the server may return the rows in a different order
+// for every page, so rows repeat or go missing between pages.
+//
+// Only the outermost statement counts: an ORDER BY inside a subquery, a
+// derived table or OVER () orders nothing the LIMIT sees, while the final
+// ORDER BY of a UNION orders the whole result. A LIMIT without an offset
+// reads the first rows only and is not reported.
+func unstablePagination(query string) bool {
+	depth := 0
+	ordered, paged, inLimit := false, false, false
+	var prev string
+	for _, tok := range tokenize(query) {
+		switch tok.kind {
+		case tokSpace, tokComment:
+			continue
+		case tokPunct:
+			switch tok.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case ",":
+				if depth == 0 && inLimit {
+					paged = true
+				}
+			}
+		case tokWord:
+			if depth != 0 {
+				break
+			}
+			word := strings.ToUpper(tok.text)
+			switch {
+			case word == "BY" && prev == "ORDER":
+				ordered = true
+			case word == "LIMIT":
+				inLimit = true
+				continue
+			case word == "OFFSET" && inLimit:
+				paged = true
+			case word == "UNION" || word == "INTERSECT" || word == "EXCEPT":
+				// The ORDER BY of a part before it orders that part only.
+				ordered = false
+			}
+			prev = word
+		}
+		if tok.kind != tokNumber && tok.kind != tokPlaceholder && tok.text != "," {
+			inLimit = false
+		}
+	}
+	return paged && !ordered
+}
+
+// maxPageRows stands for no limit in a page with only an offset, which
+// MySQL cannot express otherwise.
+const maxPageRows = "18446744073709551615"
+
+// tablePage is a page of data_type=table, read with limit and offset. Its
+// rows are ordered by the filter's distance, if any, and then by the
+// primary key, which orderTablePage looks up once connected, so every page
+// sees the rows in the same order.
+type tablePage struct {
+	head          string  // SELECT ... FROM ... WHERE ...
+	order         []ident // columns ordering the rows before the key
+	limit, offset int64
+}
+
+// statement renders the page ordered by its columns and then keys.
+func (p *tablePage) statement(keys []ident) statement {
+	b := new(stmtBuilder).text(sqlText(p.head))
+	for i, col := range append(append([]ident(nil), p.order...), keys...) {
+		if i == 0 {
+			b.text(" ORDER BY ")
+		} else {
+			b.text(", ")
+		}
+		b.ident(col)
+	}
+	b.text(" LIMIT ")
+	if p.limit > 0 {
+		b.number(p.limit)
+	} else {
+		b.text(maxPageRows)
+	}
+	b.text(" OFFSET ").number(p.offset)
+	stmt := b.statement(true)
+	stmt.page = p
+	return stmt
+}
+
+// orderTablePage orders a table page by the primary key of object_name. A
+// table without one is paged as it is, with unstable_pagination set.
+func orderTablePage(ctx context.Context, q execer, cfg settings, stmt statement, out *Output) (statement, error) {
+	names, err := primaryKeyColumns(ctx, q, cfg.objectName)
+	if err != nil {
+		return stmt, fmt.Errorf("failed to read the primary key of %s: %v", cfg.objectName, err)
+	}
+	if len(names) == 0 {
+		out.UnstablePagination = true
+		out.Warnings = append(out.Warnings, fmt.Sprintf("unstable_pagination: %s has no primary key to order its pages by, so rows may repeat or go missing between pages", cfg.objectName))
+		return stmt, nil
+	}
+	keys := make([]ident, len(names))
+	for i, name := range names {
+		if keys[i], err = columnIdent(name); err != nil {
+			return stmt, err
+		}
+	}
+	paged := stmt.page.statement(keys)
+	paged.Args = stmt.Args
+	return paged, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestUnstablePagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"offset without order", "SELECT * FROM t LIMIT 10 OFFSET 20", true},
+		{"limit offset, count", "SELECT * FROM t LIMIT 20, 10", true},
+		{"placeholders", "SELECT * FROM t LIMIT ?, ?", true},
+		{"ordered", "SELECT * FROM t ORDER BY id LIMIT 10 OFFSET 20", false},
+		{"first page only", "SELECT * FROM t LIMIT 10", false},
+		{"no limit", "SELECT * FROM t", false},
+
+		{"union ordered at the end", "SELECT a FROM t UNION SELECT a FROM u ORDER BY a LIMIT 10 OFFSET 10", false},
+		{"union ordered in a part only", "(SELECT a FROM t ORDER BY a) UNION ALL SELECT a FROM u LIMIT 10 OFFSET 10", true},
+		{"union paged in a part", "(SELECT a FROM t LIMIT 10 OFFSET 10) UNION SELECT a FROM u", false},
+
+		{"order by in a subquery only", "SELECT * FROM t WHERE id IN (SELECT id FROM u ORDER BY id) LIMIT 10 OFFSET 10", true},
+		{"nested subqueries ordered inside", "SELECT * FROM t WHERE a = (SELECT MAX(a) FROM (SELECT a FROM u ORDER BY a LIMIT 3 OFFSET 1) x) LIMIT 5 OFFSET 5", true},
+		{"paged inside nested subqueries only", "SELECT * FROM t WHERE a IN (SELECT a FROM (SELECT a FROM u LIMIT 3 OFFSET 1) x)", false},
+		{"order by inside a derived table", "SELECT * FROM (SELECT * FROM t ORDER BY id) d LIMIT 10 OFFSET 10", true},
+		{"derived table and outer order", "SELECT * FROM (SELECT * FROM t ORDER BY id) d ORDER BY d.id LIMIT 10 OFFSET 10", false},
+		{"order by in a window only", "SELECT a, ROW_NUMBER() OVER (ORDER BY a) FROM t LIMIT 10 OFFSET 10", true},
+
+		{"order by in a comment", "SELECT * FROM t /* ORDER BY id */ LIMIT 10 OFFSET 10", true},
+		{"order by in a string", "SELECT 'ORDER BY id' FROM t LIMIT 10 OFFSET 10", true},
+		{"offset as a column", "SELECT `offset` FROM t LIMIT 10", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unstablePagination(tt.query); got != tt.want {
+				t.Errorf("unstablePagination(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTablePageOrdersByKey(t *testing.T) {
+	id, _ := columnIdent("id")
+	tenant, _ := columnIdent("tenant_id")
+	distance, _ := columnIdent("distance")
+	tests := []struct {
+		page tablePage
+		keys []ident
+		want string
+	}{
+		{tablePage{head: "SELECT * FROM `t`", limit: 10, offset: 20}, []ident{id}, "SELECT * FROM `t` ORDER BY `id` LIMIT 10 OFFSET 20"},
+		{tablePage{head: "SELECT * FROM `t`", limit: 10}, []ident{tenant, id}, "SELECT * FROM `t` ORDER BY `tenant_id`, `id` LIMIT 10 OFFSET 0"},
+		{tablePage{head: "SELECT * FROM `t`", offset: 5}, nil, "SELECT * FROM `t` LIMIT 18446744073709551615 OFFSET 5"},
+		{tablePage{head: "SELECT *, d AS `distance` FROM `t`", order: []ident{distance}, limit: 3}, []ident{id}, "SELECT *, d AS `distance` FROM `t` ORDER BY `distance`, `id` LIMIT 3 OFFSET 0"},
+	}
+	for _, tt := range tests {
+		if got := tt.page.statement(tt.keys).SQL; got != tt.want {
+			t.Errorf("statement = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestTableModePagesThroughTablePage(t *testing.T) {
+	stmt, err := buildStatement(settings{dataType: "table", objectName: "erp.invoices", limit: 50, offset: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.page == nil {
+		t.Fatal("a table page has no tablePage to order once connected")
+	}
+	if want := "SELECT * FROM `erp`.`invoices` LIMIT 50 OFFSET 100"; stmt.SQL != want {
+		t.Errorf("SQL = %q, want %q", stmt.SQL, want)
+	}
+	id, _ := columnIdent("id")
+	if got, want := stmt.page.statement([]ident{id}).SQL, "SELECT * FROM `erp`.`invoices` ORDER BY `id` LIMIT 50 OFFSET 100"; got != want {
+		t.Errorf("ordered SQL = %q, want %q", got, want)
+	}
+}
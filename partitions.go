@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// partitionBoundRe admits the LESS THAN values add_range splices into the
+// DDL: integers, MAXVALUE, string literals and a function of one literal
+// such as TO_DAYS('2025-02-01'), comma separated for RANGE COLUMNS. Literals
+// may not contain commas or quotes.
+var partitionBoundRe = regexp.MustCompile(`(?i)^\s*(-?[0-9]+|MAXVALUE|'[^',\\]*'|[A-Z_][A-Z0-9_]*\('[^',\\]*'\))\s*$`)
+
+// partitionInfo is one partition as data_type=partitions lists it.
+type partitionInfo struct {
+	Name       string `json:"name"`
+	Ordinal    int64  `json:"ordinal"`
+	Rows       int64  `json:"rows"`
+	DataLength int64  `json:"data_length"`
+	HighValue  string `json:"high_value"` // LESS THAN, as the server reports it
+}
+
+type partitionLayout struct {
+	Method     string          `json:"method"`
+	Expression string          `json:"expression"`
+	Partitions []partitionInfo `json:"partitions"`
+}
+
+// readPartitions reads the partitions of table; subpartitions are summed
+// into their partition. Only RANGE and RANGE COLUMNS are supported.
+func readPartitions(ctx context.Context, db *database, table string) (*partitionLayout, error) {
+	schema, name := splitTarget(table, "")
+	var schemaArg interface{}
+	if schema != "" {
+		schemaArg = schema
+	}
+	rows, err := db.QueryContext(ctx, `SELECT partition_name, partition_ordinal_position, partition_method, partition_expression, partition_description,
+	SUM(table_rows), SUM(data_length)
+FROM information_schema.partitions
+WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? AND partition_name IS NOT NULL
+GROUP BY partition_name, partition_ordinal_position, partition_method, partition_expression, partition_description
+ORDER BY partition_ordinal_position`, schemaArg, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the partitions of %s: %v", table, err)
+	}
+	defer rows.Close()
+	layout := &partitionLayout{Partitions: []partitionInfo{}}
+	for rows.Next() {
+		var p partitionInfo
+		var expr, desc *string
+		var rowCount, dataLength *int64
+		if err := rows.Scan(&p.Name, &p.Ordinal, &layout.Method, &expr, &desc, &rowCount, &dataLength); err != nil {
+			return nil, err
+		}
+		if expr != nil {
+			layout.Expression = *expr
+		}
+		if desc != nil {
+			p.HighValue = *desc
+		}
+		if rowCount != nil {
+			p.Rows = *rowCount
+		}
+		if dataLength != nil {
+			p.DataLength = *dataLength
+		}
+		layout.Partitions = append(layout.Partitions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(layout.Partitions) == 0 {
+		return nil, fmt.Errorf("unsupported: %s is not partitioned, or does not exist", table)
+	}
+	if layout.Method != "RANGE" && layout.Method != "RANGE COLUMNS" {
+		return nil, fmt.Errorf("unsupported: %s is partitioned by %s; only RANGE and RANGE COLUMNS partitioning are managed", table, layout.Method)
+	}
+	return layout, nil
+}
+
+func validPartitionBound(value string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if !partitionBoundRe.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *partitionLayout) find(name string) bool {
+	for _, p := range l.Partitions {
+		if strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// partitions implements data_type=partitions: operation=list, add_range,
+// drop or truncate_partition. The other operations echo their ALTER TABLE.
+func partitions(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.readOnly && cfg.operation != "list" {
+		return nil, &readOnlyError{"operation=" + cfg.operation}
+	}
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := readPartitions(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.operation == "list" {
+		return layout, nil
+	}
+
+	names := strings.Split(cfg.partitionName, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	quoted, err := columnIdents(names)
+	if err != nil {
+		return nil, err
+	}
+	var ddl string
+	switch cfg.operation {
+	case "add_range":
+		if layout.find(names[0]) {
+			return nil, fmt.Errorf("partition %s already exists on %s", names[0], cfg.objectName)
+		}
+		if err := checkRangeBound(ctx, db, layout, cfg.partitionValue); err != nil {
+			return nil, err
+		}
+		// partition_value was checked by validPartitionBound.
+		ddl, _ = new(stmtBuilder).text("ALTER TABLE ").ident(table).text(" ADD PARTITION (PARTITION ").ident(quoted[0]).
+			text(" VALUES LESS THAN (").text(sqlText(cfg.partitionValue)).text("))").build()
+	case "drop", "truncate_partition":
+		for _, name := range names {
+			if !layout.find(name) {
+				return nil, fmt.Errorf("partition %s does not exist on %s", name, cfg.objectName)
+			}
+		}
+		if cfg.operation == "drop" && len(names) == len(layout.Partitions) {
+			return nil, fmt.Errorf("cannot drop every partition of %s; drop the table instead", cfg.objectName)
+		}
+		var verb sqlText = "DROP"
+		if cfg.operation == "truncate_partition" {
+			verb = "TRUNCATE"
+		}
+		ddl, _ = new(stmtBuilder).text("ALTER TABLE ").ident(table).text(" ").text(verb).text(" PARTITION ").idents(quoted).build()
+	}
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("execution error: %w", err)
+	}
+	after, err := readPartitions(ctx, db, cfg.objectName)
+	if err != nil {
+		return nil, fmt.Errorf("partitions changed but could not be read back: %v", err)
+	}
+	return map[string]interface{}{"ddl": ddl, "partitions": after.Partitions}, nil
+}
+
+// checkRangeBound refuses a new LESS THAN value that does not lie above the
+// last partition, which MySQL rejects too but with a less helpful message.
+// RANGE stores evaluated integers, so the value is evaluated on the server;
+// for RANGE COLUMNS single literals are compared as strings, which orders
+// ISO dates and zero-padded values correctly, and anything else is left to
+// the server.
+func checkRangeBound(ctx context.Context, db *database, layout *partitionLayout, value string) error {
+	value = strings.TrimSpace(value)
+	last := layout.Partitions[len(layout.Partitions)-1].HighValue
+	if strings.EqualFold(strings.TrimSpace(last), "MAXVALUE") {
+		return fmt.Errorf("the last partition already holds MAXVALUE; a new range would need REORGANIZE PARTITION, which this mode does not do")
+	}
+	if strings.EqualFold(strings.TrimSpace(value), "MAXVALUE") {
+		return nil
+	}
+	if layout.Method == "RANGE" {
+		if strings.Contains(value, ",") {
+			return fmt.Errorf("partition_value must be a single value for RANGE partitioning; lists are for RANGE COLUMNS")
+		}
+		var evaluated string
+		query, _ := new(stmtBuilder).text("SELECT ").text(sqlText(value)).build()
+		if err := db.QueryRowContext(ctx, query).Scan(&evaluated); err != nil {
+			return fmt.Errorf("failed to evaluate partition_value: %v", err)
+		}
+		n, err := strconv.ParseInt(evaluated, 10, 64)
+		if err != nil {
+			return fmt.Errorf("partition_value %s evaluates to %q, not an integer", value, evaluated)
+		}
+		if bound, err := strconv.ParseInt(last, 10, 64); err == nil && n <= bound {
+			return fmt.Errorf("partition_value %s (%d) must be above the last boundary %d", value, n, bound)
+		}
+		return nil
+	}
+	if strings.HasPrefix(value, "'") && strings.HasPrefix(last, "'") && !strings.Contains(value, ",") && value <= last {
+		return fmt.Errorf("partition_value %s must be above the last boundary %s", value, last)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configPath is the profile file given with --config; MYSQL_COMPONENT_CONFIG
+// is used when the flag is absent.
+var configPath string
+
+// profile is a named set of connection defaults. String values may be secret
+// references such as env://DB_PASSWORD or file:///run/secrets/db_password.
+type profile struct {
+	Host           string `json:"host" toml:"host"`
+	Port           int    `json:"port" toml:"port"`
+	Username       string `json:"username" toml:"username"`
+	Password       string `json:"password" toml:"password"`
+	DBName         string `json:"dbname" toml:"dbname"`
+	TLS            string `json:"tls" toml:"tls"`
+	TLSCA          string `json:"tls_ca" toml:"tls_ca"`
+	TLSCert        string `json:"tls_cert" toml:"tls_cert"`
+	TLSKey         string `json:"tls_key" toml:"tls_key"`
+	TLSServerName  string `json:"tls_server_name" toml:"tls_server_name"`
+	ConnectTimeout int    `json:"connect_timeout_seconds" toml:"connect_timeout_seconds"`
+	QueryTimeout   int    `json:"query_timeout_seconds" toml:"query_timeout_seconds"`
+}
+
+type profileFile struct {
+	Profiles map[string]profile `json:"profiles" toml:"profiles"`
+}
+
+// loadProfile reads the config file and returns the named profile. An unknown
+// name lists the available profile names, never their contents.
+func loadProfile(name string) (profile, error) {
+	path := configPath
+	if path == "" {
+		path = os.Getenv("MYSQL_COMPONENT_CONFIG")
+	}
+	if path == "" {
+		return profile{}, fmt.Errorf("profile %q requested but no config file was given (use --config or MYSQL_COMPONENT_CONFIG)", name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return profile{}, fmt.Errorf("failed to read config file: %v", err)
+	}
+	var file profileFile
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &file)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return profile{}, fmt.Errorf("failed to parse config file %s: %v", filepath.Base(path), err)
+	}
+
+	p, ok := file.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(file.Profiles))
+		for n := range file.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return profile{}, fmt.Errorf("unknown profile %q; available profiles: %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// apply copies profile values into settings that were not set explicitly,
+// resolving secret references on the way.
+func (p profile) apply(cfg *settings) error {
+	strFields := []struct {
+		field *string
+		value string
+		name  string
+	}{
+		{&cfg.host, p.Host, "host"},
+		{&cfg.username, p.Username, "username"},
+		{&cfg.password, p.Password, "password"},
+		{&cfg.dbname, p.DBName, "dbname"},
+		{&cfg.tlsMode, strings.ToLower(p.TLS), "tls"},
+		{&cfg.tlsCA, p.TLSCA, "tls_ca"},
+		{&cfg.tlsCert, p.TLSCert, "tls_cert"},
+		{&cfg.tlsKey, p.TLSKey, "tls_key"},
+		{&cfg.tlsServerName, p.TLSServerName, "tls_server_name"},
+	}
+	var firstErr error
+	for _, f := range strFields {
+		if *f.field != "" || f.value == "" {
+			continue
+		}
+		v, err := resolveSecret(f.value)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("profile %s: %v", f.name, err)
+			}
+			continue
+		}
+		*f.field = v
+	}
+
+	if cfg.port == 0 {
+		cfg.port = p.Port
+	}
+	if cfg.connectTimeout == 0 {
+		cfg.connectTimeout = p.ConnectTimeout
+	}
+	if cfg.queryTimeout == 0 {
+		cfg.queryTimeout = p.QueryTimeout
+	}
+	return firstErr
+}
+
+// resolveSecret dereferences env:// and file:// secret references; any other
+// value is returned unchanged.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env://"):
+		name := strings.TrimPrefix(value, "env://")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "file://"):
+		data, err := os.ReadFile(strings.TrimPrefix(value, "file://"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// progress reports how far a long invocation got, as JSON lines written
+// every progress_interval_seconds to stderr or progress_file. The counters
+// are updated where rows are scanned and output is written, so nothing is
+// buffered for it. A final record with done=true follows when the
+// invocation ends, whether or not it failed.
+type progress struct {
+	requestID string
+	started   time.Time
+	w         io.Writer
+	file      *os.File // progress_file, closed by stop
+
+	rows      atomic.Int64
+	bytes     atomic.Int64
+	chunk     atomic.Int64
+	chunks    atomic.Int64
+	watermark atomic.Value // copy_table watermark, see setWatermark
+
+	mu      sync.Mutex // serializes records
+	ticker  *time.Ticker
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// progressReporter is the reporter of the current invocation, nil when
+// progress_interval_seconds is not set.
+var progressReporter *progress
+
+type progressRecord struct {
+	RequestID string      `json:"request_id"`
+	Rows      int64       `json:"rows"`
+	Bytes     int64       `json:"bytes"`
+	ElapsedMS int64       `json:"elapsed_ms"`
+	Chunk     int64       `json:"chunk,omitempty"`
+	Chunks    int64       `json:"chunks,omitempty"`
+	Watermark interface{} `json:"watermark,omitempty"`
+	Done      bool        `json:"done,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// startProgress begins reporting. A progress_file that cannot be opened,
+// such as a named pipe nobody reads, falls back to stderr with a warning.
+func startProgress(cfg settings, out *Output) *progress {
+	p := &progress{requestID: cfg.requestID, started: time.Now(), w: os.Stderr, done: make(chan struct{})}
+	if cfg.progressFile != "" {
+		// O_NONBLOCK keeps opening a pipe without a reader from hanging.
+		f, err := os.OpenFile(cfg.progressFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE|syscall.O_NONBLOCK, 0o600)
+		if err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("progress_file unavailable, reporting to stderr: %v", err))
+		} else {
+			p.w, p.file = f, f
+		}
+	}
+
+	p.ticker = time.NewTicker(time.Duration(cfg.progressInterval) * time.Second)
+	p.stopped.Add(1)
+	go func() {
+		defer p.stopped.Done()
+		for {
+			select {
+			case <-p.ticker.C:
+				p.write(false, "")
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) addRows(n int64) {
+	if p != nil {
+		p.rows.Add(n)
+	}
+}
+
+func (p *progress) setChunk(chunk, chunks int) {
+	if p != nil {
+		p.chunk.Store(int64(chunk))
+		p.chunks.Store(int64(chunks))
+	}
+}
+
+// setWatermark records the resume point of a copy. It is wrapped so values
+// of different types can be stored.
+func (p *progress) setWatermark(v interface{}) {
+	if p != nil {
+		p.watermark.Store(watermarkBox{v})
+	}
+}
+
+type watermarkBox struct{ v interface{} }
+
+// stop ends the reporting with a final record carrying errMsg, if any.
+func (p *progress) stop(errMsg string) {
+	if p == nil {
+		return
+	}
+	p.ticker.Stop()
+	close(p.done)
+	p.stopped.Wait()
+	p.write(true, errMsg)
+	if p.file != nil {
+		p.file.Close()
+	}
+}
+
+func (p *progress) write(done bool, errMsg string) {
+	box, _ := p.watermark.Load().(watermarkBox)
+	data, _ := json.Marshal(progressRecord{
+		RequestID: p.requestID,
+		Rows:      p.rows.Load(),
+		Bytes:     p.bytes.Load(),
+		ElapsedMS: time.Since(p.started).Milliseconds(),
+		Chunk:     p.chunk.Load(),
+		Chunks:    p.chunks.Load(),
+		Watermark: box.v,
+		Done:      done,
+		Error:     errMsg,
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Write(append(data, '\n'))
+}
+
+// writer counts the output bytes written through it.
+func (p *progress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return progressWriter{w: w, p: p}
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.bytes.Add(int64(n))
+	return n, err
+}
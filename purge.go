@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultPurgeBatchSize is the number of rows per DELETE when batch_size is
+// not set: small enough that each batch holds its row locks only briefly.
+const defaultPurgeBatchSize = 1000
+
+// purgeResult reports a purge run. Each batch commits on its own, so a run
+// that stops early has still deleted the rows of its completed batches.
+type purgeResult struct {
+	Batches      []int64 `json:"batches"` // rows deleted per batch
+	RowsDeleted  int64   `json:"rows_deleted"`
+	StoppedEarly bool    `json:"stopped_early"`
+	StopReason   string  `json:"stop_reason,omitempty"` // max_batches, max_runtime or deadline
+}
+
+// purge implements data_type=purge: the delete of object_name by filter,
+// run as DELETE ... LIMIT batch_size until a batch deletes fewer rows than
+// that. With soft_delete_column each batch marks rows instead. Between
+// batches it sleeps sleep_between_batches_ms and stops early once
+// max_batches ran, max_runtime_seconds passed, or the next batch would not
+// finish before query_timeout_seconds. dry_run, on unless set to false,
+// only counts the matching rows.
+func purge(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if cfg.dryRun {
+		table, err := tableIdent(cfg.objectName)
+		if err != nil {
+			return nil, err
+		}
+		f, err := writeFilter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		var n int64
+		query, args := new(stmtBuilder).text("SELECT COUNT(*) FROM ").ident(table).where(f).build()
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+			return nil, fmt.Errorf("execution error: %w", err)
+		}
+		return map[string]interface{}{"dry_run": true, "matching_rows": n}, nil
+	}
+	if cfg.readOnly {
+		return nil, &readOnlyError{"data_type=purge without dry_run"}
+	}
+	b, err := deleteBuilder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.softDeleteColumn != "" {
+		out.SoftDelete = &softDelete{Column: cfg.softDeleteColumn, IncludeDeleted: cfg.includeDeleted}
+	}
+	batchSize := cfg.batchSize
+	if batchSize == 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+	stmt := b.text(" LIMIT ").number(int64(batchSize)).statement(false)
+
+	started := time.Now()
+	result := &purgeResult{Batches: []int64{}}
+	var lastBatch time.Duration
+	for {
+		if result.StopReason = batchStopReason(ctx, cfg, started, len(result.Batches), lastBatch); result.StopReason != "" {
+			result.StoppedEarly = true
+			return result, nil
+		}
+		batchStarted := time.Now()
+		res, err := db.ExecContext(ctx, stmt.SQL, stmt.Args...)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: execution error: %w (%d rows deleted by the earlier batches)", len(result.Batches)+1, err, result.RowsDeleted)
+		}
+		lastBatch = time.Since(batchStarted)
+		n, _ := res.RowsAffected()
+		result.Batches = append(result.Batches, n)
+		result.RowsDeleted += n
+		if n < int64(batchSize) {
+			return result, nil
+		}
+		if !pauseBetweenBatches(ctx, cfg) {
+			result.StoppedEarly, result.StopReason = true, "deadline"
+			return result, nil
+		}
+	}
+}
+
+// batchStopReason tells a batched run why it must not start another batch:
+// max_batches, max_runtime, or deadline when the last batch took longer than
+// the time left. It is empty while the run may go on.
+func batchStopReason(ctx context.Context, cfg settings, started time.Time, batches int, lastBatch time.Duration) string {
+	switch deadline, ok := ctx.Deadline(); {
+	case cfg.maxBatches > 0 && batches >= cfg.maxBatches:
+		return "max_batches"
+	case cfg.maxRuntime > 0 && time.Since(started) >= time.Duration(cfg.maxRuntime)*time.Second:
+		return "max_runtime"
+	case ok && time.Until(deadline) < lastBatch:
+		return "deadline"
+	}
+	return ""
+}
+
+// pauseBetweenBatches sleeps sleep_between_batches_ms, reporting false if the
+// context ends first.
+func pauseBetweenBatches(ctx context.Context, cfg settings) bool {
+	if cfg.sleepBetweenBatches <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(time.Duration(cfg.sleepBetweenBatches) * time.Millisecond):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// queriesPath is the saved query file given with --queries;
+// MYSQL_COMPONENT_QUERIES is used when the flag is absent. The file is read
+// on every invocation.
+var queriesPath string
+
+// savedQuery is a named SQL text, rendered as a query_template, with the
+// parameters it accepts.
+type savedQuery struct {
+	SQL         string                    `json:"sql" toml:"sql"`
+	Description string                    `json:"description" toml:"description"`
+	Parameters  map[string]queryParameter `json:"parameters" toml:"parameters"`
+}
+
+type queryParameter struct {
+	Type     string `json:"type" toml:"type"` // string, integer, number, boolean or list
+	Required bool   `json:"required" toml:"required"`
+}
+
+type queryFile struct {
+	// QueriesOnly refuses ad-hoc SQL in query, select_query and steps.
+	QueriesOnly bool                  `json:"queries_only" toml:"queries_only"`
+	Queries     map[string]savedQuery `json:"queries" toml:"queries"`
+}
+
+// loadQueries reads the saved query file, or returns nil when none is
+// configured.
+func loadQueries() (*queryFile, error) {
+	path := queriesPath
+	if path == "" {
+		path = os.Getenv("MYSQL_COMPONENT_QUERIES")
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queries file: %v", err)
+	}
+	var file queryFile
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &file)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse queries file %s: %v", filepath.Base(path), err)
+	}
+	for name, q := range file.Queries {
+		for param, decl := range q.Parameters {
+			if !queryParameterTypes[decl.Type] {
+				return nil, fmt.Errorf("queries file: %s.%s has type %q; use string, integer, number, boolean or list", name, param, decl.Type)
+			}
+		}
+	}
+	return &file, nil
+}
+
+var queryParameterTypes = map[string]bool{"string": true, "integer": true, "number": true, "boolean": true, "list": true}
+
+// applySavedQuery resolves query_name into the stored SQL and enforces
+// queries_only. The parameters are checked against the declaration here so
+// the statement is only built from declared, correctly typed values.
+func applySavedQuery(cfg *settings, errs *validationErrors) {
+	// The parameters are named even when the lookup fails.
+	if cfg.queryName != "" {
+		cfg.queryTemplate = true
+	}
+	file, err := loadQueries()
+	if err != nil {
+		errs.add("invalid_queries_file", "query_name", "%v", err)
+		return
+	}
+	if file == nil {
+		if cfg.queryName != "" {
+			errs.add("invalid_queries_file", "query_name", "query_name %q requested but no queries file was given (use --queries or MYSQL_COMPONENT_QUERIES)", cfg.queryName)
+		}
+		return
+	}
+	if file.QueriesOnly {
+		for _, in := range []struct{ name, val string }{{"query", cfg.query}, {"select_query", cfg.selectQuery}, {"steps", cfg.steps}} {
+			if in.val != "" {
+				errs.add("queries_only", in.name, "%s is not allowed: the queries file requires saved queries (query_name)", in.name)
+			}
+		}
+	}
+	if cfg.queryName == "" {
+		return
+	}
+
+	q, ok := file.Queries[cfg.queryName]
+	if !ok {
+		errs.add("unknown_query", "query_name", "unknown query %q; available queries: %s", cfg.queryName, strings.Join(sortedKeys(file.Queries), ", "))
+		return
+	}
+	if cfg.query != "" {
+		errs.add("conflict", "query", "query cannot be combined with query_name")
+		return
+	}
+	params, err := parseTemplateParams(cfg.parameters)
+	if err != nil {
+		errs.add("invalid_parameters", "parameters", "%v", err)
+		return
+	}
+	for _, name := range sortedKeys(params) {
+		decl, ok := q.Parameters[name]
+		if !ok {
+			errs.add("invalid_parameters", "parameters", "query %q has no parameter %q; declared: %s", cfg.queryName, name, strings.Join(sortedKeys(q.Parameters), ", "))
+		} else if v := params[name]; v != nil && !decl.accepts(v) {
+			errs.add("invalid_parameters", "parameters", "parameter %q of query %q must be of type %s", name, cfg.queryName, decl.Type)
+		}
+	}
+	for _, name := range sortedKeys(q.Parameters) {
+		if _, ok := params[name]; !ok && q.Parameters[name].Required {
+			errs.add("required", "parameters", "parameter %q of query %q is required", name, cfg.queryName)
+		}
+	}
+	cfg.query = q.SQL
+}
+
+func (p queryParameter) accepts(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return p.Type == "string"
+	case float64:
+		return p.Type == "number" || (p.Type == "integer" && v == float64(int64(v)))
+	case bool:
+		return p.Type == "boolean"
+	case []interface{}:
+		return p.Type == "list"
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// minRedactedPassword is the shortest password redact replaces wherever it
+// appears. A shorter one, such as "1", would match inside unrelated text
+// such as error 1045, so it is only replaced where it stands as a whole
+// token, as in user:1@host; it is still removed from any dsn.
+const minRedactedPassword = 4
+
+// redact removes the passwords, and the password of the dsn, from a
+// message that is about to leave the process. The dsn is matched as given
+// and as the driver formats it, and replaced by its formatted form with
+// the password cleared, so the message still names the server.
+func redact(msg string, cfg settings) string {
+	if cfg.dsn != "" {
+		if c, err := mysql.ParseDSN(cfg.dsn); err == nil {
+			formatted := c.FormatDSN()
+			c.Passwd = ""
+			safe := c.FormatDSN()
+			msg = strings.ReplaceAll(msg, cfg.dsn, safe)
+			msg = strings.ReplaceAll(msg, formatted, safe)
+		} else {
+			msg = strings.ReplaceAll(msg, cfg.dsn, "[dsn redacted]")
+		}
+	}
+	for _, password := range []string{cfg.password, cfg.targetPassword, cfg.userPassword} {
+		switch {
+		case len(password) >= minRedactedPassword:
+			msg = strings.ReplaceAll(msg, password, "***")
+		case password != "":
+			msg = redactToken(msg, password)
+		}
+	}
+	return msg
+}
+
+// redactToken replaces password where no letter, digit or underscore
+// adjoins it on either side.
+func redactToken(msg, password string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(msg, password)
+		if i < 0 {
+			break
+		}
+		end := i + len(password)
+		before, _ := utf8.DecodeLastRuneInString(msg[:i])
+		after, _ := utf8.DecodeRuneInString(msg[end:])
+		if i > 0 && isWordRune(before) || end < len(msg) && isWordRune(after) {
+			// Part of a longer word; look again from the next rune.
+			_, size := utf8.DecodeRuneInString(msg[i:])
+			b.WriteString(msg[:i+size])
+			msg = msg[i+size:]
+			continue
+		}
+		b.WriteString(msg[:i])
+		b.WriteString("***")
+		msg = msg[end:]
+	}
+	b.WriteString(msg)
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	const dsn = "app:s3cret@tcp(db.internal:3307)/erp?parseTime=true"
+	tests := []struct {
+		name string
+		cfg  settings
+		msg  string
+		want string
+	}{
+		{"dsn as given", settings{dsn: dsn, password: "s3cret"}, "failed to open " + dsn,
+			"failed to open app@tcp(db.internal:3307)/erp?parseTime=true"},
+		{"dsn as formatted", settings{dsn: "app:s3cret@tcp(db.internal:3307)/erp?parseTime=1", password: "s3cret"},
+			"failed to open app:s3cret@tcp(db.internal:3307)/erp?parseTime=true",
+			"failed to open app@tcp(db.internal:3307)/erp?parseTime=true"},
+		{"short password in a dsn", settings{dsn: "app:1@tcp(db.internal:3307)/erp", password: "1"},
+			"dial app:1@tcp(db.internal:3307)/erp: error 1045 at 10:01",
+			"dial app@tcp(db.internal:3307)/erp: error 1045 at 10:01"},
+		{"short password inside words", settings{password: "1"}, "Error 1045: row 10 of a1", "Error 1045: row 10 of a1"},
+		{"short password as a token", settings{password: "1"}, "Error 1045: row 1 of 10", "Error 1045: row *** of 10"},
+		{"short password in user:pass@", settings{password: "ab"}, "dial tcp: app:ab@db.internal denied (abc)", "dial tcp: app:***@db.internal denied (abc)"},
+		{"short password at the edges", settings{password: "x!"}, "x! and x!y and x!", "*** and x!y and ***"},
+		{"password", settings{password: "hunter22"}, "login hunter22 rejected", "login *** rejected"},
+		{"target and user passwords", settings{targetPassword: "tgt-pass", userPassword: "new-pass"},
+			"copy with tgt-pass, create with new-pass", "copy with ***, create with ***"},
+		{"unparsable dsn", settings{dsn: "not a dsn"}, "bad not a dsn", "bad [dsn redacted]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redact(tt.msg, tt.cfg)
+			if got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.msg, got, tt.want)
+			}
+			// A short password may remain inside words, never as a token.
+			if p := tt.cfg.password; p != "" && (len(p) >= minRedactedPassword && strings.Contains(got, p) || redactToken(got, p) != got) {
+				t.Errorf("%q still holds the password", got)
+			}
+		})
+	}
+}
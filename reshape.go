@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// reshapeSource is the FROM target of pivot and unpivot: object_name, or
+// select_query as a derived table.
+type reshapeSource struct {
+	table ident
+	query sqlText
+}
+
+func newReshapeSource(cfg settings) (reshapeSource, error) {
+	if cfg.selectQuery != "" {
+		if !isSelectQuery(cfg.selectQuery) {
+			return reshapeSource{}, fmt.Errorf("select_query must be a SELECT statement")
+		}
+		return reshapeSource{query: sqlText(cfg.selectQuery)}, nil
+	}
+	table, err := tableIdent(cfg.objectName)
+	return reshapeSource{table: table}, err
+}
+
+func (b *stmtBuilder) source(src reshapeSource) *stmtBuilder {
+	if src.query != "" {
+		return b.text("(").text(src.query).text(") AS src")
+	}
+	return b.ident(src.table)
+}
+
+var pivotAggregates = map[string]bool{"sum": true, "count": true, "avg": true, "min": true, "max": true}
+
+// pivot implements data_type=pivot. The distinct pivot values are read
+// first, capped by pivot_limit, and each becomes an aggregated CASE column
+// named after the value. The value itself is bound as a parameter; only the
+// column alias is derived from it, and aliases are quoted like any other
+// identifier.
+func pivot(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	src, err := newReshapeSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseIdentList(cfg.rowKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid row_keys: %v", err)
+	}
+	pivotCol, err := columnIdent(cfg.pivotColumn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pivot_column: %v", err)
+	}
+	valueCol, err := columnIdent(cfg.valueColumn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value_column: %v", err)
+	}
+	agg := strings.ToLower(cfg.aggregate)
+	if agg == "" {
+		agg = "sum"
+	}
+	if !pivotAggregates[agg] {
+		return nil, fmt.Errorf("aggregate must be one of sum, count, avg, min, max, got %q", cfg.aggregate)
+	}
+	limit := cfg.pivotLimit
+	if limit == 0 {
+		limit = 100
+	}
+
+	query, _ := new(stmtBuilder).text("SELECT DISTINCT ").ident(pivotCol).text(" FROM ").source(src).text(" ORDER BY 1 LIMIT ").number(int64(limit) + 1).build()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pivot values: %v", err)
+	}
+	var values []interface{}
+	for rows.Next() {
+		var v interface{}
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) > limit {
+		var n int64
+		query, _ := new(stmtBuilder).text("SELECT COUNT(DISTINCT ").ident(pivotCol).text(") FROM ").source(src).build()
+		if err := db.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			return nil, fmt.Errorf("pivot_column has more than %d distinct values", limit)
+		}
+		return nil, fmt.Errorf("pivot_column has %d distinct values, more than pivot_limit=%d", n, limit)
+	}
+
+	quotedKeys, _ := columnIdents(keys)
+	fn := sqlText(strings.ToUpper(agg))
+	b := new(stmtBuilder).text("SELECT ").idents(quotedKeys)
+	for _, v := range values {
+		if v == nil {
+			b.text(", ").text(fn).text("(CASE WHEN ").ident(pivotCol).text(" IS NULL THEN ").ident(valueCol).text(" END) AS `NULL`")
+			continue
+		}
+		alias, err := columnIdent(fmt.Sprint(v))
+		if err != nil {
+			return nil, fmt.Errorf("pivot value %v cannot be used as a column name: %v", v, err)
+		}
+		b.text(", ").text(fn).text("(CASE WHEN ").ident(pivotCol).text(" = ? THEN ").arg(v).ident(valueCol).text(" END) AS ").ident(alias)
+	}
+	stmt := b.text(" FROM ").source(src).text(" GROUP BY ").idents(quotedKeys).text(" ORDER BY ").idents(quotedKeys).statement(true)
+
+	result, err := execute(ctx, db, cfg, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"sql": stmt.SQL, "pivot_values": values, "rows": result}, nil
+}
+
+// castTypeRe whitelists the CAST target types accepted by cast_to.
+var castTypeRe = regexp.MustCompile(`^(char|binary|signed|unsigned|decimal|double|float|date|datetime|time|json)(\(\d+(,\d+)?\))?$`)
+
+// castType validates cast_to and renders it for CAST(... AS ...).
+func castType(raw string) (string, error) {
+	t := strings.ToLower(strings.ReplaceAll(raw, " ", ""))
+	if !castTypeRe.MatchString(t) {
+		return "", fmt.Errorf("unsupported cast_to type %q", raw)
+	}
+	return strings.ToUpper(t), nil
+}
+
+// unpivot implements data_type=unpivot as one SELECT per value column
+// combined with UNION ALL. Without cast_to, value columns of differing types
+// are rejected instead of leaving the conversion to the server.
+func unpivot(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	src, err := newReshapeSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := parseIdentList(cfg.idColumns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_columns: %v", err)
+	}
+	values, err := parseIdentList(cfg.valueColumns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value_columns: %v", err)
+	}
+	keyName, valueName := cfg.keyName, cfg.valueName
+	if keyName == "" {
+		keyName = "key"
+	}
+	if valueName == "" {
+		valueName = "value"
+	}
+	keyAlias, err := columnIdent(keyName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key_name: %v", err)
+	}
+	valueAlias, err := columnIdent(valueName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value_name: %v", err)
+	}
+
+	cast := ""
+	if cfg.castTo != "" {
+		if cast, err = castType(cfg.castTo); err != nil {
+			return nil, err
+		}
+	} else if cfg.selectQuery == "" {
+		if err := sameColumnTypes(ctx, db, cfg.objectName, values); err != nil {
+			return nil, err
+		}
+	}
+
+	idList, _ := columnIdents(ids)
+	b := new(stmtBuilder)
+	for i, v := range values {
+		col, _ := columnIdent(v)
+		if i > 0 {
+			b.text(" UNION ALL ")
+		}
+		b.text("SELECT ").idents(idList).text(", ? AS ").arg(v).ident(keyAlias).text(", ")
+		if cast != "" {
+			b.text("CAST(").ident(col).text(" AS ").text(sqlText(cast)).text(")")
+		} else {
+			b.ident(col)
+		}
+		b.text(" AS ").ident(valueAlias).text(" FROM ").source(src)
+	}
+	stmt := b.statement(true)
+
+	result, err := execute(ctx, db, cfg, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"sql": stmt.SQL, "rows": result}, nil
+}
+
+// sameColumnTypes fails when the named columns of table differ in type.
+func sameColumnTypes(ctx context.Context, q execer, table string, columns []string) error {
+	parts := splitQualified(table)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	names := make([]interface{}, len(columns))
+	for i, c := range columns {
+		names[i] = c
+	}
+	query, args := new(stmtBuilder).text("SELECT DISTINCT column_type FROM information_schema.columns WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? AND column_name IN (").
+		arg(schema, parts[len(parts)-1]).values(names).text(")").build()
+	types, err := queryStrings(ctx, q, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to read column types: %v", err)
+	}
+	if len(types) > 1 {
+		return fmt.Errorf("value_columns have mixed types (%s); set cast_to to choose a common type", strings.Join(types, ", "))
+	}
+	return nil
+}
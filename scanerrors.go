@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// maxScanErrors caps the rows listed in scan_errors; the counts go on.
+const maxScanErrors = 20
+
+// errSkipRow tells the scanner to drop the current row under
+// on_scan_error=skip.
+var errSkipRow = errors.New("row skipped")
+
+// scanErrorEntry is a row that could not be converted. RowIndex counts the
+// rows read from the server from 0, skipped ones included.
+type scanErrorEntry struct {
+	RowIndex int64  `json:"row_index"`
+	Column   string `json:"column,omitempty"`
+	Error    string `json:"error"`
+}
+
+// scanErrorReport is the scan_errors output field: what on_scan_error did
+// about the rows that failed to scan or convert.
+type scanErrorReport struct {
+	Mode    string           `json:"mode"`
+	Skipped int              `json:"skipped"`
+	Patched int              `json:"patched"`
+	Errors  []scanErrorEntry `json:"errors"`
+}
+
+// scanErrorPolicy is on_scan_error=skip or null for the current
+// invocation; nil aborts the result on the first bad row, as before.
+//
+// A failed Scan is retried with sql.RawBytes in one column at a time to
+// find the column at fault; the conversions of decimal_mode and
+// big_int_mode know theirs. Under null that column becomes NULL, or the row
+// is skipped when no single column is to blame. An error reading the row
+// from the server still ends the result: the driver cannot continue past
+// it.
+type scanErrorPolicy struct {
+	report  scanErrorReport
+	patched int64 // row index last counted as patched
+}
+
+func newScanErrorPolicy(cfg settings) *scanErrorPolicy {
+	if cfg.onScanError == "" || cfg.onScanError == "abort" {
+		return nil
+	}
+	return &scanErrorPolicy{report: scanErrorReport{Mode: cfg.onScanError, Errors: []scanErrorEntry{}}, patched: -1}
+}
+
+// handle decides about err in column col of the row at index row, -1 when
+// the column is unknown. It returns err to abort, errSkipRow to drop the
+// row, or nil when the value is to be replaced with NULL.
+func (p *scanErrorPolicy) handle(row int64, col string, err error) error {
+	if p == nil {
+		return err
+	}
+	if len(p.report.Errors) < maxScanErrors {
+		p.report.Errors = append(p.report.Errors, scanErrorEntry{RowIndex: row, Column: col, Error: err.Error()})
+	}
+	if p.report.Mode == "skip" || col == "" {
+		p.report.Skipped++
+		return errSkipRow
+	}
+	if p.patched != row {
+		p.report.Patched++
+		p.patched = row
+	}
+	return nil
+}
+
+// result returns the scan_errors output field, nil when every row was
+// scanned as it came.
+func (p *scanErrorPolicy) result() *scanErrorReport {
+	if p == nil || (p.report.Skipped == 0 && p.report.Patched == 0) {
+		return nil
+	}
+	r := p.report
+	return &r
+}
+
+// failingColumn scans the current row again with sql.RawBytes in place of
+// one column at a time. When that succeeds, the column is the one Scan
+// failed on and the other values are scanned; it returns -1 otherwise.
+func (s *rowScanner) failingColumn() int {
+	targets := make([]interface{}, len(s.targets))
+	for i := range s.targets {
+		copy(targets, s.targets)
+		targets[i] = new(sql.RawBytes)
+		if s.rows.Scan(targets...) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// convertError runs err through on_scan_error for column i, clearing the
+// value when it is patched.
+func (s *rowScanner) convertError(i int, err error) error {
+	if err = s.errors.handle(s.row-1, s.columns[i], err); err == nil {
+		s.values[i] = nil
+	}
+	return err
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fieldSchema describes one result column as it is encoded: DECIMAL (unless
+// decimal_mode=number) and TIME arrive as strings, and DATE like DATETIME as
+// an RFC 3339 timestamp since the connection parses times.
+type fieldSchema struct {
+	mysqlType string
+	jsonType  string
+	format    string
+	nullable  bool
+	maxLength int64
+	enum      []string
+	mapped    []string // extra JSON types from transform.map_values
+}
+
+// mysqlJSONType maps a type name as reported by information_schema or the
+// driver (e.g. "UNSIGNED BIGINT") to its JSON type and format.
+func mysqlJSONType(name string) (string, string) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "UNSIGNED ")
+	switch name {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR":
+		return "integer", ""
+	case "FLOAT", "DOUBLE", "REAL":
+		return "number", ""
+	case "DATE", "DATETIME", "TIMESTAMP":
+		return "string", "date-time"
+	case "NULL":
+		return "null", ""
+	}
+	return "string", ""
+}
+
+var enumValueRe = regexp.MustCompile(`'((?:[^'\\]|''|\\.)*)'`)
+
+// enumValues extracts the members of an enum('a','b') column type.
+func enumValues(columnType string) []string {
+	if !strings.HasPrefix(strings.ToLower(columnType), "enum(") {
+		return nil
+	}
+	var values []string
+	for _, m := range enumValueRe.FindAllStringSubmatch(columnType, -1) {
+		values = append(values, strings.NewReplacer("''", "'", `\'`, "'", `\\`, `\`).Replace(m[1]))
+	}
+	return values
+}
+
+// tableFieldSchemas reads the columns of a table from information_schema,
+// which also knows string lengths and enum members.
+func tableFieldSchemas(ctx context.Context, q execer, name string) ([]string, map[string]*fieldSchema, error) {
+	parts := splitQualified(name)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	rows, err := q.QueryContext(ctx,
+		"SELECT column_name, data_type, column_type, is_nullable, character_maximum_length FROM information_schema.columns WHERE table_schema = COALESCE(?, DATABASE()) AND table_name = ? ORDER BY ordinal_position",
+		schema, parts[len(parts)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	fields := map[string]*fieldSchema{}
+	for rows.Next() {
+		var col, dataType, columnType, nullable string
+		var maxLength sql.NullInt64
+		if err := rows.Scan(&col, &dataType, &columnType, &nullable, &maxLength); err != nil {
+			return nil, nil, err
+		}
+		f := &fieldSchema{mysqlType: strings.ToUpper(dataType), nullable: nullable == "YES", enum: enumValues(columnType)}
+		f.jsonType, f.format = mysqlJSONType(dataType)
+		if f.jsonType == "string" && f.format == "" && f.enum == nil && maxLength.Valid {
+			f.maxLength = maxLength.Int64
+		}
+		columns = append(columns, col)
+		fields[col] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("table %s does not exist or has no columns", name)
+	}
+	return columns, fields, nil
+}
+
+// queryFieldSchemas runs the query with LIMIT 0 and reads the result
+// metadata. The protocol does not carry lengths or enum members, so those
+// are only described for object_name.
+func queryFieldSchemas(ctx context.Context, q execer, query string, args []interface{}) ([]string, map[string]*fieldSchema, error) {
+	probe, args := new(stmtBuilder).text("SELECT * FROM (").text(sqlText(query)).text(") AS result_schema LIMIT 0").arg(args...).build()
+	rows, err := q.QueryContext(ctx, probe, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var columns []string
+	fields := map[string]*fieldSchema{}
+	for _, t := range types {
+		f := &fieldSchema{mysqlType: t.DatabaseTypeName()}
+		f.nullable, _ = t.Nullable()
+		f.jsonType, f.format = mysqlJSONType(f.mysqlType)
+		columns = append(columns, t.Name())
+		fields[t.Name()] = f
+	}
+	return columns, fields, rows.Err()
+}
+
+// resultSchema implements data_type=result_schema: a JSON Schema for the
+// result of query or object_name, after the same shaping the statement
+// would get. With group_by_columns only the keys are placed on the parent,
+// since which other columns are constant depends on the data.
+func resultSchema(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	var columns []string
+	var fields map[string]*fieldSchema
+	var err error
+	if cfg.objectName != "" {
+		columns, fields, err = tableFieldSchemas(ctx, db, cfg.objectName)
+	} else {
+		args, perr := parseArgs(cfg.parameters)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid parameters: %v", perr)
+		}
+		columns, fields, err = queryFieldSchemas(ctx, db, cfg.query, args)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the result: %v", err)
+	}
+
+	// Value mappings replace values, so the column may hold their types.
+	t, _ := parseTransform(cfg.transform)
+	row := make(map[string]interface{}, len(columns))
+	scan := cfg.scanning()
+	for _, col := range columns {
+		f := fields[col]
+		if scan.masking.dropped(col) {
+			continue
+		}
+		if scan.masking.masked(col) {
+			f.jsonType, f.format, f.enum, f.maxLength = "string", "", nil, 0
+		} else if f.mysqlType == "DECIMAL" && scan.decimals.numeric(col) {
+			f.jsonType = "number"
+		} else if strings.HasSuffix(f.mysqlType, "BIGINT") {
+			switch cfg.bigIntMode {
+			case "safe":
+				f.mapped = append(f.mapped, "string")
+			case "string":
+				f.jsonType = "string"
+			}
+		}
+		if t != nil {
+			if mapping, ok := t.values[col]; ok {
+				for _, v := range mapping {
+					f.mapped = append(f.mapped, jsonTypeOf(v))
+				}
+				f.enum, f.maxLength = nil, 0
+			}
+		}
+		row[col] = f
+	}
+
+	rows := []map[string]interface{}{row}
+	if shaper := newRowShaper(cfg); shaper != nil {
+		rows = shaper.shapeAll(rows)
+		out.Warnings = append(out.Warnings, shaper.warnings...)
+	}
+	g := newGrouping(cfg)
+	if g != nil {
+		var warnings []string
+		rows, warnings = g.apply(rows)
+		out.Warnings = append(out.Warnings, warnings...)
+	}
+
+	items := objectSchema(rows[0], cfg.nestByPrefix && cfg.nullCollapse, false)
+	if g != nil && g.maxChildren > 0 {
+		props := items["properties"].(map[string]interface{})
+		props[g.childrenKey+"_truncated"] = map[string]interface{}{"type": "boolean"}
+	}
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type":    "array",
+		"items":   items,
+	}, nil
+}
+
+// objectSchema describes a shaped row. Nested objects are nullable when
+// null_collapse can replace them with null.
+func objectSchema(m map[string]interface{}, collapse, nullable bool) map[string]interface{} {
+	props := map[string]interface{}{}
+	for key, v := range m {
+		switch v := v.(type) {
+		case *fieldSchema:
+			props[key] = v.schema()
+		case map[string]interface{}:
+			props[key] = objectSchema(v, collapse, collapse)
+		case []map[string]interface{}:
+			props[key] = map[string]interface{}{"type": "array", "items": objectSchema(v[0], collapse, false)}
+		}
+	}
+	required := sortedKeys(props)
+	s := map[string]interface{}{"type": "object", "properties": props, "required": required}
+	if nullable {
+		s["type"] = []string{"object", "null"}
+	}
+	return s
+}
+
+func (f *fieldSchema) schema() map[string]interface{} {
+	types := []string{f.jsonType}
+	for _, t := range f.mapped {
+		if !containsString(types, t) {
+			types = append(types, t)
+		}
+	}
+	if f.nullable && !containsString(types, "null") {
+		types = append(types, "null")
+	}
+	sort.Strings(types)
+
+	s := map[string]interface{}{"x-mysql-type": f.mysqlType}
+	if len(types) == 1 {
+		s["type"] = types[0]
+	} else {
+		s["type"] = types
+	}
+	if f.format != "" {
+		s["format"] = f.format
+	}
+	if f.maxLength > 0 {
+		s["maxLength"] = f.maxLength
+	}
+	if len(f.enum) > 0 {
+		enum := make([]interface{}, 0, len(f.enum)+1)
+		for _, v := range f.enum {
+			enum = append(enum, v)
+		}
+		if f.nullable {
+			enum = append(enum, nil)
+		}
+		s["enum"] = enum
+	}
+	return s
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// showFamily is a SHOW statement normalize_show knows: the key each of its
+// columns is returned under, and the columns holding numbers.
+type showFamily struct {
+	keys    map[string]string // lower-case column name -> key
+	numbers map[string]bool   // keys converted when they hold a number
+}
+
+// showFamilies covers the columns of MySQL 5.7 and 8.0 and of MariaDB, which
+// differ in name, such as Comment and Comments, and in which are present,
+// such as Visible and Expression of SHOW INDEX from 8.0 on.
+var showFamilies = map[string]showFamily{
+	"table_status": {
+		keys: map[string]string{
+			"name": "name", "engine": "engine", "version": "version", "row_format": "row_format",
+			"rows": "rows", "avg_row_length": "avg_row_length", "data_length": "data_length",
+			"max_data_length": "max_data_length", "index_length": "index_length", "data_free": "data_free",
+			"auto_increment": "auto_increment", "create_time": "create_time", "update_time": "update_time",
+			"check_time": "check_time", "collation": "collation", "checksum": "checksum",
+			"create_options": "create_options", "comment": "comment", "comments": "comment",
+			"max_index_length": "max_index_length", "temporary": "temporary",
+		},
+		numbers: map[string]bool{
+			"version": true, "rows": true, "avg_row_length": true, "data_length": true, "max_data_length": true,
+			"index_length": true, "data_free": true, "auto_increment": true, "checksum": true, "max_index_length": true,
+		},
+	},
+	"index": {
+		keys: map[string]string{
+			"table": "table", "non_unique": "non_unique", "key_name": "key_name", "seq_in_index": "seq_in_index",
+			"column_name": "column_name", "collation": "collation", "cardinality": "cardinality",
+			"sub_part": "sub_part", "packed": "packed", "null": "nullable", "index_type": "index_type",
+			"comment": "comment", "index_comment": "index_comment", "visible": "visible",
+			"expression": "expression", "ignored": "ignored",
+		},
+		numbers: map[string]bool{"non_unique": true, "seq_in_index": true, "cardinality": true, "sub_part": true},
+	},
+	"processlist": {
+		keys: map[string]string{
+			"id": "id", "user": "user", "host": "host", "db": "db", "command": "command", "time": "time",
+			"state": "state", "info": "info", "progress": "progress",
+		},
+		numbers: map[string]bool{"id": true, "time": true, "progress": true},
+	},
+	"variables": {
+		keys:    map[string]string{"variable_name": "name", "value": "value"},
+		numbers: map[string]bool{"value": true},
+	},
+}
+
+// showFamilyOf returns the family of a SHOW statement, or "" for any other
+// statement, which normalize_show passes through untouched. SHOW STATUS
+// has the shape of SHOW VARIABLES.
+func showFamilyOf(query string) string {
+	var words []string
+	for _, tok := range tokenize(query) {
+		if tok.kind == tokSpace || tok.kind == tokComment {
+			continue
+		}
+		if tok.kind != tokWord || len(words) == 3 {
+			break
+		}
+		words = append(words, strings.ToUpper(tok.text))
+	}
+	if len(words) == 0 || words[0] != "SHOW" {
+		return ""
+	}
+	words = words[1:]
+	if len(words) > 0 {
+		switch words[0] {
+		case "FULL", "GLOBAL", "SESSION", "LOCAL", "EXTENDED":
+			words = words[1:]
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	switch words[0] {
+	case "TABLE":
+		if len(words) > 1 && words[1] == "STATUS" {
+			return "table_status"
+		}
+	case "INDEX", "INDEXES", "KEYS":
+		return "index"
+	case "PROCESSLIST":
+		return "processlist"
+	case "VARIABLES", "STATUS":
+		return "variables"
+	}
+	return ""
+}
+
+// showNormalizer is normalize_show for one SHOW statement: the columns
+// become stable snake_case keys, with names it does not know lower-cased,
+// and number columns holding text, as SHOW returns most of them, become
+// JSON numbers with every digit kept.
+type showNormalizer struct {
+	family showFamily
+}
+
+func newShowNormalizer(cfg settings) *showNormalizer {
+	if !cfg.normalizeShow || cfg.dataType != "query" {
+		return nil
+	}
+	family, ok := showFamilies[showFamilyOf(cfg.query)]
+	if !ok {
+		return nil
+	}
+	return &showNormalizer{family}
+}
+
+func (n *showNormalizer) apply(row map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		col = strings.ToLower(col)
+		key, ok := n.family.keys[col]
+		if !ok {
+			key = strings.ReplaceAll(col, " ", "_")
+		}
+		if s, ok := v.(string); ok && n.family.numbers[key] && isShowNumber(s) {
+			v = json.Number(s)
+		}
+		out[key] = v
+	}
+	return out
+}
+
+// isShowNumber reports whether s is a plain decimal number. Text with
+// leading zeros, exponents or more than one dot, such as a version
+// string, is left as it is.
+func isShowNumber(s string) bool {
+	digits := strings.TrimPrefix(s, "-")
+	whole, frac, dot := strings.Cut(digits, ".")
+	if whole == "" || (dot && frac == "") || (len(whole) > 1 && whole[0] == '0') {
+		return false
+	}
+	for _, part := range []string{whole, frac} {
+		for i := 0; i < len(part); i++ {
+			if !isDigit(part[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// showFixture is a testdata/show file: rows of a SHOW statement as a server
+// version returns them, and the rows normalize_show should make of them.
+type showFixture struct {
+	Query string                   `json:"query"`
+	Rows  []map[string]interface{} `json:"rows"`
+	Want  []map[string]interface{} `json:"want"`
+}
+
+func loadShowFixture(t *testing.T, path string) showFixture {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f showFixture
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&f); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return f
+}
+
+func TestNormalizeShowFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "show", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no fixtures under testdata/show")
+	}
+	for _, path := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			f := loadShowFixture(t, path)
+			n := newShowNormalizer(settings{normalizeShow: true, dataType: "query", query: f.Query})
+			got := f.Rows
+			if n != nil {
+				got = make([]map[string]interface{}, len(f.Rows))
+				for i, row := range f.Rows {
+					got[i] = n.apply(row)
+				}
+			}
+			g, _ := json.Marshal(got)
+			w, _ := json.Marshal(f.Want)
+			if !bytes.Equal(g, w) {
+				t.Errorf("%s normalizes to\n%s\nwant\n%s", f.Query, g, w)
+			}
+		})
+	}
+}
+
+// TestNormalizeShowStableKeys checks that the versions of a family share
+// the keys of the columns they have in common.
+func TestNormalizeShowStableKeys(t *testing.T) {
+	for _, family := range []string{"table_status", "index", "processlist", "variables"} {
+		paths, _ := filepath.Glob(filepath.Join("testdata", "show", family+"_*.json"))
+		if len(paths) < 2 {
+			t.Errorf("%s has %d fixtures, want one per server version", family, len(paths))
+			continue
+		}
+		var common map[string]bool
+		for _, path := range paths {
+			f := loadShowFixture(t, path)
+			n := newShowNormalizer(settings{normalizeShow: true, dataType: "query", query: f.Query})
+			if n == nil || showFamilyOf(f.Query) != family {
+				t.Fatalf("%s: %q is not normalized as %s", path, f.Query, family)
+			}
+			keys := map[string]bool{}
+			for k := range n.apply(f.Rows[0]) {
+				keys[k] = true
+			}
+			if common == nil {
+				common = keys
+				continue
+			}
+			for k := range common {
+				if !keys[k] {
+					delete(common, k)
+				}
+			}
+		}
+		for k := range common {
+			if k != strings.ToLower(k) || strings.Contains(k, " ") {
+				t.Errorf("%s key %q is not snake_case", family, k)
+			}
+		}
+		if len(common) == 0 {
+			t.Errorf("%s versions share no keys", family)
+		}
+	}
+}
+
+func TestNormalizeShowOff(t *testing.T) {
+	for _, cfg := range []settings{
+		{normalizeShow: false, dataType: "query", query: "SHOW TABLE STATUS"},
+		{normalizeShow: true, dataType: "table", query: "SHOW TABLE STATUS"},
+		{normalizeShow: true, dataType: "query", query: "SELECT 'SHOW TABLE STATUS'"},
+		{normalizeShow: true, dataType: "query", query: "SHOW CREATE TABLE t"},
+	} {
+		if newShowNormalizer(cfg) != nil {
+			t.Errorf("%+v is normalized", cfg)
+		}
+	}
+}
+
+func TestIsShowNumber(t *testing.T) {
+	for s, want := range map[string]bool{
+		"0": true, "151": true, "-1": true, "0.000": true, "18446744073709551615": true,
+		"": false, "-": false, "032": false, "1.": false, ".5": false, "5.7.44": false, "1e5": false, "ON": false,
+	} {
+		if got := isShowNumber(s); got != want {
+			t.Errorf("isShowNumber(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
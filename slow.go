@@ -0,0 +1,31 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// explainable reports whether EXPLAIN can be run for the statement without
+// side effects. Writes are never explained even though MySQL would allow it.
+func (s statement) explainable() bool {
+	if !s.cacheable() {
+		return false
+	}
+	cmd := strings.ToUpper(strings.TrimSpace(s.SQL))
+	return strings.HasPrefix(cmd, "SELECT") || strings.HasPrefix(cmd, "WITH")
+}
+
+// explainPlan captures the EXPLAIN FORMAT=JSON plan for the statement using
+// the same arguments it was executed with.
+func explainPlan(db *sql.DB, stmt statement) (json.RawMessage, error) {
+	var plan string
+	if err := db.QueryRow("EXPLAIN FORMAT=JSON "+stmt.SQL, stmt.Args...).Scan(&plan); err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(plan)) {
+		return nil, fmt.Errorf("server returned a non-JSON plan")
+	}
+	return json.RawMessage(plan), nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Result snapshots materialize a query into a scratch table in the current
+// database so a large result can be paged through across invocations
+// without the data changing underneath. The table is named
+// _snapshot_<id>; the id starts with the creation time in milliseconds
+// (as in a version 7 UUID), so orphaned snapshots can be cleaned up by age
+// from their names alone.
+
+const (
+	snapshotPrefix   = "_snapshot_"
+	snapshotRowCol   = "_snapshot_row"
+	defaultPageLimit = 1000
+)
+
+var snapshotIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func newSnapshotID(now time.Time) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	ms := uint64(now.UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return hex.EncodeToString(b)
+}
+
+// snapshotCreated returns the creation time embedded in a snapshot id.
+func snapshotCreated(id string) (time.Time, bool) {
+	if !snapshotIDRe.MatchString(id) {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseUint(id[:12], 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(ms)), true
+}
+
+func snapshotTableName(id string) ident {
+	return ident{"`" + snapshotPrefix + id + "`"}
+}
+
+// snapshotMode reports whether the invocation works with a result snapshot
+// instead of running the query directly.
+func (cfg settings) snapshotMode() bool {
+	return cfg.snapshot || cfg.snapshotID != "" || cfg.snapshotCleanup > 0
+}
+
+// resultSnapshot creates, pages through or releases a snapshot, and first
+// drops expired ones when snapshot_cleanup_seconds is set. Paging is a read;
+// everything else is refused with read_only.
+func resultSnapshot(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	writes := cfg.snapshot || cfg.snapshotRelease || cfg.snapshotCleanup > 0
+	if cfg.readOnly && writes {
+		return nil, &readOnlyError{"a snapshot create, release or cleanup"}
+	}
+
+	result := map[string]interface{}{}
+	if cfg.snapshotCleanup > 0 {
+		dropped, err := cleanupSnapshots(ctx, db, time.Duration(cfg.snapshotCleanup)*time.Second, out)
+		if err != nil {
+			return nil, err
+		}
+		result["cleaned_up"] = dropped
+	}
+
+	switch {
+	case cfg.snapshot:
+		id, rows, err := createSnapshot(ctx, db, cfg)
+		if err != nil {
+			return nil, err
+		}
+		result["snapshot_id"], result["total_rows"] = id, rows
+	case cfg.snapshotID != "":
+		result["snapshot_id"] = cfg.snapshotID
+		if !cfg.snapshotRelease || cfg.limit > 0 {
+			if err := readSnapshotPage(ctx, db, cfg, result, out); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.snapshotRelease {
+			ddl, _ := new(stmtBuilder).text("DROP TABLE IF EXISTS ").ident(snapshotTableName(cfg.snapshotID)).build()
+			if _, err := db.ExecContext(ctx, ddl); err != nil {
+				return nil, snapshotError("release", cfg.snapshotID, err)
+			}
+			result["released"] = true
+		}
+	}
+	return result, nil
+}
+
+// createSnapshot copies the query result and then numbers its rows. Adding
+// the AUTO_INCREMENT key afterwards keeps the insertion order, so an ORDER
+// BY in the query carries over to the pages.
+func createSnapshot(ctx context.Context, db *database, cfg settings) (string, int64, error) {
+	if !isSelectQuery(cfg.query) {
+		return "", 0, fmt.Errorf("snapshot requires a SELECT query")
+	}
+	args, err := parseArgs(cfg.parameters)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid parameters: %v", err)
+	}
+
+	id := newSnapshotID(time.Now())
+	table := snapshotTableName(id)
+	ddl, args := new(stmtBuilder).text("CREATE TABLE ").ident(table).text(" AS ").text(sqlText(cfg.query)).arg(args...).build()
+	res, err := db.ExecContext(ctx, ddl, args...)
+	if err != nil {
+		return "", 0, snapshotError("create", id, err)
+	}
+	rows, _ := res.RowsAffected()
+	ddl, _ = new(stmtBuilder).text("ALTER TABLE ").ident(table).text(" ADD COLUMN " + snapshotRowCol + " BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY").build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		ddl, _ = new(stmtBuilder).text("DROP TABLE IF EXISTS ").ident(table).build()
+		db.ExecContext(ctx, ddl)
+		return "", 0, snapshotError("create", id, err)
+	}
+	return id, rows, nil
+}
+
+// countStrategies are the count_strategy values for the total_rows of a
+// snapshot page:
+//
+//	exact            a separate COUNT(*) of the snapshot (the default)
+//	calc_found_rows  SQL_CALC_FOUND_ROWS on the page read, then FOUND_ROWS();
+//	                 deprecated in MySQL 8.0.17, so only for older servers
+//	estimate         information_schema.tables table_rows, the server's
+//	                 statistics estimate; total_is_estimate is set
+var countStrategies = map[string]bool{"exact": true, "calc_found_rows": true, "estimate": true}
+
+// readSnapshotPage adds rows, total_rows and next_offset to result, with
+// count_strategy and count_ms, the time the count took. next_offset is null
+// on the last page; with an estimate, the last page is the first one that
+// is not full.
+func readSnapshotPage(ctx context.Context, db *database, cfg settings, result map[string]interface{}, out *Output) error {
+	table := snapshotTableName(cfg.snapshotID)
+	limit := cfg.limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	strategy := cfg.countStrategy
+	if strategy == "" {
+		strategy = "exact"
+	}
+
+	// FOUND_ROWS() reads the connection the page was read on.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var total int64
+	var counted time.Duration
+	started := time.Now()
+	switch strategy {
+	case "exact":
+		query, _ := new(stmtBuilder).text("SELECT COUNT(*) FROM ").ident(table).build()
+		err = conn.QueryRowContext(ctx, query).Scan(&total)
+	case "estimate":
+		var estimate sql.NullInt64
+		err = conn.QueryRowContext(ctx,
+			"SELECT table_rows FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+			snapshotPrefix+cfg.snapshotID).Scan(&estimate)
+		total = estimate.Int64
+	case "calc_found_rows":
+		var v serverVersion
+		if v, err = db.serverVersion(ctx); err == nil {
+			err = v.require("count_strategy=calc_found_rows", v.calcFoundRows())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return snapshotError("read", cfg.snapshotID, err)
+	}
+	counted = time.Since(started)
+
+	b := new(stmtBuilder).text("SELECT ")
+	if strategy == "calc_found_rows" {
+		b.text("SQL_CALC_FOUND_ROWS ")
+		started = time.Now()
+	}
+	query, args := b.text("* FROM ").ident(table).text(" ORDER BY "+snapshotRowCol+" LIMIT ? OFFSET ?").arg(limit, cfg.offset).build()
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return snapshotError("read", cfg.snapshotID, err)
+	}
+	page, err := scanRows(rows, cfg)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if strategy == "calc_found_rows" {
+		// The count is made by the page read, so its time is included.
+		if err := conn.QueryRowContext(ctx, "SELECT FOUND_ROWS()").Scan(&total); err != nil {
+			return snapshotError("read", cfg.snapshotID, err)
+		}
+		counted = time.Since(started)
+	}
+	for _, row := range page {
+		delete(row, snapshotRowCol)
+	}
+	if shaper := newRowShaper(cfg); shaper != nil {
+		page = shaper.shapeAll(page)
+		out.Warnings = append(out.Warnings, shaper.warnings...)
+	}
+
+	result["total_rows"], result["offset"], result["rows"] = total, cfg.offset, page
+	result["count_strategy"], result["count_ms"] = strategy, counted.Milliseconds()
+	result["total_is_estimate"] = strategy == "estimate"
+	result["next_offset"] = nil
+	next := cfg.offset + int64(len(page))
+	more := next < total
+	if strategy == "estimate" {
+		more = int64(len(page)) == limit
+	}
+	if more {
+		result["next_offset"] = next
+	}
+	return nil
+}
+
+// cleanupSnapshots drops the snapshots in the current database that are
+// older than maxAge and returns their ids. A failed drop is a warning so
+// one stubborn table does not block the rest.
+func cleanupSnapshots(ctx context.Context, db *database, maxAge time.Duration, out *Output) ([]string, error) {
+	names, err := queryStrings(ctx, db,
+		`SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name LIKE '\_snapshot\_%'`)
+	if err != nil {
+		return nil, err
+	}
+	dropped := []string{}
+	for _, name := range names {
+		id := name[len(snapshotPrefix):]
+		created, ok := snapshotCreated(id)
+		if !ok || time.Since(created) < maxAge {
+			continue
+		}
+		ddl, _ := new(stmtBuilder).text("DROP TABLE IF EXISTS ").ident(snapshotTableName(id)).build()
+		if _, err := db.ExecContext(ctx, ddl); err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("failed to drop expired snapshot %s: %v", id, err))
+			continue
+		}
+		dropped = append(dropped, id)
+	}
+	return dropped, nil
+}
+
+// snapshotError explains the two failures callers can act on: a snapshot
+// that no longer exists and missing privileges for the scratch table.
+func snapshotError(action, id string, err error) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case 1146: // ER_NO_SUCH_TABLE
+			return fmt.Errorf("snapshot %s does not exist; it was released or cleaned up", id)
+		case 1044, 1142: // ER_DBACCESS_DENIED_ERROR, ER_TABLEACCESS_DENIED_ERROR
+			return fmt.Errorf("failed to %s snapshot %s: %v (snapshots need CREATE, ALTER, SELECT and DROP on the current database; stream_encode avoids the scratch table)", action, id, err)
+		}
+	}
+	return fmt.Errorf("failed to %s snapshot %s: %v", action, id, err)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokSpace       tokenKind = iota
+	tokComment               // -- ..., # ..., /* ... */
+	tokString                // '...' or "..."
+	tokQuotedIdent           // `...`
+	tokNumber                // 42, 3.14, 1e10, 0xFF
+	tokWord                  // keywords and bare identifiers
+	tokPlaceholder           // ?
+	tokPunct                 // operators, parentheses, commas
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // byte offset in the statement
+}
+
+// tokenize splits a MySQL statement into tokens. It is deliberately lenient:
+// unterminated strings or comments run to the end of the input instead of
+// failing so callers can still inspect malformed SQL.
+func tokenize(s string) []token {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		start := i
+		c := s[i]
+		var kind tokenKind
+		switch {
+		case isSpace(c):
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			kind = tokSpace
+
+		case c == '#' || (c == '-' && strings.HasPrefix(s[i:], "--") && (i+2 == len(s) || isSpace(s[i+2]))):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			kind = tokComment
+
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end + 4
+			}
+			kind = tokComment
+
+		case c == '\'' || c == '"' || c == '`':
+			i = scanQuoted(s, i)
+			kind = tokString
+			if c == '`' {
+				kind = tokQuotedIdent
+			}
+
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			i = scanNumber(s, i)
+			kind = tokNumber
+			// MySQL identifiers may begin with digits (e.g. 1st_col).
+			if i < len(s) && isWordChar(s[i]) {
+				for i < len(s) && isWordChar(s[i]) {
+					i++
+				}
+				kind = tokWord
+			}
+
+		case isWordChar(c):
+			for i < len(s) && isWordChar(s[i]) {
+				i++
+			}
+			kind = tokWord
+
+		case c == '?':
+			i++
+			kind = tokPlaceholder
+
+		default:
+			i++
+			kind = tokPunct
+		}
+		tokens = append(tokens, token{kind: kind, text: s[start:i], pos: start})
+	}
+	return tokens
+}
+
+// unterminated names what a string, quoted identifier or block comment
+// token is when tokenize ran it to the end of the input without its
+// closing quote or */, and returns "" for any other token.
+func (t token) unterminated() string {
+	switch t.kind {
+	case tokComment:
+		if strings.HasPrefix(t.text, "/*") && (len(t.text) < 4 || !strings.HasSuffix(t.text, "*/")) {
+			return "comment"
+		}
+	case tokString, tokQuotedIdent:
+		if !closedQuote(t.text) {
+			if t.kind == tokString {
+				return "string"
+			}
+			return "quoted identifier"
+		}
+	}
+	return ""
+}
+
+// closedQuote reports whether the quoted section s, as scanQuoted
+// delimits it, ends with its closing quote.
+func closedQuote(s string) bool {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && q != '`':
+			i++
+		case s[i] == q:
+			if i+1 < len(s) && s[i+1] == q {
+				i++
+				continue
+			}
+			return i == len(s)-1
+		}
+	}
+	return false
+}
+
+// placeholderOffsets returns the byte offsets of the ? placeholders in a
+// statement, ignoring any in strings, quoted identifiers and comments.
+func placeholderOffsets(s string) []int {
+	var offsets []int
+	for _, tok := range tokenize(s) {
+		if tok.kind == tokPlaceholder {
+			offsets = append(offsets, tok.pos)
+		}
+	}
+	return offsets
+}
+
+// maxListedPlaceholders caps the offsets a placeholderError lists.
+const maxListedPlaceholders = 10
+
+// placeholderError reports a statement whose placeholders and arguments
+// differ in number, before it is sent to the server.
+type placeholderError struct {
+	offsets []int
+	args    int
+}
+
+func (e *placeholderError) Error() string {
+	msg := fmt.Sprintf("the statement has %d placeholders but %d parameters were given", len(e.offsets), e.args)
+	switch len(e.offsets) {
+	case 0:
+		return msg
+	case 1:
+		return fmt.Sprintf("the statement has 1 placeholder, at byte %d, but %d parameters were given", e.offsets[0], e.args)
+	}
+	listed := e.offsets
+	if len(listed) > maxListedPlaceholders {
+		listed = listed[:maxListedPlaceholders]
+	}
+	at := make([]string, len(listed))
+	for i, off := range listed {
+		at[i] = strconv.Itoa(off)
+	}
+	if len(e.offsets) > len(listed) {
+		at = append(at, "...")
+	}
+	return msg + "; they are at bytes " + strings.Join(at, ", ")
+}
+
+// checkPlaceholders returns a placeholderError when the placeholders of
+// stmt and its arguments differ in number.
+func checkPlaceholders(stmt statement) error {
+	if offsets := placeholderOffsets(stmt.SQL); len(offsets) != len(stmt.Args) {
+		return &placeholderError{offsets: offsets, args: len(stmt.Args)}
+	}
+	return nil
+}
+
+// scanQuoted returns the offset just past the quoted section starting at i,
+// honoring backslash escapes and doubled quote characters.
+func scanQuoted(s string, i int) int {
+	q := s[i]
+	i++
+	for i < len(s) {
+		switch {
+		case s[i] == '\\' && q != '`':
+			i += 2
+			continue
+		case s[i] == q:
+			if i+1 < len(s) && s[i+1] == q {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return len(s)
+}
+
+func scanNumber(s string, i int) int {
+	if strings.HasPrefix(s[i:], "0x") || strings.HasPrefix(s[i:], "0X") {
+		i += 2
+		for i < len(s) && isHexDigit(s[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		i++
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			i = j
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// splitStatements splits a script into its statements, the way the mysql
+// client does: on the delimiter outside strings, quoted identifiers and
+// comments. A DELIMITER line changes the delimiter, so compound statements
+// such as trigger bodies can contain semicolons. Statements holding only
+// comments are dropped.
+func splitStatements(script string) ([]string, error) {
+	var stmts []string
+	delim := ";"
+	start, empty := 0, true
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '#' || (c == '-' && strings.HasPrefix(script[i:], "--") && (i+2 == len(script) || isSpace(script[i+2]))):
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*") && !strings.HasPrefix(script[i:], "/*!"):
+			if end := strings.Index(script[i+2:], "*/"); end < 0 {
+				i = len(script)
+			} else {
+				i += end + 4
+			}
+		case empty && len(script)-i > 10 && strings.EqualFold(script[i:i+9], "DELIMITER") && isSpace(script[i+9]):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			if delim = strings.TrimSpace(script[i+10 : i+end]); delim == "" {
+				return nil, fmt.Errorf("DELIMITER at byte %d names no delimiter", i)
+			}
+			i += end
+			start = i
+		case strings.HasPrefix(script[i:], delim):
+			if !empty {
+				stmts = append(stmts, strings.TrimSpace(script[start:i]))
+			}
+			i += len(delim)
+			start, empty = i, true
+		case c == '\'' || c == '"' || c == '`':
+			i = scanQuoted(script, i)
+			empty = false
+		default:
+			i++
+			empty = false
+		}
+	}
+	if !empty {
+		stmts = append(stmts, strings.TrimSpace(script[start:]))
+	}
+	return stmts, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderOffsets(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []int
+	}{
+		{"plain", "SELECT * FROM t WHERE a = ? AND b = ?", []int{26, 36}},
+		{"single quoted string", "SELECT '?', 'it''s ?' FROM t WHERE a = ?", []int{39}},
+		{"double quoted string", `SELECT "a ? \" ?" FROM t WHERE a = ?`, []int{35}},
+		{"backslash escape", `SELECT 'a\'?' , ?`, []int{16}},
+		{"backticks", "SELECT `col?`, `a``?` FROM t WHERE `x` = ?", []int{41}},
+		{"dash comment", "SELECT a -- where b = ?\nFROM t WHERE c = ?", []int{41}},
+		{"dashes without space", "SELECT 1--?", []int{10}},
+		{"hash comment", "SELECT a # b = ?\nFROM t WHERE c = ?", []int{34}},
+		{"block comment", "SELECT /* ? */ a FROM t /* multi\nline ? */ WHERE c = ?", []int{53}},
+		{"unterminated string", "SELECT ? FROM t WHERE a = 'open ?", []int{7}},
+		{"none", "SELECT 1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := placeholderOffsets(tt.sql); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("placeholderOffsets(%q) = %v, want %v", tt.sql, got, tt.want)
+			}
+			for _, off := range tt.want {
+				if tt.sql[off] != '?' {
+					t.Errorf("offset %d of %q is %q, not ?", off, tt.sql, tt.sql[off])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPlaceholders(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+		want string // "" when the counts match
+	}{
+		{"match", "SELECT * FROM t WHERE a = ? AND b = '?'", []interface{}{1}, ""},
+		{"none needed", "SELECT 1 -- ?", nil, ""},
+		{"too few", "SELECT ?, ?", []interface{}{1}, "the statement has 2 placeholders but 1 parameters were given; they are at bytes 7, 10"},
+		{"too many", "SELECT ?", []interface{}{1, 2}, "the statement has 1 placeholder, at byte 7, but 2 parameters were given"},
+		{"no placeholders", "SELECT `?` # ?", []interface{}{1}, "the statement has 0 placeholders but 1 parameters were given"},
+		{"many", "SELECT " + strings.Repeat("?,", 11) + "?", nil, "the statement has 12 placeholders but 0 parameters were given; they are at bytes 7, 9, 11, 13, 15, 17, 19, 21, 23, 25, ..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPlaceholders(statement{SQL: tt.sql, Args: tt.args})
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want != "" && (err == nil || err.Error() != tt.want):
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
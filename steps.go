@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// step is one statement of data_type=steps.
+type step struct {
+	Query         string        `json:"query"`
+	Parameters    []interface{} `json:"parameters"`
+	DiscardResult bool          `json:"discard_result"`
+}
+
+func parseSteps(raw string) ([]step, error) {
+	var steps []step
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&steps); err != nil {
+		return nil, fmt.Errorf("steps must be a JSON array of {\"query\", \"parameters\", \"discard_result\"}: %v", err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("steps must contain at least one step")
+	}
+	for i, s := range steps {
+		if strings.TrimSpace(s.Query) == "" {
+			return nil, fmt.Errorf("step %d: query is required", i+1)
+		}
+	}
+	return steps, nil
+}
+
+// runSteps implements data_type=steps. All steps run in one transaction on a
+// single pinned connection, so session state such as temporary tables and
+// user variables carries from one step to the next. The result is that of
+// the last step not marked discard_result.
+//
+// The connection is closed rather than returned to the pool afterwards, on
+// success and on every error path, so temporary tables created by the steps
+// never outlive the invocation.
+//
+// With consistent_snapshot the transaction is instead a read-only REPEATABLE
+// READ one started WITH CONSISTENT SNAPSHOT, so every step sees the same
+// data; validation has already refused steps that may write.
+//
+// lock_name wraps the run in that advisory lock, taken on the pinned
+// connection before the transaction begins and released after it ends,
+// whatever the outcome.
+//
+// lock turns the SELECT steps into locking reads, whose row locks are held
+// until the transaction commits, as a queue consumer claiming rows needs.
+func runSteps(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	steps, err := parseSteps(cfg.steps)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Returning driver.ErrBadConn makes database/sql discard the
+		// physical connection, which drops its temporary tables.
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		conn.Close()
+	}()
+
+	if cfg.lockName != "" {
+		release, err := acquireAdvisoryLock(ctx, conn, cfg)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	var tx *sql.Tx
+	var q execer
+	if cfg.consistentSnapshot {
+		if out.ConsistentSnapshot, err = startStepsSnapshot(ctx, db, conn, out); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.Background(), "ROLLBACK")
+		q = newStmtCache(conn, cfg.prepared)
+	} else {
+		if err := ensureIdempotencyTable(ctx, conn, cfg); err != nil {
+			return nil, err
+		}
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if cfg.idempotencyKey != "" {
+			replay, err := claimIdempotencyKey(ctx, tx, cfg, idempotencyHash(cfg.steps, nil))
+			if err != nil {
+				return nil, err
+			}
+			if replay != nil {
+				out.IdempotentReplay = true
+				return replay, nil
+			}
+		}
+		// Steps often repeat one statement with different parameters.
+		q = newStmtCache(tx, cfg.prepared)
+	}
+	defer closeStmtCache(q, len(steps), out)
+
+	// lock applies to the SELECT steps, which keep their row locks until
+	// the transaction ends.
+	lock, _ := parseLock(cfg.lock)
+	var lockClause string
+	if lock.strength != "" {
+		v, err := db.serverVersion(ctx)
+		if err == nil {
+			lockClause, err = lock.clause(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result interface{}
+	for i, s := range steps {
+		progressReporter.setChunk(i+1, len(steps))
+		stmt := statement{SQL: s.Query, Args: s.Parameters, IsSelect: isReadQuery(s.Query)}
+		if err := checkPlaceholders(stmt); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if lockClause != "" && isSelectQuery(s.Query) {
+			if stmt.SQL, err = withLockClause(s.Query, lockClause); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+		if cfg.interpolateParams {
+			if err := checkInterpolatable(stmt.Args); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+		r, err := execute(ctx, q, cfg, stmt)
+		if err != nil {
+			if cfg.interpolateParams {
+				err = interpolationHint(err)
+			}
+			return nil, fmt.Errorf("step %d: %w", i+1, lock.classify(err))
+		}
+		if cfg.strictWarnings && !stmt.IsSelect {
+			var warned execer = conn
+			if tx != nil {
+				warned = tx
+			}
+			if err := checkStrict(ctx, warned); err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+		if !s.DiscardResult {
+			result = r
+		}
+	}
+	if tx == nil {
+		_, err = conn.ExecContext(ctx, "COMMIT")
+	} else {
+		if cfg.outbox != "" {
+			spec, _ := parseOutbox(cfg.outbox)
+			if out.OutboxID, err = recordOutbox(ctx, tx, cfg, spec, nil, result); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.idempotencyKey != "" {
+			if err := storeIdempotentResult(ctx, tx, cfg, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if tx != nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("commit failed: %v", err)
+	}
+	return result, nil
+}
+
+// startStepsSnapshot begins the consistent snapshot and records when it
+// started and, when the server has binary logging and the privilege to read
+// it, its position.
+func startStepsSnapshot(ctx context.Context, db *database, conn *sql.Conn, out *Output) (*consistentSnapshot, error) {
+	v, err := db.serverVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := beginConsistentSnapshot(ctx, conn); err != nil {
+		return nil, err
+	}
+	snap := &consistentSnapshot{}
+	if err := conn.QueryRowContext(ctx, "SELECT CAST(UTC_TIMESTAMP(6) AS CHAR)").Scan(&snap.StartedAt); err != nil {
+		return nil, fmt.Errorf("failed to read the snapshot start time: %v", err)
+	}
+	if snap.BinlogPosition, err = readBinlogPosition(ctx, conn, v, true); err != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("snapshot binlog position unavailable: %v", err))
+	}
+	return snap, nil
+}
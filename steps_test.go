@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// runStubSteps runs steps through runSteps over a stub server holding a
+// table of one INT column.
+func runStubSteps(t *testing.T, steps string) (*stubConnector, interface{}, error) {
+	t.Helper()
+	db, stub := openStubDB(t)
+	stub.columns = []stubColumn{{"id", "INT"}}
+	result, err := runSteps(context.Background(), &database{DB: db}, settings{dataType: "steps", steps: steps}, &Output{})
+	return stub, result, err
+}
+
+func TestStepsShareTemporaryTable(t *testing.T) {
+	stub, result, err := runStubSteps(t, `[
+		{"query": "CREATE TEMPORARY TABLE tmp_ids (id INT)", "discard_result": true},
+		{"query": "INSERT INTO tmp_ids VALUES (?)", "parameters": [7], "discard_result": true},
+		{"query": "SELECT * FROM tmp_ids"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(result); string(got) != `[{"id":7}]` {
+		t.Errorf("result %s, want the rows of the last step", got)
+	}
+	if left := stub.temporaries(); len(left) != 0 {
+		t.Errorf("temporary tables %q outlive the steps", left)
+	}
+}
+
+func TestStepsDropTemporaryTableOnError(t *testing.T) {
+	tests := []struct {
+		name, steps, wantErr string
+	}{
+		{
+			"failing step",
+			`[{"query": "CREATE TEMPORARY TABLE tmp_ids (id INT)"}, {"query": "SELECT * FROM tmp_missing"}]`,
+			"step 2: execution error: Error 1146",
+		},
+		{
+			"misplaced placeholders",
+			`[{"query": "CREATE TEMPORARY TABLE tmp_ids (id INT)"}, {"query": "INSERT INTO tmp_ids VALUES (?, ?)", "parameters": [1]}]`,
+			"step 2:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, _, err := runStubSteps(t, tt.steps)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+			if left := stub.temporaries(); len(left) != 0 {
+				t.Errorf("temporary tables %q outlive the failed steps", left)
+			}
+		})
+	}
+}
+
+// TestPooledConnectionKeepsTemporaryTable checks the stub against what
+// runSteps guards against: a connection returned to the pool keeps its
+// temporary tables for whoever uses it next.
+func TestPooledConnectionKeepsTemporaryTable(t *testing.T) {
+	db, stub := openStubDB(t)
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "CREATE TEMPORARY TABLE tmp_ids (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if left := stub.temporaries(); len(left) != 1 || left[0] != "tmp_ids" {
+		t.Errorf("temporary tables %q, want tmp_ids kept on the pooled connection", left)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// preparer is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type preparer interface {
+	execer
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// stmtCache is an execer that prepares each distinct statement once and
+// reuses it for the rest of the invocation. Statements without arguments go
+// straight through: the driver sends those as plain text, so preparing them
+// would only add a round-trip.
+type stmtCache struct {
+	q        preparer
+	stmts    map[string]*sql.Stmt
+	prepares int
+}
+
+// newStmtCache wraps q, or returns q itself when prepared=false.
+func newStmtCache(q preparer, enabled bool) execer {
+	if !enabled {
+		return q
+	}
+	return &stmtCache{q: q, stmts: map[string]*sql.Stmt{}}
+}
+
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	if s, ok := c.stmts[query]; ok {
+		return s, nil
+	}
+	s, err := c.q.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.prepares++
+	c.stmts[query] = s
+	return s, nil
+}
+
+func (c *stmtCache) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if len(args) == 0 {
+		return c.q.ExecContext(ctx, query)
+	}
+	s, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.ExecContext(ctx, args...)
+}
+
+func (c *stmtCache) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if len(args) == 0 {
+		return c.q.QueryContext(ctx, query)
+	}
+	s, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryContext(ctx, args...)
+}
+
+func (c *stmtCache) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if len(args) == 0 {
+		return c.q.QueryRowContext(ctx, query)
+	}
+	s, err := c.prepare(ctx, query)
+	if err != nil {
+		// sql.Row cannot carry the error; let the driver report it.
+		return c.q.QueryRowContext(ctx, query, args...)
+	}
+	return s.QueryRowContext(ctx, args...)
+}
+
+// Close releases the prepared statements.
+func (c *stmtCache) Close() {
+	for _, s := range c.stmts {
+		s.Close()
+	}
+}
+
+// timing reports per-invocation execution counters.
+type timing struct {
+	Statements int `json:"statements"`
+	Prepares   int `json:"prepares"`
+}
+
+// closeStmtCache closes q if it is a stmtCache and records its counters.
+func closeStmtCache(q execer, statements int, out *Output) {
+	c, ok := q.(*stmtCache)
+	if !ok {
+		return
+	}
+	c.Close()
+	out.Timing = &timing{Statements: statements, Prepares: c.prepares}
+}
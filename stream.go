@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// scanConfig is how an invocation scans result rows, built from its
+// settings by newScanConfig. The budget, the invalid temporal values, the
+// scan errors and the column order carry over from one result to the next.
+type scanConfig struct {
+	truncation map[string]int // truncate_columns
+	masking    *columnMasking
+	decimals   *decimalModes
+	bigInt     string // big_int_mode
+	temporals  *temporalScan
+	errors     *scanErrorPolicy
+	budget     *resultBudget
+	order      *columnOrder
+}
+
+// newScanConfig builds the scanning of cfg, whose inputs were validated
+// when it was parsed.
+func newScanConfig(cfg settings) *scanConfig {
+	sc := &scanConfig{
+		bigInt:    cfg.bigIntMode,
+		temporals: newTemporalScan(cfg),
+		errors:    newScanErrorPolicy(cfg),
+		budget:    newResultBudget(cfg),
+		order:     newColumnOrder(cfg),
+	}
+	sc.truncation, _ = parseTruncateColumns(cfg.truncateColumns)
+	sc.masking, _ = newColumnMasking(cfg)
+	sc.decimals, _ = parseDecimalModes(cfg.decimalMode, cfg.decimalColumns)
+	return sc
+}
+
+// scanning returns the scanning of the invocation, which run sets, or for
+// settings that did not come through run a new one built from cfg.
+func (cfg settings) scanning() *scanConfig {
+	if cfg.scan != nil {
+		return cfg.scan
+	}
+	return newScanConfig(cfg)
+}
+
+// rowScanner converts result rows into maps. The scan targets are allocated
+// once and reused for every row, and text values are read in place from
+// the driver's buffer as with sql.RawBytes: only the row map and its
+// strings are new per row.
+type rowScanner struct {
+	*scanConfig
+	rows    *sql.Rows
+	columns []string
+	values  []interface{}
+	targets []interface{}
+	limits  []int // truncate_columns lengths per column, 0 to keep
+	masks   []*maskRule
+	drops   []bool
+	numeric []bool // DECIMAL columns returned as JSON numbers
+	bigInts []bigIntColumn
+	times   []bool // temporal columns parsed under zero_date_mode
+	row     int64
+}
+
+// newRowScanner scans rows as the settings of cfg ask.
+func newRowScanner(rows *sql.Rows, cfg settings) (*rowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("columns error: %v", err)
+	}
+	s := &rowScanner{scanConfig: cfg.scanning(), rows: rows, columns: columns, values: make([]interface{}, len(columns)), targets: make([]interface{}, len(columns))}
+	for i := range s.values {
+		s.targets[i] = rawValue{&s.values[i]}
+	}
+	if s.limits, err = truncationLimits(rows, columns, s.truncation); err != nil {
+		return nil, err
+	}
+	if s.numeric, err = decimalColumns(rows, columns, s.decimals); err != nil {
+		return nil, err
+	}
+	if s.bigInts, err = bigIntColumns(rows, columns, s.bigInt); err != nil {
+		return nil, err
+	}
+	if s.temporals != nil {
+		if s.times, err = temporalColumns(rows, columns); err != nil {
+			return nil, err
+		}
+	}
+	s.order.record(columns)
+	if s.masking != nil {
+		s.masks, s.drops = make([]*maskRule, len(columns)), make([]bool, len(columns))
+		for i, col := range columns {
+			if rule, ok := s.masking.masks[strings.ToLower(col)]; ok {
+				s.masks[i] = &rule
+			}
+			s.drops[i] = s.masking.dropped(col)
+		}
+	}
+	return s, nil
+}
+
+// rawValue is a scan target keeping the value the driver returned as is.
+// Scanning into *interface{} copies every []byte; a rawValue keeps the
+// driver's buffer instead, which, like sql.RawBytes, is only valid until
+// the next row, so convert copies whatever it keeps out of it.
+type rawValue struct{ v *interface{} }
+
+func (r rawValue) Scan(src interface{}) error {
+	*r.v = src
+	return nil
+}
+
+// next returns the next row, or nil at the end of the result. Rows that
+// on_scan_error=skip drops are passed over.
+func (s *rowScanner) next() (map[string]interface{}, error) {
+	for {
+		if !s.rows.Next() {
+			if err := s.rows.Err(); err != nil {
+				return nil, fmt.Errorf("scan error: %v", err)
+			}
+			return nil, nil
+		}
+		s.row++
+		m, err := s.convert()
+		if err != errSkipRow {
+			return m, err
+		}
+	}
+}
+
+// convert scans the current row into a map.
+func (s *rowScanner) convert() (map[string]interface{}, error) {
+	if err := s.rows.Scan(s.targets...); err != nil {
+		err = fmt.Errorf("scan error: %v", err)
+		if s.errors == nil {
+			return nil, err
+		}
+		i := s.failingColumn()
+		if i < 0 {
+			return nil, s.errors.handle(s.row-1, "", err)
+		}
+		if err := s.convertError(i, err); err != nil {
+			return nil, err
+		}
+	}
+	for i, parse := range s.times {
+		if b, ok := s.values[i].([]byte); ok && parse {
+			s.values[i] = s.temporals.convert(s.columns[i], s.row-1, string(b))
+		}
+	}
+	for i, rule := range s.masks {
+		if s.drops[i] {
+			s.values[i] = nil
+		} else if rule != nil {
+			s.values[i] = rule.apply(s.values[i])
+		}
+	}
+	var truncated []string
+	for i, limit := range s.limits {
+		if b, ok := s.values[i].([]byte); ok && limit > 0 {
+			var cut bool
+			if s.values[i], cut = truncateRunes(b, limit); cut {
+				truncated = append(truncated, s.columns[i])
+			}
+		}
+	}
+	for i, numeric := range s.numeric {
+		// A masked value is no longer the number.
+		if b, ok := s.values[i].([]byte); ok && numeric && (s.masks == nil || s.masks[i] == nil) {
+			n, err := decimalNumber(s.columns[i], string(b))
+			if err != nil {
+				if err := s.convertError(i, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			s.values[i] = n
+		}
+	}
+	for i, kind := range s.bigInts {
+		if kind != notBigInt && (s.masks == nil || s.masks[i] == nil) && (s.drops == nil || !s.drops[i]) {
+			v, err := kind.convert(s.bigInt, s.columns[i], s.values[i])
+			if err != nil {
+				if err := s.convertError(i, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			s.values[i] = v
+		}
+	}
+	if err := s.budget.add(s.columns, s.values); err != nil {
+		return nil, err
+	}
+	progressReporter.addRows(1)
+	m := make(map[string]interface{}, len(s.columns))
+	for i, colName := range s.columns {
+		if s.drops != nil && s.drops[i] {
+			continue
+		}
+		// Copy text out of the driver's buffer.
+		if b, ok := s.values[i].([]byte); ok {
+			m[colName] = string(b)
+		} else {
+			m[colName] = s.values[i]
+		}
+	}
+	for _, col := range truncated {
+		m[col+"_truncated"] = true
+	}
+	return m, nil
+}
+
+// rowStream is a result that is encoded while it is read from the server.
+// It owns the rows and the connection, released by close.
+type rowStream struct {
+	rows   *sql.Rows
+	cfg    settings
+	shaper *rowShaper // nil without row post-processing
+	close  func()
+	redact func(string) string
+}
+
+// encodeOutput encodes out to w as JSON. A streamed result is written row by row
+// inside the normal envelope; the remaining fields, including error, follow
+// the result array, so an error found mid-stream still lands in the same
+// JSON object. Consumers must check error even when result is non-empty.
+// The returned error is set when the stream failed.
+func encodeOutput(w io.Writer, out *Output) error {
+	stream := out.stream
+	if stream == nil {
+		return json.NewEncoder(w).Encode(out)
+	}
+	defer stream.close()
+
+	streamErr := stream.encode(w)
+	if streamErr != nil {
+		out.Error = stream.redact(streamErr.Error())
+		out.ErrorClass = errorClass(streamErr)
+	}
+	if stream.shaper != nil {
+		out.Warnings = append(out.Warnings, stream.shaper.warnings...)
+	}
+	scan := stream.cfg.scanning()
+	out.Warnings = append(out.Warnings, scan.temporals.flush()...)
+	out.ScanErrors = scan.errors.result()
+
+	// Reuse the regular encoding for everything after the result.
+	var tail bytes.Buffer
+	out.Result, out.stream = nil, nil
+	if err := json.NewEncoder(&tail).Encode(out); err != nil {
+		return err
+	}
+	if _, err := w.Write(bytes.TrimPrefix(tail.Bytes(), []byte(`{"result":null`))); err != nil {
+		return err
+	}
+	return streamErr
+}
+
+func (s *rowStream) encode(w io.Writer) error {
+	if _, err := io.WriteString(w, `{"result":[`); err != nil {
+		return err
+	}
+	scanner, err := newRowScanner(s.rows, s.cfg)
+	var row map[string]interface{}
+	for n := 0; err == nil; n++ {
+		if row, err = scanner.next(); err != nil || row == nil {
+			break
+		}
+		if s.shaper != nil {
+			row = s.shaper.shape(row)
+		}
+		if n > 0 {
+			if _, err = io.WriteString(w, ","); err != nil {
+				break
+			}
+		}
+		var data []byte
+		if data, err = json.Marshal(scanner.order.row(row)); err == nil {
+			_, err = w.Write(data)
+		}
+	}
+	if _, werr := io.WriteString(w, "]"); err == nil {
+		err = werr
+	}
+	return err
+}
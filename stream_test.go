@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+var streamColumns = []stubColumn{{"id", "INT"}, {"code", "VARCHAR"}, {"amount", "DECIMAL"}, {"created_at", "DATETIME"}, {"note", "TEXT"}}
+
+func streamRows() [][]driver.Value {
+	return [][]driver.Value{
+		{[]byte("1"), []byte("INV-0001"), []byte("1250.50"), []byte("2026-01-31 09:30:00"), nil},
+		{[]byte("2"), []byte("INV-0002"), []byte("-3.10"), []byte("2026-02-01 00:00:00"), []byte("credit \"note\" <b>")},
+		{[]byte("3"), []byte("ÜBER-3"), []byte("0.00"), []byte("2026-02-02 12:00:00"), []byte("")},
+	}
+}
+
+// TestEncodeOutputGolden pins the bytes of a buffered and a streamed result,
+// which must stay the same document.
+func TestEncodeOutputGolden(t *testing.T) {
+	for _, streamed := range []bool{false, true} {
+		db, stub := openStubDB(t)
+		stub.respond = func(string) ([]stubColumn, [][]driver.Value) { return streamColumns, streamRows() }
+		rows, err := db.QueryContext(context.Background(), "SELECT * FROM invoices")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := Output{RequestID: "req-1", Warnings: []string{"a warning"}}
+		if streamed {
+			out.stream = &rowStream{rows: rows, close: func() { rows.Close() }, redact: func(s string) string { return s }}
+		} else {
+			if out.Result, err = scanRows(rows, settings{}); err != nil {
+				t.Fatal(err)
+			}
+			rows.Close()
+		}
+		var got bytes.Buffer
+		if err := encodeOutput(&got, &out); err != nil {
+			t.Fatal(err)
+		}
+		name := "encode/buffered.json"
+		if streamed {
+			name = "encode/streamed.json"
+		}
+		golden(t, name, got.Bytes())
+	}
+}
+
+// BenchmarkScanRows scans a million rows of the stub driver, dropping each
+// row as a streamed result does, so it measures the scan alone.
+func BenchmarkScanRows(b *testing.B) {
+	const n = 1000000
+	data := make([][]driver.Value, n)
+	for i, row := range streamRows() {
+		for j := i; j < n; j += 3 {
+			data[j] = row
+		}
+	}
+	db, stub := openStubDB(b)
+	stub.respond = func(string) ([]stubColumn, [][]driver.Value) { return streamColumns, data }
+	b.ReportAllocs()
+	for b.Loop() {
+		rows, err := db.QueryContext(context.Background(), "SELECT * FROM invoices")
+		if err != nil {
+			b.Fatal(err)
+		}
+		scanner, err := newRowScanner(rows, settings{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		scanned := 0
+		for {
+			row, err := scanner.next()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if row == nil {
+				break
+			}
+			scanned++
+		}
+		rows.Close()
+		if scanned != n {
+			b.Fatalf("scanned %d rows, want %d", scanned, n)
+		}
+	}
+}
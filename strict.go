@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// strictWarning is a row of SHOW WARNINGS.
+type strictWarning struct {
+	Level   string `json:"level"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// strictError fails a write under strict_warnings=true that raised warnings, such as
+// a truncated string or an out-of-range value adjusted to fit. The
+// transaction it ran in is rolled back.
+type strictError struct {
+	warnings []strictWarning
+}
+
+func (e *strictError) Error() string {
+	msgs := make([]string, len(e.warnings))
+	for i, w := range e.warnings {
+		msgs[i] = fmt.Sprintf("%s %d: %s", w.Level, w.Code, w.Message)
+	}
+	return "strict_warnings: rolled back after the statement raised warnings: " + strings.Join(msgs, "; ")
+}
+
+// checkStrict returns a strictError when the last statement on q raised
+// warnings. Notes, such as DROP TABLE IF EXISTS of a missing table, are not
+// counted. q must be the connection or transaction the statement ran on,
+// and not a statement cache: preparing SHOW WARNINGS would clear them.
+func checkStrict(ctx context.Context, q execer) error {
+	rows, err := q.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return fmt.Errorf("strict_warnings: failed to read the warnings: %v", err)
+	}
+	defer rows.Close()
+	var warnings []strictWarning
+	for rows.Next() {
+		var w strictWarning
+		if err := rows.Scan(&w.Level, &w.Code, &w.Message); err != nil {
+			return fmt.Errorf("strict_warnings: failed to read the warnings: %v", err)
+		}
+		if !strings.EqualFold(w.Level, "Note") {
+			warnings = append(warnings, w)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("strict_warnings: failed to read the warnings: %v", err)
+	}
+	if len(warnings) > 0 {
+		return &strictError{warnings}
+	}
+	return nil
+}
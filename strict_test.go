@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStrictInputsAreDistinct(t *testing.T) {
+	base := []string{"host", "db.internal", "username", "u"}
+	tests := []struct {
+		name           string
+		inputs         []string
+		strict, warned bool
+		conflict       string
+	}{
+		{"strict for kv_get", []string{"data_type", "kv_get", "object_name", "kv", "key_column", "k", "value_column", "v", "key", "a", "strict", "true"}, true, false, ""},
+		{"strict checks writes", []string{"query", "UPDATE t SET a = 1", "strict", "true"}, true, true, ""},
+		{"strict checks steps", []string{"data_type", "steps", "steps", `[{"query": "UPDATE t SET a = 1"}]`, "strict", "true"}, true, true, ""},
+		{"strict on a read operation", []string{"data_type", "list_tables", "dbname", "erp", "strict", "true"}, true, true, "strict"},
+		{"strict_warnings for writes", []string{"query", "UPDATE t SET a = 1", "strict_warnings", "true"}, false, true, ""},
+		{"strict_warnings for kv_get", []string{"data_type", "kv_get", "object_name", "kv", "key_column", "k", "value_column", "v", "key", "a", "strict_warnings", "true"}, false, true, "strict_warnings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, errs := parseSettings(inputOf(append(base, tt.inputs...)...))
+			if cfg.strict != tt.strict || cfg.strictWarnings != tt.warned {
+				t.Errorf("strict %v, strict_warnings %v, want %v, %v", cfg.strict, cfg.strictWarnings, tt.strict, tt.warned)
+			}
+			if got := strings.Join(errorInputs(errs, "conflict"), ","); got != tt.conflict {
+				t.Errorf("conflicts %q, want %q (%s)", got, tt.conflict, errs.summary())
+			}
+		})
+	}
+}
+
+func TestStrictWarningsFailSteps(t *testing.T) {
+	const steps = `[{"query": "CREATE TEMPORARY TABLE tmp_ids (id TINYINT)"}, {"query": "INSERT INTO tmp_ids VALUES (?)", "parameters": [300]}]`
+	for _, warned := range []bool{false, true} {
+		db, stub := openStubDB(t)
+		// The CREATE raises no warnings, the INSERT one.
+		checks := 0
+		stub.respond = func(query string) ([]stubColumn, [][]driver.Value) {
+			columns := []stubColumn{{"Level", "VARCHAR"}, {"Code", "INT"}, {"Message", "VARCHAR"}}
+			if checks++; checks == 1 {
+				return columns, nil
+			}
+			return columns, [][]driver.Value{{[]byte("Warning"), []byte("1264"), []byte("Out of range value for column 'id' at row 1")}}
+		}
+		cfg := settings{dataType: "steps", steps: steps, strict: true, strictWarnings: warned}
+		_, err := runSteps(context.Background(), &database{DB: db}, cfg, &Output{})
+		var stErr *strictError
+		if errors.As(err, &stErr) != warned {
+			t.Errorf("strict_warnings=%v: err = %v", warned, err)
+		}
+		if warned && !strings.Contains(err.Error(), "step 2: strict_warnings: rolled back") {
+			t.Errorf("err = %v, want it to name the step", err)
+		}
+		if left := stub.temporaries(); len(left) != 0 {
+			t.Errorf("temporary tables %q outlive the steps", left)
+		}
+	}
+}
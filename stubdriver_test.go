@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// stubConnector opens stubConns that record the statements sent to them,
+// standing in for a server in tests. It holds one table of the given
+// columns: an INSERT adds a row of its arguments and any query returns the
+// rows, as the text protocol sends them. Tables named tmp_... are
+// temporary: CREATE TEMPORARY TABLE makes one on its connection, which
+// alone can use it until the connection closes.
+type stubConnector struct {
+	mu      sync.Mutex
+	queries []string
+	columns []stubColumn
+	table   [][]driver.Value
+	conns   map[*stubConn]bool // open connections
+
+	// charsets holds the character set of a column by name. An INSERT of
+	// text the column cannot store fails with error 1366, as under a strict
+	// sql_mode.
+	charsets map[string]string
+	// respond, when set, answers queries in place of the table.
+	respond func(query string) ([]stubColumn, [][]driver.Value)
+}
+
+// stubColumn is a column of the stub table, with its DatabaseTypeName.
+type stubColumn struct {
+	name, typ string
+}
+
+func (c *stubConnector) Connect(context.Context) (driver.Conn, error) {
+	conn := &stubConn{c: c, temporary: map[string]bool{}}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil {
+		c.conns = map[*stubConn]bool{}
+	}
+	c.conns[conn] = true
+	return conn, nil
+}
+
+func (c *stubConnector) Driver() driver.Driver { return nil }
+
+func (c *stubConnector) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.queries...)
+}
+
+// temporaries returns the temporary tables of the open connections.
+func (c *stubConnector) temporaries() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for conn := range c.conns {
+		for name := range conn.temporary {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openStubDB opens a pool over a new stubConnector, through commentConnector
+// as connectDB does.
+func openStubDB(t testing.TB) (*sql.DB, *stubConnector) {
+	t.Helper()
+	c := &stubConnector{}
+	db := sql.OpenDB(commentConnector{c})
+	t.Cleanup(func() { db.Close() })
+	return db, c
+}
+
+type stubConn struct {
+	c         *stubConnector
+	temporary map[string]bool // guarded by c.mu
+}
+
+// record notes query and keeps the temporary tables of the connection:
+// it fails with error 1146 when query uses one the connection has not
+// created.
+func (s *stubConn) record(query string) error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	s.c.queries = append(s.c.queries, query)
+	toks := significant(query)
+	for i := 0; i+1 < len(toks); i++ {
+		name := toks[i+1].text
+		if !strings.HasPrefix(name, "tmp_") {
+			continue
+		}
+		switch strings.ToUpper(toks[i].text) {
+		case "TABLE":
+			if i > 0 && strings.EqualFold(toks[i-1].text, "TEMPORARY") {
+				s.temporary[name] = true
+				continue
+			}
+		case "FROM", "INTO", "JOIN":
+		default:
+			continue
+		}
+		if !s.temporary[name] {
+			return &mysql.MySQLError{Number: 1146, Message: fmt.Sprintf("Table '%s' doesn't exist", name)}
+		}
+	}
+	return nil
+}
+
+func (s *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return &stubStmt{conn: s, query: query}, nil
+}
+
+func (s *stubConn) Close() error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	delete(s.c.conns, s)
+	return nil
+}
+
+func (s *stubConn) Begin() (driver.Tx, error) { return stubTx{}, nil }
+
+func (s *stubConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.record(query); err != nil {
+		return nil, err
+	}
+	if strings.Contains(query, "INSERT") {
+		row := make([]driver.Value, len(args))
+		for i, a := range args {
+			row[i] = textValue(a.Value)
+			if i < len(s.c.columns) {
+				col := s.c.columns[i].name
+				if text, ok := a.Value.(string); ok && !charsetHolds(s.c.charsets[col], text) {
+					return nil, &mysql.MySQLError{Number: 1366, Message: fmt.Sprintf("Incorrect string value for column '%s' at row 1", col)}
+				}
+			}
+		}
+		s.c.mu.Lock()
+		s.c.table = append(s.c.table, row)
+		s.c.mu.Unlock()
+		return driver.RowsAffected(1), nil
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s *stubConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := s.record(query); err != nil {
+		return nil, err
+	}
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if s.c.respond != nil {
+		columns, data := s.c.respond(query)
+		return &stubRows{columns: columns, data: data}, nil
+	}
+	return &stubRows{columns: s.c.columns, data: append([][]driver.Value(nil), s.c.table...)}, nil
+}
+
+// CheckNamedValue accepts every argument as is, as the MySQL driver does
+// for uint64 values beyond math.MaxInt64.
+func (s *stubConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+// charsetHolds reports whether a column in charset stores text unchanged:
+// latin1 holds the first 256 code points, utf8 and utf8mb3 the BMP, and any
+// other character set, such as utf8mb4, everything.
+func charsetHolds(charset, text string) bool {
+	limit := rune(utf8.MaxRune)
+	switch charset {
+	case "latin1":
+		limit = 0xFF
+	case "utf8", "utf8mb3":
+		limit = 0xFFFF
+	}
+	for _, r := range text {
+		if r > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// textValue renders an argument as the text protocol returns it.
+func textValue(v driver.Value) driver.Value {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+type stubStmt struct {
+	conn  *stubConn
+	query string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+
+func (s *stubStmt) Exec([]driver.Value) (driver.Result, error) {
+	if err := s.conn.record(s.query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s *stubStmt) Query([]driver.Value) (driver.Rows, error) {
+	if err := s.conn.record(s.query); err != nil {
+		return nil, err
+	}
+	return &stubRows{}, nil
+}
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
+
+// stubRows is a result of the stub table.
+type stubRows struct {
+	columns []stubColumn
+	data    [][]driver.Value
+	buf     []byte // holds the text of the current row, as the driver's read buffer does
+}
+
+func (r *stubRows) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, c := range r.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+func (r *stubRows) ColumnTypeDatabaseTypeName(i int) string { return r.columns[i].typ }
+
+func (r *stubRows) Close() error { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	// The driver returns integer columns as numbers from text results, and
+	// the rest in a buffer the next row overwrites.
+	r.buf = r.buf[:0]
+	for i, c := range r.columns {
+		b, ok := dest[i].([]byte)
+		if !ok {
+			continue
+		}
+		start := len(r.buf)
+		r.buf = append(r.buf, b...)
+		dest[i] = r.buf[start:len(r.buf):len(r.buf)]
+		switch c.typ {
+		case "BIGINT", "INT":
+			if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+				dest[i] = n
+			}
+		case "UNSIGNED BIGINT":
+			if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+				dest[i] = n
+			}
+		}
+	}
+	return nil
+}
+
+// roundTrip inserts the JSON array parameters as one row of the stub table,
+// selects it back through scanRows under cfg and returns the rows as JSON.
+func roundTrip(t *testing.T, cfg settings, columns []stubColumn, parameters string) string {
+	t.Helper()
+	args, err := parseArgs(parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, stub := openStubDB(t)
+	stub.columns = columns
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES ("+placeholders(len(args))+")", args...); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	result, err := scanRows(rows, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
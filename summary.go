@@ -0,0 +1,54 @@
+package main
+
+// statementSummary describes what the component ran, for audit and
+// debugging. It never carries the password or the DSN.
+type statementSummary struct {
+	DataType        string        `json:"data_type"`
+	Host            string        `json:"host"`
+	DBName          string        `json:"dbname"`
+	SQL             string        `json:"sql,omitempty"`
+	ParameterCount  int           `json:"parameter_count"`
+	ParameterTypes  []string      `json:"parameter_types"`
+	ParameterValues []interface{} `json:"parameter_values,omitempty"`
+}
+
+func newStatementSummary(cfg settings) *statementSummary {
+	return &statementSummary{
+		DataType:       cfg.dataType,
+		Host:           cfg.host,
+		DBName:         cfg.dbname,
+		ParameterTypes: []string{},
+	}
+}
+
+// setStatement records the final SQL and describes its arguments. Values are
+// only included when explicitly requested.
+func (s *statementSummary) setStatement(stmt statement, includeValues bool) {
+	s.SQL = stmt.SQL
+	s.ParameterCount = len(stmt.Args)
+	s.ParameterTypes = make([]string, len(stmt.Args))
+	for i, arg := range stmt.Args {
+		s.ParameterTypes[i] = jsonTypeName(arg)
+	}
+	if includeValues {
+		s.ParameterValues = stmt.Args
+	}
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64, int64, int:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// listTables implements data_type=list_tables for the schema named by
+// object_name, or the connection's database. table_prefix narrows the list
+// to the tables starting with it, and strip_prefix returns their names
+// without it, so a flow can work the same for every tenant.
+func listTables(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	// table_prefix only holds identifier characters, of which _ is the one
+	// LIKE treats as a wildcard.
+	pattern := strings.ReplaceAll(cfg.tablePrefix, "_", `\_`) + "%"
+	rows, err := db.QueryContext(ctx, `SELECT table_name AS name, table_type AS type, engine AS engine, table_rows AS `+"`rows`"+`, table_comment AS comment
+FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name LIKE ? ORDER BY table_name`, cfg.objectName, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %v", err)
+	}
+	defer rows.Close()
+	tables, err := scanRows(rows, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.stripPrefix {
+		for _, t := range tables {
+			if name, ok := t["name"].(string); ok && len(name) >= len(cfg.tablePrefix) {
+				t["name"] = name[len(cfg.tablePrefix):]
+			}
+		}
+	}
+	return tables, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestListTablesStripPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		strip bool
+		want  string
+	}{
+		{false, `[{"name":"t001_invoices"},{"name":"t001_orders"}]`},
+		{true, `[{"name":"invoices"},{"name":"orders"}]`},
+	} {
+		db, stub := openStubDB(t)
+		stub.respond = func(string) ([]stubColumn, [][]driver.Value) {
+			return []stubColumn{{"name", "VARCHAR"}}, [][]driver.Value{{[]byte("t001_invoices")}, {[]byte("t001_orders")}}
+		}
+		result, err := listTables(context.Background(), &database{DB: db}, settings{tablePrefix: "t001_", stripPrefix: tt.strip}, &Output{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := json.Marshal(result); string(got) != tt.want {
+			t.Errorf("strip_prefix=%v: got %s, want %s", tt.strip, got, tt.want)
+		}
+	}
+}
+
+func TestTablePrefixDataTypes(t *testing.T) {
+	base := []string{"host", "db.internal", "username", "u", "table_prefix", "t001_"}
+	tests := []struct {
+		name       string
+		inputs     []string
+		objectName string
+		conflicts  string
+	}{
+		{"table", []string{"data_type", "table", "object_name", "erp.invoices"}, "`erp`.`t001_invoices`", ""},
+		{"describe", []string{"data_type", "describe", "object_name", "invoices"}, "`t001_invoices`", ""},
+		{"list_tables filters by the prefix", []string{"data_type", "list_tables", "object_name", "erp", "strip_prefix", "true"}, "erp", ""},
+		{"unprefixed data_type", []string{"data_type", "truncate", "object_name", "invoices"}, "invoices", "table_prefix"},
+		{"strip_prefix elsewhere", []string{"data_type", "table", "object_name", "invoices", "strip_prefix", "true"}, "`t001_invoices`", "strip_prefix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, errs := parseSettings(inputOf(append(base, tt.inputs...)...))
+			if cfg.objectName != tt.objectName {
+				t.Errorf("object_name %q, want %q", cfg.objectName, tt.objectName)
+			}
+			if got := strings.Join(errorInputs(errs, "conflict"), ","); got != tt.conflicts {
+				t.Errorf("conflicts %q, want %q (%s)", got, tt.conflicts, errs.summary())
+			}
+		})
+	}
+	cfg, errs := parseSettings(inputOf("host", "h", "username", "u", "data_type", "list_tables", "strip_prefix", "true"))
+	if got := errorInputs(errs, "conflict"); len(got) != 1 || got[0] != "strip_prefix" || cfg.tablePrefix != "" {
+		t.Errorf("strip_prefix without table_prefix: conflicts %q", got)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Query templates add optional fragments and a whitelisted ORDER BY to a
+// query without ever interpolating a value into the SQL:
+//
+//	SELECT * FROM orders WHERE 1=1
+//	{{if param "status"}} AND status = :status {{end}}
+//	{{orderby "sort" allowed "name,created_at" default "created_at"}}
+//
+// Directives are if param / if not param, else, end, orderby and prefix,
+// which inserts table_prefix. Values only ever become ? arguments through
+// :name placeholders; an array value expands to one placeholder per element
+// for IN lists.
+
+type templateNode struct {
+	text string // literal SQL when directive is empty
+
+	directive string // "if", "orderby" or "prefix"
+	param     string
+	negate    bool
+	then, els []templateNode
+	allowed   []string
+	fallback  string
+}
+
+// parseTemplate builds the directive tree. "{{" always starts a directive,
+// including inside string literals.
+func parseTemplate(src string) ([]templateNode, error) {
+	nodes, rest, end, err := parseTemplateNodes(src)
+	if err != nil {
+		return nil, err
+	}
+	if end != "" || rest != "" {
+		return nil, fmt.Errorf("template: {{%s}} without a matching {{if}}", end)
+	}
+	return nodes, nil
+}
+
+// parseTemplateNodes parses until the end of src or an else/end directive,
+// which it returns together with the text after it.
+func parseTemplateNodes(src string) ([]templateNode, string, string, error) {
+	var nodes []templateNode
+	for {
+		open := strings.Index(src, "{{")
+		if open < 0 {
+			if src != "" {
+				nodes = append(nodes, templateNode{text: src})
+			}
+			return nodes, "", "", nil
+		}
+		if open > 0 {
+			nodes = append(nodes, templateNode{text: src[:open]})
+		}
+		closing := strings.Index(src[open:], "}}")
+		if closing < 0 {
+			return nil, "", "", fmt.Errorf("template: unterminated {{ at %q", abbreviate(src[open:]))
+		}
+		body := strings.TrimSpace(src[open+2 : open+closing])
+		src = src[open+closing+2:]
+
+		words, err := directiveWords(body)
+		if err != nil {
+			return nil, "", "", err
+		}
+		switch {
+		case len(words) == 1 && (words[0] == "else" || words[0] == "end"):
+			return nodes, src, words[0], nil
+		case len(words) >= 1 && words[0] == "if":
+			n := templateNode{directive: "if"}
+			args := words[1:]
+			if len(args) > 0 && args[0] == "not" {
+				n.negate, args = true, args[1:]
+			}
+			if len(args) != 2 || args[0] != "param" || !isQuotedWord(args[1]) {
+				return nil, "", "", fmt.Errorf(`template: {{%s}} must be {{if param "name"}} or {{if not param "name"}}`, body)
+			}
+			n.param = unquoteWord(args[1])
+			var end string
+			if n.then, src, end, err = parseTemplateNodes(src); err != nil {
+				return nil, "", "", err
+			}
+			if end == "else" {
+				if n.els, src, end, err = parseTemplateNodes(src); err != nil {
+					return nil, "", "", err
+				}
+			}
+			if end != "end" {
+				return nil, "", "", fmt.Errorf("template: {{%s}} is missing its {{end}}", body)
+			}
+			nodes = append(nodes, n)
+		case len(words) == 1 && words[0] == "prefix":
+			nodes = append(nodes, templateNode{directive: "prefix"})
+		case len(words) >= 1 && words[0] == "orderby":
+			n, err := parseOrderBy(body, words[1:])
+			if err != nil {
+				return nil, "", "", err
+			}
+			nodes = append(nodes, n)
+		default:
+			return nil, "", "", fmt.Errorf(`template: unsupported directive {{%s}}; only if param, else, end, orderby and prefix are allowed, and values are referenced as :name`, body)
+		}
+	}
+}
+
+func parseOrderBy(body string, args []string) (templateNode, error) {
+	n := templateNode{directive: "orderby"}
+	if len(args) != 3 && len(args) != 5 || !isQuotedWord(args[0]) || args[1] != "allowed" || !isQuotedWord(args[2]) {
+		return n, fmt.Errorf(`template: {{%s}} must be {{orderby "param" allowed "col1,col2"}} with an optional default "col"`, body)
+	}
+	n.param = unquoteWord(args[0])
+	for _, col := range strings.Split(unquoteWord(args[2]), ",") {
+		col = strings.TrimSpace(col)
+		if _, err := quoteQualifiedIdent(col); err != nil {
+			return n, fmt.Errorf("template: orderby %q: %v", n.param, err)
+		}
+		n.allowed = append(n.allowed, col)
+	}
+	if len(args) == 5 {
+		if args[3] != "default" || !isQuotedWord(args[4]) {
+			return n, fmt.Errorf(`template: {{%s}} must end with default "col"`, body)
+		}
+		n.fallback = unquoteWord(args[4])
+		if _, _, err := n.orderTerm(n.fallback); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// directiveWords splits a directive body into words and "quoted" strings.
+func directiveWords(body string) ([]string, error) {
+	var words []string
+	for body = strings.TrimSpace(body); body != ""; body = strings.TrimSpace(body) {
+		if body[0] == '"' {
+			end := strings.IndexByte(body[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("template: unterminated string in {{%s}}", body)
+			}
+			words = append(words, body[:end+2])
+			body = body[end+2:]
+			continue
+		}
+		end := strings.IndexAny(body, " \t\n\"")
+		if end < 0 {
+			end = len(body)
+		}
+		words = append(words, body[:end])
+		body = body[end:]
+	}
+	return words, nil
+}
+
+func isQuotedWord(w string) bool { return len(w) >= 2 && w[0] == '"' && w[len(w)-1] == '"' }
+
+func unquoteWord(w string) string { return w[1 : len(w)-1] }
+
+// orderTerm validates an ORDER BY value, a whitelisted column optionally
+// followed by asc or desc.
+func (n templateNode) orderTerm(value string) (ident, sqlText, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return ident{}, "", fmt.Errorf("template: orderby %q must be a column optionally followed by asc or desc, got %q", n.param, value)
+	}
+	var dir sqlText
+	if len(fields) == 2 {
+		switch strings.ToUpper(fields[1]) {
+		case "ASC":
+			dir = " ASC"
+		case "DESC":
+			dir = " DESC"
+		default:
+			return ident{}, "", fmt.Errorf("template: orderby %q direction must be asc or desc, got %q", n.param, fields[1])
+		}
+	}
+	for _, col := range n.allowed {
+		if col == fields[0] {
+			quoted, _ := qualifiedColumnIdent(col)
+			return quoted, dir, nil
+		}
+	}
+	return ident{}, "", fmt.Errorf("template: orderby %q must be one of %s, got %q", n.param, strings.Join(n.allowed, ", "), fields[0])
+}
+
+// renderTemplate renders a query_template against named parameters and
+// returns SQL with ? placeholders and the matching arguments. prefix is the
+// validated table_prefix.
+func renderTemplate(src string, params map[string]interface{}, prefix string) (string, []interface{}, error) {
+	nodes, err := parseTemplate(src)
+	if err != nil {
+		return "", nil, err
+	}
+	b := new(stmtBuilder)
+	if err := b.templateNodes(nodes, params, prefix); err != nil {
+		return "", nil, err
+	}
+	query, _ := b.build()
+	return bindNamed(query, params)
+}
+
+// templateNodes writes the rendered nodes. Their text is the template's
+// own SQL, which bindNamed then checks for placeholders.
+func (b *stmtBuilder) templateNodes(nodes []templateNode, params map[string]interface{}, prefix string) error {
+	for _, n := range nodes {
+		switch n.directive {
+		case "":
+			b.text(sqlText(n.text))
+		case "if":
+			v, ok := params[n.param]
+			branch := n.then
+			if (ok && v != nil) == n.negate {
+				branch = n.els
+			}
+			if err := b.templateNodes(branch, params, prefix); err != nil {
+				return err
+			}
+		case "prefix":
+			if prefix == "" {
+				return fmt.Errorf("template: {{prefix}} requires table_prefix")
+			}
+			// prefix is a validated table_prefix.
+			b.text(sqlText(prefix))
+		case "orderby":
+			value := n.fallback
+			if v, ok := params[n.param]; ok && v != nil {
+				s, isString := v.(string)
+				if !isString {
+					return fmt.Errorf("template: orderby %q must be a string", n.param)
+				}
+				value = s
+			}
+			if value == "" {
+				continue
+			}
+			col, dir, err := n.orderTerm(value)
+			if err != nil {
+				return err
+			}
+			b.text("ORDER BY ").ident(col).text(dir)
+		}
+	}
+	return nil
+}
+
+// bindNamed replaces :name placeholders outside strings and comments with
+// ?. Positional ? placeholders are refused so arguments cannot shift.
+func bindNamed(sqlText string, params map[string]interface{}) (string, []interface{}, error) {
+	var b strings.Builder
+	var args []interface{}
+	tokens := tokenize(sqlText)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.kind == tokPlaceholder:
+			return "", nil, fmt.Errorf("template: use :name placeholders instead of ? in a query_template")
+		case tok.kind == tokPunct && tok.text == ":" && i+1 < len(tokens) && tokens[i+1].kind == tokWord && (i == 0 || tokens[i-1].text != ":"):
+			name := tokens[i+1].text
+			i++
+			v, ok := params[name]
+			if !ok {
+				return "", nil, fmt.Errorf("template: parameter %q is not set", name)
+			}
+			switch v := v.(type) {
+			case []interface{}:
+				if len(v) == 0 {
+					return "", nil, fmt.Errorf("template: parameter %q is an empty list", name)
+				}
+				b.WriteString(placeholders(len(v)))
+				args = append(args, v...)
+			case map[string]interface{}:
+				return "", nil, fmt.Errorf("template: parameter %q must be a value or a list, not an object", name)
+			default:
+				b.WriteString("?")
+				args = append(args, v)
+			}
+		default:
+			b.WriteString(tok.text)
+		}
+	}
+	return b.String(), args, nil
+}
+
+// parseTemplateParams reads the parameters of a query_template, a JSON
+// object of named values.
+func parseTemplateParams(raw string) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if raw == "" {
+		return params, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		return nil, fmt.Errorf("parameters must be a JSON object of named values for query_template: %v", err)
+	}
+	return params, nil
+}
+
+func abbreviate(s string) string {
+	if len(s) > 40 {
+		return s[:40] + "..."
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplateKeepsValuesOutOfSQL(t *testing.T) {
+	const src = "SELECT * FROM orders WHERE 1=1 {{if param \"status\"}}AND status = :status {{end}}AND id IN (:ids) {{orderby \"sort\" allowed \"name,created_at\" default \"created_at\"}}"
+	hostile := "x' OR '1'='1' -- {{end}} :ids ?"
+	params := map[string]interface{}{
+		"status": hostile,
+		"ids":    []interface{}{"1) OR (1=1", float64(2)},
+	}
+	sql, args, err := renderTemplate(src, params, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT * FROM orders WHERE 1=1 AND status = ? AND id IN (?,?) ORDER BY `created_at`"; sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+	if want := []interface{}{hostile, "1) OR (1=1", float64(2)}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %#v, want %#v", args, want)
+	}
+}
+
+func TestRenderTemplateOrderByOnlyTakesAllowedColumns(t *testing.T) {
+	const src = `SELECT * FROM t {{orderby "sort" allowed "name,created_at"}}`
+	for _, sort := range []string{
+		"name; DROP TABLE t",
+		"name desc, (SELECT SLEEP(5))",
+		"`name`",
+		"name desc -- ",
+		"NAME",
+		"created_at sideways",
+		"id",
+	} {
+		if sql, _, err := renderTemplate(src, map[string]interface{}{"sort": sort}, ""); err == nil {
+			t.Errorf("sort %q rendered %q, want an error", sort, sql)
+		}
+	}
+	if _, _, err := renderTemplate(src, map[string]interface{}{"sort": []interface{}{"name"}}, ""); err == nil {
+		t.Error("a list sort value rendered, want an error")
+	}
+	sql, args, err := renderTemplate(src, map[string]interface{}{"sort": "created_at desc"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT * FROM t ORDER BY `created_at` DESC"; sql != want || len(args) != 0 {
+		t.Errorf("sql = %q, args = %v, want %q", sql, args, want)
+	}
+}
+
+func TestRenderTemplateQuotesAllowedColumns(t *testing.T) {
+	sql, _, err := renderTemplate(`SELECT * FROM orders o {{orderby "sort" allowed "o.created_at,o.name"}}`, map[string]interface{}{"sort": "o.name asc"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT * FROM orders o ORDER BY `o`.`name` ASC"; sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+	if _, _, err := renderTemplate(`SELECT 1 {{orderby "sort" allowed ""}}`, nil, ""); err == nil {
+		t.Error("an empty allowed column was accepted")
+	}
+}
+
+func TestRenderTemplateRejectsRawInterpolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		params  map[string]interface{}
+		wantErr string
+	}{
+		{"go template action", "SELECT {{.status}}", nil, "unsupported directive"},
+		{"printf", `SELECT {{printf "%s" "x"}}`, nil, "unsupported directive"},
+		{"param directive", `SELECT * FROM t WHERE a = {{param "status"}}`, nil, "unsupported directive"},
+		{"positional placeholder", "SELECT * FROM t WHERE a = ?", nil, "use :name placeholders"},
+		{"object value", "SELECT * FROM t WHERE a = :a", map[string]interface{}{"a": map[string]interface{}{"b": 1}}, "not an object"},
+		{"empty list", "SELECT * FROM t WHERE a IN (:a)", map[string]interface{}{"a": []interface{}{}}, "empty list"},
+		{"unset parameter", "SELECT * FROM t WHERE a = :a", nil, "is not set"},
+		{"prefix without table_prefix", "SELECT * FROM {{prefix}}t", nil, "requires table_prefix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, _, err := renderTemplate(tt.src, tt.params, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("rendered %q, %v, want an error containing %q", sql, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateLeavesLiteralsAlone(t *testing.T) {
+	sql, args, err := renderTemplate("SELECT ':a', `:a`, a::text /* :a */ FROM t WHERE b = :a", map[string]interface{}{"a": "v"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT ':a', `:a`, a::text /* :a */ FROM t WHERE b = ?"; sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"v"}) {
+		t.Errorf("args = %v, want [v]", args)
+	}
+}
+
+func TestTablePrefixIsAPlainIdentifier(t *testing.T) {
+	for _, prefix := range []string{"t001_", "tenant$"} {
+		if !validPrefix(prefix) {
+			t.Errorf("validPrefix(%q) = false", prefix)
+		}
+	}
+	for _, prefix := range []string{"", "t; DROP TABLE x; --", "t`", "t.", "t'", "t "} {
+		if validPrefix(prefix) {
+			t.Errorf("validPrefix(%q) = true", prefix)
+		}
+	}
+	sql, _, err := renderTemplate("SELECT * FROM {{prefix}}invoices", nil, "t001_")
+	if err != nil || sql != "SELECT * FROM t001_invoices" {
+		t.Errorf("rendered %q, %v", sql, err)
+	}
+}
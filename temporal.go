@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxTemporalWarnings caps the warnings listing invalid temporal values;
+// the rest are only counted.
+const maxTemporalWarnings = 10
+
+// temporalScan is the zero_date_mode of the current invocation. Without it
+// the driver parses DATE, DATETIME and TIMESTAMP values, turning
+// 0000-00-00 into 0001-01-01 and failing the whole scan on a value it
+// cannot read. With it the connection returns the values as text, which
+// the scanner parses itself: a zero date becomes NULL (mode null) or stays
+// the text as stored (mode string), and so does an invalid value such as
+// 2024-02-30 left by a lax sql_mode, which also adds a warning naming the
+// row.
+type temporalScan struct {
+	mode     string // null or string
+	invalid  int
+	warnings []string
+}
+
+func newTemporalScan(cfg settings) *temporalScan {
+	if cfg.zeroDateMode == "" {
+		return nil
+	}
+	return &temporalScan{mode: cfg.zeroDateMode}
+}
+
+// temporalTypes are the column types parseTime would have converted.
+var temporalTypes = map[string]bool{"DATE": true, "DATETIME": true, "TIMESTAMP": true}
+
+// temporalColumns marks the temporal result columns, indexed like them,
+// which are parsed under zero_date_mode.
+func temporalColumns(rows *sql.Rows, columns []string) ([]bool, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("columns error: %v", err)
+	}
+	parse := make([]bool, len(columns))
+	for i, t := range types {
+		parse[i] = temporalTypes[t.DatabaseTypeName()]
+	}
+	return parse, nil
+}
+
+// convert returns the time in s, the text of a temporal column, or for a
+// zero or invalid value NULL or s itself. row is the index of the row in
+// the result, from 0.
+func (t *temporalScan) convert(col string, row int64, s string) interface{} {
+	layout := "2006-01-02"
+	if len(s) > len(layout) {
+		layout = "2006-01-02 15:04:05.999999"
+	}
+	v, err := time.ParseInLocation(layout, s, time.UTC)
+	if err == nil {
+		return v
+	}
+	if strings.Trim(s, "0-:. ") != "" {
+		if t.invalid < maxTemporalWarnings {
+			t.warnings = append(t.warnings, fmt.Sprintf("row_index %d: %s holds the invalid value %q, returned as %s", row, col, s, t.replacement()))
+		}
+		t.invalid++
+	}
+	if t.mode == "null" {
+		return nil
+	}
+	return s
+}
+
+func (t *temporalScan) replacement() string {
+	if t.mode == "null" {
+		return "null"
+	}
+	return "text"
+}
+
+// replaced reports whether an invalid value was replaced since the last
+// flush.
+func (t *temporalScan) replaced() bool {
+	return t != nil && t.invalid > 0
+}
+
+// flush returns the warnings collected so far and clears them.
+func (t *temporalScan) flush() []string {
+	if t == nil || t.invalid == 0 {
+		return nil
+	}
+	warnings := t.warnings
+	if t.invalid > len(warnings) {
+		warnings = append(warnings, fmt.Sprintf("%d invalid temporal values in all, returned as %s", t.invalid, t.replacement()))
+	}
+	t.invalid, t.warnings = 0, nil
+	return warnings
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// tenantSessionVariable carries tenant_value on every connection so raw
+// queries can filter with WHERE company_id = @tenant_value.
+const tenantSessionVariable = "@tenant_value"
+
+// tenantScope reports how tenant_column scoped the statement: "filter" when
+// the condition was added to the generated SQL, "checked" when a raw query
+// was verified to reference the column.
+type tenantScope struct {
+	Column string `json:"column"`
+	Mode   string `json:"mode"`
+}
+
+// tenantDataTypes are the data_types require_tenant_filter can enforce;
+// any other is refused rather than run unscoped.
+var tenantDataTypes = map[string]bool{"table": true, "update": true, "delete": true, "insert": true, "query": true, "copy_table": true, "changes": true, "create_event": true, "purge": true, "archive": true, "anonymize": true, "generate": true, "claim": true, "tree": true}
+
+func validateTenant(cfg settings, errs *validationErrors) {
+	if cfg.tenantColumn == "" {
+		if cfg.tenantValue != "" {
+			errs.add("required", "tenant_column", "tenant_value requires tenant_column")
+		}
+		if cfg.requireTenantFilter {
+			errs.add("required", "tenant_column", "require_tenant_filter requires tenant_column and tenant_value")
+		}
+		return
+	}
+	if _, err := quoteIdent(cfg.tenantColumn); err != nil {
+		errs.add("invalid_identifier", "tenant_column", "invalid tenant_column: %v", err)
+		return
+	}
+	if cfg.tenantValue == "" {
+		errs.add("required", "tenant_value", "tenant_value is required when tenant_column is set")
+		return
+	}
+	if !cfg.requireTenantFilter {
+		return
+	}
+	if !tenantDataTypes[cfg.dataType] {
+		errs.add("conflict", "require_tenant_filter", "require_tenant_filter cannot scope data_type=%s; use table, query, insert, update, delete, purge, archive, anonymize, generate, copy_table, changes or create_event", cfg.dataType)
+	} else if cfg.dataType == "query" && !referencesColumn(cfg.query, cfg.tenantColumn) {
+		errs.add("tenant_filter_missing", "query", "query must filter on tenant column %s (e.g. %s = %s) because require_tenant_filter is set", cfg.tenantColumn, cfg.tenantColumn, tenantSessionVariable)
+	} else if cfg.dataType == "create_event" && !referencesColumn(cfg.eventBody, cfg.tenantColumn) {
+		// The event runs in a server session of its own, without the
+		// tenant session variable: the value has to be written out.
+		errs.add("tenant_filter_missing", "event_body", "event_body must filter on tenant column %s with a literal value because require_tenant_filter is set", cfg.tenantColumn)
+	}
+}
+
+// referencesColumn reports whether the statement mentions col as an
+// identifier, bare, quoted or qualified. It is a token scan, not a parse:
+// any mention passes, wherever it appears.
+func referencesColumn(sqlText, col string) bool {
+	for _, tok := range tokenize(sqlText) {
+		name := tok.text
+		switch tok.kind {
+		case tokQuotedIdent:
+			name = strings.ReplaceAll(strings.Trim(name, "`"), "``", "`")
+		case tokWord:
+		default:
+			continue
+		}
+		if strings.EqualFold(name, col) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantCondition is the tenant_column = tenant_value condition, or an empty
+// string without tenant_column.
+func tenantCondition(cfg settings) (string, []interface{}) {
+	if cfg.tenantColumn == "" {
+		return "", nil
+	}
+	col, _ := columnIdent(cfg.tenantColumn)
+	return col.sql + " = ?", []interface{}{cfg.tenantValue}
+}
+
+// scopeInsertRows sets tenant_column on every inserted row, refusing rows
+// that name another tenant.
+func scopeInsertRows(cfg settings, cols []string, rows [][]interface{}) ([]string, [][]interface{}, error) {
+	if cfg.tenantColumn == "" {
+		return cols, rows, nil
+	}
+	for i, col := range cols {
+		if !strings.EqualFold(col, cfg.tenantColumn) {
+			continue
+		}
+		for j, row := range rows {
+			if fmt.Sprint(row[i]) != cfg.tenantValue {
+				return nil, nil, fmt.Errorf("row %d sets %s to %v, not tenant_value", j+1, col, row[i])
+			}
+		}
+		return cols, rows, nil
+	}
+	cols = append(cols, cfg.tenantColumn)
+	for i := range rows {
+		rows[i] = append(rows[i], cfg.tenantValue)
+	}
+	return cols, rows, nil
+}
+
+// applyTenantSession sets the tenant session variable on every connection.
+func applyTenantSession(c *mysql.Config, cfg settings) {
+	if cfg.tenantColumn == "" || cfg.tenantValue == "" {
+		return
+	}
+	if c.Params == nil {
+		c.Params = map[string]string{}
+	}
+	c.Params[tenantSessionVariable] = quoteString(cfg.tenantValue)
+}
+
+func (cfg settings) tenantScope() *tenantScope {
+	if cfg.tenantColumn == "" || !tenantDataTypes[cfg.dataType] {
+		return nil
+	}
+	mode := "filter"
+	if cfg.dataType == "query" || cfg.dataType == "create_event" {
+		if !cfg.requireTenantFilter {
+			return nil
+		}
+		mode = "checked"
+	}
+	return &tenantScope{Column: cfg.tenantColumn, Mode: mode}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// traceContext is a parsed W3C traceparent header:
+// version-traceid-parentid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+type traceContext struct {
+	TraceID  string
+	ParentID string
+	Flags    string
+}
+
+func parseTraceparent(header string) (*traceContext, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(header)), "-")
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("expected version-traceid-parentid-flags")
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return nil, fmt.Errorf("unsupported version %q", version)
+	}
+	if !isHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return nil, fmt.Errorf("invalid trace id")
+	}
+	if !isHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return nil, fmt.Errorf("invalid parent id")
+	}
+	if !isHex(flags, 2) {
+		return nil, fmt.Errorf("invalid flags")
+	}
+	return &traceContext{TraceID: traceID, ParentID: parentID, Flags: flags}, nil
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// span is the child span covering this invocation's database work. It is
+// echoed in the output so the flow engine can record it.
+type span struct {
+	TraceID      string    `json:"trace_id"`
+	SpanID       string    `json:"span_id"`
+	ParentSpanID string    `json:"parent_span_id"`
+	Start        time.Time `json:"start"`
+	DurationMS   int64     `json:"duration_ms"`
+	Status       string    `json:"status"` // ok or error
+
+	flags string
+}
+
+func newSpan(tc *traceContext) *span {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &span{
+		TraceID:      tc.TraceID,
+		SpanID:       hex.EncodeToString(id),
+		ParentSpanID: tc.ParentID,
+		Start:        time.Now().UTC(),
+		flags:        tc.Flags,
+	}
+}
+
+func (s *span) finish(errMsg string) {
+	s.DurationMS = time.Since(s.Start).Milliseconds()
+	s.Status = "ok"
+	if errMsg != "" {
+		s.Status = "error"
+	}
+}
+
+// sqlComment returns a sqlcommenter style prefix so the statement can be
+// matched to the request, and the trace when s is set, in the slow log and
+// performance_schema. Keys are sorted and values URL-encoded as the
+// sqlcommenter spec requires.
+func sqlComment(requestID string, s *span) string {
+	if s == nil {
+		return fmt.Sprintf("/*request_id='%s'*/ ", url.PathEscape(requestID))
+	}
+	traceparent := fmt.Sprintf("00-%s-%s-%s", s.TraceID, s.SpanID, s.flags)
+	return fmt.Sprintf("/*request_id='%s',traceparent='%s'*/ ", url.PathEscape(requestID), url.PathEscape(traceparent))
+}
+
+// traceComment is the sqlComment of the current invocation. commentConn
+// prepends it to every statement sent on a connection, whichever operation,
+// transaction or pinned connection sends it.
+var traceComment string
+
+// commentConnector opens connections that prepend traceComment.
+type commentConnector struct {
+	driver.Connector
+}
+
+func (c commentConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return commentConn{conn}, nil
+}
+
+// commentConn is a driver connection prepending traceComment to the
+// statements it executes, queries and prepares. The other optional driver
+// interfaces are passed through, so database/sql uses the connection as it
+// would the driver's own.
+type commentConn struct {
+	driver.Conn
+}
+
+func withTraceComment(query string) string {
+	return traceComment + query
+}
+
+func (c commentConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(withTraceComment(query))
+}
+
+func (c commentConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, withTraceComment(query))
+	}
+	return c.Prepare(query)
+}
+
+func (c commentConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, withTraceComment(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c commentConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, withTraceComment(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c commentConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c commentConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c commentConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c commentConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c commentConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// newRequestID returns a random RFC 4122 version 4 UUID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// transform is the transform input: per-row renames, value mappings and
+// column exclusions. Columns are always named as the query returns them.
+type transform struct {
+	Rename         map[string]string                     `json:"rename"`
+	MapValues      map[string]map[string]json.RawMessage `json:"map_values"`
+	ExcludeColumns []string                              `json:"exclude_columns"`
+
+	values  map[string]map[string]interface{}
+	exclude map[string]bool
+}
+
+func parseTransform(s string) (*transform, error) {
+	if s == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.DisallowUnknownFields()
+	var t transform
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("transform must be an object with rename, map_values and exclude_columns: %v", err)
+	}
+	targets := map[string]string{}
+	for from, to := range t.Rename {
+		if to == "" {
+			return nil, fmt.Errorf("transform.rename: %q has an empty new name", from)
+		}
+		if prev, ok := targets[to]; ok {
+			return nil, fmt.Errorf("transform.rename: %q and %q are both renamed to %q", prev, from, to)
+		}
+		targets[to] = from
+	}
+	t.values = map[string]map[string]interface{}{}
+	for col, mapping := range t.MapValues {
+		t.values[col] = map[string]interface{}{}
+		for from, raw := range mapping {
+			var v interface{}
+			json.Unmarshal(raw, &v)
+			t.values[col][from] = v
+		}
+	}
+	t.exclude = map[string]bool{}
+	for _, col := range t.ExcludeColumns {
+		t.exclude[col] = true
+	}
+	return &t, nil
+}
+
+// check returns a warning for every column the spec names that the result
+// does not have, so one spec can be shared by similar queries.
+func (t *transform) check(columns map[string]bool) []string {
+	var warnings []string
+	unknown := func(part, col string) {
+		if !columns[col] {
+			warnings = append(warnings, fmt.Sprintf("transform.%s: column %q is not in the result", part, col))
+		}
+	}
+	for _, col := range sortedKeys(t.Rename) {
+		unknown("rename", col)
+		if to := t.Rename[col]; columns[to] && t.Rename[to] == "" && !t.exclude[to] {
+			warnings = append(warnings, fmt.Sprintf("transform.rename: %q replaces the existing column %q", col, to))
+		}
+	}
+	for _, col := range sortedKeys(t.MapValues) {
+		unknown("map_values", col)
+	}
+	for _, col := range t.ExcludeColumns {
+		unknown("exclude_columns", col)
+	}
+	return warnings
+}
+
+// apply maps values and drops excluded columns, then renames. Values
+// without a mapping are kept; they are matched by their text form, so
+// {"1": "Active"} matches the integer 1. A renamed column replaces an
+// existing column of the same name.
+func (t *transform) apply(row map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(row))
+	var renamed []string
+	for col, v := range row {
+		if t.exclude[col] {
+			continue
+		}
+		if mapping, ok := t.values[col]; ok && v != nil {
+			if mapped, ok := mapping[fmt.Sprint(v)]; ok {
+				v = mapped
+			}
+		}
+		if _, ok := t.Rename[col]; ok {
+			renamed = append(renamed, col)
+			row[col] = v
+			continue
+		}
+		out[col] = v
+	}
+	for _, col := range renamed {
+		out[t.Rename[col]] = row[col]
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rowShaper post-processes result rows before they are encoded, in the
+// buffered and the streamed path alike: normalize_show, then transform,
+// then nesting, then column_case. The spec is checked against the first row; its warnings are
+// collected for the output.
+type rowShaper struct {
+	show       *showNormalizer
+	transform  *transform
+	nesting    *nesting
+	columnCase string
+	keys       map[string]map[string]string // column_case names per object path
+
+	checked  bool
+	warnings []string
+}
+
+// newRowShaper returns nil when no row post-processing is configured.
+func newRowShaper(cfg settings) *rowShaper {
+	// The inputs were validated by parseSettings.
+	t, _ := parseTransform(cfg.transform)
+	n, _ := newNesting(cfg)
+	columnCase := cfg.columnCase
+	if columnCase == "as_is" {
+		columnCase = ""
+	}
+	show := newShowNormalizer(cfg)
+	if show == nil && t == nil && n == nil && columnCase == "" {
+		return nil
+	}
+	return &rowShaper{show: show, transform: t, nesting: n, columnCase: columnCase, keys: map[string]map[string]string{}}
+}
+
+// shapeKey identifies the post-processing in cache keys, since cached
+// results are stored shaped.
+func (cfg settings) shapeKey() string {
+	var b strings.Builder
+	b.WriteString(cfg.transform)
+	if cfg.columnCase != "" && cfg.columnCase != "as_is" {
+		fmt.Fprintf(&b, "\ncolumn_case=%s", cfg.columnCase)
+	}
+	if cfg.nestByPrefix {
+		fmt.Fprintf(&b, "\nnest=%q,%s,%t", cfg.nestSeparator, cfg.nestPrefixes, cfg.nullCollapse)
+	}
+	if cfg.groupByColumns != "" {
+		fmt.Fprintf(&b, "\ngroup=%s,%q,%d", cfg.groupByColumns, cfg.childrenKey, cfg.maxChildren)
+	}
+	if cfg.truncateColumns != "" {
+		fmt.Fprintf(&b, "\ntruncate=%s", cfg.truncateColumns)
+	}
+	if cfg.bigIntMode != "" && cfg.bigIntMode != "number" {
+		fmt.Fprintf(&b, "\nbig_int=%s", cfg.bigIntMode)
+	}
+	if cfg.zeroDateMode != "" {
+		fmt.Fprintf(&b, "\nzero_date_mode=%s", cfg.zeroDateMode)
+	}
+	if cfg.normalizeShow {
+		b.WriteString("\nnormalize_show")
+	}
+	if cfg.preserveColumnOrder {
+		b.WriteString("\npreserve_column_order")
+	}
+	if cfg.clientSort != "" || cfg.clientLimit > 0 {
+		fmt.Fprintf(&b, "\nclient_sort=%s,%t,%d", cfg.clientSort, cfg.clientSortIgnoreCase, cfg.clientLimit)
+	}
+	return b.String()
+}
+
+func (s *rowShaper) shape(row map[string]interface{}) map[string]interface{} {
+	if s.show != nil {
+		row = s.show.apply(row)
+	}
+	if s.transform != nil {
+		if !s.checked {
+			s.warnings = append(s.warnings, s.transform.check(columnSet(row))...)
+		}
+		row = s.transform.apply(row)
+	}
+	if s.nesting != nil {
+		if !s.checked {
+			s.warnings = append(s.warnings, s.nesting.plan(sortedKeys(row))...)
+		}
+		row = s.nesting.apply(row)
+	}
+	if s.columnCase != "" {
+		row = s.convertKeys("", row)
+	}
+	s.checked = true
+	return row
+}
+
+// convertKeys applies column_case to m and the objects nested in it. The
+// names, and so the collision suffixes, are fixed by the first row that
+// has each object.
+func (s *rowShaper) convertKeys(path string, m map[string]interface{}) map[string]interface{} {
+	keys, ok := s.keys[path]
+	if !ok {
+		var warnings []string
+		keys, warnings = caseKeys(sortedKeys(m), s.columnCase)
+		s.keys[path] = keys
+		s.warnings = append(s.warnings, warnings...)
+	}
+	converted := make(map[string]interface{}, len(m))
+	for col, v := range m {
+		key, ok := keys[col]
+		if !ok {
+			key = convertCase(col, s.columnCase)
+		}
+		if child, ok := v.(map[string]interface{}); ok {
+			v = s.convertKeys(path+"\x00"+col, child)
+		}
+		converted[key] = v
+	}
+	return converted
+}
+
+func columnSet(row map[string]interface{}) map[string]bool {
+	columns := make(map[string]bool, len(row))
+	for col := range row {
+		columns[col] = true
+	}
+	return columns
+}
+
+func (s *rowShaper) shapeAll(rows []map[string]interface{}) []map[string]interface{} {
+	for i, row := range rows {
+		rows[i] = s.shape(row)
+	}
+	return rows
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The columns data_type=tree adds to each row. Internal ones are removed
+// before the rows are returned.
+const (
+	treeDepthColumn = "_tree_depth"
+	treePathColumn  = "_tree_path"
+	treeCycleColumn = "_tree_cycle"
+
+	// maxTreePath is the width of the path column, which must be declared
+	// in the non-recursive part of the CTE.
+	maxTreePath = 8192
+)
+
+// parseRootIDs decodes root_ids, a JSON array of ids.
+func parseRootIDs(raw string) ([]interface{}, error) {
+	var ids []interface{}
+	if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+		return nil, fmt.Errorf("root_ids must be a JSON array of ids: %v", err)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("root_ids is empty")
+	}
+	for _, id := range ids {
+		switch id.(type) {
+		case string, float64:
+		default:
+			return nil, fmt.Errorf("root_ids must hold strings or numbers, got %v", id)
+		}
+	}
+	return ids, nil
+}
+
+// treeCycle is a walk that came back to a row already on its path; it was
+// not followed further.
+type treeCycle struct {
+	Path  string      `json:"path"`
+	Depth int64       `json:"depth"`
+	ID    interface{} `json:"id"`
+}
+
+// tree implements data_type=tree over an adjacency list: object_name rows
+// with id_column (default id) and parent_column (default parent_id). From
+// the roots, root_ids or root_filter, or else the rows without a parent,
+// a recursive CTE walks to the descendants, or with direction=ancestors to
+// the parents, up to max_depth levels. Each row gets depth (0 for a root)
+// and path, the ids from its root joined by /.
+//
+// The walk checks each row against its path, so a cycle in the data ends
+// it: the repeated row is listed in cycles instead of being followed.
+// nest_children returns the descendants as nested objects with children.
+func tree(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	v, err := db.serverVersion(ctx)
+	if err == nil {
+		err = v.require("tree", v.recursiveCTE())
+	}
+	if err != nil {
+		return nil, err
+	}
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	idName, parentName := cfg.idColumn, cfg.parentColumn
+	if idName == "" {
+		idName = "id"
+	}
+	if parentName == "" {
+		parentName = "parent_id"
+	}
+	id, err := columnIdent(idName)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := columnIdent(parentName)
+	if err != nil {
+		return nil, err
+	}
+	ancestors := cfg.direction == "ancestors"
+
+	var nodes filter
+	if cond, args := tenantCondition(cfg); cond != "" {
+		nodes = nodes.and(cond, args...)
+	}
+	roots, err := parseFilter(cfg.rootFilter)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.rootIDs != "":
+		ids, err := parseRootIDs(cfg.rootIDs)
+		if err != nil {
+			return nil, err
+		}
+		roots = roots.and(id.sql+" IN ("+placeholders(len(ids))+")", ids...)
+	case cfg.rootFilter == "":
+		roots = roots.and(parent.sql + " IS NULL")
+	}
+	b := new(stmtBuilder).text("WITH RECURSIVE nodes AS (SELECT * FROM ").ident(table).where(nodes).text("), walk AS (").
+		text("SELECT n.*, 0 AS " + treeDepthColumn + ", CAST(CONCAT('/', n.").ident(id).text(", '/') AS CHAR(").number(maxTreePath).
+		text(")) AS " + treePathColumn + ", 0 AS " + treeCycleColumn + " FROM nodes AS n").where(roots).
+		text(" UNION ALL SELECT n.*, t." + treeDepthColumn + " + 1, CONCAT(t." + treePathColumn + ", n.").ident(id).
+		text(", '/'), LOCATE(CONCAT('/', n.").ident(id).text(", '/'), t." + treePathColumn + ") > 0 FROM walk AS t JOIN nodes AS n ON ")
+	// Descendants join a row's children, ancestors its parent.
+	if ancestors {
+		b.text("n.").ident(id).text(" = t.").ident(parent)
+	} else {
+		b.text("n.").ident(parent).text(" = t.").ident(id)
+	}
+	b.text(" WHERE t." + treeCycleColumn + " = 0")
+	if cfg.maxDepth > 0 {
+		b.text(" AND t." + treeDepthColumn + " < ").number(int64(cfg.maxDepth))
+	}
+	query, args := b.text(") SELECT * FROM walk ORDER BY " + treeDepthColumn + ", " + treePathColumn).build()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execution error: %w", err)
+	}
+	walked, err := scanRows(rows, cfg)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	added := []string{"depth", "path"}
+	if cfg.nestChildren {
+		added = append(added, "children")
+	}
+	result := []map[string]interface{}{}
+	cycles := []treeCycle{}
+	for _, row := range walked {
+		for _, col := range added {
+			if _, ok := row[col]; ok {
+				return nil, fmt.Errorf("%s has a column named %s, which tree adds to each row", cfg.objectName, col)
+			}
+		}
+		depth := treeInt(row[treeDepthColumn])
+		path := strings.Trim(fmt.Sprint(row[treePathColumn]), "/")
+		cycle := treeInt(row[treeCycleColumn]) != 0
+		delete(row, treeDepthColumn)
+		delete(row, treePathColumn)
+		delete(row, treeCycleColumn)
+		if cycle {
+			cycles = append(cycles, treeCycle{Path: path, Depth: depth, ID: row[idName]})
+			continue
+		}
+		row["depth"], row["path"] = depth, path
+		result = append(result, row)
+	}
+	if len(cycles) > 0 {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("the walk came back on itself %d times in %s; see cycles", len(cycles), cfg.objectName))
+	}
+	if cfg.nestChildren {
+		return map[string]interface{}{"tree": nestTree(result), "count": len(result), "cycles": cycles}, nil
+	}
+	return map[string]interface{}{"rows": result, "count": len(result), "cycles": cycles}, nil
+}
+
+// treeInt reads a depth or cycle flag, which arrives as a number or as
+// text depending on the protocol.
+func treeInt(v interface{}) int64 {
+	n, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+	return n
+}
+
+// nestTree hangs each row under the row its path continues, returning the
+// roots. The rows arrive in depth order, so a parent always comes first.
+func nestTree(rows []map[string]interface{}) []map[string]interface{} {
+	byPath := make(map[string]map[string]interface{}, len(rows))
+	roots := []map[string]interface{}{}
+	for _, row := range rows {
+		path := row["path"].(string)
+		row["children"] = []map[string]interface{}{}
+		byPath[path] = row
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			roots = append(roots, row)
+			continue
+		}
+		if p, ok := byPath[path[:i]]; ok {
+			p["children"] = append(p["children"].([]map[string]interface{}), row)
+		}
+	}
+	return roots
+}
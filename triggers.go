@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	triggerTimings = map[string]bool{"BEFORE": true, "AFTER": true}
+	triggerEvents  = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true}
+)
+
+// triggerError turns the privilege errors trigger statements hit into
+// messages saying what to grant.
+func triggerError(err error, schema, table string) error {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return fmt.Errorf("execution error: %v", err)
+	}
+	switch myErr.Number {
+	case 1142: // ER_TABLEACCESS_DENIED_ERROR
+		return &privilegeError{privilegeReport{Schema: schema, Object: table, Required: []string{"TRIGGER"}, Missing: []string{"TRIGGER"}}}
+	case 1227: // ER_SPECIFIC_ACCESS_DENIED_ERROR, e.g. a DEFINER other than the current user
+		return &privilegeError{privilegeReport{Schema: "*", Required: []string{"SUPER"}, Missing: []string{"SUPER"}}}
+	case 1419: // ER_BINLOG_CREATE_ROUTINE_NEED_SUPER
+		return fmt.Errorf("binary logging is enabled and the user lacks SUPER: grant SUPER or have a DBA set log_bin_trust_function_creators=1 (%v)", myErr.Message)
+	}
+	return fmt.Errorf("execution error: %v", err)
+}
+
+// listTriggers implements data_type=list_triggers: the triggers on the table
+// object_name, on every table of a schema given as schema.*, or of the
+// connection's database when object_name is empty.
+func listTriggers(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	schema, table := interface{}(nil), interface{}(nil)
+	if name := cfg.objectName; strings.HasSuffix(name, ".*") {
+		schema = strings.TrimSuffix(name, ".*")
+	} else if name != "" {
+		parts := splitQualified(name)
+		if len(parts) == 2 {
+			schema = parts[0]
+		}
+		table = parts[len(parts)-1]
+	}
+	rows, err := db.QueryContext(ctx, `SELECT trigger_schema AS `+"`schema`"+`, trigger_name AS name, event_object_table AS `+"`table`"+`,
+	action_timing AS timing, event_manipulation AS event, action_order AS `+"`order`"+`, action_statement AS statement,
+	definer AS definer, CAST(created AS CHAR) AS created
+FROM information_schema.triggers
+WHERE trigger_schema = COALESCE(?, DATABASE()) AND (? IS NULL OR event_object_table = ?)
+ORDER BY event_object_table, action_timing, event_manipulation, action_order`, schema, table, table)
+	if err != nil {
+		s, t := splitTarget(strings.TrimSuffix(cfg.objectName, ".*"), cfg.dbname)
+		return nil, triggerError(err, s, t)
+	}
+	defer rows.Close()
+	triggers, err := scanRows(rows, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(triggers) == 0 {
+		out.Warnings = append(out.Warnings, "information_schema.triggers only lists triggers on tables the user holds TRIGGER on; an empty list may be missing privileges")
+	}
+	return triggers, nil
+}
+
+// showTrigger implements data_type=show_trigger with SHOW CREATE TRIGGER.
+func showTrigger(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	name, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	query, _ := new(stmtBuilder).text("SHOW CREATE TRIGGER ").ident(name).build()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		schema, _ := splitTarget(cfg.objectName, cfg.dbname)
+		return nil, triggerError(err, schema, "")
+	}
+	defer rows.Close()
+	result, err := scanRows(rows, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("trigger %s does not exist", name)
+	}
+	row := result[0]
+	return map[string]interface{}{"trigger": row["Trigger"], "create_trigger": row["SQL Original Statement"], "sql_mode": row["sql_mode"], "created": row["Created"]}, nil
+}
+
+// createTrigger implements data_type=create_trigger on trigger_table.
+func createTrigger(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	name, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	table, err := tableIdent(cfg.triggerTable)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trigger_table: %v", err)
+	}
+	b := new(stmtBuilder).text("CREATE TRIGGER ")
+	if cfg.ifNotExists {
+		b.text("IF NOT EXISTS ")
+	}
+	// The timing and event are keywords and the body a statement, all
+	// checked with the inputs.
+	ddl, _ := b.ident(name).text(" ").text(sqlText(cfg.triggerTiming)).text(" ").text(sqlText(cfg.triggerEvent)).
+		text(" ON ").ident(table).text(" FOR EACH ROW ").text(sqlText(cfg.triggerBody)).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		schema, object := splitTarget(cfg.triggerTable, cfg.dbname)
+		return nil, triggerError(err, schema, object)
+	}
+	return map[string]interface{}{"ddl": ddl}, nil
+}
+
+// dropTrigger implements data_type=drop_trigger. With if_exists a missing
+// trigger is reported rather than treated as an error.
+func dropTrigger(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	name, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	parts := splitQualified(cfg.objectName)
+	schema := interface{}(nil)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	var n int
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.triggers WHERE trigger_schema = COALESCE(?, DATABASE()) AND trigger_name = ?",
+		schema, parts[len(parts)-1]).Scan(&n); err != nil {
+		return nil, fmt.Errorf("failed to look up trigger: %v", err)
+	}
+	b := new(stmtBuilder).text("DROP TRIGGER ")
+	if cfg.ifExists {
+		b.text("IF EXISTS ")
+	}
+	ddl, _ := b.ident(name).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		s, _ := splitTarget(cfg.objectName, cfg.dbname)
+		return nil, triggerError(err, s, "")
+	}
+	return map[string]interface{}{"existed": n > 0, "dropped": n > 0, "ddl": ddl}, nil
+}
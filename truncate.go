@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTruncateColumns parses truncate_columns: the number of characters
+// kept per column. Values are cut while scanning, so the full string is
+// never converted or encoded.
+func parseTruncateColumns(s string) (map[string]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var limits map[string]int
+	if err := json.Unmarshal([]byte(s), &limits); err != nil {
+		return nil, fmt.Errorf(`truncate_columns must be an object of column lengths like {"notes": 200}: %v`, err)
+	}
+	for _, col := range sortedKeys(limits) {
+		if limits[col] <= 0 {
+			return nil, fmt.Errorf("truncate_columns: %q must be a positive length", col)
+		}
+	}
+	return limits, nil
+}
+
+// binaryTypes are the column types whose values are bytes, not text;
+// cutting them would silently corrupt the value.
+var binaryTypes = map[string]bool{
+	"BINARY": true, "VARBINARY": true, "BIT": true, "GEOMETRY": true,
+	"TINYBLOB": true, "BLOB": true, "MEDIUMBLOB": true, "LONGBLOB": true,
+}
+
+// truncationLimits resolves the truncate_columns lengths against the result
+// columns, indexed like them.
+func truncationLimits(rows *sql.Rows, columns []string, truncation map[string]int) ([]int, error) {
+	if len(truncation) == 0 {
+		return nil, nil
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("columns error: %v", err)
+	}
+	limits := make([]int, len(columns))
+	for i, col := range columns {
+		limit, ok := truncation[col]
+		if !ok {
+			continue
+		}
+		if t := types[i].DatabaseTypeName(); binaryTypes[t] {
+			return nil, fmt.Errorf("truncate_columns: %s is a binary column (%s) and cannot be truncated", col, strings.ToLower(t))
+		}
+		limits[i] = limit
+	}
+	return limits, nil
+}
+
+// truncateRunes cuts b after n characters, never inside a multi-byte rune.
+// It reports whether anything was cut.
+func truncateRunes(b []byte, n int) ([]byte, bool) {
+	for i := 0; i < len(b); n-- {
+		if n == 0 {
+			return b[:i], true
+		}
+		_, size := utf8.DecodeRune(b[i:])
+		i += size
+	}
+	return b, false
+}
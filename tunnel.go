@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshNetwork is the driver network name bound to the tunnel dialer.
+const sshNetwork = "ssh"
+
+// tunnelError marks failures to establish or use the SSH tunnel so they can
+// be told apart from MySQL errors.
+type tunnelError struct{ err error }
+
+func (e *tunnelError) Error() string { return "ssh tunnel: " + e.err.Error() }
+func (e *tunnelError) Unwrap() error { return e.err }
+
+// openTunnel connects to the bastion and registers a dialer that opens MySQL
+// connections through it.
+func openTunnel(cfg settings) (*ssh.Client, error) {
+	auth, err := sshAuthMethods(cfg)
+	if err != nil {
+		return nil, &tunnelError{err}
+	}
+	hostKeyCallback, err := sshHostKeyCallback(cfg)
+	if err != nil {
+		return nil, &tunnelError{err}
+	}
+
+	timeout := time.Duration(cfg.connectTimeout) * time.Second
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	client, err := ssh.Dial("tcp", hostPort(cfg.sshHost, cfg.sshPort), &ssh.ClientConfig{
+		User:            cfg.sshUser,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return nil, &tunnelError{fmt.Errorf("failed to connect to %s: %v", cfg.sshHost, err)}
+	}
+
+	mysql.RegisterDialContext(sshNetwork, func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := client.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, &tunnelError{fmt.Errorf("failed to forward to %s: %v", addr, err)}
+		}
+		return conn, nil
+	})
+	return client, nil
+}
+
+func sshAuthMethods(cfg settings) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if cfg.sshKey != "" {
+		pemBytes := []byte(cfg.sshKey)
+		if !strings.Contains(cfg.sshKey, "-----BEGIN") {
+			var err error
+			if pemBytes, err = os.ReadFile(cfg.sshKey); err != nil {
+				return nil, fmt.Errorf("failed to read ssh_key: %v", err)
+			}
+		}
+		var signer ssh.Signer
+		var err error
+		if cfg.sshKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(cfg.sshKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh_key: %v", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if cfg.sshPassword != "" {
+		methods = append(methods, ssh.Password(cfg.sshPassword))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("ssh_key or ssh_password is required")
+	}
+	return methods, nil
+}
+
+// sshHostKeyCallback verifies the bastion against known_hosts unless the
+// caller explicitly opted out.
+func sshHostKeyCallback(cfg settings) (ssh.HostKeyCallback, error) {
+	if cfg.sshInsecureIgnoreHostKey {
+		logf("ssh host key verification disabled for %s", cfg.sshHost)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path := cfg.sshKnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("ssh_known_hosts is required: %v", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %v", err)
+	}
+	return callback, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var privilegeRe = regexp.MustCompile(`^[A-Z]+( [A-Z]+)*$`)
+
+// privilegeList parses privilege: comma separated privilege names, such as
+// SELECT, SHOW VIEW.
+func privilegeList(raw string) ([]string, error) {
+	var privs []string
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.Join(strings.Fields(strings.ToUpper(p)), " ")
+		if p == "" {
+			continue
+		}
+		if !privilegeRe.MatchString(p) {
+			return nil, fmt.Errorf("invalid privilege %q", p)
+		}
+		privs = appendUnique(privs, p)
+	}
+	if len(privs) == 0 {
+		return nil, fmt.Errorf("privilege must list at least one privilege")
+	}
+	return privs, nil
+}
+
+// userAccount quotes user_name@user_host.
+func userAccount(cfg settings) string {
+	account, _ := new(stmtBuilder).account(cfg).build()
+	return account
+}
+
+// account writes user_name@user_host.
+func (b *stmtBuilder) account(cfg settings) *stmtBuilder {
+	return b.literal(cfg.userName).text("@").literal(cfg.userHost)
+}
+
+// grantTarget quotes the object_name of grant and revoke: *.*, schema.* or
+// schema.table. In a schema-level grant _ and % are wildcards; they are
+// escaped so erp_t1.* grants on erp_t1 alone.
+func grantTarget(name string) (ident, error) {
+	if name == "*.*" {
+		return ident{name}, nil
+	}
+	if schema := strings.TrimSuffix(name, ".*"); schema != name {
+		if _, err := quoteIdent(schema); err != nil {
+			return ident{}, err
+		}
+		escaped := strings.NewReplacer("_", `\_`, "%", `\%`).Replace(schema)
+		return ident{"`" + strings.ReplaceAll(escaped, "`", "``") + "`.*"}, nil
+	}
+	if len(splitQualified(name)) != 2 {
+		return ident{}, fmt.Errorf("object_name must be *.*, schema.* or schema.table, got %q", name)
+	}
+	return tableIdent(name)
+}
+
+// checkGrantPolicy refuses what the policy file does not allow: wildcard
+// host patterns not listed in grant_hosts, *.* without allow_global_grants,
+// and privileges outside grant_privileges, or without that list ALL and
+// GRANT OPTION.
+func checkGrantPolicy(cfg settings, privs []string) error {
+	policy, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(cfg.userHost, "%_") && !containsString(policy.GrantHosts, cfg.userHost) {
+		return fmt.Errorf("user_host %q is a wildcard pattern not listed in the policy file's grant_hosts", cfg.userHost)
+	}
+	if cfg.objectName == "*.*" && !policy.AllowGlobalGrants {
+		return fmt.Errorf("global grants on *.* require allow_global_grants in the policy file")
+	}
+	for _, p := range privs {
+		switch {
+		case len(policy.GrantPrivileges) > 0:
+			if !containsString(policy.GrantPrivileges, p) {
+				return fmt.Errorf("privilege %s is not listed in the policy file's grant_privileges", p)
+			}
+		case p == "ALL" || p == "ALL PRIVILEGES" || p == "GRANT OPTION":
+			return fmt.Errorf("privilege %s requires listing it in the policy file's grant_privileges", p)
+		}
+	}
+	return nil
+}
+
+// userError names the privilege account management needs when the server
+// refuses it.
+func userError(err error, privilege string) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case 1044, 1045, 1142, 1227, 1410: // access denied variants
+			return &privilegeError{privilegeReport{Schema: "*", Required: []string{privilege}, Missing: []string{privilege}}}
+		case 1396: // ER_CANNOT_USER
+			return fmt.Errorf("%s (the account already exists, or does not)", myErr.Message)
+		}
+	}
+	return fmt.Errorf("execution error: %v", err)
+}
+
+// effectiveGrants returns the statement run and SHOW GRANTS for the account
+// afterwards.
+func effectiveGrants(ctx context.Context, db *database, cfg settings, ddl string) (interface{}, error) {
+	query, _ := new(stmtBuilder).text("SHOW GRANTS FOR ").account(cfg).build()
+	grants, err := queryStrings(ctx, db, query)
+	if err != nil {
+		return nil, fmt.Errorf("account changed but SHOW GRANTS failed: %v", err)
+	}
+	return map[string]interface{}{"user": userAccount(cfg), "ddl": ddl, "grants": grants}, nil
+}
+
+// createUser implements data_type=create_user. The password is sent as a
+// literal, since account statements take no placeholders, and never echoed.
+func createUser(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	if err := checkGrantPolicy(cfg, nil); err != nil {
+		return nil, err
+	}
+	// with renders the statement with password in place of user_password.
+	with := func(password string) string {
+		b := new(stmtBuilder).text("CREATE USER ")
+		if cfg.ifNotExists {
+			b.text("IF NOT EXISTS ")
+		}
+		b.account(cfg)
+		if cfg.authPlugin != "" {
+			b.text(" IDENTIFIED WITH ").text(sqlText(cfg.authPlugin))
+		}
+		if cfg.userPassword != "" {
+			if cfg.authPlugin == "" {
+				b.text(" IDENTIFIED")
+			}
+			b.text(" BY ").literal(password)
+		}
+		ddl, _ := b.build()
+		return ddl
+	}
+	ddl, shown := with(cfg.userPassword), with(defaultMaskToken)
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, userError(err, "CREATE USER")
+	}
+	return effectiveGrants(ctx, db, cfg, shown)
+}
+
+// grantPrivileges implements data_type=grant and data_type=revoke.
+func grantPrivileges(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	privs, err := privilegeList(cfg.privilege)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrantPolicy(cfg, privs); err != nil {
+		return nil, err
+	}
+	target, err := grantTarget(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	// privilegeList only passes words of capital letters.
+	verb, to := sqlText("GRANT "), sqlText(" TO ")
+	if cfg.dataType == "revoke" {
+		verb, to = "REVOKE ", " FROM "
+	}
+	ddl, _ := new(stmtBuilder).text(verb).text(sqlText(strings.Join(privs, ", "))).text(" ON ").ident(target).text(to).account(cfg).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, userError(err, "GRANT OPTION")
+	}
+	return effectiveGrants(ctx, db, cfg, ddl)
+}
+
+// dropUser implements data_type=drop_user.
+func dropUser(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	b := new(stmtBuilder).text("DROP USER ")
+	if cfg.ifExists {
+		b.text("IF EXISTS ")
+	}
+	ddl, _ := b.account(cfg).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, userError(err, "CREATE USER")
+	}
+	return map[string]interface{}{"user": userAccount(cfg), "ddl": ddl}, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// statementKeywords are the words a MySQL statement can start with, for
+// the partial check of statements the server will not prepare.
+var statementKeywords = map[string]bool{
+	"ALTER": true, "ANALYZE": true, "BEGIN": true, "CALL": true, "CHANGE": true, "CHECK": true, "CHECKSUM": true,
+	"COMMIT": true, "CREATE": true, "DEALLOCATE": true, "DELETE": true, "DESC": true, "DESCRIBE": true, "DO": true,
+	"DROP": true, "EXECUTE": true, "EXPLAIN": true, "FLUSH": true, "GET": true, "GRANT": true, "HANDLER": true,
+	"IMPORT": true, "INSERT": true, "INSTALL": true, "KILL": true, "LOAD": true, "LOCK": true, "OPTIMIZE": true,
+	"PREPARE": true, "PURGE": true, "RELEASE": true, "RENAME": true, "REPAIR": true, "REPLACE": true, "RESET": true,
+	"RESIGNAL": true, "REVOKE": true, "ROLLBACK": true, "SAVEPOINT": true, "SELECT": true, "SET": true, "SHOW": true,
+	"SIGNAL": true, "START": true, "TABLE": true, "TRUNCATE": true, "UNINSTALL": true, "UNLOCK": true, "UPDATE": true,
+	"USE": true, "VALUES": true, "WITH": true, "XA": true,
+}
+
+// validation is the result of data_type=validate. Partial is set when the
+// server refused to prepare the statement and only its first keyword was
+// checked; Placeholders is then counted from the text.
+type validation struct {
+	OK           bool   `json:"ok"`
+	Error        string `json:"error,omitempty"`
+	Placeholders int    `json:"placeholders"`
+	Parameters   *int   `json:"parameters,omitempty"` // supplied, when parameters is set
+	Partial      bool   `json:"partial,omitempty"`
+	Keyword      string `json:"keyword,omitempty"`
+}
+
+// validateStatement implements data_type=validate: query is prepared on
+// the server, which checks its syntax and the tables and columns it names,
+// and closed again without executing. parameters are counted against the
+// placeholders but never bound. A statement that does not validate is a
+// result with ok=false, not a failed request.
+func validateStatement(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	result := &validation{}
+	if cfg.parameters != "" {
+		args, _ := parseArgs(cfg.parameters)
+		n := len(args)
+		result.Parameters = &n
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+	defer conn.Close()
+	// database/sql does not expose the placeholder count of a prepared
+	// statement, so the driver connection prepares it directly.
+	prepareErr := conn.Raw(func(dc interface{}) error {
+		preparer, ok := dc.(driver.ConnPrepareContext)
+		if !ok {
+			return fmt.Errorf("the driver cannot prepare statements")
+		}
+		stmt, err := preparer.PrepareContext(ctx, cfg.query)
+		if err != nil {
+			return err
+		}
+		result.Placeholders = stmt.NumInput()
+		return stmt.Close()
+	})
+
+	var myErr *mysql.MySQLError
+	switch {
+	case prepareErr == nil:
+		result.OK = true
+	case errors.As(prepareErr, &myErr) && myErr.Number == 1295: // ER_UNSUPPORTED_PS
+		result.Partial = true
+		result.Keyword, result.OK = firstKeyword(cfg.query)
+		result.Placeholders = len(placeholderOffsets(cfg.query))
+		if !result.OK {
+			result.Error = fmt.Sprintf("the server cannot prepare this statement, and %q does not start a MySQL statement", result.Keyword)
+		}
+		out.Warnings = append(out.Warnings, "the server does not prepare this kind of statement: only its first keyword was checked")
+	case errors.As(prepareErr, &myErr):
+		result.Error = prepareErr.Error()
+	default:
+		return nil, fmt.Errorf("prepare failed: %v", prepareErr)
+	}
+	if result.OK && result.Parameters != nil && *result.Parameters != result.Placeholders {
+		result.OK = false
+		result.Error = fmt.Sprintf("the statement has %d placeholders but %d parameters were given", result.Placeholders, *result.Parameters)
+	}
+	return result, nil
+}
+
+// firstKeyword returns the first word of the statement and whether it can
+// start a MySQL statement.
+func firstKeyword(query string) (string, bool) {
+	for _, tok := range tokenize(query) {
+		switch tok.kind {
+		case tokSpace, tokComment:
+			continue
+		case tokPunct:
+			if tok.text == "(" {
+				continue
+			}
+		}
+		word := strings.ToUpper(tok.text)
+		return word, tok.kind == tokWord && statementKeywords[word]
+	}
+	return "", false
+}
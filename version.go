@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// serverVersion is the parsed result of SELECT VERSION().
+type serverVersion struct {
+	Raw     string `json:"raw"`
+	Major   int    `json:"major"`
+	Minor   int    `json:"minor"`
+	Patch   int    `json:"patch"`
+	MariaDB bool   `json:"mariadb"`
+}
+
+var versionRe = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+func parseServerVersion(raw string) serverVersion {
+	v := serverVersion{Raw: raw, MariaDB: strings.Contains(strings.ToLower(raw), "mariadb")}
+	// Old MariaDB releases report a 5.5.5- prefix for replication compatibility.
+	s := strings.TrimPrefix(raw, "5.5.5-")
+	if m := versionRe.FindStringSubmatch(s); m != nil {
+		v.Major, _ = strconv.Atoi(m[1])
+		v.Minor, _ = strconv.Atoi(m[2])
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	return v
+}
+
+func queryServerVersion(ctx context.Context, q execer) (serverVersion, error) {
+	var raw string
+	if err := q.QueryRowContext(ctx, "SELECT VERSION()").Scan(&raw); err != nil {
+		return serverVersion{}, fmt.Errorf("failed to read server version: %v", err)
+	}
+	return parseServerVersion(raw), nil
+}
+
+// atLeast reports whether the version is major.minor or newer.
+func (v serverVersion) atLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// windowFunctions reports support for OVER clauses (MySQL 8.0, MariaDB 10.2).
+func (v serverVersion) windowFunctions() bool {
+	if v.MariaDB {
+		return v.atLeast(10, 2)
+	}
+	return v.atLeast(8, 0)
+}
+
+func (v serverVersion) String() string {
+	if v.MariaDB {
+		return fmt.Sprintf("MariaDB %d.%d", v.Major, v.Minor)
+	}
+	return fmt.Sprintf("MySQL %d.%d", v.Major, v.Minor)
+}
+
+// unsupportedError reports a feature the connected server cannot provide.
+type unsupportedError struct {
+	feature string
+	version serverVersion
+}
+
+func (e *unsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by %s", e.feature, e.version)
+}
+
+// require returns an unsupportedError for feature unless supported.
+func (v serverVersion) require(feature string, supported bool) error {
+	if supported {
+		return nil
+	}
+	return &unsupportedError{feature, v}
+}
+
+// roles reports support for SET ROLE DEFAULT and SHOW GRANTS ... USING.
+func (v serverVersion) roles() bool {
+	return !v.MariaDB && v.atLeast(8, 0)
+}
+
+// explainJSON reports support for EXPLAIN FORMAT=JSON.
+func (v serverVersion) explainJSON() bool {
+	if v.MariaDB {
+		return v.atLeast(10, 1)
+	}
+	return v.atLeast(5, 6)
+}
+
+// returning reports support for INSERT ... RETURNING (MariaDB 10.5).
+func (v serverVersion) returning() bool {
+	return v.MariaDB && v.atLeast(10, 5)
+}
+
+// sequences reports support for CREATE SEQUENCE and NEXT VALUE FOR
+// (MariaDB 10.3).
+func (v serverVersion) sequences() bool {
+	return v.MariaDB && v.atLeast(10, 3)
+}
+
+// lockNowait reports support for NOWAIT on locking reads (MySQL 8.0,
+// MariaDB 10.3).
+func (v serverVersion) lockNowait() bool {
+	if v.MariaDB {
+		return v.atLeast(10, 3)
+	}
+	return v.atLeast(8, 0)
+}
+
+// skipLocked reports support for SKIP LOCKED (MySQL 8.0, MariaDB 10.6).
+func (v serverVersion) skipLocked() bool {
+	if v.MariaDB {
+		return v.atLeast(10, 6)
+	}
+	return v.atLeast(8, 0)
+}
+
+// recursiveCTE reports support for WITH RECURSIVE (MySQL 8.0, MariaDB
+// 10.2).
+func (v serverVersion) recursiveCTE() bool {
+	return v.windowFunctions()
+}
+
+// calcFoundRows reports whether SQL_CALC_FOUND_ROWS is still current: MySQL
+// deprecated it in 8.0.17, MariaDB keeps it.
+func (v serverVersion) calcFoundRows() bool {
+	if v.MariaDB {
+		return true
+	}
+	return !v.atLeast(8, 1) && !(v.Major == 8 && v.Minor == 0 && v.Patch >= 17)
+}
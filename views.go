@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// isSelectQuery reports whether query is a plain SELECT (or WITH ... SELECT),
+// the only statements a view or materialized table may be defined from.
+func isSelectQuery(query string) bool {
+	for _, tok := range tokenize(query) {
+		switch tok.kind {
+		case tokSpace, tokComment:
+			continue
+		case tokPunct:
+			return tok.text == "("
+		case tokWord:
+			word := strings.ToUpper(tok.text)
+			return word == "SELECT" || word == "WITH"
+		}
+		return false
+	}
+	return false
+}
+
+// validateSelect checks select_query with EXPLAIN so a broken definition is
+// rejected before any DDL runs.
+func validateSelect(ctx context.Context, q execer, query string) error {
+	if !isSelectQuery(query) {
+		return fmt.Errorf("select_query must be a SELECT statement")
+	}
+	explain, _ := new(stmtBuilder).text("EXPLAIN ").text(sqlText(query)).build()
+	rows, err := q.QueryContext(ctx, explain)
+	if err != nil {
+		return fmt.Errorf("select_query is invalid: %v", err)
+	}
+	return rows.Close()
+}
+
+// createView implements data_type=create_view and returns SHOW CREATE VIEW.
+// Views use SQL SECURITY INVOKER unless definer_security is set.
+func createView(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	view, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSelect(ctx, db, cfg.selectQuery); err != nil {
+		return nil, err
+	}
+
+	b := new(stmtBuilder).text("CREATE ")
+	if cfg.orReplace {
+		b.text("OR REPLACE ")
+	}
+	if cfg.definerSecurity {
+		b.text("SQL SECURITY DEFINER ")
+	} else {
+		b.text("SQL SECURITY INVOKER ")
+	}
+	ddl, _ := b.text("VIEW ").ident(view).text(" AS ").text(sqlText(cfg.selectQuery)).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
+
+	var name, definition string
+	var charset, collation interface{}
+	show, _ := new(stmtBuilder).text("SHOW CREATE VIEW ").ident(view).build()
+	if err := db.QueryRowContext(ctx, show).Scan(&name, &definition, &charset, &collation); err != nil {
+		return nil, fmt.Errorf("view created but SHOW CREATE VIEW failed: %v", err)
+	}
+	return map[string]interface{}{"view": name, "create_view": definition}, nil
+}
+
+// suffixedName appends suffix to the object part of a possibly qualified
+// name and quotes the result.
+func suffixedName(name, suffix string) (ident, error) {
+	parts := splitQualified(name)
+	parts[len(parts)-1] += suffix
+	for i, part := range parts {
+		q, err := quoteIdent(part)
+		if err != nil {
+			return ident{}, err
+		}
+		parts[i] = q
+	}
+	return ident{strings.Join(parts, ".")}, nil
+}
+
+// materializeView implements data_type=materialize_view. The table is built
+// under a "__new" name and swapped in with a single RENAME TABLE, which MySQL
+// performs atomically, so readers see the old or the new dataset and never
+// a partial one. CREATE TABLE ... SELECT commits implicitly, so the swap
+// rather than a transaction is what provides the guarantee.
+func materializeView(ctx context.Context, db *database, cfg settings, out *Output) (interface{}, error) {
+	table, err := tableIdent(cfg.objectName)
+	if err != nil {
+		return nil, err
+	}
+	newTable, err := suffixedName(cfg.objectName, "__new")
+	if err != nil {
+		return nil, err
+	}
+	oldTable, err := suffixedName(cfg.objectName, "__old")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSelect(ctx, db, cfg.selectQuery); err != nil {
+		return nil, err
+	}
+
+	// Leftovers from an interrupted run would make the CREATE fail.
+	ddl, _ := new(stmtBuilder).text("DROP TABLE IF EXISTS ").idents([]ident{newTable, oldTable}).build()
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
+	ddl, _ = new(stmtBuilder).text("CREATE TABLE ").ident(newTable).text(" AS ").text(sqlText(cfg.selectQuery)).build()
+	res, err := db.ExecContext(ctx, ddl)
+	if err != nil {
+		return nil, err
+	}
+	rows, _ := res.RowsAffected()
+
+	existed, err := tableExists(ctx, db, cfg.objectName)
+	if err == nil {
+		b := new(stmtBuilder).text("RENAME TABLE ")
+		if existed {
+			b.ident(table).text(" TO ").ident(oldTable).text(", ")
+		}
+		ddl, _ = b.ident(newTable).text(" TO ").ident(table).build()
+		_, err = db.ExecContext(ctx, ddl)
+	}
+	if err != nil {
+		ddl, _ = new(stmtBuilder).text("DROP TABLE IF EXISTS ").ident(newTable).build()
+		db.ExecContext(ctx, ddl)
+		return nil, fmt.Errorf("swap failed: %v", err)
+	}
+	if existed {
+		ddl, _ = new(stmtBuilder).text("DROP TABLE ").ident(oldTable).build()
+		if _, err := db.ExecContext(ctx, ddl); err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("failed to drop previous table %s: %v", oldTable, err))
+		}
+	}
+	return map[string]interface{}{"rows": rows, "replaced": existed}, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// windowSpec is one entry of the window input, rendered as
+// fn(column) OVER (PARTITION BY ... ORDER BY ... frame) AS alias.
+type windowSpec struct {
+	Fn          string   `json:"fn"`
+	Column      string   `json:"column"`
+	PartitionBy []string `json:"partition_by"`
+	OrderBy     []string `json:"order_by"`
+	As          string   `json:"as"`
+	Frame       string   `json:"frame"`
+}
+
+// windowFns maps the supported functions to whether they take a column.
+var windowFns = map[string]bool{
+	"row_number": false, "rank": false, "dense_rank": false, "percent_rank": false, "cume_dist": false,
+	"sum": true, "avg": true, "min": true, "max": true, "count": true,
+	"lag": true, "lead": true, "first_value": true, "last_value": true,
+}
+
+var windowFrames = map[string]sqlText{
+	"rows_unbounded_preceding":  "ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW",
+	"rows_unbounded_following":  "ROWS BETWEEN CURRENT ROW AND UNBOUNDED FOLLOWING",
+	"rows_whole_partition":      "ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING",
+	"range_unbounded_preceding": "RANGE BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW",
+	"range_unbounded_following": "RANGE BETWEEN CURRENT ROW AND UNBOUNDED FOLLOWING",
+	"range_whole_partition":     "RANGE BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING",
+}
+
+// windowProjection validates the window input and renders the extra
+// projection columns. Every name is quoted; functions and frames come from
+// fixed lists.
+func windowProjection(raw string) (sqlText, error) {
+	var specs []windowSpec
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&specs); err != nil {
+		return "", fmt.Errorf("window must be a JSON array of window definitions: %v", err)
+	}
+	if len(specs) == 0 {
+		return "", fmt.Errorf("window must define at least one function")
+	}
+
+	b := new(stmtBuilder)
+	for i, w := range specs {
+		if i > 0 {
+			b.text(", ")
+		}
+		if err := b.window(w); err != nil {
+			return "", fmt.Errorf("window %d: %v", i+1, err)
+		}
+	}
+	exprs, _ := b.build()
+	return sqlText(exprs), nil
+}
+
+// window writes w as fn(column) OVER (...) AS alias.
+func (b *stmtBuilder) window(w windowSpec) error {
+	fn := strings.ToLower(w.Fn)
+	takesColumn, ok := windowFns[fn]
+	if !ok {
+		return fmt.Errorf("unsupported function %q", w.Fn)
+	}
+	alias, err := columnIdent(w.As)
+	if err != nil {
+		return fmt.Errorf("invalid as: %v", err)
+	}
+
+	b.text(sqlText(strings.ToUpper(fn))).text("(")
+	switch {
+	case takesColumn && w.Column == "" && fn == "count":
+		b.text("*")
+	case takesColumn:
+		col, err := columnIdent(w.Column)
+		if err != nil {
+			return fmt.Errorf("invalid column: %v", err)
+		}
+		b.ident(col)
+	case w.Column != "":
+		return fmt.Errorf("%s does not take a column", fn)
+	}
+	b.text(") OVER (")
+
+	sep := sqlText("")
+	if len(w.PartitionBy) > 0 {
+		cols, err := columnIdents(w.PartitionBy)
+		if err != nil {
+			return fmt.Errorf("invalid partition_by: %v", err)
+		}
+		b.text("PARTITION BY ").idents(cols)
+		sep = " "
+	}
+	if len(w.OrderBy) > 0 {
+		b.text(sep).text("ORDER BY ")
+		for i, o := range w.OrderBy {
+			if i > 0 {
+				b.text(", ")
+			}
+			if err := b.orderTerm(o); err != nil {
+				return fmt.Errorf("invalid order_by: %v", err)
+			}
+		}
+		sep = " "
+	}
+	if w.Frame != "" {
+		frame, ok := windowFrames[strings.ToLower(w.Frame)]
+		if !ok {
+			return fmt.Errorf("unsupported frame %q", w.Frame)
+		}
+		b.text(sep).text(frame)
+	}
+	b.text(") AS ").ident(alias)
+	return nil
+}
+
+// orderTerm writes "column [ASC|DESC]".
+func (b *stmtBuilder) orderTerm(term string) error {
+	fields := strings.Fields(term)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("expected \"column [ASC|DESC]\", got %q", term)
+	}
+	col, err := columnIdent(fields[0])
+	if err != nil {
+		return err
+	}
+	var dir sqlText
+	if len(fields) == 2 {
+		switch strings.ToUpper(fields[1]) {
+		case "ASC":
+			dir = " ASC"
+		case "DESC":
+			dir = " DESC"
+		default:
+			return fmt.Errorf("expected ASC or DESC, got %q", fields[1])
+		}
+	}
+	b.ident(col).text(dir)
+	return nil
+}